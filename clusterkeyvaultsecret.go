@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+
+	keyvaultsecretv1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+)
+
+const (
+	// MessageKeyvaultSecretExists is the message used for Events when a
+	// ClusterKeyvaultSecret fails to sync due to a KeyvaultSecret already
+	// existing
+	MessageKeyvaultSecretExists = "KeyvaultSecret %s/%s already exists and is not managed by ClusterKeyvaultSecret"
+)
+
+// clusterKeyvaultSecretLabel holds the name of the ClusterKeyvaultSecret that
+// created a KeyvaultSecret
+var clusterKeyvaultSecretLabel = keyvaultsecretv1alpha1.SchemeGroupVersion.Group + "/cluster-keyvault-secret"
+
+// clusterKeyvaultSecretHandler creates a KeyvaultSecret from the template of
+// a ClusterKeyvaultSecret in every matching namespace and deletes the
+// KeyvaultSecrets in namespaces that no longer match. The KeyvaultSecrets are
+// controlled by the ClusterKeyvaultSecret, so they are garbage collected
+// together with it.
+func (c *Controller) clusterKeyvaultSecretHandler(ctx context.Context, key string) error {
+	_, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("invalid resource key: %s", key))
+		return nil
+	}
+
+	clusterKeyvaultSecret, err := c.clusterKeyvaultSecretsLister.Get(name)
+	if errors.IsNotFound(err) {
+		c.logger.Infof("cluster keyvault secret '%s' deleted", key)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := clusterKeyvaultSecret.Spec.IsValid(); err != nil {
+		return err
+	}
+
+	namespaces, err := c.matchingNamespaces(clusterKeyvaultSecret)
+	if err != nil {
+		return err
+	}
+	status := keyvaultsecretv1alpha1.ClusterKeyvaultSecretStatus{}
+	var syncErr error
+	for _, namespace := range namespaces {
+		err := c.syncClusterKeyvaultSecret(clusterKeyvaultSecret, namespace)
+		if err != nil {
+			status.FailedNamespaces = append(status.FailedNamespaces, namespace)
+			if syncErr == nil {
+				syncErr = err
+			}
+			continue
+		}
+		status.Namespaces = append(status.Namespaces, namespace)
+	}
+
+	if err := c.deleteUnmatchedKeyvaultSecrets(clusterKeyvaultSecret, namespaces); err != nil {
+		return err
+	}
+	if err := c.updateClusterKeyvaultSecretStatus(clusterKeyvaultSecret, status); err != nil {
+		return err
+	}
+	return syncErr
+}
+
+// matchingNamespaces returns the sorted names of the namespaces that the
+// ClusterKeyvaultSecret selects. Namespaces that are being deleted or that
+// the controller is restricted from are left out.
+func (c *Controller) matchingNamespaces(clusterKeyvaultSecret *keyvaultsecretv1alpha1.ClusterKeyvaultSecret) ([]string, error) {
+	selector := labels.Nothing()
+	if clusterKeyvaultSecret.Spec.NamespaceSelector != nil {
+		var err error
+		selector, err = metav1.LabelSelectorAsSelector(clusterKeyvaultSecret.Spec.NamespaceSelector)
+		if err != nil {
+			return nil, err
+		}
+	}
+	listed := make(map[string]bool)
+	for _, namespace := range clusterKeyvaultSecret.Spec.Namespaces {
+		listed[namespace] = true
+	}
+
+	all, err := c.namespacesLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	var namespaces []string
+	for _, namespace := range all {
+		if namespace.Status.Phase == corev1.NamespaceTerminating {
+			continue
+		}
+		if !listed[namespace.Name] && !selector.Matches(labels.Set(namespace.Labels)) {
+			continue
+		}
+		if !c.inNamespaces(&metav1.ObjectMeta{Namespace: namespace.Name}) {
+			continue
+		}
+		namespaces = append(namespaces, namespace.Name)
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
+// syncClusterKeyvaultSecret creates or updates the KeyvaultSecret of the
+// ClusterKeyvaultSecret in namespace
+func (c *Controller) syncClusterKeyvaultSecret(clusterKeyvaultSecret *keyvaultsecretv1alpha1.ClusterKeyvaultSecret, namespace string) error {
+	desired := newClusterOwnedKeyvaultSecret(clusterKeyvaultSecret, namespace)
+	existing, err := c.keyvaultSecretsLister.KeyvaultSecrets(namespace).Get(desired.Name)
+	if errors.IsNotFound(err) {
+		_, err = c.crdclientset.SecretcontrollerV1alpha1().KeyvaultSecrets(namespace).Create(desired)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if !metav1.IsControlledBy(existing, clusterKeyvaultSecret) {
+		msg := fmt.Sprintf(MessageKeyvaultSecretExists, namespace, existing.Name)
+		c.recorder.Event(clusterKeyvaultSecret, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf("%s", msg)
+	}
+	if reflect.DeepEqual(existing.Spec, desired.Spec) && reflect.DeepEqual(existing.Labels, desired.Labels) {
+		return nil
+	}
+	// NEVER modify objects from the store. It's a read-only, local cache.
+	existingCopy := existing.DeepCopy()
+	existingCopy.Spec = desired.Spec
+	existingCopy.Labels = desired.Labels
+	_, err = c.crdclientset.SecretcontrollerV1alpha1().KeyvaultSecrets(namespace).Update(existingCopy)
+	return err
+}
+
+// deleteUnmatchedKeyvaultSecrets deletes the KeyvaultSecrets of the
+// ClusterKeyvaultSecret outside of namespaces. Their Secrets are garbage
+// collected.
+func (c *Controller) deleteUnmatchedKeyvaultSecrets(clusterKeyvaultSecret *keyvaultsecretv1alpha1.ClusterKeyvaultSecret, namespaces []string) error {
+	matched := make(map[string]bool)
+	for _, namespace := range namespaces {
+		matched[namespace] = true
+	}
+	selector := labels.SelectorFromSet(labels.Set{clusterKeyvaultSecretLabel: clusterKeyvaultSecret.Name})
+	keyvaultSecrets, err := c.keyvaultSecretsLister.List(selector)
+	if err != nil {
+		return err
+	}
+	for _, keyvaultSecret := range keyvaultSecrets {
+		if matched[keyvaultSecret.Namespace] || !metav1.IsControlledBy(keyvaultSecret, clusterKeyvaultSecret) {
+			continue
+		}
+		c.logger.Infof("Deleting KeyvaultSecret %s/%s of cluster keyvault secret '%s'", keyvaultSecret.Namespace, keyvaultSecret.Name, clusterKeyvaultSecret.Name)
+		err := c.crdclientset.SecretcontrollerV1alpha1().KeyvaultSecrets(keyvaultSecret.Namespace).Delete(keyvaultSecret.Name, &metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Controller) updateClusterKeyvaultSecretStatus(clusterKeyvaultSecret *keyvaultsecretv1alpha1.ClusterKeyvaultSecret, status keyvaultsecretv1alpha1.ClusterKeyvaultSecretStatus) error {
+	if reflect.DeepEqual(clusterKeyvaultSecret.Status, status) {
+		return nil
+	}
+	// NEVER modify objects from the store. It's a read-only, local cache.
+	clusterKeyvaultSecretCopy := clusterKeyvaultSecret.DeepCopy()
+	clusterKeyvaultSecretCopy.Status = status
+	_, err := c.crdclientset.SecretcontrollerV1alpha1().ClusterKeyvaultSecrets().Update(clusterKeyvaultSecretCopy)
+	return err
+}
+
+// newClusterOwnedKeyvaultSecret returns the KeyvaultSecret of the
+// ClusterKeyvaultSecret in namespace. It carries the labels of the
+// ClusterKeyvaultSecret, so that it matches the same label selector.
+func newClusterOwnedKeyvaultSecret(clusterKeyvaultSecret *keyvaultsecretv1alpha1.ClusterKeyvaultSecret, namespace string) *keyvaultsecretv1alpha1.KeyvaultSecret {
+	keyvaultSecretLabels := make(map[string]string, len(clusterKeyvaultSecret.Labels)+1)
+	for key, value := range clusterKeyvaultSecret.Labels {
+		keyvaultSecretLabels[key] = value
+	}
+	keyvaultSecretLabels[clusterKeyvaultSecretLabel] = clusterKeyvaultSecret.Name
+	return &keyvaultsecretv1alpha1.KeyvaultSecret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      clusterKeyvaultSecret.Name,
+			Namespace: namespace,
+			Labels:    keyvaultSecretLabels,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(clusterKeyvaultSecret, schema.GroupVersionKind{
+					Group:   keyvaultsecretv1alpha1.SchemeGroupVersion.Group,
+					Version: keyvaultsecretv1alpha1.SchemeGroupVersion.Version,
+					Kind:    "ClusterKeyvaultSecret",
+				}),
+			},
+		},
+		Spec: *clusterKeyvaultSecret.Spec.Template.DeepCopy(),
+	}
+}
+
+// handleClusterOwnedKeyvaultSecret enqueues the ClusterKeyvaultSecret that
+// controls the given KeyvaultSecret, so that KeyvaultSecrets that are
+// edited or deleted by hand are restored
+func (c *Controller) handleClusterOwnedKeyvaultSecret(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	object, ok := obj.(metav1.Object)
+	if !ok {
+		return
+	}
+	ownerRef := metav1.GetControllerOf(object)
+	if ownerRef == nil || ownerRef.Kind != "ClusterKeyvaultSecret" || c.clusterKeyvaultSecretsLister == nil {
+		return
+	}
+	clusterKeyvaultSecret, err := c.clusterKeyvaultSecretsLister.Get(ownerRef.Name)
+	if err != nil || clusterKeyvaultSecret.UID != ownerRef.UID {
+		return
+	}
+	c.enqueueClusterKeyvaultSecret(clusterKeyvaultSecret)
+}
+
+// enqueueAllClusterKeyvaultSecrets enqueues all ClusterKeyvaultSecrets, e.g.
+// when a namespace is created or its labels change
+func (c *Controller) enqueueAllClusterKeyvaultSecrets() {
+	clusterKeyvaultSecrets, err := c.clusterKeyvaultSecretsLister.List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	for _, clusterKeyvaultSecret := range clusterKeyvaultSecrets {
+		c.enqueueClusterKeyvaultSecret(clusterKeyvaultSecret)
+	}
+}
+
+func (c *Controller) enqueueClusterKeyvaultSecret(obj interface{}) {
+	var key string
+	var err error
+	if key, err = cache.MetaNamespaceKeyFunc(obj); err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	c.clusterWorkqueue.AddRateLimited(key)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	keyvaultsecretv1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+	"github.com/twendt/secret-controller/pkg/client/clientset/versioned/fake"
+	listers "github.com/twendt/secret-controller/pkg/client/listers/secretcontroller/v1alpha1"
+)
+
+func Test_clusterKeyvaultSecretHandler(t *testing.T) {
+	clusterKeyvaultSecret := &keyvaultsecretv1alpha1.ClusterKeyvaultSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry", UID: "uid", Labels: map[string]string{"team": "platform"}},
+		Spec: keyvaultsecretv1alpha1.ClusterKeyvaultSecretSpec{
+			Template: keyvaultsecretv1alpha1.KeyvaultSecretSpec{
+				SecretName: "registry",
+				Items:      []keyvaultsecretv1alpha1.KeyvaultSecretEntry{{KubernetesName: "password", KeyvaultName: "registry-password"}},
+			},
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"registry": "true"}},
+			Namespaces:        []string{"listed"},
+		},
+	}
+	newNamespace := func(name string, namespaceLabels map[string]string, phase corev1.NamespacePhase) *corev1.Namespace {
+		return &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: namespaceLabels},
+			Status:     corev1.NamespaceStatus{Phase: phase},
+		}
+	}
+	namespaces := []*corev1.Namespace{
+		newNamespace("selected", map[string]string{"registry": "true"}, corev1.NamespaceActive),
+		newNamespace("listed", nil, corev1.NamespaceActive),
+		newNamespace("unselected", nil, corev1.NamespaceActive),
+		newNamespace("terminating", map[string]string{"registry": "true"}, corev1.NamespaceTerminating),
+		newNamespace("conflict", map[string]string{"registry": "true"}, corev1.NamespaceActive),
+	}
+	owned := newClusterOwnedKeyvaultSecret(clusterKeyvaultSecret, "unselected")
+	foreign := &keyvaultsecretv1alpha1.KeyvaultSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "conflict"},
+	}
+
+	namespaceIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, namespace := range namespaces {
+		namespaceIndexer.Add(namespace)
+	}
+	keyvaultSecretIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	keyvaultSecretIndexer.Add(owned)
+	keyvaultSecretIndexer.Add(foreign)
+	clusterIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	clusterIndexer.Add(clusterKeyvaultSecret)
+
+	crdclientset := fake.NewSimpleClientset([]runtime.Object{clusterKeyvaultSecret, owned, foreign}...)
+	c := &Controller{
+		crdclientset:                 crdclientset,
+		keyvaultSecretsLister:        listers.NewKeyvaultSecretLister(keyvaultSecretIndexer),
+		clusterKeyvaultSecretsLister: listers.NewClusterKeyvaultSecretLister(clusterIndexer),
+		namespacesLister:             corelisters.NewNamespaceLister(namespaceIndexer),
+		recorder:                     record.NewFakeRecorder(10),
+		logger:                       logrus.NewEntry(logrus.New()),
+	}
+
+	if err := c.clusterKeyvaultSecretHandler(context.Background(), "registry"); err == nil {
+		t.Errorf("clusterKeyvaultSecretHandler() succeeded despite a conflicting KeyvaultSecret")
+	}
+
+	keyvaultSecrets := crdclientset.SecretcontrollerV1alpha1()
+	for _, namespace := range []string{"selected", "listed"} {
+		keyvaultSecret, err := keyvaultSecrets.KeyvaultSecrets(namespace).Get("registry", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("KeyvaultSecret in %s not created: %v", namespace, err)
+		}
+		if !metav1.IsControlledBy(keyvaultSecret, clusterKeyvaultSecret) {
+			t.Errorf("KeyvaultSecret in %s is not controlled by the ClusterKeyvaultSecret", namespace)
+		}
+		if keyvaultSecret.Labels["team"] != "platform" || keyvaultSecret.Labels[clusterKeyvaultSecretLabel] != "registry" {
+			t.Errorf("KeyvaultSecret in %s labels = %v", namespace, keyvaultSecret.Labels)
+		}
+		if !reflect.DeepEqual(keyvaultSecret.Spec, clusterKeyvaultSecret.Spec.Template) {
+			t.Errorf("KeyvaultSecret in %s spec = %v, want %v", namespace, keyvaultSecret.Spec, clusterKeyvaultSecret.Spec.Template)
+		}
+	}
+	for _, namespace := range []string{"unselected", "terminating"} {
+		if _, err := keyvaultSecrets.KeyvaultSecrets(namespace).Get("registry", metav1.GetOptions{}); err == nil {
+			t.Errorf("KeyvaultSecret in %s exists, want it deleted or not created", namespace)
+		}
+	}
+	if _, err := keyvaultSecrets.KeyvaultSecrets("conflict").Get("registry", metav1.GetOptions{}); err != nil {
+		t.Errorf("foreign KeyvaultSecret deleted: %v", err)
+	}
+
+	updated, err := keyvaultSecrets.ClusterKeyvaultSecrets().Get("registry", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus := keyvaultsecretv1alpha1.ClusterKeyvaultSecretStatus{
+		Namespaces:       []string{"listed", "selected"},
+		FailedNamespaces: []string{"conflict"},
+	}
+	if !reflect.DeepEqual(updated.Status, wantStatus) {
+		t.Errorf("status = %+v, want %+v", updated.Status, wantStatus)
+	}
+}
+
+func Test_clusterKeyvaultSecretHandler_invalidTemplate(t *testing.T) {
+	clusterKeyvaultSecret := &keyvaultsecretv1alpha1.ClusterKeyvaultSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry", UID: "uid"},
+		Spec: keyvaultsecretv1alpha1.ClusterKeyvaultSecretSpec{
+			Template: keyvaultsecretv1alpha1.KeyvaultSecretSpec{
+				SecretName: "registry",
+				Items:      []keyvaultsecretv1alpha1.KeyvaultSecretEntry{{KubernetesName: "password"}},
+			},
+			Namespaces: []string{"listed"},
+		},
+	}
+	namespaceIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	namespaceIndexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "listed"}})
+	clusterIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	clusterIndexer.Add(clusterKeyvaultSecret)
+
+	crdclientset := fake.NewSimpleClientset(clusterKeyvaultSecret)
+	c := &Controller{
+		crdclientset:                 crdclientset,
+		keyvaultSecretsLister:        listers.NewKeyvaultSecretLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})),
+		clusterKeyvaultSecretsLister: listers.NewClusterKeyvaultSecretLister(clusterIndexer),
+		namespacesLister:             corelisters.NewNamespaceLister(namespaceIndexer),
+		recorder:                     record.NewFakeRecorder(10),
+		logger:                       logrus.NewEntry(logrus.New()),
+	}
+
+	if err := c.clusterKeyvaultSecretHandler(context.Background(), "registry"); err == nil {
+		t.Errorf("clusterKeyvaultSecretHandler() succeeded with an invalid template")
+	}
+	if _, err := crdclientset.SecretcontrollerV1alpha1().KeyvaultSecrets("listed").Get("registry", metav1.GetOptions{}); err == nil {
+		t.Errorf("KeyvaultSecret created from an invalid template")
+	}
+}
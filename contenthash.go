@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// defaultContentHashSecretName is the name of the Secret with the
+	// content hash key in the namespace of the controller
+	defaultContentHashSecretName = "secret-controller-content-hash"
+	// contentHashKeyName is the key of the content hash key in its Secret
+	contentHashKeyName = "key"
+	// serviceAccountNamespaceFile holds the namespace of the controller when
+	// it runs in a pod
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// defaultContentHashSecret returns the namespace/name of the Secret with the
+// content hash key in the namespace of the controller, or in the default
+// namespace if the controller runs outside of the cluster
+func defaultContentHashSecret() string {
+	namespace := metav1.NamespaceDefault
+	if data, err := ioutil.ReadFile(serviceAccountNamespaceFile); err == nil && len(strings.TrimSpace(string(data))) > 0 {
+		namespace = strings.TrimSpace(string(data))
+	}
+	return namespace + "/" + defaultContentHashSecretName
+}
+
+// loadContentHashKey returns the key of the HMACs over the content of
+// Secrets, which is read from the Secret namespace/name and created with a
+// random key if the Secret does not exist. The key has to stay the same
+// across restarts, as the hashes name immutable Secrets and trigger
+// rollouts when they change.
+func loadContentHashKey(client kubernetes.Interface, namespace, name string) ([]byte, error) {
+	secrets := client.CoreV1().Secrets(namespace)
+	secret, err := secrets.Get(name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		secret, err = secrets.Create(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Data:       map[string][]byte{contentHashKeyName: key},
+		})
+		// another replica may have created the Secret in the meantime
+		if errors.IsAlreadyExists(err) {
+			secret, err = secrets.Get(name, metav1.GetOptions{})
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	key := secret.Data[contentHashKeyName]
+	if len(key) < 16 {
+		return nil, fmt.Errorf("secret %s/%s has no content hash key of at least 16 bytes in %q", namespace, name, contentHashKeyName)
+	}
+	return key, nil
+}
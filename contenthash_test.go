@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_loadContentHashKey(t *testing.T) {
+	kubeclientset := fake.NewSimpleClientset()
+	key, err := loadContentHashKey(kubeclientset, "secret-controller", "content-hash")
+	if err != nil {
+		t.Fatalf("loadContentHashKey() error = %v", err)
+	}
+	if len(key) != 32 {
+		t.Errorf("loadContentHashKey() created a key of %d bytes, want 32", len(key))
+	}
+	// the key is kept across restarts
+	again, err := loadContentHashKey(kubeclientset, "secret-controller", "content-hash")
+	if err != nil {
+		t.Fatalf("loadContentHashKey() error = %v", err)
+	}
+	if !bytes.Equal(key, again) {
+		t.Errorf("loadContentHashKey() = %x, want the stored key %x", again, key)
+	}
+
+	kubeclientset.CoreV1().Secrets("secret-controller").Create(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "short", Namespace: "secret-controller"},
+		Data:       map[string][]byte{contentHashKeyName: []byte("short")},
+	})
+	if _, err := loadContentHashKey(kubeclientset, "secret-controller", "short"); err == nil {
+		t.Errorf("loadContentHashKey() accepted a short key")
+	}
+}
+
+func Test_hashData(t *testing.T) {
+	data := map[string][]byte{"password": []byte("secret")}
+	if hashData([]byte("key-1"), data) == hashData([]byte("key-2"), data) {
+		t.Errorf("hashData() returned the same hash for different keys")
+	}
+	if hashData([]byte("key-1"), data) != hashData([]byte("key-1"), map[string][]byte{"password": []byte("secret")}) {
+		t.Errorf("hashData() returned different hashes for the same data")
+	}
+}
+
+func Test_hashSecret(t *testing.T) {
+	secret := &corev1.Secret{Data: map[string][]byte{"password": []byte("secret")}}
+	labeled := secret.DeepCopy()
+	labeled.Labels = map[string]string{"team": "a"}
+	annotated := secret.DeepCopy()
+	annotated.Annotations = map[string]string{"team": "a"}
+	hashes := map[string]bool{}
+	for _, s := range []*corev1.Secret{secret, labeled, annotated} {
+		hashes[hashSecret(nil, s)] = true
+	}
+	if len(hashes) != 3 {
+		t.Errorf("hashSecret() returned the same hash for different metadata")
+	}
+}
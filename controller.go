@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
+	stderrors "errors"
 	"fmt"
+	"reflect"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -17,6 +23,7 @@ import (
 	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/client-go/util/workqueue"
 
 	keyvaultsecretv1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
@@ -24,7 +31,9 @@ import (
 	secretscheme "github.com/twendt/secret-controller/pkg/client/clientset/versioned/scheme"
 	informers "github.com/twendt/secret-controller/pkg/client/informers/externalversions/secretcontroller/v1alpha1"
 	listers "github.com/twendt/secret-controller/pkg/client/listers/secretcontroller/v1alpha1"
+	"github.com/twendt/secret-controller/pkg/policy"
 	"github.com/twendt/secret-controller/pkg/secretstore"
+	kubestore "github.com/twendt/secret-controller/pkg/secretstore/kubernetes"
 )
 
 const controllerAgentName = "secret-controller"
@@ -39,20 +48,97 @@ const (
 	// MessageSecretCreated is the message used for an Event fired when a KeyvaultSecret
 	// is created successfully
 	MessageSecretCreated = "Key Vault Secret created successfully"
+	// MessageResourceExists is the message used for Events when a resource
+	// fails to sync due to a Secret already existing
+	MessageResourceExists = "Resource %q already exists and is not managed by KeyvaultSecret"
+	// ErrResourceMissing is used as part of the Event 'reason' when a
+	// KeyvaultSecret with creation policy Merge fails to sync due to the
+	// Secret not existing
+	ErrResourceMissing = "ErrResourceMissing"
+	// MessageResourceMissing is the message used for Events when a resource
+	// fails to sync due to a Secret not existing
+	MessageResourceMissing = "Resource %q does not exist and the creation policy is Merge"
+	// SecretGenerated is used as part of the Event 'reason' when a missing
+	// Key Vault secret is created by a generator
+	SecretGenerated = "Generated"
+	// MessageSecretGenerated is the message used for an Event fired when a
+	// Key Vault secret is created by a generator
+	MessageSecretGenerated = "Key Vault secret %q created with a generated value"
+	// ErrAccessDenied is used as part of the Event 'reason' when a
+	// KeyvaultSecret references a Key Vault secret that the policy does not
+	// allow for its namespace
+	ErrAccessDenied = "ErrAccessDenied"
 )
 
+// policyConfigMapKey is the key of the policy in the policy ConfigMap
+const policyConfigMapKey = "policy.yaml"
+
+// Config holds the settings of a Controller
+type Config struct {
+	// SyncTimeout bounds the time a single KeyvaultSecret may take to sync
+	SyncTimeout time.Duration
+	// ShutdownTimeout is how long Run waits for busy workers to finish
+	// before in-flight requests are cancelled
+	ShutdownTimeout time.Duration
+	// Namespaces restricts the controller to KeyvaultSecrets in these
+	// namespaces. An empty list means all namespaces.
+	Namespaces []string
+	// PolicyConfigMap is the namespace/name of the ConfigMap holding the
+	// policy that maps namespaces to the Key Vault secrets they may read.
+	// An empty value allows every namespace to read every secret.
+	PolicyConfigMap string
+	// ValidityPolicy defines how disabled, expired and not yet valid
+	// secrets are treated
+	ValidityPolicy ValidityPolicy
+	// ExpiryWarning is how long before a secret expires Warning events are
+	// recorded
+	ExpiryWarning time.Duration
+	// VaultName is the name of the Key Vault the controller reads from.
+	// Event Grid notifications for other vaults are ignored. An empty value
+	// accepts notifications for any vault.
+	VaultName string
+	// KubernetesSources allows entries and templates to read the keys of
+	// other Secrets and ConfigMaps with kubernetes:// references
+	KubernetesSources bool
+	// ContentHashKey is the key of the HMACs over the content of Secrets
+	// that are stored in the status of resources and in the names of
+	// immutable Secrets
+	ContentHashKey []byte
+}
+
 // Controller is the controller implementation for KeyvaultSecret resources
 type Controller struct {
-	kubeclientset          kubernetes.Interface
-	crdclientset           clientset.Interface
-	keyvaultClient         secretstore.Client
-	keyvaultSecretInformer informers.KeyvaultSecretInformer
-	keyvaultSecretsLister  listers.KeyvaultSecretLister
-	keyvaultSecretsSynced  cache.InformerSynced
-	secretsLister          corelisters.SecretLister
-	workqueue              workqueue.RateLimitingInterface
-	recorder               record.EventRecorder
-	logger                 *logrus.Entry
+	config                        Config
+	kubeclientset                 kubernetes.Interface
+	crdclientset                  clientset.Interface
+	keyvaultClient                secretstore.Client
+	identities                    *identityStores
+	keyvaultSecretInformer        informers.KeyvaultSecretInformer
+	keyvaultSecretsLister         listers.KeyvaultSecretLister
+	keyvaultSecretsSynced         cache.InformerSynced
+	keyvaultSecretIndexer         cache.Indexer
+	secretInformer                coreinformers.SecretInformer
+	secretsLister                 corelisters.SecretLister
+	secretsSynced                 cache.InformerSynced
+	pushSecretInformer            informers.PushSecretInformer
+	pushSecretsLister             listers.PushSecretLister
+	pushSecretsSynced             cache.InformerSynced
+	clusterKeyvaultSecretInformer informers.ClusterKeyvaultSecretInformer
+	clusterKeyvaultSecretsLister  listers.ClusterKeyvaultSecretLister
+	clusterKeyvaultSecretsSynced  cache.InformerSynced
+	namespaceInformer             coreinformers.NamespaceInformer
+	namespacesLister              corelisters.NamespaceLister
+	namespacesSynced              cache.InformerSynced
+	policyInformer                coreinformers.ConfigMapInformer
+	configMapInformer             coreinformers.ConfigMapInformer
+	configMapsLister              corelisters.ConfigMapLister
+	configMapsSynced              cache.InformerSynced
+	workqueue                     workqueue.RateLimitingInterface
+	pushWorkqueue                 workqueue.RateLimitingInterface
+	clusterWorkqueue              workqueue.RateLimitingInterface
+	recorder                      record.EventRecorder
+	logger                        *logrus.Entry
+	now                           func() time.Time
 }
 
 // NewController returns a new controller
@@ -61,7 +147,14 @@ func NewController(
 	crdclientset clientset.Interface,
 	kubeInformer coreinformers.SecretInformer,
 	keyvaultSecretInformer informers.KeyvaultSecretInformer,
+	pushSecretInformer informers.PushSecretInformer,
+	clusterKeyvaultSecretInformer informers.ClusterKeyvaultSecretInformer,
+	namespaceInformer coreinformers.NamespaceInformer,
+	policyInformer coreinformers.ConfigMapInformer,
+	configMapInformer coreinformers.ConfigMapInformer,
 	keyvaultClient secretstore.Client,
+	identities *identityStores,
+	config Config,
 	logger *logrus.Entry) *Controller {
 
 	utilruntime.Must(secretscheme.AddToScheme(scheme.Scheme))
@@ -72,17 +165,51 @@ func NewController(
 	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeclientset.CoreV1().Events("")})
 	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: controllerAgentName})
 
+	utilruntime.Must(keyvaultSecretInformer.Informer().AddIndexers(cache.Indexers{
+		keyvaultNameIndex: indexByKeyvaultName,
+	}))
+	if config.KubernetesSources {
+		utilruntime.Must(keyvaultSecretInformer.Informer().AddIndexers(cache.Indexers{
+			kubernetesSourceIndex: indexByKubernetesSource,
+		}))
+	}
+
 	controller := &Controller{
-		kubeclientset:          kubeclientset,
-		crdclientset:           crdclientset,
-		keyvaultClient:         keyvaultClient,
-		keyvaultSecretInformer: keyvaultSecretInformer,
-		keyvaultSecretsLister:  keyvaultSecretInformer.Lister(),
-		keyvaultSecretsSynced:  keyvaultSecretInformer.Informer().HasSynced,
-		secretsLister:          kubeInformer.Lister(),
-		workqueue:              workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "KeyvaultSecrets"),
-		recorder:               recorder,
-		logger:                 logger,
+		config:                        config,
+		kubeclientset:                 kubeclientset,
+		crdclientset:                  crdclientset,
+		keyvaultClient:                keyvaultClient,
+		identities:                    identities,
+		keyvaultSecretInformer:        keyvaultSecretInformer,
+		keyvaultSecretsLister:         keyvaultSecretInformer.Lister(),
+		keyvaultSecretsSynced:         keyvaultSecretInformer.Informer().HasSynced,
+		keyvaultSecretIndexer:         keyvaultSecretInformer.Informer().GetIndexer(),
+		secretInformer:                kubeInformer,
+		secretsLister:                 kubeInformer.Lister(),
+		secretsSynced:                 kubeInformer.Informer().HasSynced,
+		pushSecretInformer:            pushSecretInformer,
+		pushSecretsLister:             pushSecretInformer.Lister(),
+		pushSecretsSynced:             pushSecretInformer.Informer().HasSynced,
+		clusterKeyvaultSecretInformer: clusterKeyvaultSecretInformer,
+		namespaceInformer:             namespaceInformer,
+		policyInformer:                policyInformer,
+		configMapInformer:             configMapInformer,
+		workqueue:                     workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "KeyvaultSecrets"),
+		pushWorkqueue:                 workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "PushSecrets"),
+		clusterWorkqueue:              workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "ClusterKeyvaultSecrets"),
+		recorder:                      recorder,
+		logger:                        logger,
+		now:                           time.Now,
+	}
+	if clusterKeyvaultSecretInformer != nil {
+		controller.clusterKeyvaultSecretsLister = clusterKeyvaultSecretInformer.Lister()
+		controller.clusterKeyvaultSecretsSynced = clusterKeyvaultSecretInformer.Informer().HasSynced
+		controller.namespacesLister = namespaceInformer.Lister()
+		controller.namespacesSynced = namespaceInformer.Informer().HasSynced
+	}
+	if configMapInformer != nil {
+		controller.configMapsLister = configMapInformer.Lister()
+		controller.configMapsSynced = configMapInformer.Informer().HasSynced
 	}
 
 	return controller
@@ -92,71 +219,330 @@ func NewController(
 func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 	defer runtime.HandleCrash()
 	defer c.workqueue.ShutDown()
+	defer c.pushWorkqueue.ShutDown()
+	defer c.clusterWorkqueue.ShutDown()
 
 	c.logger.Info("Starting Secret controller")
 	c.setupWatches()
 
 	c.logger.Info("Waiting for informer caches to sync")
-	if ok := cache.WaitForCacheSync(stopCh, c.keyvaultSecretsSynced); !ok {
+	cacheSyncs := []cache.InformerSynced{c.keyvaultSecretsSynced, c.secretsSynced, c.pushSecretsSynced}
+	if c.clusterKeyvaultSecretInformer != nil {
+		cacheSyncs = append(cacheSyncs, c.clusterKeyvaultSecretsSynced, c.namespacesSynced)
+	}
+	if c.policyInformer != nil {
+		cacheSyncs = append(cacheSyncs, c.policyInformer.Informer().HasSynced)
+	}
+	if c.configMapsSynced != nil {
+		cacheSyncs = append(cacheSyncs, c.configMapsSynced)
+	}
+	if ok := cache.WaitForCacheSync(stopCh, cacheSyncs...); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
+	// ctx is only cancelled when the workers do not drain the queue within
+	// the shutdown timeout, so that a shutdown does not interrupt a sync that
+	// is about to finish
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	c.logger.Info("Starting workers")
+	var wg sync.WaitGroup
 	for i := 0; i < threadiness; i++ {
-		go wait.Until(c.runWorker, time.Second, stopCh)
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			wait.Until(func() { c.runWorker(ctx, c.workqueue, c.secretHandler) }, time.Second, stopCh)
+		}()
+		go func() {
+			defer wg.Done()
+			wait.Until(func() { c.runWorker(ctx, c.pushWorkqueue, c.pushSecretHandler) }, time.Second, stopCh)
+		}()
+		go func() {
+			defer wg.Done()
+			wait.Until(func() { c.runWorker(ctx, c.clusterWorkqueue, c.clusterKeyvaultSecretHandler) }, time.Second, stopCh)
+		}()
 	}
 
 	c.logger.Info("Started workers")
 	<-stopCh
 	c.logger.Info("Shutting down workers")
+	c.workqueue.ShutDown()
+	c.pushWorkqueue.ShutDown()
+	c.clusterWorkqueue.ShutDown()
+
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(c.config.ShutdownTimeout):
+		c.logger.Warn("Workers did not finish in time, cancelling in-flight requests")
+		cancel()
+		<-drained
+	}
+	c.logger.Info("Workers stopped")
 
 	return nil
 }
 
 func (c *Controller) setupWatches() {
 	c.logger.Info("Setting up event handlers")
-	c.keyvaultSecretInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
-			var key string
-			var err error
-			if key, err = cache.MetaNamespaceKeyFunc(obj); err != nil {
-				runtime.HandleError(err)
-				return
-			}
-			c.workqueue.AddRateLimited(key)
+	c.keyvaultSecretInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: c.inNamespaces,
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				var key string
+				var err error
+				if key, err = cache.MetaNamespaceKeyFunc(obj); err != nil {
+					runtime.HandleError(err)
+					return
+				}
+				c.workqueue.AddRateLimited(key)
+			},
+			UpdateFunc: func(old, new interface{}) {
+				oldObj := old.(*keyvaultsecretv1alpha1.KeyvaultSecret)
+				newObj := new.(*keyvaultsecretv1alpha1.KeyvaultSecret)
+				if oldObj.GetResourceVersion() != newObj.GetResourceVersion() {
+					c.enqueueKeyvaultSecret(new)
+					c.handleClusterOwnedKeyvaultSecret(new)
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				var key string
+				var err error
+				if key, err = cache.DeletionHandlingMetaNamespaceKeyFunc(obj); err != nil {
+					runtime.HandleError(err)
+					return
+				}
+				c.workqueue.AddRateLimited(key)
+				c.handleClusterOwnedKeyvaultSecret(obj)
+			},
 		},
-		UpdateFunc: func(old, new interface{}) {
-			oldObj := old.(*keyvaultsecretv1alpha1.KeyvaultSecret)
-			newObj := new.(*keyvaultsecretv1alpha1.KeyvaultSecret)
-			if oldObj.GetResourceVersion() != newObj.GetResourceVersion() {
-				c.enqueueKeyvaultSecret(new)
-			}
+	})
+	c.pushSecretInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: c.inNamespaces,
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc: c.enqueuePushSecret,
+			UpdateFunc: func(old, new interface{}) {
+				oldObj := old.(*keyvaultsecretv1alpha1.PushSecret)
+				newObj := new.(*keyvaultsecretv1alpha1.PushSecret)
+				if oldObj.GetResourceVersion() != newObj.GetResourceVersion() {
+					c.enqueuePushSecret(new)
+				}
+			},
 		},
-		DeleteFunc: func(obj interface{}) {
-			var key string
-			var err error
-			if key, err = cache.DeletionHandlingMetaNamespaceKeyFunc(obj); err != nil {
-				runtime.HandleError(err)
-				return
-			}
-			c.workqueue.AddRateLimited(key)
+	})
+	// Secrets are watched so that generated Secrets which are deleted or
+	// edited by hand are restored from their KeyvaultSecret, and so that
+	// changes of pushed Secrets are written to Key Vault
+	c.secretInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: c.inNamespaces,
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				c.handleSecret(obj)
+				c.handlePushedSecret(obj)
+			},
+			UpdateFunc: func(old, new interface{}) {
+				oldObj := old.(*corev1.Secret)
+				newObj := new.(*corev1.Secret)
+				if oldObj.GetResourceVersion() != newObj.GetResourceVersion() {
+					c.handleSecret(new)
+					c.handlePushedSecret(new)
+				}
+			},
+			DeleteFunc: c.handleSecret,
 		},
 	})
+	if c.clusterKeyvaultSecretInformer != nil {
+		c.clusterKeyvaultSecretInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: c.enqueueClusterKeyvaultSecret,
+			UpdateFunc: func(old, new interface{}) {
+				oldObj := old.(*keyvaultsecretv1alpha1.ClusterKeyvaultSecret)
+				newObj := new.(*keyvaultsecretv1alpha1.ClusterKeyvaultSecret)
+				if oldObj.GetResourceVersion() != newObj.GetResourceVersion() {
+					c.enqueueClusterKeyvaultSecret(new)
+				}
+			},
+		})
+		// new namespaces and changed labels change the namespaces that
+		// ClusterKeyvaultSecrets select
+		c.namespaceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) { c.enqueueAllClusterKeyvaultSecrets() },
+			UpdateFunc: func(old, new interface{}) {
+				oldObj := old.(*corev1.Namespace)
+				newObj := new.(*corev1.Namespace)
+				if !reflect.DeepEqual(oldObj.Labels, newObj.Labels) || oldObj.Status.Phase != newObj.Status.Phase {
+					c.enqueueAllClusterKeyvaultSecrets()
+				}
+			},
+			DeleteFunc: func(obj interface{}) { c.enqueueAllClusterKeyvaultSecrets() },
+		})
+	}
+	// a changed policy may grant or revoke access for any KeyvaultSecret
+	if c.policyInformer != nil {
+		c.policyInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { c.enqueueAll() },
+			UpdateFunc: func(old, new interface{}) { c.enqueueAll() },
+			DeleteFunc: func(obj interface{}) { c.enqueueAll() },
+		})
+	}
+	// KeyvaultSecrets that read other Secrets and ConfigMaps are synced when
+	// those change, even outside of the watched namespaces
+	if c.config.KubernetesSources {
+		c.secretInformer.Informer().AddEventHandler(c.kubernetesSourceHandler(kubestore.KindSecret))
+		if c.configMapInformer != nil {
+			c.configMapInformer.Informer().AddEventHandler(c.kubernetesSourceHandler(kubestore.KindConfigMap))
+		}
+	}
+}
+
+// inNamespaces reports whether obj is in one of the namespaces the controller
+// is restricted to
+func (c *Controller) inNamespaces(obj interface{}) bool {
+	if len(c.config.Namespaces) == 0 {
+		return true
+	}
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	object, ok := obj.(metav1.Object)
+	if !ok {
+		return false
+	}
+	for _, namespace := range c.config.Namespaces {
+		if object.GetNamespace() == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueueAll enqueues all KeyvaultSecrets and PushSecrets
+func (c *Controller) enqueueAll() {
+	keyvaultSecrets, err := c.keyvaultSecretsLister.List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	for _, keyvaultSecret := range keyvaultSecrets {
+		if c.inNamespaces(keyvaultSecret) {
+			c.enqueueKeyvaultSecret(keyvaultSecret)
+		}
+	}
+	pushSecrets, err := c.pushSecretsLister.List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	for _, pushSecret := range pushSecrets {
+		if c.inNamespaces(pushSecret) {
+			c.enqueuePushSecret(pushSecret)
+		}
+	}
+}
+
+// loadPolicy returns the policy from the policy ConfigMap. A missing
+// ConfigMap denies all access, nil is returned if no policy is configured.
+func (c *Controller) loadPolicy() (*policy.Policy, error) {
+	if c.policyInformer == nil {
+		return nil, nil
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(c.config.PolicyConfigMap)
+	if err != nil {
+		return nil, err
+	}
+	configMap, err := c.policyInformer.Lister().ConfigMaps(namespace).Get(name)
+	if errors.IsNotFound(err) {
+		c.logger.Warnf("policy configmap '%s' not found, denying access to all secrets", c.config.PolicyConfigMap)
+		return &policy.Policy{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return policy.Parse([]byte(configMap.Data[policyConfigMapKey]))
+}
+
+// storeClientFor returns the store client for the KeyvaultSecrets and
+// PushSecrets in namespace, which enforces the policy. Without a policy all
+// secrets may be read and none written.
+// If serviceAccountName is set, the client authenticates as the identity of
+// that ServiceAccount. With KubernetesSources the client also reads the
+// Secrets and ConfigMaps that the policy allows.
+func (c *Controller) storeClientFor(namespace, serviceAccountName string) (secretstore.Client, error) {
+	p, err := c.loadPolicy()
+	if err != nil {
+		return nil, err
+	}
+	storeClient := c.keyvaultClient
+	if serviceAccountName != "" {
+		if c.identities == nil {
+			return nil, fmt.Errorf("ServiceAccount identities are only supported by the keyvault store")
+		}
+		storeClient, err = c.identities.storeClientFor(namespace, serviceAccountName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// writes are denied even if no policy is configured
+	storeClient = policy.NewClient(storeClient, p, namespace)
+	if c.config.KubernetesSources {
+		storeClient = kubestore.NewClient(storeClient, c.secretsLister, c.configMapsLister, namespace, p)
+	}
+	return storeClient, nil
+}
+
+// handleSecret enqueues the KeyvaultSecret that controls the given Secret.
+// Secrets without a KeyvaultSecret as controller are ignored.
+func (c *Controller) handleSecret(obj interface{}) {
+	var object metav1.Object
+	var ok bool
+	if object, ok = obj.(metav1.Object); !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			runtime.HandleError(fmt.Errorf("error decoding object, invalid type"))
+			return
+		}
+		object, ok = tombstone.Obj.(metav1.Object)
+		if !ok {
+			runtime.HandleError(fmt.Errorf("error decoding object tombstone, invalid type"))
+			return
+		}
+	}
+
+	ownerRef := metav1.GetControllerOf(object)
+	if ownerRef == nil || ownerRef.Kind != "KeyvaultSecret" {
+		return
+	}
+	keyvaultSecret, err := c.keyvaultSecretsLister.KeyvaultSecrets(object.GetNamespace()).Get(ownerRef.Name)
+	if err != nil {
+		c.logger.Debugf("ignoring orphaned secret '%s/%s' of keyvault secret '%s'", object.GetNamespace(), object.GetName(), ownerRef.Name)
+		return
+	}
+	if keyvaultSecret.UID != ownerRef.UID {
+		return
+	}
+	c.enqueueKeyvaultSecret(keyvaultSecret)
 }
 
-func (c *Controller) runWorker() {
-	for c.processNextWorkItem() {
+// syncHandler syncs the resource with the given namespace/name key
+type syncHandler func(ctx context.Context, key string) error
+
+func (c *Controller) runWorker(ctx context.Context, queue workqueue.RateLimitingInterface, handler syncHandler) {
+	for c.processNextWorkItem(ctx, queue, handler) {
 	}
 }
 
-func (c *Controller) processNextWorkItem() bool {
-	obj, shutdown := c.workqueue.Get()
+func (c *Controller) processNextWorkItem(ctx context.Context, queue workqueue.RateLimitingInterface, handler syncHandler) bool {
+	obj, shutdown := queue.Get()
 
 	if shutdown {
 		return false
 	}
 
-	err := c.processItem(obj)
+	err := c.processItem(ctx, queue, handler, obj)
 	if err != nil {
 		runtime.HandleError(err)
 		return true
@@ -165,25 +551,27 @@ func (c *Controller) processNextWorkItem() bool {
 	return true
 }
 
-func (c *Controller) processItem(obj interface{}) error {
-	defer c.workqueue.Done(obj)
+func (c *Controller) processItem(ctx context.Context, queue workqueue.RateLimitingInterface, handler syncHandler, obj interface{}) error {
+	defer queue.Done(obj)
 	var key string
 	var ok bool
 	if key, ok = obj.(string); !ok {
-		c.workqueue.Forget(obj)
+		queue.Forget(obj)
 		runtime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
 		return nil
 	}
-	if err := c.secretHandler(key); err != nil {
-		c.workqueue.AddRateLimited(key)
+	ctx, cancel := context.WithTimeout(ctx, c.config.SyncTimeout)
+	defer cancel()
+	if err := handler(ctx, key); err != nil {
+		queue.AddRateLimited(key)
 		return fmt.Errorf("error syncing '%s': %s, requeuing", key, err.Error())
 	}
-	c.workqueue.Forget(obj)
+	queue.Forget(obj)
 	c.logger.Infof("Successfully synced '%s'", key)
 	return nil
 }
 
-func (c *Controller) secretHandler(key string) error {
+func (c *Controller) secretHandler(ctx context.Context, key string) error {
 	// Convert the namespace/name string into a distinct namespace and name
 	namespace, secretName, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
@@ -194,38 +582,201 @@ func (c *Controller) secretHandler(key string) error {
 	keyvaultSecret, err := c.keyvaultSecretsLister.KeyvaultSecrets(namespace).Get(secretName)
 	if errors.IsNotFound(err) {
 		c.logger.Infof("keyvault secret key '%s' deleted", key)
+		secretExpiry.DeleteLabelValues(namespace, secretName)
 		return nil
 	}
 	if err != nil {
 		return err
 	}
+	if _, ok := keyvaultSecret.Annotations[rollbackAnnotation]; ok {
+		return c.rollback(keyvaultSecret)
+	}
 
-	if err := c.createOrUpdateSecret(keyvaultSecret); err != nil {
+	storeClient, err := c.storeClientFor(namespace, keyvaultSecret.Spec.ServiceAccountName)
+	if err != nil {
 		return err
 	}
-	c.recorder.Event(keyvaultSecret, corev1.EventTypeNormal, SecretCreated, MessageSecretCreated)
-	return nil
-}
 
-func (c *Controller) createOrUpdateSecret(keyvaultSecret *keyvaultsecretv1alpha1.KeyvaultSecret) error {
-	converter := SecretConverter{keyvaultSecret: keyvaultSecret, storeClient: c.keyvaultClient}
+	converter := SecretConverter{ctx: ctx, keyvaultSecret: keyvaultSecret, storeClient: storeClient, hashKey: c.config.ContentHashKey}
 	secret, err := converter.getK8sSecret()
+	for _, name := range converter.generated {
+		c.recorder.Eventf(keyvaultSecret, corev1.EventTypeNormal, SecretGenerated, MessageSecretGenerated, name)
+	}
+	if policy.IsAccessDenied(err) {
+		c.recorder.Event(keyvaultSecret, corev1.EventTypeWarning, ErrAccessDenied, err.Error())
+		return err
+	}
+	// the value of a disabled secret cannot be read, so it fails the sync
+	// regardless of the validity policy
+	if secretstore.IsDisabled(err) {
+		c.recorder.Event(keyvaultSecret, corev1.EventTypeWarning, ErrSecretInvalid, err.Error())
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	c.recordFailover(keyvaultSecret, converter.secrets)
+	next, err := c.checkValidity(keyvaultSecret, converter.secrets)
+	next = earliest(next, converter.resync)
+	if !next.IsZero() {
+		// sync again when a secret becomes valid, expires or is about to
+		c.workqueue.AddAfter(key, next.Sub(c.now()))
+	}
 	if err != nil {
 		return err
 	}
-	_, err = c.kubeclientset.CoreV1().Secrets(keyvaultSecret.Namespace).Update(secret)
+	if keyvaultSecret.Spec.Target.GetCreationPolicy() == keyvaultsecretv1alpha1.CreationPolicyNone {
+		return nil
+	}
+
+	changed, err := c.createOrUpdateSecret(keyvaultSecret, secret)
+	if err != nil {
+		return err
+	}
+	if changed {
+		c.recorder.Event(keyvaultSecret, corev1.EventTypeNormal, SecretCreated, MessageSecretCreated)
+	}
+
+	status := keyvaultsecretv1alpha1.KeyvaultSecretStatus{
+		SecretName:    secret.Name,
+		ContentHash:   hashData(c.config.ContentHashKey, secret.Data),
+		Items:         converter.resolved,
+		PreviousItems: keyvaultSecret.Status.PreviousItems,
+	}
+	if previous := keyvaultSecret.Status.Items; len(previous) > 0 && !reflect.DeepEqual(previous, status.Items) {
+		status.PreviousItems = previous
+	}
+	// workloads are only restarted when the content changed after the first
+	// sync, as they could not have started without the Secret before
+	if previous := keyvaultSecret.Status.ContentHash; previous != "" && previous != status.ContentHash {
+		if err := c.rolloutWorkloads(keyvaultSecret, secret, status.ContentHash); err != nil {
+			return err
+		}
+	}
+	return c.updateStatus(keyvaultSecret, status)
+}
+
+// createOrUpdateSecret writes secret according to the creation policy of
+// keyvaultSecret. The returned bool reports whether the Secret was written.
+func (c *Controller) createOrUpdateSecret(keyvaultSecret *keyvaultsecretv1alpha1.KeyvaultSecret, secret *corev1.Secret) (bool, error) {
+	var changed bool
+	var err error
+	if keyvaultSecret.Spec.Target.Immutable {
+		changed, err = c.createImmutableSecret(keyvaultSecret, secret)
+	} else {
+		changed, err = c.writeSecret(keyvaultSecret, secret)
+	}
 	if err != nil {
+		c.logger.Errorf("Failed to write secret %s : %s", secret.Name, err)
+	}
+	return changed, err
+}
+
+// writeSecret creates the Secret or merges it into the existing one, so that
+// labels, annotations and keys added by other tools survive. Nothing is
+// written when the existing Secret is already up to date.
+func (c *Controller) writeSecret(keyvaultSecret *keyvaultsecretv1alpha1.KeyvaultSecret, secret *corev1.Secret) (bool, error) {
+	mergeOnly := keyvaultSecret.Spec.Target.GetCreationPolicy() == keyvaultsecretv1alpha1.CreationPolicyMerge
+	secrets := c.kubeclientset.CoreV1().Secrets(secret.Namespace)
+	getExisting := func() (*corev1.Secret, error) {
+		return c.secretsLister.Secrets(secret.Namespace).Get(secret.Name)
+	}
+	changed := false
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		existing, err := getExisting()
+		// after a conflict the lister may still return the outdated Secret
+		getExisting = func() (*corev1.Secret, error) {
+			return secrets.Get(secret.Name, metav1.GetOptions{})
+		}
+		if errors.IsNotFound(err) && mergeOnly {
+			msg := fmt.Sprintf(MessageResourceMissing, secret.Name)
+			c.recorder.Event(keyvaultSecret, corev1.EventTypeWarning, ErrResourceMissing, msg)
+			return stderrors.New(msg)
+		}
 		if errors.IsNotFound(err) {
-			_, err = c.kubeclientset.CoreV1().Secrets(keyvaultSecret.Namespace).Create(secret)
-			if err != nil {
-				c.logger.Errorf("Failed to create secret %s : %s", secret.Name, err)
-				return err
+			_, err = secrets.Create(secret)
+			if errors.IsAlreadyExists(err) {
+				return errors.NewConflict(corev1.Resource("secrets"), secret.Name, err)
 			}
+			changed = err == nil
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		// with the creation policy Owner the Secret has to be one that the
+		// KeyvaultSecret created, an existing Secret is only written to with
+		// the policy Merge
+		if owner := metav1.GetControllerOf(existing); !mergeOnly && (owner == nil || owner.UID != keyvaultSecret.UID) {
+			msg := fmt.Sprintf(MessageResourceExists, existing.Name)
+			c.recorder.Event(keyvaultSecret, corev1.EventTypeWarning, ErrResourceExists, msg)
+			return stderrors.New(msg)
+		}
+
+		merged, needsUpdate := mergeSecret(existing, secret)
+		if !needsUpdate {
 			return nil
 		}
+		_, err = secrets.Update(merged)
+		changed = err == nil
 		return err
+	})
+	return changed, err
+}
+
+// createImmutableSecret creates the Secret unless it already exists. As the
+// name of an immutable Secret contains a hash of its content and metadata,
+// an existing Secret is always up to date. Once a new version has been
+// created, all older versions except the previous one are deleted.
+func (c *Controller) createImmutableSecret(keyvaultSecret *keyvaultsecretv1alpha1.KeyvaultSecret, secret *corev1.Secret) (bool, error) {
+	_, err := c.secretsLister.Secrets(secret.Namespace).Get(secret.Name)
+	if err == nil {
+		return false, nil
 	}
-	return nil
+	if !errors.IsNotFound(err) {
+		return false, err
+	}
+	_, err = c.kubeclientset.CoreV1().Secrets(secret.Namespace).Create(secret)
+	if errors.IsAlreadyExists(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	selector := labels.SelectorFromSet(labels.Set{keyvaultSecretLabel: keyvaultSecret.Name})
+	versions, err := c.secretsLister.Secrets(secret.Namespace).List(selector)
+	if err != nil {
+		return true, err
+	}
+	for _, version := range versions {
+		if version.Name == secret.Name || version.Name == keyvaultSecret.Status.SecretName {
+			continue
+		}
+		if owner := metav1.GetControllerOf(version); owner == nil || owner.UID != keyvaultSecret.UID {
+			continue
+		}
+		err := c.kubeclientset.CoreV1().Secrets(version.Namespace).Delete(version.Name, &metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// updateStatus writes status to keyvaultSecret if it changed. The
+// KeyvaultSecret CRD has no status subresource, so the whole resource is
+// updated.
+func (c *Controller) updateStatus(keyvaultSecret *keyvaultsecretv1alpha1.KeyvaultSecret, status keyvaultsecretv1alpha1.KeyvaultSecretStatus) error {
+	if reflect.DeepEqual(keyvaultSecret.Status, status) {
+		return nil
+	}
+	// NEVER modify objects from the store. It's a read-only, local cache.
+	keyvaultSecretCopy := keyvaultSecret.DeepCopy()
+	keyvaultSecretCopy.Status = status
+	_, err := c.crdclientset.SecretcontrollerV1alpha1().KeyvaultSecrets(keyvaultSecret.Namespace).Update(keyvaultSecretCopy)
+	return err
 }
 
 func (c *Controller) enqueueKeyvaultSecret(obj interface{}) {
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+
+	keyvaultsecretv1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+	listers "github.com/twendt/secret-controller/pkg/client/listers/secretcontroller/v1alpha1"
+)
+
+func newTestController(keyvaultSecrets ...*keyvaultsecretv1alpha1.KeyvaultSecret) *Controller {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, keyvaultSecret := range keyvaultSecrets {
+		indexer.Add(keyvaultSecret)
+	}
+	return &Controller{
+		keyvaultSecretsLister: listers.NewKeyvaultSecretLister(indexer),
+		workqueue:             workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "KeyvaultSecrets"),
+		logger:                logrus.NewEntry(logrus.New()),
+	}
+}
+
+func Test_handleSecret(t *testing.T) {
+	keyvaultSecret := &keyvaultsecretv1alpha1.KeyvaultSecret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-secret",
+			Namespace: "test-namespace",
+			UID:       "uid",
+		},
+	}
+	controllerRef := metav1.NewControllerRef(keyvaultSecret, schema.GroupVersionKind{
+		Group:   keyvaultsecretv1alpha1.SchemeGroupVersion.Group,
+		Version: keyvaultsecretv1alpha1.SchemeGroupVersion.Version,
+		Kind:    "KeyvaultSecret",
+	})
+	staleRef := controllerRef.DeepCopy()
+	staleRef.UID = "other-uid"
+
+	newSecret := func(refs ...metav1.OwnerReference) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "test-secret",
+				Namespace:       "test-namespace",
+				OwnerReferences: refs,
+			},
+		}
+	}
+	tests := []struct {
+		name string
+		obj  interface{}
+		want bool
+	}{
+		{
+			name: "owned secret",
+			obj:  newSecret(*controllerRef),
+			want: true,
+		},
+		{
+			name: "deleted owned secret",
+			obj:  cache.DeletedFinalStateUnknown{Key: "test-namespace/test-secret", Obj: newSecret(*controllerRef)},
+			want: true,
+		},
+		{
+			name: "secret without owner",
+			obj:  newSecret(),
+		},
+		{
+			name: "secret owned by a previous keyvault secret with the same name",
+			obj:  newSecret(*staleRef),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(keyvaultSecret)
+			defer c.workqueue.ShutDown()
+			c.handleSecret(tt.obj)
+
+			// the rate limiter counts every key passed to AddRateLimited
+			if got := c.workqueue.NumRequeues("test-namespace/test-secret") > 0; got != tt.want {
+				t.Errorf("handleSecret() enqueued = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_writeSecret(t *testing.T) {
+	keyvaultSecret := &keyvaultsecretv1alpha1.KeyvaultSecret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-secret",
+			Namespace: "test-namespace",
+			UID:       "uid",
+		},
+	}
+	controllerRef := metav1.NewControllerRef(keyvaultSecret, schema.GroupVersionKind{
+		Group:   keyvaultsecretv1alpha1.SchemeGroupVersion.Group,
+		Version: keyvaultsecretv1alpha1.SchemeGroupVersion.Version,
+		Kind:    "KeyvaultSecret",
+	})
+	staleRef := controllerRef.DeepCopy()
+	staleRef.UID = "other-uid"
+
+	newSecret := func(value string, refs ...metav1.OwnerReference) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "test-secret",
+				Namespace:       "test-namespace",
+				OwnerReferences: refs,
+			},
+			Data: map[string][]byte{"password": []byte(value)},
+		}
+	}
+	tests := []struct {
+		name     string
+		existing *corev1.Secret
+		policy   keyvaultsecretv1alpha1.CreationPolicy
+		wantErr  bool
+		want     string
+	}{
+		{name: "new secret", want: "new"},
+		{name: "owned secret", existing: newSecret("old", *controllerRef), want: "new"},
+		{name: "secret without owner", existing: newSecret("old"), wantErr: true, want: "old"},
+		{name: "secret of another owner", existing: newSecret("old", *staleRef), wantErr: true, want: "old"},
+		{name: "merge into secret without owner", existing: newSecret("old"), policy: keyvaultsecretv1alpha1.CreationPolicyMerge, want: "new"},
+		{name: "merge into missing secret", policy: keyvaultsecretv1alpha1.CreationPolicyMerge, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kubeclientset := fake.NewSimpleClientset()
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			if tt.existing != nil {
+				kubeclientset.CoreV1().Secrets(tt.existing.Namespace).Create(tt.existing)
+				indexer.Add(tt.existing)
+			}
+			c := &Controller{
+				kubeclientset: kubeclientset,
+				secretsLister: corelisters.NewSecretLister(indexer),
+				recorder:      record.NewFakeRecorder(10),
+				logger:        logrus.NewEntry(logrus.New()),
+			}
+			target := keyvaultSecret.DeepCopy()
+			target.Spec.Target.CreationPolicy = tt.policy
+
+			_, err := c.writeSecret(target, newSecret("new", *controllerRef))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("writeSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got, err := kubeclientset.CoreV1().Secrets("test-namespace").Get("test-secret", metav1.GetOptions{})
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("writeSecret() created the secret")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value := string(got.Data["password"]); value != tt.want {
+				t.Errorf("writeSecret() wrote %q, want %q", value, tt.want)
+			}
+		})
+	}
+}
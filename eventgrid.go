@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+
+	keyvaultsecretv1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+// keyvaultNameIndex indexes KeyvaultSecrets by the lower-cased names of the
+// Key Vault secrets they reference
+const keyvaultNameIndex = "keyvaultName"
+
+const (
+	eventTypeSubscriptionValidation = "Microsoft.EventGrid.SubscriptionValidationEvent"
+	eventTypeSecretNewVersion       = "Microsoft.KeyVault.SecretNewVersionCreated"
+	eventTypeSecretNearExpiry       = "Microsoft.KeyVault.SecretNearExpiry"
+
+	// maxEventBatchSize is the largest batch of events Event Grid delivers
+	maxEventBatchSize = 1 << 20
+)
+
+// eventGridEvent holds the fields of an event in the Event Grid schema and
+// in the CloudEvents schema that the controller uses
+type eventGridEvent struct {
+	// EventType is set in the Event Grid schema
+	EventType string `json:"eventType"`
+	// Type is set in the CloudEvents schema
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// keyvaultEventData is the data of a Key Vault event
+type keyvaultEventData struct {
+	VaultName  string `json:"VaultName"`
+	ObjectType string `json:"ObjectType"`
+	ObjectName string `json:"ObjectName"`
+	Version    string `json:"Version"`
+}
+
+// subscriptionValidationData is the data of the event Event Grid sends to
+// validate a subscription in the Event Grid schema
+type subscriptionValidationData struct {
+	ValidationCode string `json:"validationCode"`
+}
+
+// indexByKeyvaultName returns the names of the Key Vault secrets referenced
+// by the entries of a KeyvaultSecret and recorded in its status. Secrets that
+// are only read from templates are not indexed.
+func indexByKeyvaultName(obj interface{}) ([]string, error) {
+	keyvaultSecret, ok := obj.(*keyvaultsecretv1alpha1.KeyvaultSecret)
+	if !ok {
+		return nil, nil
+	}
+	seen := make(map[string]bool)
+	var names []string
+	add := func(name string) {
+		name = strings.ToLower(name)
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, item := range keyvaultSecret.Spec.Items {
+		add(item.KeyvaultName)
+	}
+	for _, item := range keyvaultSecret.Status.Items {
+		add(item.KeyvaultName)
+	}
+	return names, nil
+}
+
+// eventGridHandler returns the webhook that receives Key Vault events from
+// Event Grid, in the Event Grid or the CloudEvents schema. The KeyvaultSecrets
+// that reference the secret of an event are synced immediately. If token is
+// not empty, requests must pass it in the token query parameter.
+func (c *Controller) eventGridHandler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(token)) != 1 {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodOptions:
+			// abuse protection handshake of CloudEvents webhooks
+			origin := r.Header.Get("WebHook-Request-Origin")
+			if origin == "" {
+				http.Error(w, "missing WebHook-Request-Origin", http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("WebHook-Allowed-Origin", origin)
+			w.WriteHeader(http.StatusOK)
+			return
+		case http.MethodPost:
+		default:
+			w.Header().Set("Allow", "OPTIONS, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		events, err := readEvents(io.LimitReader(r.Body, maxEventBatchSize))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid events: %s", err), http.StatusBadRequest)
+			return
+		}
+		for _, event := range events {
+			eventType := event.Type
+			if eventType == "" {
+				eventType = event.EventType
+			}
+			switch eventType {
+			case eventTypeSubscriptionValidation:
+				var data subscriptionValidationData
+				if err := json.Unmarshal(event.Data, &data); err != nil || data.ValidationCode == "" {
+					http.Error(w, "invalid subscription validation event", http.StatusBadRequest)
+					return
+				}
+				c.logger.Info("Validating Event Grid subscription")
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(map[string]string{"validationResponse": data.ValidationCode})
+				return
+			case eventTypeSecretNewVersion, eventTypeSecretNearExpiry:
+				var data keyvaultEventData
+				if err := json.Unmarshal(event.Data, &data); err != nil {
+					http.Error(w, fmt.Sprintf("invalid data of %s event: %s", eventType, err), http.StatusBadRequest)
+					return
+				}
+				c.handleKeyvaultEvent(eventType, data)
+			default:
+				c.logger.Debugf("Ignoring Event Grid event of type %s", eventType)
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// readEvents decodes a single event or a batch of events
+func readEvents(r io.Reader) ([]eventGridEvent, error) {
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	body = bytes.TrimSpace(body)
+	var events []eventGridEvent
+	if len(body) > 0 && body[0] == '[' {
+		err = json.Unmarshal(body, &events)
+	} else {
+		var event eventGridEvent
+		err = json.Unmarshal(body, &event)
+		events = append(events, event)
+	}
+	return events, err
+}
+
+// handleKeyvaultEvent enqueues the KeyvaultSecrets that reference the secret
+// of a Key Vault event. The cached latest version of the secret is dropped
+// when a new version was created.
+func (c *Controller) handleKeyvaultEvent(eventType string, data keyvaultEventData) {
+	if c.config.VaultName != "" && !strings.EqualFold(data.VaultName, c.config.VaultName) {
+		c.logger.Debugf("Ignoring %s event for secret %s of vault %s", eventType, data.ObjectName, data.VaultName)
+		return
+	}
+	if eventType == eventTypeSecretNewVersion {
+		if invalidator, ok := c.keyvaultClient.(secretstore.Invalidator); ok {
+			invalidator.Invalidate(data.ObjectName)
+		}
+		if c.identities != nil {
+			c.identities.Invalidate(data.ObjectName)
+		}
+	}
+
+	objs, err := c.keyvaultSecretIndexer.ByIndex(keyvaultNameIndex, strings.ToLower(data.ObjectName))
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	for _, obj := range objs {
+		if !c.inNamespaces(obj) {
+			continue
+		}
+		key, err := cache.MetaNamespaceKeyFunc(obj)
+		if err != nil {
+			runtime.HandleError(err)
+			continue
+		}
+		c.logger.Infof("Syncing KeyvaultSecret %s after %s event for secret %s", key, eventType, data.ObjectName)
+		c.workqueue.Add(key)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	keyvaultsecretv1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+)
+
+func Test_eventGridHandler(t *testing.T) {
+	newKeyvaultSecret := func(namespace, name, keyvaultName string) *keyvaultsecretv1alpha1.KeyvaultSecret {
+		return &keyvaultsecretv1alpha1.KeyvaultSecret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: keyvaultsecretv1alpha1.KeyvaultSecretSpec{
+				Items: []keyvaultsecretv1alpha1.KeyvaultSecretEntry{{KubernetesName: "value", KeyvaultName: keyvaultName}},
+			},
+		}
+	}
+	keyvaultSecrets := []*keyvaultsecretv1alpha1.KeyvaultSecret{
+		newKeyvaultSecret("team-a", "db", "db-password"),
+		newKeyvaultSecret("team-b", "db", "DB-Password"),
+		newKeyvaultSecret("team-a", "api", "api-key"),
+	}
+	newVersionEvent := `{
+		"specversion": "1.0",
+		"type": "Microsoft.KeyVault.SecretNewVersionCreated",
+		"source": "/subscriptions/id/resourceGroups/rg/providers/Microsoft.KeyVault/vaults/my-vault",
+		"subject": "db-password",
+		"id": "1",
+		"data": {"Id": "https://my-vault.vault.azure.net/secrets/db-password/v2", "VaultName": "my-vault", "ObjectType": "Secret", "ObjectName": "db-password", "Version": "v2"}
+	}`
+
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		header     map[string]string
+		body       string
+		wantStatus int
+		wantBody   string
+		wantHeader map[string]string
+		wantKeys   []string
+	}{
+		{
+			name:       "subscription validation",
+			method:     http.MethodPost,
+			url:        "/eventgrid",
+			body:       `[{"id": "1", "eventType": "Microsoft.EventGrid.SubscriptionValidationEvent", "data": {"validationCode": "512d38b6"}}]`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"validationResponse":"512d38b6"}`,
+		},
+		{
+			name:       "CloudEvents handshake",
+			method:     http.MethodOptions,
+			url:        "/eventgrid",
+			header:     map[string]string{"WebHook-Request-Origin": "eventgrid.azure.net"},
+			wantStatus: http.StatusOK,
+			wantHeader: map[string]string{"WebHook-Allowed-Origin": "eventgrid.azure.net"},
+		},
+		{
+			name:       "new version",
+			method:     http.MethodPost,
+			url:        "/eventgrid",
+			body:       newVersionEvent,
+			wantStatus: http.StatusOK,
+			wantKeys:   []string{"team-a/db", "team-b/db"},
+		},
+		{
+			name:       "near expiry in a batch",
+			method:     http.MethodPost,
+			url:        "/eventgrid",
+			body:       `[{"type": "Microsoft.KeyVault.SecretNearExpiry", "data": {"VaultName": "my-vault", "ObjectType": "Secret", "ObjectName": "api-key"}}]`,
+			wantStatus: http.StatusOK,
+			wantKeys:   []string{"team-a/api"},
+		},
+		{
+			name:       "other vault",
+			method:     http.MethodPost,
+			url:        "/eventgrid",
+			body:       strings.Replace(newVersionEvent, `"VaultName": "my-vault"`, `"VaultName": "other-vault"`, 1),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "invalid body",
+			method:     http.MethodPost,
+			url:        "/eventgrid",
+			body:       `{"type":`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "wrong token",
+			method:     http.MethodPost,
+			url:        "/eventgrid?token=guess",
+			body:       newVersionEvent,
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{keyvaultNameIndex: indexByKeyvaultName})
+			for _, keyvaultSecret := range keyvaultSecrets {
+				indexer.Add(keyvaultSecret)
+			}
+			queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "KeyvaultSecrets")
+			c := &Controller{
+				config:                Config{VaultName: "My-Vault"},
+				keyvaultClient:        testSecretStoreClient{},
+				keyvaultSecretIndexer: indexer,
+				workqueue:             queue,
+				logger:                logrus.NewEntry(logrus.New()),
+			}
+			url := tt.url
+			if !strings.Contains(url, "token=") {
+				url += "?token=secret"
+			}
+			req := httptest.NewRequest(tt.method, url, strings.NewReader(tt.body))
+			for key, value := range tt.header {
+				req.Header.Set(key, value)
+			}
+			rec := httptest.NewRecorder()
+
+			c.eventGridHandler("secret").ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("eventGridHandler() status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantBody != "" && strings.TrimSpace(rec.Body.String()) != tt.wantBody {
+				t.Errorf("eventGridHandler() body = %s, want %s", rec.Body.String(), tt.wantBody)
+			}
+			for key, value := range tt.wantHeader {
+				if rec.Header().Get(key) != value {
+					t.Errorf("eventGridHandler() header %s = %q, want %q", key, rec.Header().Get(key), value)
+				}
+			}
+			var keys []string
+			for queue.Len() > 0 {
+				key, _ := queue.Get()
+				keys = append(keys, key.(string))
+				queue.Done(key)
+			}
+			sort.Strings(keys)
+			if strings.Join(keys, ",") != strings.Join(tt.wantKeys, ",") {
+				t.Errorf("eventGridHandler() enqueued %v, want %v", keys, tt.wantKeys)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+// runExec implements the exec subcommand, which replaces the Key Vault
+// references in the environment with the values of the secrets and then
+// executes the command in place of itself. It only returns on errors.
+func runExec(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("exec", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	var store storeConfig
+	store.addFlags(flags)
+	timeout := flags.Duration("timeout", 30*time.Second, "Maximum time allowed to resolve all references")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	command := flags.Args()
+	if len(command) == 0 {
+		fmt.Fprintln(stderr, "usage: secret-controller exec [flags] -- <command> [args]")
+		flags.PrintDefaults()
+		return 2
+	}
+
+	storeClient, _, err := store.newClient()
+	if err != nil {
+		fmt.Fprintf(stderr, "Error creating secret store: %s\n", err)
+		return 1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	env, err := resolveEnv(ctx, storeClient, os.Environ())
+	if err != nil {
+		fmt.Fprintf(stderr, "Error resolving Key Vault references: %s\n", err)
+		return 1
+	}
+
+	path, err := exec.LookPath(command[0])
+	if err != nil {
+		fmt.Fprintf(stderr, "Error finding %s: %s\n", command[0], err)
+		return 127
+	}
+	err = syscall.Exec(path, command, env)
+	fmt.Fprintf(stderr, "Error executing %s: %s\n", path, err)
+	return 126
+}
+
+// resolveEnv returns environ with every Key Vault reference replaced by the
+// value of the secret
+func resolveEnv(ctx context.Context, storeClient secretstore.Client, environ []string) ([]string, error) {
+	resolved := make([]string, 0, len(environ))
+	for _, entry := range environ {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			resolved = append(resolved, entry)
+			continue
+		}
+		name, version, ok := parseKeyvaultReference(parts[1])
+		if !ok {
+			resolved = append(resolved, entry)
+			continue
+		}
+		value, err := storeClient.GetSecretValueForVersion(ctx, name, version)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", parts[0], err)
+		}
+		resolved = append(resolved, parts[0]+"="+value)
+	}
+	return resolved, nil
+}
+
+// runInstall implements the install subcommand, which copies the binary into
+// a directory, so that containers of other images can run the exec
+// subcommand
+func runInstall(args []string, stdout, stderr io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprintln(stderr, "usage: secret-controller install <directory>")
+		return 2
+	}
+	if err := installBinary(args[0]); err != nil {
+		fmt.Fprintf(stderr, "Error installing secret-controller: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// installBinary copies the running binary into dir, together with the CA
+// certificates in the environment variable injectorCAEnv if it is set
+func installBinary(dir string) error {
+	if caBundle := os.Getenv(injectorCAEnv); caBundle != "" {
+		if err := ioutil.WriteFile(filepath.Join(dir, filepath.Base(injectorCAFile)), []byte(caBundle), 0644); err != nil {
+			return err
+		}
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	src, err := os.Open(executable)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(filepath.Join(dir, filepath.Base(injectorBinary)), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
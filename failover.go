@@ -0,0 +1,38 @@
+package main
+
+import (
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+
+	keyvaultsecretv1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+const (
+	// SecretFailover is used as part of the Event 'reason' when a
+	// KeyvaultSecret uses a secret that was read from a failover vault
+	SecretFailover = "SecretFailover"
+
+	// MessageSecretFailover is the message used for an Event fired when a
+	// KeyvaultSecret uses a secret that was read from a failover vault
+	MessageSecretFailover = "Key Vault secret %q was read from failover vault %s"
+)
+
+// recordFailover records events for the secrets that were not read from the
+// primary vault
+func (c *Controller) recordFailover(keyvaultSecret *keyvaultsecretv1alpha1.KeyvaultSecret, secrets map[string]*secretstore.Secret) {
+	// keep events in a stable order
+	keys := make([]string, 0, len(secrets))
+	for key, secret := range secrets {
+		if secret.Source != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		secret := secrets[key]
+		c.logger.Warnf("KeyvaultSecret %s/%s uses secret %q from failover vault %s", keyvaultSecret.Namespace, keyvaultSecret.Name, secret.Name, secret.Source)
+		c.recorder.Eventf(keyvaultSecret, corev1.EventTypeWarning, SecretFailover, MessageSecretFailover, secret.Name, secret.Source)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	keyvaultsecretv1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+func Test_recordFailover(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	c := &Controller{recorder: recorder, logger: logrus.NewEntry(logrus.New())}
+	keyvaultSecret := &keyvaultsecretv1alpha1.KeyvaultSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-secret", Namespace: "test-namespace"},
+	}
+	c.recordFailover(keyvaultSecret, map[string]*secretstore.Secret{
+		"primary/":  {Name: "primary"},
+		"failover/": {Name: "failover", Source: "secondary-vault"},
+	})
+	if got := len(recorder.Events); got != 1 {
+		t.Fatalf("recordFailover() recorded %d events, want 1", got)
+	}
+	if event := <-recorder.Events; !strings.Contains(event, SecretFailover) || !strings.Contains(event, `"failover" was read from failover vault secondary-vault`) {
+		t.Errorf("recordFailover() recorded %q", event)
+	}
+}
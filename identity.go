@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/twendt/secret-controller/pkg/secretstore"
+	storecache "github.com/twendt/secret-controller/pkg/secretstore/cache"
+	"github.com/twendt/secret-controller/pkg/secretstore/keyvault"
+	"github.com/twendt/secret-controller/pkg/secretstore/keyvault/auth"
+)
+
+const (
+	// clientIDAnnotation holds the client ID of the Azure AD application
+	// that a ServiceAccount is federated with
+	clientIDAnnotation = "azure.workload.identity/client-id"
+	// tenantIDAnnotation overrides the tenant of that application
+	tenantIDAnnotation = "azure.workload.identity/tenant-id"
+
+	// serviceAccountTokenExpiration is the lifetime of the ServiceAccount
+	// tokens requested for the token exchange
+	serviceAccountTokenExpiration = time.Hour
+	// identityClientIdleTimeout is how long the client of an identity is
+	// kept after its last use, so that clients of deleted ServiceAccounts
+	// and of changed annotations are dropped
+	identityClientIdleTimeout = time.Hour
+)
+
+// identityStores creates Key Vault clients that authenticate as the Azure AD
+// application a ServiceAccount is federated with. Each identity has a cache
+// of its own, so that one identity never sees the secrets read by another.
+// The clients only read from the vault of --vault-name or --vault-url, the
+// vaults of --failover-vault-names are never used for identities.
+type identityStores struct {
+	kubeclientset kubernetes.Interface
+	tokens        *auth.FederatedTokenCache
+	httpClient    *http.Client
+	vaultURL      string
+	tenantID      string
+	cacheTTL      time.Duration
+	now           func() time.Time
+
+	mu      sync.Mutex
+	clients map[string]*identityClient
+}
+
+// identityClient is the store client of an identity and the time it was
+// last returned
+type identityClient struct {
+	client   secretstore.Client
+	lastUsed time.Time
+}
+
+// newIdentityStores returns identityStores for the vault at vaultURL. Tokens
+// and secrets are requested with httpClient, or with default HTTP clients if
+// httpClient is nil.
+func newIdentityStores(kubeclientset kubernetes.Interface, vaultURL, tenantID, authorityHost string, httpClient *http.Client, cacheTTL time.Duration) *identityStores {
+	return &identityStores{
+		kubeclientset: kubeclientset,
+		tokens:        auth.NewFederatedTokenCache(authorityHost, httpClient),
+		httpClient:    httpClient,
+		vaultURL:      strings.TrimSuffix(vaultURL, "/") + "/",
+		tenantID:      tenantID,
+		cacheTTL:      cacheTTL,
+		now:           time.Now,
+		clients:       make(map[string]*identityClient),
+	}
+}
+
+// storeClientFor returns the store client for the identity of the
+// ServiceAccount namespace/name. The ServiceAccount is read every time, so
+// that changes of its annotations take effect immediately.
+func (s *identityStores) storeClientFor(namespace, name string) (secretstore.Client, error) {
+	serviceAccount, err := s.kubeclientset.CoreV1().ServiceAccounts(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	clientID := serviceAccount.Annotations[clientIDAnnotation]
+	if clientID == "" {
+		return nil, fmt.Errorf("ServiceAccount %s/%s has no annotation %s", namespace, name, clientIDAnnotation)
+	}
+	tenantID := serviceAccount.Annotations[tenantIDAnnotation]
+	if tenantID == "" {
+		tenantID = s.tenantID
+	}
+	if tenantID == "" {
+		return nil, fmt.Errorf("no tenant ID for ServiceAccount %s/%s, set --azure-tenant-id or the annotation %s", namespace, name, tenantIDAnnotation)
+	}
+
+	key := strings.Join([]string{namespace, name, tenantID, clientID}, "/")
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(now)
+	if cached, ok := s.clients[key]; ok {
+		cached.lastUsed = now
+		return cached.client, nil
+	}
+	authorizer := s.tokens.Authorizer(namespace+"/"+name, tenantID, clientID, func(ctx context.Context) (string, error) {
+		return s.serviceAccountToken(ctx, namespace, name)
+	})
+	vaultClient, err := keyvault.NewVaultClientForURL(s.vaultURL, authorizer, s.httpClient)
+	if err != nil {
+		return nil, err
+	}
+	var client secretstore.Client = vaultClient
+	if s.cacheTTL > 0 {
+		client = storecache.NewClient(client, s.vaultURL+key, s.cacheTTL)
+	}
+	s.clients[key] = &identityClient{client: client, lastUsed: now}
+	return client, nil
+}
+
+// prune drops the clients that were not used within
+// identityClientIdleTimeout. s.mu must be held.
+func (s *identityStores) prune(now time.Time) {
+	for key, cached := range s.clients {
+		if now.Sub(cached.lastUsed) > identityClientIdleTimeout {
+			delete(s.clients, key)
+		}
+	}
+}
+
+// serviceAccountToken requests a token for the ServiceAccount with the
+// audience of the Azure AD token exchange. The client of this client-go
+// version does not take a context, so the request is only abandoned when ctx
+// is done.
+func (s *identityStores) serviceAccountToken(ctx context.Context, namespace, name string) (string, error) {
+	expirationSeconds := int64(serviceAccountTokenExpiration / time.Second)
+	type result struct {
+		token string
+		err   error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		tokenRequest, err := s.kubeclientset.CoreV1().ServiceAccounts(namespace).CreateToken(name, &authenticationv1.TokenRequest{
+			Spec: authenticationv1.TokenRequestSpec{
+				Audiences:         []string{auth.FederatedTokenAudience},
+				ExpirationSeconds: &expirationSeconds,
+			},
+		})
+		if err != nil {
+			ch <- result{err: err}
+			return
+		}
+		ch <- result{token: tokenRequest.Status.Token}
+	}()
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case r := <-ch:
+		return r.token, r.err
+	}
+}
+
+// Invalidate drops the cached latest version of the secret for every
+// identity
+func (s *identityStores) Invalidate(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cached := range s.clients {
+		if invalidator, ok := cached.client.(secretstore.Invalidator); ok {
+			invalidator.Invalidate(name)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_identityStores_storeClientFor(t *testing.T) {
+	newServiceAccount := func(name string, annotations map[string]string) *corev1.ServiceAccount {
+		return &corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team-a", Annotations: annotations},
+		}
+	}
+	kubeclientset := fake.NewSimpleClientset(
+		newServiceAccount("federated", map[string]string{clientIDAnnotation: "client"}),
+		newServiceAccount("other-tenant", map[string]string{clientIDAnnotation: "client", tenantIDAnnotation: "other"}),
+		newServiceAccount("plain", nil),
+	)
+	tests := []struct {
+		name           string
+		serviceAccount string
+		tenantID       string
+		wantErr        bool
+	}{
+		{name: "federated", serviceAccount: "federated", tenantID: "tenant"},
+		{name: "tenant from annotation", serviceAccount: "other-tenant"},
+		{name: "missing tenant", serviceAccount: "federated", wantErr: true},
+		{name: "missing client ID", serviceAccount: "plain", tenantID: "tenant", wantErr: true},
+		{name: "missing ServiceAccount", serviceAccount: "missing", tenantID: "tenant", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stores := newIdentityStores(kubeclientset, "https://vault.vault.azure.net/", tt.tenantID, "", nil, time.Minute)
+			client, err := stores.storeClientFor("team-a", tt.serviceAccount)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("storeClientFor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			again, _ := stores.storeClientFor("team-a", tt.serviceAccount)
+			if again != client {
+				t.Errorf("storeClientFor() created a second client for the same identity")
+			}
+		})
+	}
+}
+
+func Test_identityStores_prune(t *testing.T) {
+	kubeclientset := fake.NewSimpleClientset(&corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: "federated", Namespace: "team-a", Annotations: map[string]string{clientIDAnnotation: "client"}},
+	})
+	stores := newIdentityStores(kubeclientset, "https://vault.vault.azure.net/", "tenant", "", nil, time.Minute)
+	now := time.Now()
+	stores.now = func() time.Time { return now }
+
+	client, err := stores.storeClientFor("team-a", "federated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(identityClientIdleTimeout)
+	if again, _ := stores.storeClientFor("team-a", "federated"); again != client {
+		t.Errorf("storeClientFor() dropped a client that was used within the idle timeout")
+	}
+	now = now.Add(identityClientIdleTimeout + time.Second)
+	if again, _ := stores.storeClientFor("team-a", "federated"); again == client {
+		t.Errorf("storeClientFor() kept an idle client")
+	}
+	if len(stores.clients) != 1 {
+		t.Errorf("%d clients, want 1", len(stores.clients))
+	}
+}
+
+func Test_identityStores_serviceAccountToken(t *testing.T) {
+	// the TokenRequest hangs until the test ends
+	release := make(chan struct{})
+	defer close(release)
+	kubeclientset := fake.NewSimpleClientset()
+	kubeclientset.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		<-release
+		return true, &authenticationv1.TokenRequest{}, nil
+	})
+	stores := newIdentityStores(kubeclientset, "https://vault.vault.azure.net/", "tenant", "", nil, time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := stores.serviceAccountToken(ctx, "team-a", "federated"); err != context.Canceled {
+		t.Errorf("serviceAccountToken() error = %v, want %v", err, context.Canceled)
+	}
+}
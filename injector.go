@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	keyvaultsecretv1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+)
+
+const (
+	// keyvaultReferencePrefix marks environment variables whose value is a
+	// reference to a Key Vault secret, keyvault://name[@version]
+	keyvaultReferencePrefix = "keyvault://"
+
+	injectorVolumeName = "secret-controller-bin"
+	injectorMountPath  = "/keyvault/bin"
+	injectorBinary     = injectorMountPath + "/secret-controller"
+	// injectorCAFile is the copy of the CA certificates of --keyvault-ca-file
+	// that the install init container writes from the environment variable
+	// injectorCAEnv
+	injectorCAFile        = injectorMountPath + "/ca.pem"
+	injectorCAEnv         = "SECRET_CONTROLLER_KEYVAULT_CA"
+	injectorInitContainer = "secret-controller-install"
+	// injectorImageBinary is the path of the binary in the image of the
+	// secret-controller
+	injectorImageBinary = "/secret-controller"
+
+	// maxAdmissionReviewSize bounds the size of admission requests
+	maxAdmissionReviewSize = 3 << 20
+)
+
+// vaultNameAnnotation selects another vault than the default of the injector
+// for the references of a pod
+var vaultNameAnnotation = keyvaultsecretv1alpha1.SchemeGroupVersion.Group + "/vault-name"
+
+// workloadIdentityLabel marks pods that use Azure AD workload identity, whose
+// references are read with the identity of the pod
+const workloadIdentityLabel = "azure.workload.identity/use"
+
+// injector is a mutating admission webhook for pods. Containers with
+// environment variables that reference Key Vault secrets are started through
+// the exec subcommand, which replaces the references with the values of the
+// secrets, so that the values never end up in a Kubernetes Secret.
+type injector struct {
+	// image contains the secret-controller binary, which an init container
+	// copies into a volume shared with the containers
+	image string
+	// store holds the vault and the Key Vault settings of the controller,
+	// which are passed on to the exec subcommand
+	store storeConfig
+	// caBundle are the CA certificates of --keyvault-ca-file, which the
+	// init container copies into the pod
+	caBundle string
+	logger   *logrus.Entry
+}
+
+func (i *injector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxAdmissionReviewSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	review := admissionv1beta1.AdmissionReview{}
+	if err := json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		http.Error(w, "invalid AdmissionReview", http.StatusBadRequest)
+		return
+	}
+
+	response := i.admit(review.Request)
+	response.UID = review.Request.UID
+	review.Request = nil
+	review.Response = response
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		i.logger.Errorf("Error writing admission response: %s", err)
+	}
+}
+
+// admit returns the response to an admission request. Pods that cannot be
+// injected are rejected, so that they do not start with the references in
+// place of the values.
+func (i *injector) admit(request *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	if request.Kind.Kind != "Pod" {
+		return &admissionv1beta1.AdmissionResponse{Allowed: true}
+	}
+	pod := &corev1.Pod{}
+	if err := json.Unmarshal(request.Object.Raw, pod); err != nil {
+		return deniedResponse(fmt.Errorf("invalid pod: %s", err))
+	}
+	patch, err := i.mutatePod(pod)
+	if err != nil {
+		i.logger.Infof("Rejecting pod %s/%s%s: %s", request.Namespace, pod.Name, pod.GenerateName, err)
+		return deniedResponse(err)
+	}
+	if patch == nil {
+		return &admissionv1beta1.AdmissionResponse{Allowed: true}
+	}
+	i.logger.Infof("Injecting Key Vault references into pod %s/%s%s", request.Namespace, pod.Name, pod.GenerateName)
+	patchType := admissionv1beta1.PatchTypeJSONPatch
+	return &admissionv1beta1.AdmissionResponse{
+		Allowed:   true,
+		Patch:     patch,
+		PatchType: &patchType,
+	}
+}
+
+func deniedResponse(err error) *admissionv1beta1.AdmissionResponse {
+	return &admissionv1beta1.AdmissionResponse{
+		Allowed: false,
+		Result:  &metav1.Status{Message: err.Error()},
+	}
+}
+
+// mutatePod returns a JSON patch that starts the containers of pod with Key
+// Vault references through the exec subcommand. It returns nil if no
+// container has references.
+func (i *injector) mutatePod(pod *corev1.Pod) ([]byte, error) {
+	// the annotation replaces the vault of the controller, including its
+	// URL and failover vaults
+	vaultName := pod.Annotations[vaultNameAnnotation]
+	vaultConfigured := vaultName != "" || i.store.vaultName != "" || i.store.vaultURL != ""
+	args := append([]string{injectorBinary, "exec"}, i.store.execArgs(vaultName)...)
+	if i.caBundle != "" {
+		args = append(args, "--keyvault-ca-file", injectorCAFile)
+	}
+	if pod.Labels[workloadIdentityLabel] == "true" {
+		args = append(args, "--azure-workload-identity")
+	}
+
+	injected := false
+	inject := func(containers []corev1.Container) error {
+		for n := range containers {
+			container := &containers[n]
+			if !hasKeyvaultReferences(container.Env) {
+				continue
+			}
+			if len(container.Command) > 0 && container.Command[0] == injectorBinary {
+				// the webhook may be called again for the same pod
+				continue
+			}
+			if len(container.Command) == 0 {
+				return fmt.Errorf("container %s references Key Vault secrets but does not set command: the webhook cannot look up the ENTRYPOINT of image %s, set command to it", container.Name, container.Image)
+			}
+			if !vaultConfigured {
+				return fmt.Errorf("container %s references Key Vault secrets but no vault is configured, set the annotation %s", container.Name, vaultNameAnnotation)
+			}
+			container.Command = append(append(args[:len(args):len(args)], "--"), container.Command...)
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+				Name:      injectorVolumeName,
+				MountPath: injectorMountPath,
+				ReadOnly:  true,
+			})
+			injected = true
+		}
+		return nil
+	}
+	if err := inject(pod.Spec.InitContainers); err != nil {
+		return nil, err
+	}
+	if err := inject(pod.Spec.Containers); err != nil {
+		return nil, err
+	}
+	if !injected {
+		return nil, nil
+	}
+
+	// the binary has to be copied before any other init container runs
+	install := corev1.Container{
+		Name:    injectorInitContainer,
+		Image:   i.image,
+		Command: []string{injectorImageBinary, "install", injectorMountPath},
+		VolumeMounts: []corev1.VolumeMount{{
+			Name:      injectorVolumeName,
+			MountPath: injectorMountPath,
+		}},
+	}
+	if i.caBundle != "" {
+		install.Env = []corev1.EnvVar{{Name: injectorCAEnv, Value: i.caBundle}}
+	}
+	initContainers := append([]corev1.Container{install}, pod.Spec.InitContainers...)
+	volumes := append(pod.Spec.Volumes, corev1.Volume{
+		Name: injectorVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMediumMemory},
+		},
+	})
+
+	// add replaces the lists as a whole, whether they exist or not
+	type patchOperation struct {
+		Op    string      `json:"op"`
+		Path  string      `json:"path"`
+		Value interface{} `json:"value"`
+	}
+	return json.Marshal([]patchOperation{
+		{Op: "add", Path: "/spec/initContainers", Value: initContainers},
+		{Op: "add", Path: "/spec/containers", Value: pod.Spec.Containers},
+		{Op: "add", Path: "/spec/volumes", Value: volumes},
+	})
+}
+
+// hasKeyvaultReferences reports whether an environment variable references a
+// Key Vault secret
+func hasKeyvaultReferences(env []corev1.EnvVar) bool {
+	for _, envVar := range env {
+		if strings.HasPrefix(envVar.Value, keyvaultReferencePrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseKeyvaultReference returns the secret name and version of a reference
+// of the form keyvault://name[@version]. ok is false for other values.
+func parseKeyvaultReference(value string) (name, version string, ok bool) {
+	if !strings.HasPrefix(value, keyvaultReferencePrefix) {
+		return "", "", false
+	}
+	name = strings.TrimPrefix(value, keyvaultReferencePrefix)
+	if at := strings.Index(name, "@"); at >= 0 {
+		name, version = name[:at], name[at+1:]
+	}
+	return name, version, name != ""
+}
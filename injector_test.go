@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/twendt/secret-controller/pkg/secretstore"
+	"github.com/twendt/secret-controller/pkg/secretstore/keyvault"
+)
+
+func Test_mutatePod(t *testing.T) {
+	referencing := corev1.Container{
+		Name:    "app",
+		Command: []string{"/app"},
+		Env:     []corev1.EnvVar{{Name: "PASSWORD", Value: "keyvault://db-password"}},
+	}
+	plain := corev1.Container{Name: "sidecar", Env: []corev1.EnvVar{{Name: "MODE", Value: "plain"}}}
+	injected := referencing
+	injected.Command = []string{injectorBinary, "exec", "--vault-name", "default-vault", "--", "/app"}
+	defaultStore := storeConfig{vaultName: "default-vault"}
+
+	tests := []struct {
+		name        string
+		store       storeConfig
+		caBundle    string
+		labels      map[string]string
+		annotations map[string]string
+		containers  []corev1.Container
+		wantPatch   bool
+		wantCommand []string
+		wantErr     bool
+	}{
+		{
+			name:       "no references",
+			store:      defaultStore,
+			containers: []corev1.Container{plain},
+		},
+		{
+			name:        "references",
+			store:       defaultStore,
+			containers:  []corev1.Container{referencing, plain},
+			wantPatch:   true,
+			wantCommand: []string{injectorBinary, "exec", "--vault-name", "default-vault", "--", "/app"},
+		},
+		{
+			name:        "vault from annotation",
+			store:       defaultStore,
+			annotations: map[string]string{vaultNameAnnotation: "team-vault"},
+			containers:  []corev1.Container{referencing},
+			wantPatch:   true,
+			wantCommand: []string{injectorBinary, "exec", "--vault-name", "team-vault", "--", "/app"},
+		},
+		{
+			name:        "workload identity",
+			store:       defaultStore,
+			labels:      map[string]string{workloadIdentityLabel: "true"},
+			containers:  []corev1.Container{referencing},
+			wantPatch:   true,
+			wantCommand: []string{injectorBinary, "exec", "--vault-name", "default-vault", "--azure-workload-identity", "--", "/app"},
+		},
+		{
+			name: "vault URL and Key Vault settings",
+			store: storeConfig{
+				vaultURL:           "https://myvault.privatelink.vaultcore.azure.net/",
+				failoverVaultNames: "myvault-northeurope",
+				keyvault:           keyvault.Options{Proxy: "http://proxy:3128", MinTLSVersion: "1.2", Timeout: 10 * time.Second},
+			},
+			caBundle:   "-----BEGIN CERTIFICATE-----",
+			containers: []corev1.Container{referencing},
+			wantPatch:  true,
+			wantCommand: []string{injectorBinary, "exec", "--vault-url", "https://myvault.privatelink.vaultcore.azure.net/",
+				"--failover-vault-names", "myvault-northeurope", "--keyvault-proxy", "http://proxy:3128",
+				"--keyvault-min-tls-version", "1.2", "--keyvault-timeout", "10s", "--keyvault-ca-file", injectorCAFile, "--", "/app"},
+		},
+		{
+			name: "vault from annotation replaces the vault URL",
+			store: storeConfig{
+				vaultURL: "https://myvault.privatelink.vaultcore.azure.net/",
+				keyvault: keyvault.Options{Proxy: "http://proxy:3128"},
+			},
+			annotations: map[string]string{vaultNameAnnotation: "team-vault"},
+			containers:  []corev1.Container{referencing},
+			wantPatch:   true,
+			wantCommand: []string{injectorBinary, "exec", "--vault-name", "team-vault", "--keyvault-proxy", "http://proxy:3128", "--", "/app"},
+		},
+		{
+			name:       "no vault",
+			containers: []corev1.Container{referencing},
+			wantErr:    true,
+		},
+		{
+			name:       "already injected",
+			store:      defaultStore,
+			containers: []corev1.Container{injected},
+		},
+		{
+			name:       "no command",
+			store:      defaultStore,
+			containers: []corev1.Container{{Name: "app", Env: referencing.Env}},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &injector{image: "secret-controller:latest", store: tt.store, caBundle: tt.caBundle, logger: logrus.NewEntry(logrus.New())}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Labels: tt.labels, Annotations: tt.annotations},
+				Spec:       corev1.PodSpec{Containers: tt.containers},
+			}
+			patch, err := i.mutatePod(pod.DeepCopy())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("mutatePod() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (patch != nil) != tt.wantPatch {
+				t.Fatalf("mutatePod() patch = %s, wantPatch %v", patch, tt.wantPatch)
+			}
+			if patch == nil {
+				return
+			}
+
+			var operations []struct {
+				Path  string          `json:"path"`
+				Value json.RawMessage `json:"value"`
+			}
+			if err := json.Unmarshal(patch, &operations); err != nil {
+				t.Fatal(err)
+			}
+			var initContainers, containers []corev1.Container
+			var volumes []corev1.Volume
+			for _, operation := range operations {
+				switch operation.Path {
+				case "/spec/initContainers":
+					json.Unmarshal(operation.Value, &initContainers)
+				case "/spec/containers":
+					json.Unmarshal(operation.Value, &containers)
+				case "/spec/volumes":
+					json.Unmarshal(operation.Value, &volumes)
+				}
+			}
+			if len(initContainers) != 1 || initContainers[0].Image != "secret-controller:latest" {
+				t.Fatalf("mutatePod() init containers = %+v, want the install container", initContainers)
+			}
+			if tt.caBundle != "" && (len(initContainers[0].Env) != 1 || initContainers[0].Env[0].Value != tt.caBundle) {
+				t.Errorf("mutatePod() install container env = %+v, want the CA certificates in %s", initContainers[0].Env, injectorCAEnv)
+			}
+			if len(volumes) != 1 || volumes[0].EmptyDir == nil || volumes[0].EmptyDir.Medium != corev1.StorageMediumMemory {
+				t.Errorf("mutatePod() volumes = %+v, want an in-memory emptyDir", volumes)
+			}
+			if !reflect.DeepEqual(containers[0].Command, tt.wantCommand) {
+				t.Errorf("mutatePod() command = %v, want %v", containers[0].Command, tt.wantCommand)
+			}
+			if len(containers[0].VolumeMounts) != 1 {
+				t.Errorf("mutatePod() volume mounts = %+v, want the binary volume", containers[0].VolumeMounts)
+			}
+			for _, container := range containers[1:] {
+				if !reflect.DeepEqual(container, plain) {
+					t.Errorf("mutatePod() changed container without references: %+v", container)
+				}
+			}
+		})
+	}
+}
+
+func Test_injectorServeHTTP(t *testing.T) {
+	pod := &corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Name: "app"},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name:    "app",
+			Command: []string{"/app"},
+			Env:     []corev1.EnvVar{{Name: "PASSWORD", Value: "keyvault://db-password"}},
+		}}},
+	}
+	raw, _ := json.Marshal(pod)
+	body, _ := json.Marshal(admissionv1beta1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1beta1", Kind: "AdmissionReview"},
+		Request: &admissionv1beta1.AdmissionRequest{
+			UID:       "request-uid",
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Namespace: "team-a",
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	})
+	i := &injector{image: "secret-controller:latest", store: storeConfig{vaultName: "vault"}, logger: logrus.NewEntry(logrus.New())}
+	rec := httptest.NewRecorder()
+	i.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/inject", bytes.NewReader(body)))
+
+	review := admissionv1beta1.AdmissionReview{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &review); err != nil {
+		t.Fatalf("ServeHTTP() returned %s: %v", rec.Body.String(), err)
+	}
+	if review.Response == nil || review.Response.UID != "request-uid" || !review.Response.Allowed {
+		t.Fatalf("ServeHTTP() response = %+v, want allowed response for request-uid", review.Response)
+	}
+	if review.Response.PatchType == nil || *review.Response.PatchType != admissionv1beta1.PatchTypeJSONPatch || len(review.Response.Patch) == 0 {
+		t.Errorf("ServeHTTP() response has no JSON patch")
+	}
+}
+
+func Test_resolveEnv(t *testing.T) {
+	store := &testWritableStore{secrets: map[string]*secretstore.Secret{
+		"db-password": {Name: "db-password", Value: "s3cret"},
+	}}
+	got, err := resolveEnv(context.Background(), store, []string{
+		"PATH=/bin",
+		"PASSWORD=keyvault://db-password",
+		"OLD_PASSWORD=keyvault://db-password@v1",
+		"URL=https://example.com",
+	})
+	if err != nil {
+		t.Fatalf("resolveEnv() error = %v", err)
+	}
+	want := []string{"PATH=/bin", "PASSWORD=s3cret", "OLD_PASSWORD=s3cret", "URL=https://example.com"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("resolveEnv() = %v, want %v", got, want)
+	}
+
+	if _, err := resolveEnv(context.Background(), store, []string{"KEY=keyvault://missing"}); err == nil {
+		t.Errorf("resolveEnv() succeeded for a missing secret")
+	}
+}
+
+func Test_parseKeyvaultReference(t *testing.T) {
+	tests := []struct {
+		value       string
+		wantName    string
+		wantVersion string
+		wantOK      bool
+	}{
+		{value: "keyvault://db-password", wantName: "db-password", wantOK: true},
+		{value: "keyvault://db-password@v1", wantName: "db-password", wantVersion: "v1", wantOK: true},
+		{value: "keyvault://"},
+		{value: "db-password"},
+	}
+	for _, tt := range tests {
+		name, version, ok := parseKeyvaultReference(tt.value)
+		if name != tt.wantName || version != tt.wantVersion || ok != tt.wantOK {
+			t.Errorf("parseKeyvaultReference(%q) = %q, %q, %v, want %q, %q, %v", tt.value, name, version, ok, tt.wantName, tt.wantVersion, tt.wantOK)
+		}
+	}
+}
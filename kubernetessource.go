@@ -0,0 +1,94 @@
+package main
+
+import (
+	"regexp"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+
+	keyvaultsecretv1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+	kubestore "github.com/twendt/secret-controller/pkg/secretstore/kubernetes"
+)
+
+// kubernetesSourceIndex indexes KeyvaultSecrets by the Secrets and
+// ConfigMaps they read, in the form <kind>/<namespace>/<name>
+const kubernetesSourceIndex = "kubernetesSource"
+
+// kubernetesReferencePattern finds references to Secrets and ConfigMaps in
+// entries and templates
+var kubernetesReferencePattern = regexp.MustCompile(regexp.QuoteMeta(kubestore.Prefix) + `[0-9A-Za-z._/-]+`)
+
+// indexByKubernetesSource returns the Secrets and ConfigMaps referenced by
+// the entries and templates of a KeyvaultSecret
+func indexByKubernetesSource(obj interface{}) ([]string, error) {
+	keyvaultSecret, ok := obj.(*keyvaultsecretv1alpha1.KeyvaultSecret)
+	if !ok {
+		return nil, nil
+	}
+	seen := make(map[string]bool)
+	var sources []string
+	for _, item := range keyvaultSecret.Spec.Items {
+		references := kubernetesReferencePattern.FindAllString(item.SecretTemplate, -1)
+		if kubestore.IsReference(item.KeyvaultName) {
+			references = append(references, item.KeyvaultName)
+		}
+		for _, reference := range references {
+			ref, err := kubestore.ParseReference(reference, keyvaultSecret.Namespace)
+			if err != nil {
+				continue
+			}
+			source := sourceKey(ref.Kind, ref.Namespace, ref.Name)
+			if !seen[source] {
+				seen[source] = true
+				sources = append(sources, source)
+			}
+		}
+	}
+	return sources, nil
+}
+
+func sourceKey(kind, namespace, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// handleKubernetesSource enqueues the KeyvaultSecrets that read the given
+// Secret or ConfigMap, so that changes are picked up immediately
+func (c *Controller) handleKubernetesSource(kind string, obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	namespace, name, _ := cache.SplitMetaNamespaceKey(key)
+	objs, err := c.keyvaultSecretIndexer.ByIndex(kubernetesSourceIndex, sourceKey(kind, namespace, name))
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	for _, obj := range objs {
+		if c.inNamespaces(obj) {
+			c.enqueueKeyvaultSecret(obj)
+		}
+	}
+}
+
+// kubernetesSourceHandler returns the event handler for the Secrets or
+// ConfigMaps of kind
+func (c *Controller) kubernetesSourceHandler(kind string) cache.ResourceEventHandler {
+	handle := func(obj interface{}) { c.handleKubernetesSource(kind, obj) }
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: handle,
+		UpdateFunc: func(old, new interface{}) {
+			oldObj := old.(metav1.Object)
+			newObj := new.(metav1.Object)
+			if oldObj.GetResourceVersion() != newObj.GetResourceVersion() {
+				handle(new)
+			}
+		},
+		DeleteFunc: handle,
+	}
+}
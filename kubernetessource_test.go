@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	keyvaultsecretv1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+	kubestore "github.com/twendt/secret-controller/pkg/secretstore/kubernetes"
+)
+
+func Test_indexByKubernetesSource(t *testing.T) {
+	keyvaultSecret := &keyvaultsecretv1alpha1.KeyvaultSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "team-a"},
+		Spec: keyvaultsecretv1alpha1.KeyvaultSecretSpec{
+			Items: []keyvaultsecretv1alpha1.KeyvaultSecretEntry{
+				{KubernetesName: "password", KeyvaultName: "kubernetes://secret/databases/postgres-credentials/password"},
+				{KubernetesName: "api-key", KeyvaultName: "api-key"},
+				{
+					KubernetesName: "url",
+					SecretTemplate: `postgres://app:[[ secretValue "kubernetes://secret/databases/postgres-credentials/password" ]]@[[ secretValue "kubernetes://configmap/settings/host" ]]/[[ secretValue "db-name" ]]`,
+				},
+			},
+		},
+	}
+	got, err := indexByKubernetesSource(keyvaultSecret)
+	if err != nil {
+		t.Fatalf("indexByKubernetesSource() error = %v", err)
+	}
+	want := []string{"secret/databases/postgres-credentials", "configmap/team-a/settings"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("indexByKubernetesSource() = %v, want %v", got, want)
+	}
+}
+
+func Test_handleKubernetesSource(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{kubernetesSourceIndex: indexByKubernetesSource})
+	indexer.Add(&keyvaultsecretv1alpha1.KeyvaultSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "team-a"},
+		Spec: keyvaultsecretv1alpha1.KeyvaultSecretSpec{
+			Items: []keyvaultsecretv1alpha1.KeyvaultSecretEntry{
+				{KubernetesName: "password", KeyvaultName: "kubernetes://secret/databases/postgres-credentials/password"},
+			},
+		},
+	})
+	tests := []struct {
+		name string
+		kind string
+		obj  interface{}
+		want bool
+	}{
+		{
+			name: "referenced secret",
+			kind: kubestore.KindSecret,
+			obj:  &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "postgres-credentials", Namespace: "databases"}},
+			want: true,
+		},
+		{
+			name: "deleted referenced secret",
+			kind: kubestore.KindSecret,
+			obj: cache.DeletedFinalStateUnknown{
+				Key: "databases/postgres-credentials",
+				Obj: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "postgres-credentials", Namespace: "databases"}},
+			},
+			want: true,
+		},
+		{
+			name: "configmap with the name of a referenced secret",
+			kind: kubestore.KindConfigMap,
+			obj:  &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "postgres-credentials", Namespace: "databases"}},
+		},
+		{
+			name: "other secret",
+			kind: kubestore.KindSecret,
+			obj:  &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "admin", Namespace: "databases"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController()
+			defer c.workqueue.ShutDown()
+			c.keyvaultSecretIndexer = indexer
+			c.handleKubernetesSource(tt.kind, tt.obj)
+
+			if got := c.workqueue.NumRequeues("team-a/app") > 0; got != tt.want {
+				t.Errorf("handleKubernetesSource() enqueued = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
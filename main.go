@@ -2,27 +2,73 @@ package main
 
 import (
 	"flag"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	kubeinformers "k8s.io/client-go/informers"
+	coreinformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 
 	clientset "github.com/twendt/secret-controller/pkg/client/clientset/versioned"
 	informers "github.com/twendt/secret-controller/pkg/client/informers/externalversions"
-	"github.com/twendt/secret-controller/pkg/secretstore/keyvault"
+	crdinformers "github.com/twendt/secret-controller/pkg/client/informers/externalversions/secretcontroller/v1alpha1"
+	storecache "github.com/twendt/secret-controller/pkg/secretstore/cache"
 	"github.com/twendt/secret-controller/pkg/signals"
 )
 
 var (
-	masterURL  string
-	kubeconfig string
-	vaultName  string
+	masterURL       string
+	kubeconfig      string
+	store           storeConfig
+	cacheTTL        time.Duration
+	metricsAddress  string
+	syncTimeout     time.Duration
+	shutdownTimeout time.Duration
+	namespaces      string
+	labelSelector   string
+	policyConfigMap string
+	validityPolicy  string
+	expiryWarning   time.Duration
+	webhookAddress  string
+	webhookToken    string
+	azureTenantID   string
+	injectorAddress string
+	injectorCert    string
+	injectorKey     string
+	injectorImage   string
+	// kubernetesSources allows references to other Secrets and ConfigMaps
+	kubernetesSources bool
+	// clusterKeyvaultSecrets enables ClusterKeyvaultSecrets, which require
+	// their CRD and permission to watch namespaces
+	clusterKeyvaultSecrets bool
+	// contentHashSecret is the namespace/name of the Secret with the key of
+	// the content hashes
+	contentHashSecret string
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "render":
+			os.Exit(runRender(os.Args[2:], os.Stdout, os.Stderr))
+		case "rollback":
+			os.Exit(runRollback(os.Args[2:], os.Stdout, os.Stderr))
+		case "exec":
+			os.Exit(runExec(os.Args[2:], os.Stdout, os.Stderr))
+		case "install":
+			os.Exit(runInstall(os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
+
 	logrus.SetOutput(os.Stdout)
 	logrus.SetFormatter(&logrus.JSONFormatter{})
 	logrus.SetLevel(logrus.DebugLevel)
@@ -30,8 +76,33 @@ func main() {
 
 	flag.Parse()
 
+	// the webhook triggers syncs and drops cached secrets, so it must not
+	// be open to anyone who can reach it
+	if webhookAddress != "" && webhookToken == "" {
+		logrus.Fatalln("The Event Grid webhook requires --webhook-token")
+	}
+
+	// a sync with a timeout of zero or less is cancelled before it starts
+	if syncTimeout <= 0 {
+		logrus.Fatalf("Invalid sync timeout '%s', must be positive", syncTimeout)
+	}
+
+	switch ValidityPolicy(validityPolicy) {
+	case ValidityPolicyIgnore, ValidityPolicyWarn, ValidityPolicyEnforce:
+	default:
+		logrus.Fatalf("Invalid validity policy '%s'", validityPolicy)
+	}
+
+	storeClient, storeName, err := store.newClient()
+	if err != nil {
+		logrus.Fatalln("Could not get secret store client:", err)
+	}
+	if cacheTTL > 0 {
+		storeClient = storecache.NewClient(storeClient, storeName, cacheTTL)
+	}
+
 	logger := logrus.WithFields(logrus.Fields{
-		"vaultName": vaultName,
+		"store": storeName,
 	})
 
 	// set up signals so we handle the first shutdown signal gracefully
@@ -52,32 +123,190 @@ func main() {
 		logrus.Fatalf("Error building example clientset: %s", err.Error())
 	}
 
-	keyvaultClient, err := keyvault.NewVaultClient(vaultName)
+	if contentHashSecret == "" {
+		contentHashSecret = defaultContentHashSecret()
+	}
+	contentHashNamespace, contentHashName, err := cache.SplitMetaNamespaceKey(contentHashSecret)
+	if err != nil || contentHashNamespace == "" {
+		logrus.Fatalf("Invalid content hash secret '%s', expected namespace/name", contentHashSecret)
+	}
+	contentHashKey, err := loadContentHashKey(kubeClient, contentHashNamespace, contentHashName)
 	if err != nil {
-		logrus.Fatalln("Could not get Key Vault Client:", err)
+		logrus.Fatalf("Error loading the content hash key: %s", err.Error())
+	}
+
+	if metricsAddress != "" {
+		go serveMetrics(metricsAddress, logger)
 	}
 
-	crdInformerFactory := informers.NewSharedInformerFactory(crdClient, time.Second*30)
-	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, time.Second*30)
+	if injectorAddress != "" {
+		if injectorCert == "" || injectorKey == "" || injectorImage == "" {
+			logrus.Fatalln("The injector requires --injector-tls-cert, --injector-tls-key and --injector-image")
+		}
+		handler := &injector{image: injectorImage, store: store, logger: logger}
+		if store.keyvault.CAFile != "" {
+			caBundle, err := ioutil.ReadFile(store.keyvault.CAFile)
+			if err != nil {
+				logrus.Fatalf("Error reading the CA certificates for the injector: %s", err)
+			}
+			handler.caBundle = string(caBundle)
+		}
+		go serveInjector(injectorAddress, injectorCert, injectorKey, handler, logger)
+	}
+
+	// Event Grid notifications are only matched against the vault that is
+	// read from
+	var vaultName string
+	// KeyvaultSecrets and PushSecrets may authenticate as the identity of a
+	// ServiceAccount instead of the controller's
+	var identities *identityStores
+	if store.store == "keyvault" {
+		// newClient already validated the vault and the HTTP settings
+		var vaultURL string
+		vaultURL, vaultName, _ = store.vault()
+		httpClient, _ := store.httpClient()
+		identities = newIdentityStores(kubeClient, vaultURL, azureTenantID, os.Getenv("AZURE_AUTHORITY_HOST"), httpClient, cacheTTL)
+	}
+
+	// a single namespace is watched directly, multiple namespaces are watched
+	// cluster-wide and filtered by the controller
+	var watchedNamespaces []string
+	if namespaces != "" {
+		watchedNamespaces = strings.Split(namespaces, ",")
+	}
+	crdOptions := []informers.SharedInformerOption{
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = labelSelector
+		}),
+	}
+	var kubeOptions []kubeinformers.SharedInformerOption
+	if len(watchedNamespaces) == 1 {
+		crdOptions = append(crdOptions, informers.WithNamespace(watchedNamespaces[0]))
+		kubeOptions = append(kubeOptions, kubeinformers.WithNamespace(watchedNamespaces[0]))
+	}
+	crdInformerFactory := informers.NewSharedInformerFactoryWithOptions(crdClient, time.Second*30, crdOptions...)
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, time.Second*30, kubeOptions...)
+
+	// the policy ConfigMap is watched on its own, so that the controller
+	// does not need to cache all ConfigMaps of the cluster
+	var policyInformerFactory kubeinformers.SharedInformerFactory
+	var policyInformer coreinformers.ConfigMapInformer
+	if policyConfigMap != "" {
+		policyNamespace, policyName, err := cache.SplitMetaNamespaceKey(policyConfigMap)
+		if err != nil || policyNamespace == "" {
+			logrus.Fatalf("Invalid policy configmap '%s', expected namespace/name", policyConfigMap)
+		}
+		policyInformerFactory = kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, time.Second*30,
+			kubeinformers.WithNamespace(policyNamespace),
+			kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+				options.FieldSelector = fields.OneTermEqualSelector("metadata.name", policyName).String()
+			}))
+		policyInformer = policyInformerFactory.Core().V1().ConfigMaps()
+	}
+
+	// ConfigMaps are only cached if they can be referenced
+	var configMapInformer coreinformers.ConfigMapInformer
+	if kubernetesSources {
+		configMapInformer = kubeInformerFactory.Core().V1().ConfigMaps()
+	}
+
+	// ClusterKeyvaultSecrets and namespaces are only watched if enabled, as
+	// their informers never sync without the CRD or the permissions
+	var clusterKeyvaultSecretInformer crdinformers.ClusterKeyvaultSecretInformer
+	var namespaceInformer coreinformers.NamespaceInformer
+	if clusterKeyvaultSecrets {
+		clusterKeyvaultSecretInformer = crdInformerFactory.Secretcontroller().V1alpha1().ClusterKeyvaultSecrets()
+		namespaceInformer = kubeInformerFactory.Core().V1().Namespaces()
+	}
 
 	controller := NewController(kubeClient, crdClient,
 		kubeInformerFactory.Core().V1().Secrets(),
 		crdInformerFactory.Secretcontroller().V1alpha1().KeyvaultSecrets(),
-		keyvaultClient,
+		crdInformerFactory.Secretcontroller().V1alpha1().PushSecrets(),
+		clusterKeyvaultSecretInformer,
+		namespaceInformer,
+		policyInformer,
+		configMapInformer,
+		storeClient,
+		identities,
+		Config{
+			SyncTimeout:       syncTimeout,
+			ShutdownTimeout:   shutdownTimeout,
+			Namespaces:        watchedNamespaces,
+			PolicyConfigMap:   policyConfigMap,
+			ValidityPolicy:    ValidityPolicy(validityPolicy),
+			ExpiryWarning:     expiryWarning,
+			VaultName:         vaultName,
+			KubernetesSources: kubernetesSources,
+			ContentHashKey:    contentHashKey,
+		},
 		logger)
 
+	if webhookAddress != "" {
+		go serveWebhook(webhookAddress, controller.eventGridHandler(webhookToken), logger)
+	}
+
 	// notice that there is no need to run Start methods in a separate goroutine. (i.e. go kubeInformerFactory.Start(stopCh)
 	// Start method is non-blocking and runs all registered informers in a dedicated goroutine.
 	kubeInformerFactory.Start(stopCh)
 	crdInformerFactory.Start(stopCh)
+	if policyInformerFactory != nil {
+		policyInformerFactory.Start(stopCh)
+	}
 
 	if err = controller.Run(1, stopCh); err != nil {
 		logger.Fatalf("Error running controller: %s", err.Error())
 	}
 }
 
+func serveMetrics(address string, logger *logrus.Entry) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	logger.Infof("Serving metrics on %s", address)
+	if err := http.ListenAndServe(address, mux); err != nil {
+		logger.Errorf("Error serving metrics: %s", err.Error())
+	}
+}
+
+func serveWebhook(address string, handler http.Handler, logger *logrus.Entry) {
+	mux := http.NewServeMux()
+	mux.Handle("/eventgrid", handler)
+	logger.Infof("Serving Event Grid webhook on %s", address)
+	if err := http.ListenAndServe(address, mux); err != nil {
+		logger.Errorf("Error serving Event Grid webhook: %s", err.Error())
+	}
+}
+
+func serveInjector(address, certFile, keyFile string, handler http.Handler, logger *logrus.Entry) {
+	mux := http.NewServeMux()
+	mux.Handle("/inject", handler)
+	logger.Infof("Serving injector webhook on %s", address)
+	if err := http.ListenAndServeTLS(address, certFile, keyFile, mux); err != nil {
+		logger.Errorf("Error serving injector webhook: %s", err.Error())
+	}
+}
+
 func init() {
 	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
 	flag.StringVar(&masterURL, "master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
-	flag.StringVar(&vaultName, "vault-name", "", "Name of Azure Key Vault to use")
+	store.addFlags(flag.CommandLine)
+	flag.DurationVar(&cacheTTL, "cache-ttl", time.Minute, "How long the latest version of a Key Vault secret is cached. Pinned versions are cached until the cache is full. 0 disables the cache.")
+	flag.StringVar(&metricsAddress, "metrics-address", ":8080", "The address the Prometheus metrics endpoint binds to. Empty disables the endpoint.")
+	flag.DurationVar(&syncTimeout, "sync-timeout", 30*time.Second, "Maximum time allowed to sync a single KeyvaultSecret, must be positive")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 10*time.Second, "How long to wait for running syncs to finish on shutdown before cancelling them")
+	flag.StringVar(&namespaces, "namespaces", "", "Comma separated list of namespaces to watch. Empty watches all namespaces.")
+	flag.StringVar(&labelSelector, "label-selector", "", "Only KeyvaultSecrets and PushSecrets matching this label selector are processed")
+	flag.StringVar(&policyConfigMap, "policy-configmap", "", "namespace/name of a ConfigMap with the policy that controls which Key Vault secrets a namespace may read. Empty allows all.")
+	flag.StringVar(&validityPolicy, "validity-policy", string(ValidityPolicyWarn), "How disabled, expired and not yet valid secrets are treated: Ignore, Warn or Enforce, which does not update the Secret")
+	flag.DurationVar(&expiryWarning, "expiry-warning", 7*24*time.Hour, "How long before a secret expires Warning events are recorded")
+	flag.StringVar(&azureTenantID, "azure-tenant-id", os.Getenv("KEYVAULT_TENANT_ID"), "Azure AD tenant of the applications that ServiceAccounts are federated with, unless a ServiceAccount sets azure.workload.identity/tenant-id")
+	flag.StringVar(&injectorAddress, "injector-address", "", "The address the mutating webhook that injects Key Vault references into pods binds to. Empty disables the injector.")
+	flag.StringVar(&injectorCert, "injector-tls-cert", "", "Path to the TLS certificate of the injector webhook")
+	flag.StringVar(&injectorKey, "injector-tls-key", "", "Path to the TLS key of the injector webhook")
+	flag.StringVar(&injectorImage, "injector-image", "", "Image of the secret-controller that the injector copies the binary from into pods")
+	flag.BoolVar(&clusterKeyvaultSecrets, "cluster-keyvault-secrets", false, "Enable ClusterKeyvaultSecrets. Requires their CRD and permission to list and watch namespaces.")
+	flag.BoolVar(&kubernetesSources, "kubernetes-sources", false, "Allow entries and templates to read other Secrets and ConfigMaps with kubernetes:// references. All ConfigMaps are cached.")
+	flag.StringVar(&webhookAddress, "webhook-address", "", "The address the Event Grid webhook binds to. Empty disables the webhook.")
+	flag.StringVar(&webhookToken, "webhook-token", os.Getenv("WEBHOOK_TOKEN"), "Token that Event Grid must pass in the token query parameter of the webhook URL, required with --webhook-address")
+	flag.StringVar(&contentHashSecret, "content-hash-secret", "", "namespace/name of the Secret with the key of the content hashes, which is created if it does not exist. Defaults to "+defaultContentHashSecretName+" in the namespace of the controller.")
 }
@@ -31,6 +31,10 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 	scheme.AddKnownTypes(SchemeGroupVersion,
 		&KeyvaultSecret{},
 		&KeyvaultSecretList{},
+		&PushSecret{},
+		&PushSecretList{},
+		&ClusterKeyvaultSecret{},
+		&ClusterKeyvaultSecretList{},
 	)
 	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 	return nil
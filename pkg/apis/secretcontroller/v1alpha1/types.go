@@ -15,13 +15,80 @@ type KeyvaultSecret struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	Spec KeyvaultSecretSpec `json:"spec"`
+	Spec   KeyvaultSecretSpec   `json:"spec"`
+	Status KeyvaultSecretStatus `json:"status,omitempty"`
 }
 
 // KeyvaultSecretSpec is the spec for a KeyvaultSecret resource
 type KeyvaultSecretSpec struct {
 	SecretName string                `json:"secretName"`
 	Items      []KeyvaultSecretEntry `json:"items"`
+	Target     KeyvaultSecretTarget  `json:"target,omitempty"`
+	// RolloutTargets are restarted whenever the content of the Secret
+	// changes, in addition to all workloads that reference the Secret and
+	// carry the annotation secretcontroller.twendt.de/rollout: "true"
+	RolloutTargets []RolloutTarget `json:"rolloutTargets,omitempty"`
+	// RolloutDryRun only records Events for the workloads that would be
+	// restarted
+	RolloutDryRun bool `json:"rolloutDryRun,omitempty"`
+	// ServiceAccountName is a ServiceAccount in the namespace of the
+	// KeyvaultSecret whose Azure AD identity is used to read from Key Vault.
+	// An empty value uses the identity of the controller.
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+}
+
+// RolloutTarget references a workload in the namespace of the KeyvaultSecret
+type RolloutTarget struct {
+	// Kind is one of Deployment, StatefulSet and DaemonSet
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// CreationPolicy defines how the Secret of a KeyvaultSecret is managed
+type CreationPolicy string
+
+const (
+	// CreationPolicyOwner creates the Secret and makes the KeyvaultSecret its
+	// controller, so the Secret is deleted together with the KeyvaultSecret
+	CreationPolicyOwner CreationPolicy = "Owner"
+	// CreationPolicyMerge merges the entries into an existing Secret that is
+	// managed by someone else. The Secret is not created if it is missing.
+	CreationPolicyMerge CreationPolicy = "Merge"
+	// CreationPolicyNone resolves all entries without writing a Secret
+	CreationPolicyNone CreationPolicy = "None"
+)
+
+// KeyvaultSecretTarget describes the Secret created for a KeyvaultSecret
+type KeyvaultSecretTarget struct {
+	// Labels and Annotations are added to the Secret. Their values are
+	// templates with access to the Key Vault metadata of the entries.
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Immutable Secrets are never updated. Every change of their content
+	// creates a new Secret whose name carries a hash of the content.
+	Immutable      bool           `json:"immutable,omitempty"`
+	CreationPolicy CreationPolicy `json:"creationPolicy,omitempty"`
+}
+
+// KeyvaultSecretStatus is the status for a KeyvaultSecret resource
+type KeyvaultSecretStatus struct {
+	// SecretName is the name of the Secret that was last written
+	SecretName string `json:"secretName,omitempty"`
+	// ContentHash is a hash over the data of that Secret
+	ContentHash string `json:"contentHash,omitempty"`
+	// Items are the Key Vault secret versions used for that Secret
+	Items []KeyvaultSecretStatusItem `json:"items,omitempty"`
+	// PreviousItems are the versions used before Items changed last. A
+	// rollback pins the entries to these versions.
+	PreviousItems []KeyvaultSecretStatusItem `json:"previousItems,omitempty"`
+}
+
+// KeyvaultSecretStatusItem records the Key Vault secret version resolved for
+// an entry
+type KeyvaultSecretStatusItem struct {
+	KubernetesName string `json:"kubernetesName"`
+	KeyvaultName   string `json:"keyvaultName"`
+	Version        string `json:"version"`
 }
 
 type KeyvaultSecretEntry struct {
@@ -29,6 +96,93 @@ type KeyvaultSecretEntry struct {
 	KeyvaultVersion string `json:"keyvaultVersion"`
 	KubernetesName  string `json:"kubernetesName"`
 	SecretTemplate  string `json:"secretTemplate"`
+	// Generator creates the Key Vault secret with a generated value if it
+	// does not exist
+	Generator *SecretGenerator `json:"generator,omitempty"`
+	// VersionPolicy selects the version of the Key Vault secret
+	VersionPolicy VersionPolicy `json:"versionPolicy,omitempty"`
+	// PreviousVersions is the number of versions before the selected one
+	// that are written to additional keys, so that applications can accept
+	// the old and the new value during a rotation
+	PreviousVersions int `json:"previousVersions,omitempty"`
+	// PreviousKeySuffix is appended to KubernetesName for the keys of the
+	// previous versions, followed by the number of the version for all but
+	// the first one. It defaults to "_PREVIOUS", which results in the keys
+	// PASSWORD_PREVIOUS, PASSWORD_PREVIOUS_2 and so on.
+	PreviousKeySuffix string `json:"previousKeySuffix,omitempty"`
+}
+
+const (
+	// DefaultPreviousKeySuffix is the default of PreviousKeySuffix
+	DefaultPreviousKeySuffix = "_PREVIOUS"
+	// MaxPreviousVersions is the largest PreviousVersions, as every previous
+	// version is read with its own request on every sync
+	MaxPreviousVersions = 10
+)
+
+// VersionPolicyType selects how the version of a Key Vault secret is chosen
+type VersionPolicyType string
+
+const (
+	// VersionPolicyLatest uses the latest version
+	VersionPolicyLatest VersionPolicyType = "Latest"
+	// VersionPolicyPinned uses the version in KeyvaultVersion
+	VersionPolicyPinned VersionPolicyType = "Pinned"
+	// VersionPolicyPrevious uses the version Previous versions before the
+	// latest one
+	VersionPolicyPrevious VersionPolicyType = "Previous"
+	// VersionPolicyMinAge uses the latest version that is older than MinAge,
+	// so that a new version is only rolled out after it has been staged
+	VersionPolicyMinAge VersionPolicyType = "MinAge"
+)
+
+// VersionPolicy selects the version of a Key Vault secret. Disabled versions
+// are skipped by the Previous and MinAge policies.
+type VersionPolicy struct {
+	// Type defaults to Pinned if KeyvaultVersion is set and Latest otherwise
+	Type VersionPolicyType `json:"type,omitempty"`
+	// Previous is the number of versions to go back for the Previous policy
+	Previous int `json:"previous,omitempty"`
+	// MinAge is the minimum age of the version for the MinAge policy
+	MinAge metav1.Duration `json:"minAge,omitempty"`
+}
+
+// GeneratorType is the kind of value created by a SecretGenerator
+type GeneratorType string
+
+const (
+	// GeneratorTypePassword generates a random password
+	GeneratorTypePassword GeneratorType = "Password"
+	// GeneratorTypeRSA generates an RSA private key
+	GeneratorTypeRSA GeneratorType = "RSA"
+	// GeneratorTypeECDSA generates an ECDSA private key
+	GeneratorTypeECDSA GeneratorType = "ECDSA"
+	// GeneratorTypeEd25519 generates an Ed25519 private key
+	GeneratorTypeEd25519 GeneratorType = "Ed25519"
+	// GeneratorTypeUUID generates a random UUID
+	GeneratorTypeUUID GeneratorType = "UUID"
+)
+
+const (
+	// MaxPasswordLength is the largest Length of a generated password
+	MaxPasswordLength = 4096
+	// MaxRSABits is the largest size of a generated RSA key, as generating
+	// larger keys takes minutes
+	MaxRSABits = 8192
+)
+
+// SecretGenerator describes how the value of a missing Key Vault secret is
+// generated. Private keys are stored as PEM encoded PKCS #8.
+type SecretGenerator struct {
+	Type GeneratorType `json:"type"`
+	// Length is the length of a password, 32 by default and at most 4096
+	Length int `json:"length,omitempty"`
+	// Charset are the characters a password is made of, letters and digits
+	// by default
+	Charset string `json:"charset,omitempty"`
+	// Bits is the size of an RSA key, 2048 by default and at most 8192, or
+	// the curve size of an ECDSA key, one of 256 (default), 384 and 521
+	Bits int `json:"bits,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -41,6 +195,188 @@ type KeyvaultSecretList struct {
 	Items []KeyvaultSecret `json:"items"`
 }
 
+// +genclient
+// +genclient:noStatus
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// PushSecret writes keys of a Secret to Key Vault
+type PushSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PushSecretSpec   `json:"spec"`
+	Status PushSecretStatus `json:"status,omitempty"`
+}
+
+// PushSecretSpec is the spec for a PushSecret resource
+type PushSecretSpec struct {
+	// SecretName is the Secret in the namespace of the PushSecret whose keys
+	// are written to Key Vault
+	SecretName string            `json:"secretName"`
+	Items      []PushSecretEntry `json:"items"`
+	// ConflictPolicy defines what happens when a Key Vault secret already
+	// exists and is not managed by this PushSecret
+	ConflictPolicy ConflictPolicy `json:"conflictPolicy,omitempty"`
+	// DeletionPolicy defines what happens to the Key Vault secrets when the
+	// PushSecret or one of its items is deleted
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+	// ServiceAccountName is a ServiceAccount in the namespace of the
+	// PushSecret whose Azure AD identity is used to write to Key Vault. An
+	// empty value uses the identity of the controller.
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+}
+
+// PushSecretEntry maps a key of the Secret to a Key Vault secret
+type PushSecretEntry struct {
+	KubernetesName string `json:"kubernetesName"`
+	KeyvaultName   string `json:"keyvaultName"`
+	ContentType    string `json:"contentType,omitempty"`
+}
+
+// ConflictPolicy defines how a PushSecret treats existing Key Vault secrets
+type ConflictPolicy string
+
+const (
+	// ConflictPolicyFail refuses to write Key Vault secrets that are not
+	// managed by the PushSecret
+	ConflictPolicyFail ConflictPolicy = "Fail"
+	// ConflictPolicyOverwrite takes over existing Key Vault secrets
+	ConflictPolicyOverwrite ConflictPolicy = "Overwrite"
+)
+
+// DeletionPolicy defines how a PushSecret treats the Key Vault secrets it
+// wrote once they are no longer pushed
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyRetain keeps the Key Vault secrets
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+	// DeletionPolicyDelete deletes the Key Vault secrets
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+)
+
+// PushSecretStatus is the status for a PushSecret resource
+type PushSecretStatus struct {
+	// Items are the Key Vault secrets written by the PushSecret
+	Items []PushSecretStatusItem `json:"items,omitempty"`
+}
+
+// PushSecretStatusItem records the last version written to a Key Vault
+// secret
+type PushSecretStatusItem struct {
+	KeyvaultName string `json:"keyvaultName"`
+	Version      string `json:"version"`
+	// ContentHash is a hash over the value and content type that were
+	// written, so that unchanged values are not written again
+	ContentHash string `json:"contentHash"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// PushSecretList is a list of PushSecret resources
+type PushSecretList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []PushSecret `json:"items"`
+}
+
+// +genclient
+// +genclient:nonNamespaced
+// +genclient:noStatus
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ClusterKeyvaultSecret is a cluster-scoped KeyvaultSecret that the
+// controller creates in every matching namespace
+type ClusterKeyvaultSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClusterKeyvaultSecretSpec   `json:"spec"`
+	Status ClusterKeyvaultSecretStatus `json:"status,omitempty"`
+}
+
+// ClusterKeyvaultSecretSpec is the spec for a ClusterKeyvaultSecret resource
+type ClusterKeyvaultSecretSpec struct {
+	// Template is the spec of the KeyvaultSecret created in every matching
+	// namespace. The KeyvaultSecret has the name of the
+	// ClusterKeyvaultSecret.
+	Template KeyvaultSecretSpec `json:"template"`
+	// NamespaceSelector selects namespaces by their labels
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// Namespaces are selected in addition to those matching
+	// NamespaceSelector
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// ClusterKeyvaultSecretStatus is the status for a ClusterKeyvaultSecret
+// resource
+type ClusterKeyvaultSecretStatus struct {
+	// Namespaces are the namespaces the KeyvaultSecret was created in
+	Namespaces []string `json:"namespaces,omitempty"`
+	// FailedNamespaces are matching namespaces in which the KeyvaultSecret
+	// could not be created, e.g. because one of the same name exists
+	FailedNamespaces []string `json:"failedNamespaces,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ClusterKeyvaultSecretList is a list of ClusterKeyvaultSecret resources
+type ClusterKeyvaultSecretList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []ClusterKeyvaultSecret `json:"items"`
+}
+
+// GetCreationPolicy returns the creation policy of the Secret, which defaults
+// to CreationPolicyOwner
+func (target KeyvaultSecretTarget) GetCreationPolicy() CreationPolicy {
+	if target.CreationPolicy == "" {
+		return CreationPolicyOwner
+	}
+	return target.CreationPolicy
+}
+
+// IsValid checks the target and the items of the spec, and that the keys of
+// previous versions do not replace other keys of the Secret
+func (spec KeyvaultSecretSpec) IsValid() (bool, error) {
+	if ok, err := spec.Target.IsValid(); !ok {
+		return false, err
+	}
+	keys := make(map[string]bool, len(spec.Items))
+	for _, item := range spec.Items {
+		if ok, err := item.IsValid(); !ok {
+			return false, err
+		}
+		keys[item.KubernetesName] = true
+	}
+	for _, item := range spec.Items {
+		for n := 1; n <= item.PreviousVersions; n++ {
+			key := item.PreviousKey(n)
+			if keys[key] {
+				return false, fmt.Errorf("key %q of a previous version of %q is used more than once", key, item.KubernetesName)
+			}
+			keys[key] = true
+		}
+	}
+	return true, nil
+}
+
+// IsValid checks the combination of the target settings
+func (target KeyvaultSecretTarget) IsValid() (bool, error) {
+	switch target.GetCreationPolicy() {
+	case CreationPolicyOwner, CreationPolicyNone:
+	case CreationPolicyMerge:
+		if target.Immutable {
+			return false, fmt.Errorf("immutable Secrets cannot be merged into an existing Secret")
+		}
+	default:
+		return false, fmt.Errorf("unknown creationPolicy %q", target.CreationPolicy)
+	}
+	return true, nil
+}
+
 func (entry KeyvaultSecretEntry) IsTemplateEntry() bool {
 	return entry.SecretTemplate != ""
 }
@@ -49,5 +385,163 @@ func (entry KeyvaultSecretEntry) IsValid() (bool, error) {
 	if entry.KubernetesName == "" || (entry.KeyvaultName == "" && entry.SecretTemplate == "") {
 		return false, fmt.Errorf("NameKubernetes and one of NameKeyvault and SecretTemplate must be set")
 	}
+	if entry.Generator != nil {
+		if entry.IsTemplateEntry() || entry.KeyvaultVersion != "" {
+			return false, fmt.Errorf("generator cannot be combined with SecretTemplate or KeyvaultVersion")
+		}
+		// other policies list the versions first, which fails for the
+		// missing secret before it could be generated
+		if entry.GetVersionPolicyType() != VersionPolicyLatest {
+			return false, fmt.Errorf("generator can only be combined with the Latest version policy")
+		}
+		if ok, err := entry.Generator.IsValid(); !ok {
+			return false, err
+		}
+	}
+	switch entry.GetVersionPolicyType() {
+	case VersionPolicyLatest:
+	case VersionPolicyPinned:
+		if entry.KeyvaultVersion == "" {
+			return false, fmt.Errorf("the Pinned version policy requires KeyvaultVersion")
+		}
+	case VersionPolicyPrevious:
+		if entry.VersionPolicy.Previous < 1 {
+			return false, fmt.Errorf("the Previous version policy requires previous to be at least 1")
+		}
+	case VersionPolicyMinAge:
+		if entry.VersionPolicy.MinAge.Duration <= 0 {
+			return false, fmt.Errorf("the MinAge version policy requires a positive minAge")
+		}
+	default:
+		return false, fmt.Errorf("unknown version policy %q", entry.VersionPolicy.Type)
+	}
+	if entry.KeyvaultVersion != "" && entry.GetVersionPolicyType() != VersionPolicyPinned {
+		return false, fmt.Errorf("KeyvaultVersion can only be used with the Pinned version policy")
+	}
+	if entry.PreviousVersions < 0 {
+		return false, fmt.Errorf("previousVersions must not be negative")
+	}
+	if entry.PreviousVersions > MaxPreviousVersions {
+		return false, fmt.Errorf("previousVersions must not be larger than %d", MaxPreviousVersions)
+	}
+	if entry.PreviousVersions > 0 && entry.IsTemplateEntry() {
+		return false, fmt.Errorf("previousVersions cannot be combined with SecretTemplate")
+	}
+	return true, nil
+}
+
+// PreviousKey returns the Secret key of the n-th version before the selected
+// one, starting at 1
+func (entry KeyvaultSecretEntry) PreviousKey(n int) string {
+	suffix := entry.PreviousKeySuffix
+	if suffix == "" {
+		suffix = DefaultPreviousKeySuffix
+	}
+	if n == 1 {
+		return entry.KubernetesName + suffix
+	}
+	return fmt.Sprintf("%s%s_%d", entry.KubernetesName, suffix, n)
+}
+
+// GetVersionPolicyType returns the version policy type, which defaults to
+// VersionPolicyPinned if KeyvaultVersion is set and VersionPolicyLatest
+// otherwise
+func (entry KeyvaultSecretEntry) GetVersionPolicyType() VersionPolicyType {
+	if entry.VersionPolicy.Type != "" {
+		return entry.VersionPolicy.Type
+	}
+	if entry.KeyvaultVersion != "" {
+		return VersionPolicyPinned
+	}
+	return VersionPolicyLatest
+}
+
+// IsValid checks the type and the settings of the generator
+func (generator SecretGenerator) IsValid() (bool, error) {
+	switch generator.Type {
+	case GeneratorTypePassword:
+		if generator.Length < 0 {
+			return false, fmt.Errorf("length must not be negative")
+		}
+		if generator.Length > MaxPasswordLength {
+			return false, fmt.Errorf("passwords must not be longer than %d characters", MaxPasswordLength)
+		}
+	case GeneratorTypeRSA:
+		if generator.Bits != 0 && generator.Bits < 2048 {
+			return false, fmt.Errorf("RSA keys must have at least 2048 bits")
+		}
+		if generator.Bits > MaxRSABits {
+			return false, fmt.Errorf("RSA keys must not have more than %d bits", MaxRSABits)
+		}
+	case GeneratorTypeECDSA:
+		switch generator.Bits {
+		case 0, 256, 384, 521:
+		default:
+			return false, fmt.Errorf("unsupported ECDSA curve size %d", generator.Bits)
+		}
+	case GeneratorTypeEd25519, GeneratorTypeUUID:
+	default:
+		return false, fmt.Errorf("unknown generator type %q", generator.Type)
+	}
+	return true, nil
+}
+
+// GetConflictPolicy returns the conflict policy, which defaults to
+// ConflictPolicyFail
+func (spec PushSecretSpec) GetConflictPolicy() ConflictPolicy {
+	if spec.ConflictPolicy == "" {
+		return ConflictPolicyFail
+	}
+	return spec.ConflictPolicy
+}
+
+// GetDeletionPolicy returns the deletion policy, which defaults to
+// DeletionPolicyRetain
+func (spec PushSecretSpec) GetDeletionPolicy() DeletionPolicy {
+	if spec.DeletionPolicy == "" {
+		return DeletionPolicyRetain
+	}
+	return spec.DeletionPolicy
+}
+
+// IsValid checks the policies and items of the spec
+func (spec PushSecretSpec) IsValid() (bool, error) {
+	if spec.SecretName == "" {
+		return false, fmt.Errorf("secretName must be set")
+	}
+	switch spec.GetConflictPolicy() {
+	case ConflictPolicyFail, ConflictPolicyOverwrite:
+	default:
+		return false, fmt.Errorf("unknown conflictPolicy %q", spec.ConflictPolicy)
+	}
+	switch spec.GetDeletionPolicy() {
+	case DeletionPolicyRetain, DeletionPolicyDelete:
+	default:
+		return false, fmt.Errorf("unknown deletionPolicy %q", spec.DeletionPolicy)
+	}
+	keyvaultNames := make(map[string]bool)
+	for _, item := range spec.Items {
+		if item.KubernetesName == "" || item.KeyvaultName == "" {
+			return false, fmt.Errorf("kubernetesName and keyvaultName must be set")
+		}
+		if keyvaultNames[item.KeyvaultName] {
+			return false, fmt.Errorf("keyvaultName %q is used more than once", item.KeyvaultName)
+		}
+		keyvaultNames[item.KeyvaultName] = true
+	}
+	return true, nil
+}
+
+// IsValid checks the namespace selection and the template of the spec
+func (spec ClusterKeyvaultSecretSpec) IsValid() (bool, error) {
+	if spec.NamespaceSelector == nil && len(spec.Namespaces) == 0 {
+		return false, fmt.Errorf("one of namespaceSelector and namespaces must be set")
+	}
+	if spec.Template.SecretName == "" {
+		return false, fmt.Errorf("template.secretName must be set")
+	}
+	if ok, err := spec.Template.IsValid(); !ok {
+		return false, fmt.Errorf("invalid template: %v", err)
+	}
 	return true, nil
 }
@@ -0,0 +1,131 @@
+// Code generated by client-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	v1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+	scheme "github.com/twendt/secret-controller/pkg/client/clientset/versioned/scheme"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	rest "k8s.io/client-go/rest"
+)
+
+// ClusterKeyvaultSecretsGetter has a method to return a ClusterKeyvaultSecretInterface.
+// A group's client should implement this interface.
+type ClusterKeyvaultSecretsGetter interface {
+	ClusterKeyvaultSecrets() ClusterKeyvaultSecretInterface
+}
+
+// ClusterKeyvaultSecretInterface has methods to work with ClusterKeyvaultSecret resources.
+type ClusterKeyvaultSecretInterface interface {
+	Create(*v1alpha1.ClusterKeyvaultSecret) (*v1alpha1.ClusterKeyvaultSecret, error)
+	Update(*v1alpha1.ClusterKeyvaultSecret) (*v1alpha1.ClusterKeyvaultSecret, error)
+	Delete(name string, options *v1.DeleteOptions) error
+	DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error
+	Get(name string, options v1.GetOptions) (*v1alpha1.ClusterKeyvaultSecret, error)
+	List(opts v1.ListOptions) (*v1alpha1.ClusterKeyvaultSecretList, error)
+	Watch(opts v1.ListOptions) (watch.Interface, error)
+	Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1alpha1.ClusterKeyvaultSecret, err error)
+	ClusterKeyvaultSecretExpansion
+}
+
+// clusterKeyvaultSecrets implements ClusterKeyvaultSecretInterface
+type clusterKeyvaultSecrets struct {
+	client rest.Interface
+}
+
+// newClusterKeyvaultSecrets returns a ClusterKeyvaultSecrets
+func newClusterKeyvaultSecrets(c *SecretcontrollerV1alpha1Client) *clusterKeyvaultSecrets {
+	return &clusterKeyvaultSecrets{
+		client: c.RESTClient(),
+	}
+}
+
+// Get takes name of the clusterKeyvaultSecret, and returns the corresponding clusterKeyvaultSecret object, and an error if there is any.
+func (c *clusterKeyvaultSecrets) Get(name string, options v1.GetOptions) (result *v1alpha1.ClusterKeyvaultSecret, err error) {
+	result = &v1alpha1.ClusterKeyvaultSecret{}
+	err = c.client.Get().
+		Resource("clusterkeyvaultsecrets").
+		Name(name).
+		VersionedParams(&options, scheme.ParameterCodec).
+		Do().
+		Into(result)
+	return
+}
+
+// List takes label and field selectors, and returns the list of ClusterKeyvaultSecrets that match those selectors.
+func (c *clusterKeyvaultSecrets) List(opts v1.ListOptions) (result *v1alpha1.ClusterKeyvaultSecretList, err error) {
+	result = &v1alpha1.ClusterKeyvaultSecretList{}
+	err = c.client.Get().
+		Resource("clusterkeyvaultsecrets").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Do().
+		Into(result)
+	return
+}
+
+// Watch returns a watch.Interface that watches the requested clusterKeyvaultSecrets.
+func (c *clusterKeyvaultSecrets) Watch(opts v1.ListOptions) (watch.Interface, error) {
+	opts.Watch = true
+	return c.client.Get().
+		Resource("clusterkeyvaultsecrets").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Watch()
+}
+
+// Create takes the representation of a clusterKeyvaultSecret and creates it.  Returns the server's representation of the clusterKeyvaultSecret, and an error, if there is any.
+func (c *clusterKeyvaultSecrets) Create(clusterKeyvaultSecret *v1alpha1.ClusterKeyvaultSecret) (result *v1alpha1.ClusterKeyvaultSecret, err error) {
+	result = &v1alpha1.ClusterKeyvaultSecret{}
+	err = c.client.Post().
+		Resource("clusterkeyvaultsecrets").
+		Body(clusterKeyvaultSecret).
+		Do().
+		Into(result)
+	return
+}
+
+// Update takes the representation of a clusterKeyvaultSecret and updates it. Returns the server's representation of the clusterKeyvaultSecret, and an error, if there is any.
+func (c *clusterKeyvaultSecrets) Update(clusterKeyvaultSecret *v1alpha1.ClusterKeyvaultSecret) (result *v1alpha1.ClusterKeyvaultSecret, err error) {
+	result = &v1alpha1.ClusterKeyvaultSecret{}
+	err = c.client.Put().
+		Resource("clusterkeyvaultsecrets").
+		Name(clusterKeyvaultSecret.Name).
+		Body(clusterKeyvaultSecret).
+		Do().
+		Into(result)
+	return
+}
+
+// Delete takes name of the clusterKeyvaultSecret and deletes it. Returns an error if one occurs.
+func (c *clusterKeyvaultSecrets) Delete(name string, options *v1.DeleteOptions) error {
+	return c.client.Delete().
+		Resource("clusterkeyvaultsecrets").
+		Name(name).
+		Body(options).
+		Do().
+		Error()
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *clusterKeyvaultSecrets) DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error {
+	return c.client.Delete().
+		Resource("clusterkeyvaultsecrets").
+		VersionedParams(&listOptions, scheme.ParameterCodec).
+		Body(options).
+		Do().
+		Error()
+}
+
+// Patch applies the patch and returns the patched clusterKeyvaultSecret.
+func (c *clusterKeyvaultSecrets) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1alpha1.ClusterKeyvaultSecret, err error) {
+	result = &v1alpha1.ClusterKeyvaultSecret{}
+	err = c.client.Patch(pt).
+		Resource("clusterkeyvaultsecrets").
+		SubResource(subresources...).
+		Name(name).
+		Body(data).
+		Do().
+		Into(result)
+	return
+}
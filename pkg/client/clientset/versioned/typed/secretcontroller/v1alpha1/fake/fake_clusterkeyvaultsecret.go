@@ -0,0 +1,104 @@
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	v1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	schema "k8s.io/apimachinery/pkg/runtime/schema"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	testing "k8s.io/client-go/testing"
+)
+
+// FakeClusterKeyvaultSecrets implements ClusterKeyvaultSecretInterface
+type FakeClusterKeyvaultSecrets struct {
+	Fake *FakeSecretcontrollerV1alpha1
+}
+
+var clusterkeyvaultsecretsResource = schema.GroupVersionResource{Group: "secretcontroller.twendt.de", Version: "v1alpha1", Resource: "clusterkeyvaultsecrets"}
+
+var clusterkeyvaultsecretsKind = schema.GroupVersionKind{Group: "secretcontroller.twendt.de", Version: "v1alpha1", Kind: "ClusterKeyvaultSecret"}
+
+// Get takes name of the clusterKeyvaultSecret, and returns the corresponding clusterKeyvaultSecret object, and an error if there is any.
+func (c *FakeClusterKeyvaultSecrets) Get(name string, options v1.GetOptions) (result *v1alpha1.ClusterKeyvaultSecret, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootGetAction(clusterkeyvaultsecretsResource, name), &v1alpha1.ClusterKeyvaultSecret{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.ClusterKeyvaultSecret), err
+}
+
+// List takes label and field selectors, and returns the list of ClusterKeyvaultSecrets that match those selectors.
+func (c *FakeClusterKeyvaultSecrets) List(opts v1.ListOptions) (result *v1alpha1.ClusterKeyvaultSecretList, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootListAction(clusterkeyvaultsecretsResource, clusterkeyvaultsecretsKind, opts), &v1alpha1.ClusterKeyvaultSecretList{})
+	if obj == nil {
+		return nil, err
+	}
+
+	label, _, _ := testing.ExtractFromListOptions(opts)
+	if label == nil {
+		label = labels.Everything()
+	}
+	list := &v1alpha1.ClusterKeyvaultSecretList{ListMeta: obj.(*v1alpha1.ClusterKeyvaultSecretList).ListMeta}
+	for _, item := range obj.(*v1alpha1.ClusterKeyvaultSecretList).Items {
+		if label.Matches(labels.Set(item.Labels)) {
+			list.Items = append(list.Items, item)
+		}
+	}
+	return list, err
+}
+
+// Watch returns a watch.Interface that watches the requested clusterKeyvaultSecrets.
+func (c *FakeClusterKeyvaultSecrets) Watch(opts v1.ListOptions) (watch.Interface, error) {
+	return c.Fake.
+		InvokesWatch(testing.NewRootWatchAction(clusterkeyvaultsecretsResource, opts))
+}
+
+// Create takes the representation of a clusterKeyvaultSecret and creates it.  Returns the server's representation of the clusterKeyvaultSecret, and an error, if there is any.
+func (c *FakeClusterKeyvaultSecrets) Create(clusterKeyvaultSecret *v1alpha1.ClusterKeyvaultSecret) (result *v1alpha1.ClusterKeyvaultSecret, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootCreateAction(clusterkeyvaultsecretsResource, clusterKeyvaultSecret), &v1alpha1.ClusterKeyvaultSecret{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.ClusterKeyvaultSecret), err
+}
+
+// Update takes the representation of a clusterKeyvaultSecret and updates it. Returns the server's representation of the clusterKeyvaultSecret, and an error, if there is any.
+func (c *FakeClusterKeyvaultSecrets) Update(clusterKeyvaultSecret *v1alpha1.ClusterKeyvaultSecret) (result *v1alpha1.ClusterKeyvaultSecret, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootUpdateAction(clusterkeyvaultsecretsResource, clusterKeyvaultSecret), &v1alpha1.ClusterKeyvaultSecret{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.ClusterKeyvaultSecret), err
+}
+
+// Delete takes name of the clusterKeyvaultSecret and deletes it. Returns an error if one occurs.
+func (c *FakeClusterKeyvaultSecrets) Delete(name string, options *v1.DeleteOptions) error {
+	_, err := c.Fake.
+		Invokes(testing.NewRootDeleteAction(clusterkeyvaultsecretsResource, name), &v1alpha1.ClusterKeyvaultSecret{})
+	return err
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *FakeClusterKeyvaultSecrets) DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error {
+	action := testing.NewRootDeleteCollectionAction(clusterkeyvaultsecretsResource, listOptions)
+
+	_, err := c.Fake.Invokes(action, &v1alpha1.ClusterKeyvaultSecretList{})
+	return err
+}
+
+// Patch applies the patch and returns the patched clusterKeyvaultSecret.
+func (c *FakeClusterKeyvaultSecrets) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1alpha1.ClusterKeyvaultSecret, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootPatchSubresourceAction(clusterkeyvaultsecretsResource, name, pt, data, subresources...), &v1alpha1.ClusterKeyvaultSecret{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.ClusterKeyvaultSecret), err
+}
@@ -0,0 +1,112 @@
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	v1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	schema "k8s.io/apimachinery/pkg/runtime/schema"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	testing "k8s.io/client-go/testing"
+)
+
+// FakePushSecrets implements PushSecretInterface
+type FakePushSecrets struct {
+	Fake *FakeSecretcontrollerV1alpha1
+	ns   string
+}
+
+var pushsecretsResource = schema.GroupVersionResource{Group: "secretcontroller.twendt.de", Version: "v1alpha1", Resource: "pushsecrets"}
+
+var pushsecretsKind = schema.GroupVersionKind{Group: "secretcontroller.twendt.de", Version: "v1alpha1", Kind: "PushSecret"}
+
+// Get takes name of the pushSecret, and returns the corresponding pushSecret object, and an error if there is any.
+func (c *FakePushSecrets) Get(name string, options v1.GetOptions) (result *v1alpha1.PushSecret, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewGetAction(pushsecretsResource, c.ns, name), &v1alpha1.PushSecret{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.PushSecret), err
+}
+
+// List takes label and field selectors, and returns the list of PushSecrets that match those selectors.
+func (c *FakePushSecrets) List(opts v1.ListOptions) (result *v1alpha1.PushSecretList, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewListAction(pushsecretsResource, pushsecretsKind, c.ns, opts), &v1alpha1.PushSecretList{})
+
+	if obj == nil {
+		return nil, err
+	}
+
+	label, _, _ := testing.ExtractFromListOptions(opts)
+	if label == nil {
+		label = labels.Everything()
+	}
+	list := &v1alpha1.PushSecretList{ListMeta: obj.(*v1alpha1.PushSecretList).ListMeta}
+	for _, item := range obj.(*v1alpha1.PushSecretList).Items {
+		if label.Matches(labels.Set(item.Labels)) {
+			list.Items = append(list.Items, item)
+		}
+	}
+	return list, err
+}
+
+// Watch returns a watch.Interface that watches the requested pushSecrets.
+func (c *FakePushSecrets) Watch(opts v1.ListOptions) (watch.Interface, error) {
+	return c.Fake.
+		InvokesWatch(testing.NewWatchAction(pushsecretsResource, c.ns, opts))
+
+}
+
+// Create takes the representation of a pushSecret and creates it.  Returns the server's representation of the pushSecret, and an error, if there is any.
+func (c *FakePushSecrets) Create(pushSecret *v1alpha1.PushSecret) (result *v1alpha1.PushSecret, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewCreateAction(pushsecretsResource, c.ns, pushSecret), &v1alpha1.PushSecret{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.PushSecret), err
+}
+
+// Update takes the representation of a pushSecret and updates it. Returns the server's representation of the pushSecret, and an error, if there is any.
+func (c *FakePushSecrets) Update(pushSecret *v1alpha1.PushSecret) (result *v1alpha1.PushSecret, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateAction(pushsecretsResource, c.ns, pushSecret), &v1alpha1.PushSecret{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.PushSecret), err
+}
+
+// Delete takes name of the pushSecret and deletes it. Returns an error if one occurs.
+func (c *FakePushSecrets) Delete(name string, options *v1.DeleteOptions) error {
+	_, err := c.Fake.
+		Invokes(testing.NewDeleteAction(pushsecretsResource, c.ns, name), &v1alpha1.PushSecret{})
+
+	return err
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *FakePushSecrets) DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error {
+	action := testing.NewDeleteCollectionAction(pushsecretsResource, c.ns, listOptions)
+
+	_, err := c.Fake.Invokes(action, &v1alpha1.PushSecretList{})
+	return err
+}
+
+// Patch applies the patch and returns the patched pushSecret.
+func (c *FakePushSecrets) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1alpha1.PushSecret, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewPatchSubresourceAction(pushsecretsResource, c.ns, name, pt, data, subresources...), &v1alpha1.PushSecret{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.PushSecret), err
+}
@@ -12,10 +12,18 @@ type FakeSecretcontrollerV1alpha1 struct {
 	*testing.Fake
 }
 
+func (c *FakeSecretcontrollerV1alpha1) ClusterKeyvaultSecrets() v1alpha1.ClusterKeyvaultSecretInterface {
+	return &FakeClusterKeyvaultSecrets{c}
+}
+
 func (c *FakeSecretcontrollerV1alpha1) KeyvaultSecrets(namespace string) v1alpha1.KeyvaultSecretInterface {
 	return &FakeKeyvaultSecrets{c, namespace}
 }
 
+func (c *FakeSecretcontrollerV1alpha1) PushSecrets(namespace string) v1alpha1.PushSecretInterface {
+	return &FakePushSecrets{c, namespace}
+}
+
 // RESTClient returns a RESTClient that is used to communicate
 // with API server by this client implementation.
 func (c *FakeSecretcontrollerV1alpha1) RESTClient() rest.Interface {
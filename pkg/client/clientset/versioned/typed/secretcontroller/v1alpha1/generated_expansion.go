@@ -2,4 +2,8 @@
 
 package v1alpha1
 
+type ClusterKeyvaultSecretExpansion interface{}
+
 type KeyvaultSecretExpansion interface{}
+
+type PushSecretExpansion interface{}
@@ -0,0 +1,141 @@
+// Code generated by client-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	v1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+	scheme "github.com/twendt/secret-controller/pkg/client/clientset/versioned/scheme"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	rest "k8s.io/client-go/rest"
+)
+
+// PushSecretsGetter has a method to return a PushSecretInterface.
+// A group's client should implement this interface.
+type PushSecretsGetter interface {
+	PushSecrets(namespace string) PushSecretInterface
+}
+
+// PushSecretInterface has methods to work with PushSecret resources.
+type PushSecretInterface interface {
+	Create(*v1alpha1.PushSecret) (*v1alpha1.PushSecret, error)
+	Update(*v1alpha1.PushSecret) (*v1alpha1.PushSecret, error)
+	Delete(name string, options *v1.DeleteOptions) error
+	DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error
+	Get(name string, options v1.GetOptions) (*v1alpha1.PushSecret, error)
+	List(opts v1.ListOptions) (*v1alpha1.PushSecretList, error)
+	Watch(opts v1.ListOptions) (watch.Interface, error)
+	Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1alpha1.PushSecret, err error)
+	PushSecretExpansion
+}
+
+// pushSecrets implements PushSecretInterface
+type pushSecrets struct {
+	client rest.Interface
+	ns     string
+}
+
+// newPushSecrets returns a PushSecrets
+func newPushSecrets(c *SecretcontrollerV1alpha1Client, namespace string) *pushSecrets {
+	return &pushSecrets{
+		client: c.RESTClient(),
+		ns:     namespace,
+	}
+}
+
+// Get takes name of the pushSecret, and returns the corresponding pushSecret object, and an error if there is any.
+func (c *pushSecrets) Get(name string, options v1.GetOptions) (result *v1alpha1.PushSecret, err error) {
+	result = &v1alpha1.PushSecret{}
+	err = c.client.Get().
+		Namespace(c.ns).
+		Resource("pushsecrets").
+		Name(name).
+		VersionedParams(&options, scheme.ParameterCodec).
+		Do().
+		Into(result)
+	return
+}
+
+// List takes label and field selectors, and returns the list of PushSecrets that match those selectors.
+func (c *pushSecrets) List(opts v1.ListOptions) (result *v1alpha1.PushSecretList, err error) {
+	result = &v1alpha1.PushSecretList{}
+	err = c.client.Get().
+		Namespace(c.ns).
+		Resource("pushsecrets").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Do().
+		Into(result)
+	return
+}
+
+// Watch returns a watch.Interface that watches the requested pushSecrets.
+func (c *pushSecrets) Watch(opts v1.ListOptions) (watch.Interface, error) {
+	opts.Watch = true
+	return c.client.Get().
+		Namespace(c.ns).
+		Resource("pushsecrets").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Watch()
+}
+
+// Create takes the representation of a pushSecret and creates it.  Returns the server's representation of the pushSecret, and an error, if there is any.
+func (c *pushSecrets) Create(pushSecret *v1alpha1.PushSecret) (result *v1alpha1.PushSecret, err error) {
+	result = &v1alpha1.PushSecret{}
+	err = c.client.Post().
+		Namespace(c.ns).
+		Resource("pushsecrets").
+		Body(pushSecret).
+		Do().
+		Into(result)
+	return
+}
+
+// Update takes the representation of a pushSecret and updates it. Returns the server's representation of the pushSecret, and an error, if there is any.
+func (c *pushSecrets) Update(pushSecret *v1alpha1.PushSecret) (result *v1alpha1.PushSecret, err error) {
+	result = &v1alpha1.PushSecret{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("pushsecrets").
+		Name(pushSecret.Name).
+		Body(pushSecret).
+		Do().
+		Into(result)
+	return
+}
+
+// Delete takes name of the pushSecret and deletes it. Returns an error if one occurs.
+func (c *pushSecrets) Delete(name string, options *v1.DeleteOptions) error {
+	return c.client.Delete().
+		Namespace(c.ns).
+		Resource("pushsecrets").
+		Name(name).
+		Body(options).
+		Do().
+		Error()
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *pushSecrets) DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error {
+	return c.client.Delete().
+		Namespace(c.ns).
+		Resource("pushsecrets").
+		VersionedParams(&listOptions, scheme.ParameterCodec).
+		Body(options).
+		Do().
+		Error()
+}
+
+// Patch applies the patch and returns the patched pushSecret.
+func (c *pushSecrets) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1alpha1.PushSecret, err error) {
+	result = &v1alpha1.PushSecret{}
+	err = c.client.Patch(pt).
+		Namespace(c.ns).
+		Resource("pushsecrets").
+		SubResource(subresources...).
+		Name(name).
+		Body(data).
+		Do().
+		Into(result)
+	return
+}
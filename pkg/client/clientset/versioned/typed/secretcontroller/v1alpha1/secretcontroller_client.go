@@ -11,7 +11,9 @@ import (
 
 type SecretcontrollerV1alpha1Interface interface {
 	RESTClient() rest.Interface
+	ClusterKeyvaultSecretsGetter
 	KeyvaultSecretsGetter
+	PushSecretsGetter
 }
 
 // SecretcontrollerV1alpha1Client is used to interact with features provided by the secretcontroller.twendt.de group.
@@ -19,10 +21,18 @@ type SecretcontrollerV1alpha1Client struct {
 	restClient rest.Interface
 }
 
+func (c *SecretcontrollerV1alpha1Client) ClusterKeyvaultSecrets() ClusterKeyvaultSecretInterface {
+	return newClusterKeyvaultSecrets(c)
+}
+
 func (c *SecretcontrollerV1alpha1Client) KeyvaultSecrets(namespace string) KeyvaultSecretInterface {
 	return newKeyvaultSecrets(c, namespace)
 }
 
+func (c *SecretcontrollerV1alpha1Client) PushSecrets(namespace string) PushSecretInterface {
+	return newPushSecrets(c, namespace)
+}
+
 // NewForConfig creates a new SecretcontrollerV1alpha1Client for the given config.
 func NewForConfig(c *rest.Config) (*SecretcontrollerV1alpha1Client, error) {
 	config := *c
@@ -37,8 +37,12 @@ func (f *genericInformer) Lister() cache.GenericLister {
 func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource) (GenericInformer, error) {
 	switch resource {
 	// Group=secretcontroller.twendt.de, Version=v1alpha1
+	case v1alpha1.SchemeGroupVersion.WithResource("clusterkeyvaultsecrets"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Secretcontroller().V1alpha1().ClusterKeyvaultSecrets().Informer()}, nil
 	case v1alpha1.SchemeGroupVersion.WithResource("keyvaultsecrets"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Secretcontroller().V1alpha1().KeyvaultSecrets().Informer()}, nil
+	case v1alpha1.SchemeGroupVersion.WithResource("pushsecrets"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Secretcontroller().V1alpha1().PushSecrets().Informer()}, nil
 
 	}
 
@@ -0,0 +1,72 @@
+// Code generated by informer-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	time "time"
+
+	secretcontrollerv1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+	versioned "github.com/twendt/secret-controller/pkg/client/clientset/versioned"
+	internalinterfaces "github.com/twendt/secret-controller/pkg/client/informers/externalversions/internalinterfaces"
+	v1alpha1 "github.com/twendt/secret-controller/pkg/client/listers/secretcontroller/v1alpha1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	watch "k8s.io/apimachinery/pkg/watch"
+	cache "k8s.io/client-go/tools/cache"
+)
+
+// ClusterKeyvaultSecretInformer provides access to a shared informer and lister for
+// ClusterKeyvaultSecrets.
+type ClusterKeyvaultSecretInformer interface {
+	Informer() cache.SharedIndexInformer
+	Lister() v1alpha1.ClusterKeyvaultSecretLister
+}
+
+type clusterKeyvaultSecretInformer struct {
+	factory          internalinterfaces.SharedInformerFactory
+	tweakListOptions internalinterfaces.TweakListOptionsFunc
+}
+
+// NewClusterKeyvaultSecretInformer constructs a new informer for ClusterKeyvaultSecret type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewClusterKeyvaultSecretInformer(client versioned.Interface, resyncPeriod time.Duration, indexers cache.Indexers) cache.SharedIndexInformer {
+	return NewFilteredClusterKeyvaultSecretInformer(client, resyncPeriod, indexers, nil)
+}
+
+// NewFilteredClusterKeyvaultSecretInformer constructs a new informer for ClusterKeyvaultSecret type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewFilteredClusterKeyvaultSecretInformer(client versioned.Interface, resyncPeriod time.Duration, indexers cache.Indexers, tweakListOptions internalinterfaces.TweakListOptionsFunc) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options v1.ListOptions) (runtime.Object, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.SecretcontrollerV1alpha1().ClusterKeyvaultSecrets().List(options)
+			},
+			WatchFunc: func(options v1.ListOptions) (watch.Interface, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.SecretcontrollerV1alpha1().ClusterKeyvaultSecrets().Watch(options)
+			},
+		},
+		&secretcontrollerv1alpha1.ClusterKeyvaultSecret{},
+		resyncPeriod,
+		indexers,
+	)
+}
+
+func (f *clusterKeyvaultSecretInformer) defaultInformer(client versioned.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	return NewFilteredClusterKeyvaultSecretInformer(client, resyncPeriod, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, f.tweakListOptions)
+}
+
+func (f *clusterKeyvaultSecretInformer) Informer() cache.SharedIndexInformer {
+	return f.factory.InformerFor(&secretcontrollerv1alpha1.ClusterKeyvaultSecret{}, f.defaultInformer)
+}
+
+func (f *clusterKeyvaultSecretInformer) Lister() v1alpha1.ClusterKeyvaultSecretLister {
+	return v1alpha1.NewClusterKeyvaultSecretLister(f.Informer().GetIndexer())
+}
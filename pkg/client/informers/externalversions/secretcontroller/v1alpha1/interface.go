@@ -8,8 +8,12 @@ import (
 
 // Interface provides access to all the informers in this group version.
 type Interface interface {
+	// ClusterKeyvaultSecrets returns a ClusterKeyvaultSecretInformer.
+	ClusterKeyvaultSecrets() ClusterKeyvaultSecretInformer
 	// KeyvaultSecrets returns a KeyvaultSecretInformer.
 	KeyvaultSecrets() KeyvaultSecretInformer
+	// PushSecrets returns a PushSecretInformer.
+	PushSecrets() PushSecretInformer
 }
 
 type version struct {
@@ -23,7 +27,17 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 	return &version{factory: f, namespace: namespace, tweakListOptions: tweakListOptions}
 }
 
+// ClusterKeyvaultSecrets returns a ClusterKeyvaultSecretInformer.
+func (v *version) ClusterKeyvaultSecrets() ClusterKeyvaultSecretInformer {
+	return &clusterKeyvaultSecretInformer{factory: v.factory, tweakListOptions: v.tweakListOptions}
+}
+
 // KeyvaultSecrets returns a KeyvaultSecretInformer.
 func (v *version) KeyvaultSecrets() KeyvaultSecretInformer {
 	return &keyvaultSecretInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
 }
+
+// PushSecrets returns a PushSecretInformer.
+func (v *version) PushSecrets() PushSecretInformer {
+	return &pushSecretInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
@@ -0,0 +1,73 @@
+// Code generated by informer-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	time "time"
+
+	secretcontrollerv1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+	versioned "github.com/twendt/secret-controller/pkg/client/clientset/versioned"
+	internalinterfaces "github.com/twendt/secret-controller/pkg/client/informers/externalversions/internalinterfaces"
+	v1alpha1 "github.com/twendt/secret-controller/pkg/client/listers/secretcontroller/v1alpha1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	watch "k8s.io/apimachinery/pkg/watch"
+	cache "k8s.io/client-go/tools/cache"
+)
+
+// PushSecretInformer provides access to a shared informer and lister for
+// PushSecrets.
+type PushSecretInformer interface {
+	Informer() cache.SharedIndexInformer
+	Lister() v1alpha1.PushSecretLister
+}
+
+type pushSecretInformer struct {
+	factory          internalinterfaces.SharedInformerFactory
+	tweakListOptions internalinterfaces.TweakListOptionsFunc
+	namespace        string
+}
+
+// NewPushSecretInformer constructs a new informer for PushSecret type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewPushSecretInformer(client versioned.Interface, namespace string, resyncPeriod time.Duration, indexers cache.Indexers) cache.SharedIndexInformer {
+	return NewFilteredPushSecretInformer(client, namespace, resyncPeriod, indexers, nil)
+}
+
+// NewFilteredPushSecretInformer constructs a new informer for PushSecret type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewFilteredPushSecretInformer(client versioned.Interface, namespace string, resyncPeriod time.Duration, indexers cache.Indexers, tweakListOptions internalinterfaces.TweakListOptionsFunc) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options v1.ListOptions) (runtime.Object, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.SecretcontrollerV1alpha1().PushSecrets(namespace).List(options)
+			},
+			WatchFunc: func(options v1.ListOptions) (watch.Interface, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.SecretcontrollerV1alpha1().PushSecrets(namespace).Watch(options)
+			},
+		},
+		&secretcontrollerv1alpha1.PushSecret{},
+		resyncPeriod,
+		indexers,
+	)
+}
+
+func (f *pushSecretInformer) defaultInformer(client versioned.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	return NewFilteredPushSecretInformer(client, f.namespace, resyncPeriod, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, f.tweakListOptions)
+}
+
+func (f *pushSecretInformer) Informer() cache.SharedIndexInformer {
+	return f.factory.InformerFor(&secretcontrollerv1alpha1.PushSecret{}, f.defaultInformer)
+}
+
+func (f *pushSecretInformer) Lister() v1alpha1.PushSecretLister {
+	return v1alpha1.NewPushSecretLister(f.Informer().GetIndexer())
+}
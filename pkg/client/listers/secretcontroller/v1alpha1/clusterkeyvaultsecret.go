@@ -0,0 +1,49 @@
+// Code generated by lister-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	v1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ClusterKeyvaultSecretLister helps list ClusterKeyvaultSecrets.
+type ClusterKeyvaultSecretLister interface {
+	// List lists all ClusterKeyvaultSecrets in the indexer.
+	List(selector labels.Selector) (ret []*v1alpha1.ClusterKeyvaultSecret, err error)
+	// Get retrieves the ClusterKeyvaultSecret from the index for a given name.
+	Get(name string) (*v1alpha1.ClusterKeyvaultSecret, error)
+	ClusterKeyvaultSecretListerExpansion
+}
+
+// clusterKeyvaultSecretLister implements the ClusterKeyvaultSecretLister interface.
+type clusterKeyvaultSecretLister struct {
+	indexer cache.Indexer
+}
+
+// NewClusterKeyvaultSecretLister returns a new ClusterKeyvaultSecretLister.
+func NewClusterKeyvaultSecretLister(indexer cache.Indexer) ClusterKeyvaultSecretLister {
+	return &clusterKeyvaultSecretLister{indexer: indexer}
+}
+
+// List lists all ClusterKeyvaultSecrets in the indexer.
+func (s *clusterKeyvaultSecretLister) List(selector labels.Selector) (ret []*v1alpha1.ClusterKeyvaultSecret, err error) {
+	err = cache.ListAll(s.indexer, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1alpha1.ClusterKeyvaultSecret))
+	})
+	return ret, err
+}
+
+// Get retrieves the ClusterKeyvaultSecret from the index for a given name.
+func (s *clusterKeyvaultSecretLister) Get(name string) (*v1alpha1.ClusterKeyvaultSecret, error) {
+	obj, exists, err := s.indexer.GetByKey(name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(v1alpha1.Resource("clusterkeyvaultsecret"), name)
+	}
+	return obj.(*v1alpha1.ClusterKeyvaultSecret), nil
+}
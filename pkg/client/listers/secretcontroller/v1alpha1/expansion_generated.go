@@ -2,6 +2,10 @@
 
 package v1alpha1
 
+// ClusterKeyvaultSecretListerExpansion allows custom methods to be added to
+// ClusterKeyvaultSecretLister.
+type ClusterKeyvaultSecretListerExpansion interface{}
+
 // KeyvaultSecretListerExpansion allows custom methods to be added to
 // KeyvaultSecretLister.
 type KeyvaultSecretListerExpansion interface{}
@@ -9,3 +13,11 @@ type KeyvaultSecretListerExpansion interface{}
 // KeyvaultSecretNamespaceListerExpansion allows custom methods to be added to
 // KeyvaultSecretNamespaceLister.
 type KeyvaultSecretNamespaceListerExpansion interface{}
+
+// PushSecretListerExpansion allows custom methods to be added to
+// PushSecretLister.
+type PushSecretListerExpansion interface{}
+
+// PushSecretNamespaceListerExpansion allows custom methods to be added to
+// PushSecretNamespaceLister.
+type PushSecretNamespaceListerExpansion interface{}
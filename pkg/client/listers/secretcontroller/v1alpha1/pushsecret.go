@@ -0,0 +1,78 @@
+// Code generated by lister-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	v1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// PushSecretLister helps list PushSecrets.
+type PushSecretLister interface {
+	// List lists all PushSecrets in the indexer.
+	List(selector labels.Selector) (ret []*v1alpha1.PushSecret, err error)
+	// PushSecrets returns an object that can list and get PushSecrets.
+	PushSecrets(namespace string) PushSecretNamespaceLister
+	PushSecretListerExpansion
+}
+
+// pushSecretLister implements the PushSecretLister interface.
+type pushSecretLister struct {
+	indexer cache.Indexer
+}
+
+// NewPushSecretLister returns a new PushSecretLister.
+func NewPushSecretLister(indexer cache.Indexer) PushSecretLister {
+	return &pushSecretLister{indexer: indexer}
+}
+
+// List lists all PushSecrets in the indexer.
+func (s *pushSecretLister) List(selector labels.Selector) (ret []*v1alpha1.PushSecret, err error) {
+	err = cache.ListAll(s.indexer, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1alpha1.PushSecret))
+	})
+	return ret, err
+}
+
+// PushSecrets returns an object that can list and get PushSecrets.
+func (s *pushSecretLister) PushSecrets(namespace string) PushSecretNamespaceLister {
+	return pushSecretNamespaceLister{indexer: s.indexer, namespace: namespace}
+}
+
+// PushSecretNamespaceLister helps list and get PushSecrets.
+type PushSecretNamespaceLister interface {
+	// List lists all PushSecrets in the indexer for a given namespace.
+	List(selector labels.Selector) (ret []*v1alpha1.PushSecret, err error)
+	// Get retrieves the PushSecret from the indexer for a given namespace and name.
+	Get(name string) (*v1alpha1.PushSecret, error)
+	PushSecretNamespaceListerExpansion
+}
+
+// pushSecretNamespaceLister implements the PushSecretNamespaceLister
+// interface.
+type pushSecretNamespaceLister struct {
+	indexer   cache.Indexer
+	namespace string
+}
+
+// List lists all PushSecrets in the indexer for a given namespace.
+func (s pushSecretNamespaceLister) List(selector labels.Selector) (ret []*v1alpha1.PushSecret, err error) {
+	err = cache.ListAllByNamespace(s.indexer, s.namespace, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1alpha1.PushSecret))
+	})
+	return ret, err
+}
+
+// Get retrieves the PushSecret from the indexer for a given namespace and name.
+func (s pushSecretNamespaceLister) Get(name string) (*v1alpha1.PushSecret, error) {
+	obj, exists, err := s.indexer.GetByKey(s.namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(v1alpha1.Resource("pushsecret"), name)
+	}
+	return obj.(*v1alpha1.PushSecret), nil
+}
@@ -0,0 +1,197 @@
+package generator
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/ed25519"
+
+	keyvaultsecretv1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+)
+
+const (
+	defaultPasswordLength = 32
+	defaultCharset        = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	defaultRSABits        = 2048
+
+	// contentTypePEM is the Key Vault content type of generated private keys
+	contentTypePEM = "application/x-pem-file"
+)
+
+// oidEd25519 identifies Ed25519 keys, see RFC 8410
+var oidEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+// Generate returns a new value and its content type for generator
+func Generate(generator keyvaultsecretv1alpha1.SecretGenerator) (string, string, error) {
+	if _, err := generator.IsValid(); err != nil {
+		return "", "", err
+	}
+	switch generator.Type {
+	case keyvaultsecretv1alpha1.GeneratorTypePassword:
+		value, err := password(generator.Length, generator.Charset)
+		return value, "", err
+	case keyvaultsecretv1alpha1.GeneratorTypeUUID:
+		value, err := uuid()
+		return value, "", err
+	}
+
+	var key crypto.Signer
+	var err error
+	switch generator.Type {
+	case keyvaultsecretv1alpha1.GeneratorTypeRSA:
+		bits := generator.Bits
+		if bits == 0 {
+			bits = defaultRSABits
+		}
+		key, err = rsa.GenerateKey(rand.Reader, bits)
+	case keyvaultsecretv1alpha1.GeneratorTypeECDSA:
+		key, err = ecdsa.GenerateKey(curve(generator.Bits), rand.Reader)
+	case keyvaultsecretv1alpha1.GeneratorTypeEd25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	der, err := marshalPrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), contentTypePEM, nil
+}
+
+// PublicKey returns the PEM encoded public key of a PEM encoded PKCS #8
+// private key created by Generate
+func PublicKey(privateKey string) (string, error) {
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return "", fmt.Errorf("no PEM data found")
+	}
+	key, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+	der, err := marshalPublicKey(key.Public())
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// password returns a random password of the given length made of the
+// characters in charset
+func password(length int, charset string) (string, error) {
+	if length == 0 {
+		length = defaultPasswordLength
+	}
+	if charset == "" {
+		charset = defaultCharset
+	}
+	chars := []rune(charset)
+	max := big.NewInt(int64(len(chars)))
+	value := make([]rune, length)
+	for i := range value {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		value[i] = chars[n.Int64()]
+	}
+	return string(value), nil
+}
+
+// uuid returns a random UUID as defined in RFC 4122 section 4.4
+func uuid() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+func curve(bits int) elliptic.Curve {
+	switch bits {
+	case 384:
+		return elliptic.P384()
+	case 521:
+		return elliptic.P521()
+	default:
+		return elliptic.P256()
+	}
+}
+
+// pkcs8 and publicKeyInfo are the ASN.1 structures of private and public
+// keys. They are only used for Ed25519 keys, as crypto/x509 does not
+// support them.
+type pkcs8 struct {
+	Version    int
+	Algorithm  pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+type publicKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+func marshalPrivateKey(key crypto.Signer) ([]byte, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return x509.MarshalPKCS8PrivateKey(key)
+	}
+	seed, err := asn1.Marshal(privateKey.Seed())
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs8{
+		Algorithm:  pkix.AlgorithmIdentifier{Algorithm: oidEd25519},
+		PrivateKey: seed,
+	})
+}
+
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	var info pkcs8
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	}
+	if info.Algorithm.Algorithm.Equal(oidEd25519) {
+		var seed []byte
+		if _, err := asn1.Unmarshal(info.PrivateKey, &seed); err != nil {
+			return nil, err
+		}
+		if len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("invalid Ed25519 private key")
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+func marshalPublicKey(key crypto.PublicKey) ([]byte, error) {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return x509.MarshalPKIXPublicKey(key)
+	}
+	return asn1.Marshal(publicKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidEd25519},
+		PublicKey: asn1.BitString{Bytes: publicKey, BitLength: 8 * len(publicKey)},
+	})
+}
@@ -0,0 +1,109 @@
+package generator
+
+import (
+	"encoding/pem"
+	"regexp"
+	"strings"
+	"testing"
+
+	keyvaultsecretv1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+)
+
+func TestGenerate(t *testing.T) {
+	uuidPattern := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	tests := []struct {
+		name      string
+		generator keyvaultsecretv1alpha1.SecretGenerator
+		check     func(t *testing.T, value string)
+		wantErr   bool
+	}{
+		{
+			name:      "default password",
+			generator: keyvaultsecretv1alpha1.SecretGenerator{Type: keyvaultsecretv1alpha1.GeneratorTypePassword},
+			check: func(t *testing.T, value string) {
+				if len(value) != defaultPasswordLength {
+					t.Errorf("len(password) = %d, want %d", len(value), defaultPasswordLength)
+				}
+			},
+		},
+		{
+			name:      "password with charset",
+			generator: keyvaultsecretv1alpha1.SecretGenerator{Type: keyvaultsecretv1alpha1.GeneratorTypePassword, Length: 64, Charset: "ab"},
+			check: func(t *testing.T, value string) {
+				if len(value) != 64 || strings.Trim(value, "ab") != "" {
+					t.Errorf("password = %q, want 64 characters of %q", value, "ab")
+				}
+			},
+		},
+		{
+			name:      "UUID",
+			generator: keyvaultsecretv1alpha1.SecretGenerator{Type: keyvaultsecretv1alpha1.GeneratorTypeUUID},
+			check: func(t *testing.T, value string) {
+				if !uuidPattern.MatchString(value) {
+					t.Errorf("uuid = %q, want a version 4 UUID", value)
+				}
+			},
+		},
+		{
+			name:      "RSA",
+			generator: keyvaultsecretv1alpha1.SecretGenerator{Type: keyvaultsecretv1alpha1.GeneratorTypeRSA},
+			check:     checkKeyPair,
+		},
+		{
+			name:      "ECDSA",
+			generator: keyvaultsecretv1alpha1.SecretGenerator{Type: keyvaultsecretv1alpha1.GeneratorTypeECDSA, Bits: 384},
+			check:     checkKeyPair,
+		},
+		{
+			name:      "Ed25519",
+			generator: keyvaultsecretv1alpha1.SecretGenerator{Type: keyvaultsecretv1alpha1.GeneratorTypeEd25519},
+			check:     checkKeyPair,
+		},
+		{
+			name:      "weak RSA key",
+			generator: keyvaultsecretv1alpha1.SecretGenerator{Type: keyvaultsecretv1alpha1.GeneratorTypeRSA, Bits: 1024},
+			wantErr:   true,
+		},
+		{
+			name:      "large RSA key",
+			generator: keyvaultsecretv1alpha1.SecretGenerator{Type: keyvaultsecretv1alpha1.GeneratorTypeRSA, Bits: 16384},
+			wantErr:   true,
+		},
+		{
+			name:      "long password",
+			generator: keyvaultsecretv1alpha1.SecretGenerator{Type: keyvaultsecretv1alpha1.GeneratorTypePassword, Length: 1 << 20},
+			wantErr:   true,
+		},
+		{
+			name:      "unknown type",
+			generator: keyvaultsecretv1alpha1.SecretGenerator{Type: "DSA"},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, _, err := Generate(tt.generator)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Generate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.check != nil {
+				tt.check(t, value)
+			}
+		})
+	}
+}
+
+func checkKeyPair(t *testing.T, value string) {
+	block, _ := pem.Decode([]byte(value))
+	if block == nil || block.Type != "PRIVATE KEY" {
+		t.Fatalf("Generate() = %q, want a PEM encoded private key", value)
+	}
+	publicKey, err := PublicKey(value)
+	if err != nil {
+		t.Fatalf("PublicKey() error = %v", err)
+	}
+	block, _ = pem.Decode([]byte(publicKey))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		t.Errorf("PublicKey() = %q, want a PEM encoded public key", publicKey)
+	}
+}
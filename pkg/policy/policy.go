@@ -0,0 +1,219 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+// Policy defines which Key Vault secrets the KeyvaultSecrets and
+// PushSecrets of a namespace may read and write. A namespace that is not
+// matched by any rule may not access any secret. It also defines which
+// Secrets and ConfigMaps of other namespaces they may read.
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Rule allows the namespaces matching one of Namespaces to read the secrets
+// matching one of Secrets. Both are lists of shell patterns as understood by
+// path.Match.
+type Rule struct {
+	Namespaces []string `json:"namespaces"`
+	Secrets    []string `json:"secrets"`
+	// WriteSecrets are patterns of the secrets that PushSecrets and
+	// generators may write and delete. Secrets are only written if they
+	// match, reading them is not enough.
+	WriteSecrets []string `json:"writeSecrets,omitempty"`
+	// Sources are patterns of the Secrets and ConfigMaps of other
+	// namespaces in the form <kind>/<namespace>/<name>, e.g.
+	// secret/databases/*-credentials
+	Sources []string `json:"sources,omitempty"`
+}
+
+// Parse reads a Policy from YAML or JSON and validates its patterns
+func Parse(data []byte) (*Policy, error) {
+	var p Policy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	for _, rule := range p.Rules {
+		patterns := append(append(append(append([]string{}, rule.Namespaces...), rule.Secrets...), rule.WriteSecrets...), rule.Sources...)
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %s", pattern, err)
+			}
+		}
+	}
+	return &p, nil
+}
+
+// Allowed reports whether namespace may access the secret with the given
+// name. Secret names are compared case-insensitively like in Key Vault.
+// A nil Policy allows everything.
+func (p *Policy) Allowed(namespace, name string) bool {
+	if p == nil {
+		return true
+	}
+	for _, rule := range p.Rules {
+		if matchAny(rule.Namespaces, namespace) && matchAny(rule.Secrets, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteAllowed reports whether namespace may write or delete the secret with
+// the given name. Unlike reads, writes are denied without a Policy, so that
+// no namespace can overwrite or delete the secrets of others by default.
+func (p *Policy) WriteAllowed(namespace, name string) bool {
+	if p == nil {
+		return false
+	}
+	for _, rule := range p.Rules {
+		if matchAny(rule.Namespaces, namespace) && matchAny(rule.WriteSecrets, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// SourceAllowed reports whether namespace may read the Secret or ConfigMap
+// (kind secret or configmap) sourceNamespace/name. Every namespace may read
+// its own Secrets and ConfigMaps. Unlike Key Vault secrets, those of other
+// namespaces may not be read without a Policy.
+func (p *Policy) SourceAllowed(namespace, kind, sourceNamespace, name string) bool {
+	if namespace == sourceNamespace {
+		return true
+	}
+	if p == nil {
+		return false
+	}
+	source := kind + "/" + sourceNamespace + "/" + name
+	for _, rule := range p.Rules {
+		if matchAny(rule.Namespaces, namespace) && matchAny(rule.Sources, source) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// AccessDeniedError is returned by Client for secrets the namespace may not
+// read or write
+type AccessDeniedError struct {
+	Namespace string
+	Name      string
+	// Write is set if the secret may not be written
+	Write bool
+}
+
+func (e *AccessDeniedError) Error() string {
+	if e.Write {
+		return fmt.Sprintf("namespace %q is not allowed to write secret %q", e.Namespace, e.Name)
+	}
+	return fmt.Sprintf("namespace %q is not allowed to read secret %q", e.Namespace, e.Name)
+}
+
+// IsAccessDenied reports whether err is an AccessDeniedError
+func IsAccessDenied(err error) bool {
+	_, ok := err.(*AccessDeniedError)
+	return ok
+}
+
+// Client is a secretstore.Client that only returns the secrets a namespace
+// may read and only writes the secrets it may write according to a Policy.
+// A nil Policy allows all reads and denies all writes.
+type Client struct {
+	client    secretstore.Client
+	policy    *Policy
+	namespace string
+}
+
+// NewClient returns a Client that enforces policy on client for namespace
+func NewClient(client secretstore.Client, policy *Policy, namespace string) *Client {
+	return &Client{
+		client:    client,
+		policy:    policy,
+		namespace: namespace,
+	}
+}
+
+func (c *Client) GetSecretValue(ctx context.Context, name string) (string, error) {
+	return c.GetSecretValueForVersion(ctx, name, "")
+}
+
+func (c *Client) GetSecretValueForVersion(ctx context.Context, name, version string) (string, error) {
+	secret, err := c.GetSecret(ctx, name, version)
+	if err != nil {
+		return "", err
+	}
+	return secret.Value, nil
+}
+
+// GetSecret returns the secret if the namespace may read it and, if the
+// store followed a reference to another secret, the referenced secret
+func (c *Client) GetSecret(ctx context.Context, name, version string) (*secretstore.Secret, error) {
+	if !c.policy.Allowed(c.namespace, name) {
+		return nil, &AccessDeniedError{Namespace: c.namespace, Name: name}
+	}
+	secret, err := c.client.GetSecret(ctx, name, version)
+	if err != nil {
+		return nil, err
+	}
+	if secret.Reference != "" && !c.policy.Allowed(c.namespace, secret.Reference) {
+		return nil, &AccessDeniedError{Namespace: c.namespace, Name: secret.Reference}
+	}
+	return secret, nil
+}
+
+// ListVersions lists the versions of the secret with the wrapped client if it
+// is a secretstore.VersionLister and the namespace may access the secret
+func (c *Client) ListVersions(ctx context.Context, name string) ([]*secretstore.Secret, error) {
+	lister, ok := c.client.(secretstore.VersionLister)
+	if !ok {
+		return nil, fmt.Errorf("secret store does not support listing versions")
+	}
+	if !c.policy.Allowed(c.namespace, name) {
+		return nil, &AccessDeniedError{Namespace: c.namespace, Name: name}
+	}
+	return lister.ListVersions(ctx, name)
+}
+
+// SetSecret writes secret to the wrapped client if it is a
+// secretstore.Writer and the namespace may write the secret
+func (c *Client) SetSecret(ctx context.Context, secret *secretstore.Secret) (*secretstore.Secret, error) {
+	writer, ok := c.client.(secretstore.Writer)
+	if !ok {
+		return nil, fmt.Errorf("secret store does not support writing secrets")
+	}
+	if !c.policy.WriteAllowed(c.namespace, secret.Name) {
+		return nil, &AccessDeniedError{Namespace: c.namespace, Name: secret.Name, Write: true}
+	}
+	return writer.SetSecret(ctx, secret)
+}
+
+// DeleteSecret deletes the secret from the wrapped client if it is a
+// secretstore.Writer and the namespace may write the secret
+func (c *Client) DeleteSecret(ctx context.Context, name string) error {
+	writer, ok := c.client.(secretstore.Writer)
+	if !ok {
+		return fmt.Errorf("secret store does not support writing secrets")
+	}
+	if !c.policy.WriteAllowed(c.namespace, name) {
+		return &AccessDeniedError{Namespace: c.namespace, Name: name, Write: true}
+	}
+	return writer.DeleteSecret(ctx, name)
+}
@@ -0,0 +1,183 @@
+package policy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+const testPolicy = `
+rules:
+  - namespaces: ["team-a", "team-a-*"]
+    secrets: ["team-a-*"]
+  - namespaces: ["*"]
+    secrets: ["shared-*"]
+`
+
+func TestPolicy_Allowed(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	tests := []struct {
+		name      string
+		policy    *Policy
+		namespace string
+		secret    string
+		want      bool
+	}{
+		{"own secret", p, "team-a", "team-a-db", true},
+		{"own secret from sub namespace", p, "team-a-dev", "team-a-db", true},
+		{"secret names are case-insensitive", p, "team-a", "Team-A-DB", true},
+		{"other tenant's secret", p, "team-b", "team-a-db", false},
+		{"shared secret", p, "team-b", "shared-ca", true},
+		{"no matching rule", p, "team-b", "team-b-db", false},
+		{"no policy", nil, "team-b", "team-a-db", true},
+		{"empty policy", &Policy{}, "team-a", "team-a-db", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Allowed(tt.namespace, tt.secret); got != tt.want {
+				t.Errorf("Allowed(%q, %q) = %v, want %v", tt.namespace, tt.secret, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicy_WriteAllowed(t *testing.T) {
+	p, err := Parse([]byte(testPolicy + `
+  - namespaces: ["team-a"]
+    writeSecrets: ["team-a-*"]
+`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	tests := []struct {
+		name      string
+		policy    *Policy
+		namespace string
+		secret    string
+		want      bool
+	}{
+		{"own secret", p, "team-a", "team-a-db", true},
+		{"readable secret", p, "team-a", "shared-ca", false},
+		{"other namespace", p, "team-a-dev", "team-a-db", false},
+		{"no policy", nil, "team-a", "team-a-db", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.WriteAllowed(tt.namespace, tt.secret); got != tt.want {
+				t.Errorf("WriteAllowed(%q, %q) = %v, want %v", tt.namespace, tt.secret, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{"valid", testPolicy, false},
+		{"invalid pattern", "rules: [{namespaces: ['[a'], secrets: ['*']}]", true},
+		{"invalid yaml", "rules: {", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse([]byte(tt.data)); (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPolicy_SourceAllowed(t *testing.T) {
+	p, err := Parse([]byte(testPolicy + `
+  - namespaces: ["team-*"]
+    sources: ["secret/databases/*-credentials", "configmap/shared/*"]
+`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	tests := []struct {
+		name            string
+		policy          *Policy
+		namespace       string
+		kind            string
+		sourceNamespace string
+		source          string
+		want            bool
+	}{
+		{"own secret", p, "team-a", "secret", "team-a", "db", true},
+		{"own secret without policy", nil, "team-a", "secret", "team-a", "db", true},
+		{"allowed secret", p, "team-a", "secret", "databases", "postgres-credentials", true},
+		{"other secret of the namespace", p, "team-a", "secret", "databases", "admin", false},
+		{"allowed configmap", p, "team-b", "configmap", "shared", "ca", true},
+		{"kind does not match", p, "team-b", "secret", "shared", "ca", false},
+		{"namespace does not match", p, "ops", "secret", "databases", "postgres-credentials", false},
+		{"other namespace without policy", nil, "team-a", "secret", "databases", "postgres-credentials", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.SourceAllowed(tt.namespace, tt.kind, tt.sourceNamespace, tt.source); got != tt.want {
+				t.Errorf("SourceAllowed(%q, %q, %q, %q) = %v, want %v", tt.namespace, tt.kind, tt.sourceNamespace, tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+// referenceStore serves every secret as a reference to the secret in refs
+type referenceStore struct {
+	refs map[string]string
+}
+
+func (s referenceStore) GetSecretValue(ctx context.Context, name string) (string, error) {
+	return s.GetSecretValueForVersion(ctx, name, "")
+}
+
+func (s referenceStore) GetSecretValueForVersion(ctx context.Context, name, version string) (string, error) {
+	secret, err := s.GetSecret(ctx, name, version)
+	if err != nil {
+		return "", err
+	}
+	return secret.Value, nil
+}
+
+func (s referenceStore) GetSecret(ctx context.Context, name, version string) (*secretstore.Secret, error) {
+	return &secretstore.Secret{Name: name, Value: "value", Reference: s.refs[name]}, nil
+}
+
+func TestClient_GetSecret_reference(t *testing.T) {
+	p, err := Parse([]byte(`
+rules:
+  - namespaces: ["team-a"]
+    secrets: ["team-a/*", "myvault.vault.azure.net/team-a-*"]
+`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	client := NewClient(referenceStore{refs: map[string]string{
+		"team-a/db":    "myvault.vault.azure.net/team-a-db",
+		"team-a/admin": "myvault.vault.azure.net/admin",
+	}}, p, "team-a")
+	tests := []struct {
+		name         string
+		secret       string
+		accessDenied bool
+	}{
+		{"allowed reference", "team-a/db", false},
+		{"reference to another secret", "team-a/admin", true},
+		{"plain secret", "team-a/color", false},
+		{"other key", "team-b/db", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.GetSecretValue(context.Background(), tt.secret)
+			if IsAccessDenied(err) != tt.accessDenied || (err != nil && !tt.accessDenied) {
+				t.Errorf("GetSecretValue(%q) error = %v, accessDenied %v", tt.secret, err, tt.accessDenied)
+			}
+		})
+	}
+}
@@ -0,0 +1,431 @@
+// Package appconfig implements a secret store that reads the key-values of
+// Azure App Configuration. Key Vault references are followed to the
+// referenced secret.
+//
+// A key-value is addressed by its key and the label of the Client, or as
+// <key>@<label> with a label of its own. An empty label after @ selects the
+// key-value without a label. Versions are the ETags of the revisions of a
+// key-value.
+package appconfig
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+const (
+	apiVersion = "1.0"
+	// keyVaultReferenceContentType is the content type of key-values that
+	// refer to a Key Vault secret
+	keyVaultReferenceContentType = "application/vnd.microsoft.appconfig.keyvaultref+json"
+	labelSeparator               = "@"
+
+	// DefaultVaultHosts matches the vaults of the Azure public cloud
+	DefaultVaultHosts = "*.vault.azure.net"
+)
+
+// KeyVaultFunc returns the client for the Key Vault at vaultURL, e.g.
+// https://myvault.vault.azure.net/
+type KeyVaultFunc func(vaultURL string) (secretstore.Client, error)
+
+// Config configures a Client
+type Config struct {
+	// Endpoint is the URL of the store, e.g. https://mystore.azconfig.io
+	Endpoint string
+	// Credential and Secret are the ID and the decoded value of an access
+	// key of the store
+	Credential string
+	Secret     []byte
+	// Label is the label of key-values that are addressed without one
+	Label string
+	// KeyVault returns the clients that Key Vault references are read with.
+	// If it is nil, references are returned as they are stored.
+	KeyVault KeyVaultFunc
+	// VaultHosts are patterns as understood by path.Match of the hosts of
+	// the vaults that Key Vault references may point to, DefaultVaultHosts
+	// if empty. References are only followed over HTTPS, as the clients of
+	// KeyVault send the credentials of the controller.
+	VaultHosts []string
+}
+
+// ParseConnectionString returns a Config for the connection string of an
+// access key, Endpoint=https://...;Id=...;Secret=...
+func ParseConnectionString(connectionString string) (Config, error) {
+	var config Config
+	var secret string
+	for _, part := range strings.Split(connectionString, ";") {
+		i := strings.Index(part, "=")
+		if i < 0 {
+			continue
+		}
+		switch value := part[i+1:]; part[:i] {
+		case "Endpoint":
+			config.Endpoint = value
+		case "Id":
+			config.Credential = value
+		case "Secret":
+			secret = value
+		}
+	}
+	if config.Endpoint == "" || config.Credential == "" || secret == "" {
+		return Config{}, fmt.Errorf("connection string must contain Endpoint, Id and Secret")
+	}
+	var err error
+	if config.Secret, err = base64.StdEncoding.DecodeString(secret); err != nil {
+		return Config{}, fmt.Errorf("invalid secret in connection string: %v", err)
+	}
+	return config, nil
+}
+
+// Client is a secretstore.Client for an App Configuration store
+type Client struct {
+	config     Config
+	httpClient *http.Client
+	now        func() time.Time
+
+	mu        sync.Mutex
+	keyVaults map[string]secretstore.Client
+}
+
+// keyValue is a key-value or a revision of it
+type keyValue struct {
+	ETag         string            `json:"etag"`
+	Key          string            `json:"key"`
+	ContentType  string            `json:"content_type"`
+	Value        string            `json:"value"`
+	Tags         map[string]string `json:"tags"`
+	LastModified *time.Time        `json:"last_modified"`
+}
+
+type keyValuePage struct {
+	Items    []keyValue `json:"items"`
+	NextLink string     `json:"@nextLink"`
+}
+
+// keyVaultReference is the value of a Key Vault reference
+type keyVaultReference struct {
+	URI string `json:"uri"`
+}
+
+// APIError is an error response of App Configuration
+type APIError struct {
+	StatusCode int
+	Title      string `json:"title"`
+	Detail     string `json:"detail"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("App Configuration request failed with status %d: %s %s", e.StatusCode, e.Title, e.Detail)
+}
+
+// Transient reports whether the request was throttled or failed with an
+// internal error of App Configuration
+func (e *APIError) Transient() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// NewClient returns a Client for the store of config
+func NewClient(config Config) (*Client, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid App Configuration endpoint %q", config.Endpoint)
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	if len(config.VaultHosts) == 0 {
+		config.VaultHosts = []string{DefaultVaultHosts}
+	}
+	for _, pattern := range config.VaultHosts {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid vault host pattern %q: %v", pattern, err)
+		}
+	}
+	return &Client{
+		config:     config,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		now:        time.Now,
+		keyVaults:  make(map[string]secretstore.Client),
+	}, nil
+}
+
+func (c *Client) GetSecretValue(ctx context.Context, name string) (string, error) {
+	return c.GetSecretValueForVersion(ctx, name, "")
+}
+
+func (c *Client) GetSecretValueForVersion(ctx context.Context, name, version string) (string, error) {
+	secret, err := c.GetSecret(ctx, name, version)
+	if err != nil {
+		return "", err
+	}
+	return secret.Value, nil
+}
+
+func (c *Client) GetSecret(ctx context.Context, name, version string) (*secretstore.Secret, error) {
+	key, label := c.splitLabel(name)
+	if key == "" {
+		return nil, fmt.Errorf("invalid key-value name %q", name)
+	}
+
+	var kv *keyValue
+	if version == "" {
+		query := url.Values{}
+		if label != "" {
+			query.Set("label", label)
+		}
+		kv = &keyValue{}
+		err := c.get(ctx, "/kv/"+url.PathEscape(key), query, kv)
+		if isNotFound(err) {
+			return nil, &secretstore.NotFoundError{Name: name}
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		revisions, err := c.revisions(ctx, key, label)
+		if err != nil {
+			return nil, err
+		}
+		for i := range revisions {
+			if revisions[i].ETag == version {
+				kv = &revisions[i]
+				break
+			}
+		}
+		if kv == nil {
+			return nil, &secretstore.NotFoundError{Name: name, Version: version}
+		}
+	}
+
+	secret := newSecret(name, kv)
+	secret.Value = kv.Value
+	if c.config.KeyVault == nil || !isKeyVaultReference(kv.ContentType) {
+		return secret, nil
+	}
+	referenced, reference, err := c.resolve(ctx, kv.Value)
+	if secretstore.IsNotFound(err) {
+		return nil, &secretstore.NotFoundError{Name: name, Version: version}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading Key Vault reference %s: %v", name, err)
+	}
+	// the validity of the referenced secret applies to the key-value
+	secret.Value = referenced.Value
+	secret.ContentType = referenced.ContentType
+	secret.Expires = referenced.Expires
+	secret.NotBefore = referenced.NotBefore
+	secret.Disabled = referenced.Disabled
+	secret.Reference = reference
+	return secret, nil
+}
+
+// ListVersions returns the revisions of a key-value, newest first
+func (c *Client) ListVersions(ctx context.Context, name string) ([]*secretstore.Secret, error) {
+	key, label := c.splitLabel(name)
+	if key == "" {
+		return nil, fmt.Errorf("invalid key-value name %q", name)
+	}
+	revisions, err := c.revisions(ctx, key, label)
+	if err != nil {
+		return nil, err
+	}
+	if len(revisions) == 0 {
+		return nil, &secretstore.NotFoundError{Name: name}
+	}
+	versions := make([]*secretstore.Secret, 0, len(revisions))
+	for i := range revisions {
+		versions = append(versions, newSecret(name, &revisions[i]))
+	}
+	secretstore.SortVersions(versions)
+	return versions, nil
+}
+
+// splitLabel splits name into the key and the label
+func (c *Client) splitLabel(name string) (string, string) {
+	if i := strings.LastIndex(name, labelSeparator); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return name, c.config.Label
+}
+
+// revisions returns all revisions of the key-value with key and label
+func (c *Client) revisions(ctx context.Context, key, label string) ([]keyValue, error) {
+	query := url.Values{"key": {escapeFilter(key)}}
+	if label == "" {
+		// %00 filters the key-values without a label
+		query.Set("label", "\x00")
+	} else {
+		query.Set("label", escapeFilter(label))
+	}
+	var revisions []keyValue
+	path := "/revisions"
+	for path != "" {
+		var page keyValuePage
+		if err := c.get(ctx, path, query, &page); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, page.Items...)
+		// the next link holds the query of the next page
+		path, query = page.NextLink, nil
+	}
+	return revisions, nil
+}
+
+// resolve reads the secret that a Key Vault reference points to. It also
+// returns the <host>/<name> of the secret, which the policy of a namespace
+// is checked against.
+func (c *Client) resolve(ctx context.Context, value string) (*secretstore.Secret, string, error) {
+	var reference keyVaultReference
+	if err := json.Unmarshal([]byte(value), &reference); err != nil {
+		return nil, "", fmt.Errorf("invalid Key Vault reference: %v", err)
+	}
+	// the URI has the form https://<vault>.vault.azure.net/secrets/<name>[/<version>]
+	uri, err := url.Parse(reference.URI)
+	if err != nil || uri.Host == "" {
+		return nil, "", fmt.Errorf("invalid Key Vault reference URI %q", reference.URI)
+	}
+	parts := strings.Split(strings.Trim(uri.Path, "/"), "/")
+	if (len(parts) != 2 && len(parts) != 3) || parts[0] != "secrets" || parts[1] == "" {
+		return nil, "", fmt.Errorf("invalid Key Vault reference URI %q", reference.URI)
+	}
+	// anyone who can write a key-value could otherwise send the token of the
+	// controller to a host of their choice
+	if uri.Scheme != "https" || !c.allowedVault(uri.Host) {
+		return nil, "", fmt.Errorf("Key Vault reference URI %q points to a vault that is not allowed", reference.URI)
+	}
+	var version string
+	if len(parts) == 3 {
+		version = parts[2]
+	}
+
+	vaultURL := "https://" + uri.Host + "/"
+	c.mu.Lock()
+	keyVault, ok := c.keyVaults[vaultURL]
+	if !ok {
+		keyVault, err = c.config.KeyVault(vaultURL)
+		if err == nil {
+			c.keyVaults[vaultURL] = keyVault
+		}
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := keyVault.GetSecret(ctx, parts[1], version)
+	return secret, strings.ToLower(uri.Host) + "/" + parts[1], err
+}
+
+// allowedVault reports whether host matches one of the VaultHosts
+func (c *Client) allowedVault(host string) bool {
+	for _, pattern := range c.config.VaultHosts {
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(host)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// get sends a signed GET request for path and decodes the response into
+// result
+func (c *Client) get(ctx context.Context, path string, query url.Values, result interface{}) error {
+	target, err := url.Parse(c.config.Endpoint + path)
+	if err != nil {
+		return err
+	}
+	q := target.Query()
+	for key, values := range query {
+		q[key] = values
+	}
+	q.Set("api-version", apiVersion)
+	target.RawQuery = q.Encode()
+	req, err := http.NewRequest(http.MethodGet, target.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.microsoft.appconfig.kv+json, application/vnd.microsoft.appconfig.kvset+json, application/problem+json")
+	c.sign(req)
+
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		json.Unmarshal(body, apiErr)
+		return apiErr
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("invalid App Configuration response: %v", err)
+	}
+	return nil
+}
+
+// sign authenticates req, which has no body, with the access key of the
+// Client
+func (c *Client) sign(req *http.Request) {
+	date := c.now().UTC().Format(http.TimeFormat)
+	hash := sha256.Sum256(nil)
+	contentHash := base64.StdEncoding.EncodeToString(hash[:])
+	req.Header.Set("x-ms-date", date)
+	req.Header.Set("x-ms-content-sha256", contentHash)
+
+	stringToSign := req.Method + "\n" + req.URL.RequestURI() + "\n" + date + ";" + req.URL.Host + ";" + contentHash
+	mac := hmac.New(sha256.New, c.config.Secret)
+	mac.Write([]byte(stringToSign))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	req.Header.Set("Authorization", "HMAC-SHA256 Credential="+c.config.Credential+"&SignedHeaders=x-ms-date;host;x-ms-content-sha256&Signature="+signature)
+}
+
+// newSecret converts the metadata of a key-value
+func newSecret(name string, kv *keyValue) *secretstore.Secret {
+	secret := &secretstore.Secret{
+		Name:        name,
+		Version:     kv.ETag,
+		ContentType: kv.ContentType,
+		Tags:        make(map[string]string),
+		Created:     kv.LastModified,
+	}
+	for key, value := range kv.Tags {
+		secret.Tags[key] = value
+	}
+	return secret
+}
+
+func isKeyVaultReference(contentType string) bool {
+	return strings.HasPrefix(strings.ToLower(contentType), keyVaultReferenceContentType)
+}
+
+func isNotFound(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+// escapeFilter escapes the characters that have a meaning in the key and
+// label filters of App Configuration
+func escapeFilter(s string) string {
+	var b bytes.Buffer
+	for _, r := range s {
+		if r == '\\' || r == '*' || r == ',' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
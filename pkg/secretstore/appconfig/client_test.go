@@ -0,0 +1,269 @@
+package appconfig
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+
+	"github.com/twendt/secret-controller/pkg/secretstore"
+	"github.com/twendt/secret-controller/pkg/secretstore/keyvault"
+)
+
+var testSecret = []byte("test-secret")
+
+// testAppConfig is a stand-in for the REST API of App Configuration.
+// keyValues and revisions are keyed by <key>|<label>, revisions holds the
+// pages of revisions.
+type testAppConfig struct {
+	keyValues map[string]keyValue
+	revisions map[string][]keyValuePage
+}
+
+func (s *testAppConfig) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !validSignature(r) {
+		writeError(w, http.StatusUnauthorized)
+		return
+	}
+	query := r.URL.Query()
+	if query.Get("api-version") != apiVersion {
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/kv/") {
+		kv, ok := s.keyValues[strings.TrimPrefix(r.URL.Path, "/kv/")+"|"+query.Get("label")]
+		if !ok {
+			writeError(w, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(kv)
+		return
+	}
+	label := query.Get("label")
+	if label == "\x00" {
+		label = ""
+	}
+	pages := s.revisions[query.Get("key")+"|"+label]
+	if len(pages) == 0 {
+		json.NewEncoder(w).Encode(keyValuePage{})
+		return
+	}
+	page := pages[0]
+	if query.Get("after") != "" {
+		page = pages[1]
+	}
+	json.NewEncoder(w).Encode(page)
+}
+
+// validSignature verifies the HMAC signature of r with testSecret
+func validSignature(r *http.Request) bool {
+	contentHash := r.Header.Get("x-ms-content-sha256")
+	stringToSign := r.Method + "\n" + r.URL.RequestURI() + "\n" + r.Header.Get("x-ms-date") + ";" + r.Host + ";" + contentHash
+	mac := hmac.New(sha256.New, testSecret)
+	mac.Write([]byte(stringToSign))
+	want := "HMAC-SHA256 Credential=test-id&SignedHeaders=x-ms-date;host;x-ms-content-sha256&Signature=" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return r.Header.Get("Authorization") == want
+}
+
+func writeError(w http.ResponseWriter, status int) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"title":"test error","status":%d}`, status)
+}
+
+// testKeyVault is a stand-in for Key Vault, values maps <name>/<version> to
+// the values of secrets, <name>/ is the latest version
+type testKeyVault struct {
+	values map[string]string
+}
+
+func (s *testKeyVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	value, ok := s.values[strings.TrimPrefix(r.URL.Path, "/secrets/")]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":{"code":"SecretNotFound","message":"test error"}}`)
+		return
+	}
+	fmt.Fprintf(w, `{"value":%q,"id":"https://%s%s","contentType":"text/plain","attributes":{"enabled":true,"exp":1893456000}}`, value, r.Host, r.URL.Path)
+}
+
+func newTestClient(t *testing.T, appConfig *testAppConfig, keyVault *testKeyVault) (*Client, func()) {
+	appConfigServer := httptest.NewServer(appConfig)
+	keyVaultServer := httptest.NewServer(keyVault)
+	client, err := NewClient(Config{
+		Endpoint:   appConfigServer.URL,
+		Credential: "test-id",
+		Secret:     testSecret,
+		Label:      "prod",
+		KeyVault: func(vaultURL string) (secretstore.Client, error) {
+			// the references point to the public endpoint of the vault
+			if vaultURL != "https://myvault.vault.azure.net/" {
+				return nil, fmt.Errorf("unexpected vault %s", vaultURL)
+			}
+			return keyvault.NewVaultClientForURL(keyVaultServer.URL, autorest.NullAuthorizer{}, nil)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, func() {
+		appConfigServer.Close()
+		keyVaultServer.Close()
+	}
+}
+
+func reference(uri string) keyValue {
+	return keyValue{
+		ETag:        "ref",
+		ContentType: keyVaultReferenceContentType + ";charset=utf-8",
+		Value:       fmt.Sprintf(`{"uri":%q}`, uri),
+	}
+}
+
+func TestClient_GetSecret(t *testing.T) {
+	client, closeServers := newTestClient(t, &testAppConfig{
+		keyValues: map[string]keyValue{
+			"app/color|prod":        {ETag: "c2", Value: "blue", Tags: map[string]string{"owner": "team-a"}},
+			"app/color|dev":         {ETag: "c3", Value: "red"},
+			"app/color|":            {ETag: "c1", Value: "default"},
+			"app/db-password|prod":  reference("https://myvault.vault.azure.net/secrets/db-password"),
+			"app/old-password|prod": reference("https://myvault.vault.azure.net/secrets/db-password/1"),
+			"app/missing-ref|prod":  reference("https://myvault.vault.azure.net/secrets/other"),
+			"app/invalid-ref|prod":  reference("https://myvault.vault.azure.net/keys/db-key"),
+			"app/other-vault|prod":  reference("https://othervault.vault.azure.net/secrets/db-password"),
+			"app/http-ref|prod":     reference("http://myvault.vault.azure.net/secrets/db-password"),
+			"app/foreign-ref|prod":  reference("https://attacker.example/secrets/db-password"),
+		},
+		revisions: map[string][]keyValuePage{
+			"app/color|prod": {{Items: []keyValue{{ETag: "c2", Value: "blue"}, {ETag: "c0", Value: "green"}}}},
+		},
+	}, &testKeyVault{values: map[string]string{
+		"db-password/":  "s3cret",
+		"db-password/1": "old",
+	}})
+	defer closeServers()
+
+	tests := []struct {
+		name        string
+		secret      string
+		version     string
+		wantValue   string
+		wantVersion string
+		wantExpires bool
+		wantRef     string
+		wantErr     bool
+		notFound    bool
+	}{
+		{name: "label of the client", secret: "app/color", wantValue: "blue", wantVersion: "c2"},
+		{name: "label of the name", secret: "app/color@dev", wantValue: "red", wantVersion: "c3"},
+		{name: "no label", secret: "app/color@", wantValue: "default", wantVersion: "c1"},
+		{name: "revision", secret: "app/color", version: "c0", wantValue: "green", wantVersion: "c0"},
+		{name: "missing revision", secret: "app/color", version: "c9", wantErr: true, notFound: true},
+		{name: "missing key", secret: "app/size", wantErr: true, notFound: true},
+		{name: "Key Vault reference", secret: "app/db-password", wantValue: "s3cret", wantVersion: "ref", wantExpires: true, wantRef: "myvault.vault.azure.net/db-password"},
+		{name: "Key Vault reference with version", secret: "app/old-password", wantValue: "old", wantVersion: "ref", wantExpires: true, wantRef: "myvault.vault.azure.net/db-password"},
+		{name: "missing Key Vault secret", secret: "app/missing-ref", wantErr: true, notFound: true},
+		{name: "invalid Key Vault reference", secret: "app/invalid-ref", wantErr: true},
+		{name: "unknown vault", secret: "app/other-vault", wantErr: true},
+		{name: "reference over HTTP", secret: "app/http-ref", wantErr: true},
+		{name: "reference to another host", secret: "app/foreign-ref", wantErr: true},
+		{name: "empty key", secret: "@prod", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := client.GetSecret(context.Background(), tt.secret, tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if secretstore.IsNotFound(err) != tt.notFound {
+				t.Errorf("GetSecret() error = %v, notFound %v", err, tt.notFound)
+			}
+			if err != nil {
+				return
+			}
+			if got.Name != tt.secret || got.Value != tt.wantValue || got.Version != tt.wantVersion {
+				t.Errorf("GetSecret() = %s %q %s, want %s %q %s", got.Name, got.Value, got.Version, tt.secret, tt.wantValue, tt.wantVersion)
+			}
+			if got.Reference != tt.wantRef {
+				t.Errorf("GetSecret() reference = %q, want %q", got.Reference, tt.wantRef)
+			}
+			if (got.Expires != nil) != tt.wantExpires {
+				t.Errorf("GetSecret() expires = %v, want expiry %v", got.Expires, tt.wantExpires)
+			}
+		})
+	}
+}
+
+func TestClient_ListVersions(t *testing.T) {
+	var pages []keyValuePage
+	err := json.Unmarshal([]byte(`[
+		{"items": [
+			{"etag": "c1", "key": "app/color", "value": "blue", "last_modified": "2019-01-01T12:00:00Z"},
+			{"etag": "c3", "key": "app/color", "value": "green", "last_modified": "2019-03-01T12:00:00Z"}
+		], "@nextLink": "/revisions?key=app%2Fcolor&label=prod&after=c3"},
+		{"items": [
+			{"etag": "c2", "key": "app/color", "value": "red", "last_modified": "2019-02-01T12:00:00Z"}
+		]}
+	]`), &pages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, closeServers := newTestClient(t, &testAppConfig{
+		revisions: map[string][]keyValuePage{"app/color|prod": pages},
+	}, &testKeyVault{})
+	defer closeServers()
+
+	versions, err := client.ListVersions(context.Background(), "app/color")
+	if err != nil {
+		t.Fatalf("ListVersions() error = %v", err)
+	}
+	var got []string
+	for _, version := range versions {
+		got = append(got, version.Version+":"+version.Created.Format(time.RFC3339)+":"+version.Value)
+	}
+	want := "c3:2019-03-01T12:00:00Z: c2:2019-02-01T12:00:00Z: c1:2019-01-01T12:00:00Z:"
+	if strings.Join(got, " ") != want {
+		t.Errorf("ListVersions() = %v, want %s", got, want)
+	}
+
+	if _, err := client.ListVersions(context.Background(), "app/size"); !secretstore.IsNotFound(err) {
+		t.Errorf("ListVersions() error = %v, want NotFoundError", err)
+	}
+}
+
+func TestClient_invalidCredential(t *testing.T) {
+	client, closeServers := newTestClient(t, &testAppConfig{
+		keyValues: map[string]keyValue{"app/color|prod": {Value: "blue"}},
+	}, &testKeyVault{})
+	defer closeServers()
+	client.config.Secret = []byte("other-secret")
+
+	_, err := client.GetSecret(context.Background(), "app/color", "")
+	if apiErr, ok := err.(*APIError); !ok || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("GetSecret() error = %v, want status 401", err)
+	}
+}
+
+func TestParseConnectionString(t *testing.T) {
+	config, err := ParseConnectionString("Endpoint=https://mystore.azconfig.io;Id=test-id;Secret=" + base64.StdEncoding.EncodeToString(testSecret))
+	if err != nil {
+		t.Fatalf("ParseConnectionString() error = %v", err)
+	}
+	if config.Endpoint != "https://mystore.azconfig.io" || config.Credential != "test-id" || string(config.Secret) != string(testSecret) {
+		t.Errorf("ParseConnectionString() = %+v", config)
+	}
+	for _, invalid := range []string{"Endpoint=https://mystore.azconfig.io;Id=test-id", "Endpoint=https://mystore.azconfig.io;Id=test-id;Secret=%%%"} {
+		if _, err := ParseConnectionString(invalid); err == nil {
+			t.Errorf("ParseConnectionString(%q) accepted an invalid connection string", invalid)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Package aws implements secret stores for AWS Secrets Manager and AWS
+// Systems Manager Parameter Store.
+//
+// A name may select a key of a secret or parameter holding a JSON object
+// with the suffix #key, e.g. db-credentials#password. Neither service allows
+// # in names.
+package aws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// jsonKeySeparator separates the name of a secret from a key of its JSON
+// value
+const jsonKeySeparator = "#"
+
+// Config configures the clients of both services
+type Config struct {
+	Region string
+	// Endpoint replaces the public endpoint of the service in Region, e.g.
+	// with a VPC endpoint
+	Endpoint    string
+	Credentials CredentialsProvider
+}
+
+// APIError is an error response of an AWS service
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s (status %d): %s", e.Code, e.StatusCode, e.Message)
+}
+
+// Transient reports whether the request was throttled or failed with an
+// internal error of the service. Throttling has status 400 in the JSON
+// protocol.
+func (e *APIError) Transient() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError || e.Code == "ThrottlingException"
+}
+
+// apiClient calls the operations of an AWS service with the JSON protocol
+type apiClient struct {
+	region       string
+	service      string
+	endpoint     string
+	targetPrefix string
+	credentials  CredentialsProvider
+	httpClient   *http.Client
+	now          func() time.Time
+}
+
+func newAPIClient(config Config, service, targetPrefix string) (*apiClient, error) {
+	if config.Region == "" {
+		return nil, fmt.Errorf("no AWS region set")
+	}
+	if config.Credentials == nil {
+		return nil, fmt.Errorf("no AWS credentials set")
+	}
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = "https://" + service + "." + config.Region + ".amazonaws.com/"
+	}
+	return &apiClient{
+		region:       config.Region,
+		service:      service,
+		endpoint:     endpoint,
+		targetPrefix: targetPrefix,
+		credentials:  config.Credentials,
+		httpClient:   &http.Client{Timeout: 30 * time.Second},
+		now:          time.Now,
+	}, nil
+}
+
+// call invokes operation with input and decodes the response into output
+func (c *apiClient) call(ctx context.Context, operation string, input, output interface{}) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	credentials, err := c.credentials.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", c.targetPrefix+"."+operation)
+	signRequest(req, body, credentials, c.region, c.service, c.now())
+
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return newAPIError(resp.StatusCode, respBody)
+	}
+	return json.Unmarshal(respBody, output)
+}
+
+// newAPIError decodes an error response. The type may carry the namespace
+// of the service before a #.
+func newAPIError(statusCode int, body []byte) *APIError {
+	var result struct {
+		Type         string `json:"__type"`
+		Message      string `json:"message"`
+		MessageUpper string `json:"Message"`
+	}
+	json.Unmarshal(body, &result)
+	apiErr := &APIError{
+		StatusCode: statusCode,
+		Code:       result.Type[strings.LastIndex(result.Type, "#")+1:],
+		Message:    result.Message,
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = result.MessageUpper
+	}
+	return apiErr
+}
+
+// isAPIError reports whether err is an APIError with one of codes
+func isAPIError(err error, codes ...string) bool {
+	apiErr, ok := err.(*APIError)
+	if !ok {
+		return false
+	}
+	for _, code := range codes {
+		if apiErr.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// splitJSONKey splits name into the name of the secret and the key of its
+// JSON value
+func splitJSONKey(name string) (string, string) {
+	if i := strings.Index(name, jsonKeySeparator); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return name, ""
+}
+
+// extractJSONKey returns the value of key in the JSON object value. Values
+// that are not strings are returned as JSON.
+func extractJSONKey(name, value, key string) (string, error) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(value), &object); err != nil {
+		return "", fmt.Errorf("secret %q is not a JSON object: %v", name, err)
+	}
+	raw, ok := object[key]
+	if !ok {
+		return "", fmt.Errorf("secret %q has no key %q", name, key)
+	}
+	var str string
+	if err := json.Unmarshal(raw, &str); err == nil {
+		return str, nil
+	}
+	return string(raw), nil
+}
+
+// epochTime converts the timestamps of the JSON protocol, which are seconds
+// since the epoch
+func epochTime(seconds float64) *time.Time {
+	if seconds == 0 {
+		return nil
+	}
+	t := time.Unix(0, int64(seconds*float64(time.Second))).UTC()
+	return &t
+}
@@ -0,0 +1,134 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCredentials are the example credentials of the Signature Version 4
+// test suite
+var testCredentials = StaticCredentials{
+	AccessKeyID:     "AKIDEXAMPLE",
+	SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+}
+
+// testService is a local stand-in for an AWS service with the JSON protocol.
+// operations maps the operation of X-Amz-Target to a handler that returns
+// the response or an APIError.
+type testService struct {
+	t          *testing.T
+	operations map[string]func(input map[string]interface{}) (interface{}, error)
+	calls      []string
+}
+
+func (s *testService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), signingAlgorithm+" Credential=AKIDEXAMPLE/") {
+		http.Error(w, `{"__type":"MissingAuthenticationTokenException"}`, http.StatusForbidden)
+		return
+	}
+	target := r.Header.Get("X-Amz-Target")
+	operation := target[strings.Index(target, ".")+1:]
+	s.calls = append(s.calls, operation)
+	handler, ok := s.operations[operation]
+	if !ok {
+		s.t.Errorf("unexpected operation %s", target)
+		http.Error(w, `{"__type":"UnknownOperationException"}`, http.StatusBadRequest)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	var input map[string]interface{}
+	if err := json.Unmarshal(body, &input); err != nil {
+		s.t.Errorf("invalid request body %s: %v", body, err)
+	}
+	output, err := handler(input)
+	if apiErr, ok := err.(*APIError); ok {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"__type":"com.amazonaws.test#%s","message":%q}`, apiErr.Code, apiErr.Message)
+		return
+	}
+	json.NewEncoder(w).Encode(output)
+}
+
+func newTestConfig(t *testing.T, service *testService) (Config, func()) {
+	service.t = t
+	server := httptest.NewServer(service)
+	return Config{Region: "us-east-1", Endpoint: server.URL + "/", Credentials: testCredentials}, server.Close
+}
+
+func Test_signRequest(t *testing.T) {
+	// get-vanilla of the Signature Version 4 test suite
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	signRequest(req, nil, Credentials(testCredentials), "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("signRequest() Authorization = %s, want %s", got, want)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("signRequest() X-Amz-Date = %s", got)
+	}
+}
+
+func TestWebIdentityCredentials(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		r.ParseForm()
+		if r.Form.Get("Action") != "AssumeRoleWithWebIdentity" || r.Form.Get("WebIdentityToken") != "sa-token" ||
+			r.Form.Get("RoleArn") != "arn:aws:iam::123456789012:role/reader" || r.Form.Get("RoleSessionName") != defaultSessionName {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<ErrorResponse><Error><Code>AccessDenied</Code><Message>denied</Message></Error></ErrorResponse>`)
+			return
+		}
+		fmt.Fprint(w, `<AssumeRoleWithWebIdentityResponse><AssumeRoleWithWebIdentityResult><Credentials>
+			<AccessKeyId>ASIAEXAMPLE</AccessKeyId>
+			<SecretAccessKey>secret</SecretAccessKey>
+			<SessionToken>session</SessionToken>
+			<Expiration>2019-03-01T13:00:00Z</Expiration>
+		</Credentials></AssumeRoleWithWebIdentityResult></AssumeRoleWithWebIdentityResponse>`)
+	}))
+	defer server.Close()
+
+	token := "sa-token"
+	credentials := NewWebIdentityCredentials(server.URL, "arn:aws:iam::123456789012:role/reader", "", func() (string, error) {
+		return token, nil
+	})
+	now := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	credentials.now = func() time.Time { return now }
+
+	got, err := credentials.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	want := Credentials{
+		AccessKeyID:     "ASIAEXAMPLE",
+		SecretAccessKey: "secret",
+		SessionToken:    "session",
+		Expires:         time.Date(2019, 3, 1, 13, 0, 0, 0, time.UTC),
+	}
+	if got != want {
+		t.Errorf("Retrieve() = %+v, want %+v", got, want)
+	}
+
+	credentials.Retrieve(context.Background())
+	if requests != 1 {
+		t.Errorf("Retrieve() assumed the role %d times, want cached credentials", requests)
+	}
+
+	// shortly before they expire the credentials are replaced
+	now = now.Add(56 * time.Minute)
+	token = "other-token"
+	if _, err := credentials.Retrieve(context.Background()); err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("Retrieve() error = %v, want AccessDenied", err)
+	}
+	if requests != 2 {
+		t.Errorf("Retrieve() assumed the role %d times, want 2", requests)
+	}
+}
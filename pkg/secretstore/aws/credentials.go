@@ -0,0 +1,184 @@
+package aws
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	stsAPIVersion = "2011-06-15"
+	// credentialsRefreshMargin is how long before they expire temporary
+	// credentials are replaced
+	credentialsRefreshMargin = 5 * time.Minute
+	// defaultSessionName is used for the sessions of assumed roles if
+	// AWS_ROLE_SESSION_NAME is not set
+	defaultSessionName = "secret-controller"
+)
+
+// Credentials are the AWS credentials requests are signed with. Temporary
+// credentials have a session token and an expiry.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Expires is zero for credentials that do not expire
+	Expires time.Time
+}
+
+// CredentialsProvider returns the credentials for the next request
+type CredentialsProvider interface {
+	Retrieve(ctx context.Context) (Credentials, error)
+}
+
+// StaticCredentials always returns the same credentials
+type StaticCredentials Credentials
+
+func (c StaticCredentials) Retrieve(ctx context.Context) (Credentials, error) {
+	return Credentials(c), nil
+}
+
+// TokenFunc returns the web identity token that is exchanged for
+// credentials, e.g. the projected ServiceAccount token of IRSA
+type TokenFunc func() (string, error)
+
+// WebIdentityCredentials assumes a role with a web identity token and keeps
+// the temporary credentials until shortly before they expire
+type WebIdentityCredentials struct {
+	endpoint    string
+	roleARN     string
+	sessionName string
+	token       TokenFunc
+	httpClient  *http.Client
+	now         func() time.Time
+
+	mu          sync.Mutex
+	credentials Credentials
+}
+
+// NewWebIdentityCredentials returns WebIdentityCredentials for roleARN that
+// call AssumeRoleWithWebIdentity of the STS at endpoint
+func NewWebIdentityCredentials(endpoint, roleARN, sessionName string, token TokenFunc) *WebIdentityCredentials {
+	if sessionName == "" {
+		sessionName = defaultSessionName
+	}
+	return &WebIdentityCredentials{
+		endpoint:    endpoint,
+		roleARN:     roleARN,
+		sessionName: sessionName,
+		token:       token,
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+		now:         time.Now,
+	}
+}
+
+func (c *WebIdentityCredentials) Retrieve(ctx context.Context) (Credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.credentials.AccessKeyID != "" && c.now().Add(credentialsRefreshMargin).Before(c.credentials.Expires) {
+		return c.credentials, nil
+	}
+
+	token, err := c.token()
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to read web identity token: %v", err)
+	}
+	credentials, err := c.assumeRole(ctx, token)
+	if err != nil {
+		return Credentials{}, err
+	}
+	c.credentials = credentials
+	return credentials, nil
+}
+
+// assumeRole calls AssumeRoleWithWebIdentity, which is not signed because
+// the token proves the identity
+func (c *WebIdentityCredentials) assumeRole(ctx context.Context, token string) (Credentials, error) {
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {stsAPIVersion},
+		"RoleArn":          {c.roleARN},
+		"RoleSessionName":  {c.sessionName},
+		"WebIdentityToken": {token},
+	}
+	req, err := http.NewRequest(http.MethodPost, c.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Credentials{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return Credentials{}, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var result struct {
+			Error struct {
+				Code    string `xml:"Code"`
+				Message string `xml:"Message"`
+			} `xml:"Error"`
+		}
+		xml.Unmarshal(body, &result)
+		return Credentials{}, fmt.Errorf("assuming role %s failed with status %d: %s %s", c.roleARN, resp.StatusCode, result.Error.Code, result.Error.Message)
+	}
+	var result struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal(body, &result); err != nil || result.Credentials.AccessKeyID == "" {
+		return Credentials{}, fmt.Errorf("invalid AssumeRoleWithWebIdentity response for role %s: %v", c.roleARN, err)
+	}
+	return Credentials{
+		AccessKeyID:     result.Credentials.AccessKeyID,
+		SecretAccessKey: result.Credentials.SecretAccessKey,
+		SessionToken:    result.Credentials.SessionToken,
+		Expires:         result.Credentials.Expiration,
+	}, nil
+}
+
+// NewCredentialsFromEnv returns the credentials configured in the
+// environment. With IRSA, AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE are
+// set and the role is assumed through the STS of region. Otherwise the
+// static credentials in AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN are used.
+func NewCredentialsFromEnv(region string) (CredentialsProvider, error) {
+	roleARN := os.Getenv("AWS_ROLE_ARN")
+	tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	if roleARN != "" && tokenFile != "" {
+		endpoint := "https://sts.amazonaws.com/"
+		if region != "" {
+			endpoint = "https://sts." + region + ".amazonaws.com/"
+		}
+		return NewWebIdentityCredentials(endpoint, roleARN, os.Getenv("AWS_ROLE_SESSION_NAME"), func() (string, error) {
+			token, err := ioutil.ReadFile(tokenFile)
+			return strings.TrimSpace(string(token)), err
+		}), nil
+	}
+
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKeyID == "" || secretAccessKey == "" {
+		return nil, fmt.Errorf("no AWS credentials, set AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return StaticCredentials{
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}, nil
+}
@@ -0,0 +1,218 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+// ParameterStoreClient is a secretstore.Client for AWS Systems Manager
+// Parameter Store. SecureString parameters are decrypted. A version is either
+// the number of a parameter version or a parameter label.
+//
+// A name ending with / reads the parameters directly below that path, e.g.
+// /app/prod/, as a JSON object that maps the names relative to the path to
+// the values. Parameters in nested paths are not read.
+type ParameterStoreClient struct {
+	api *apiClient
+}
+
+type parameter struct {
+	Name             string  `json:"Name"`
+	Value            string  `json:"Value"`
+	Version          int64   `json:"Version"`
+	LastModifiedDate float64 `json:"LastModifiedDate"`
+}
+
+type getParameterInput struct {
+	Name           string `json:"Name"`
+	WithDecryption bool   `json:"WithDecryption"`
+}
+
+type getParameterOutput struct {
+	Parameter parameter `json:"Parameter"`
+}
+
+type getParameterHistoryInput struct {
+	Name      string `json:"Name"`
+	NextToken string `json:"NextToken,omitempty"`
+}
+
+type getParametersByPathInput struct {
+	Path           string `json:"Path"`
+	Recursive      bool   `json:"Recursive"`
+	WithDecryption bool   `json:"WithDecryption"`
+	NextToken      string `json:"NextToken,omitempty"`
+}
+
+type parametersOutput struct {
+	Parameters []parameter `json:"Parameters"`
+	NextToken  string      `json:"NextToken"`
+}
+
+// NewParameterStoreClient returns a ParameterStoreClient for the region of
+// config
+func NewParameterStoreClient(config Config) (*ParameterStoreClient, error) {
+	api, err := newAPIClient(config, "ssm", "AmazonSSM")
+	if err != nil {
+		return nil, err
+	}
+	return &ParameterStoreClient{api: api}, nil
+}
+
+func (c *ParameterStoreClient) GetSecretValue(ctx context.Context, name string) (string, error) {
+	return c.GetSecretValueForVersion(ctx, name, "")
+}
+
+func (c *ParameterStoreClient) GetSecretValueForVersion(ctx context.Context, name, version string) (string, error) {
+	secret, err := c.GetSecret(ctx, name, version)
+	if err != nil {
+		return "", err
+	}
+	return secret.Value, nil
+}
+
+func (c *ParameterStoreClient) GetSecret(ctx context.Context, name, version string) (*secretstore.Secret, error) {
+	parameterName, key := splitJSONKey(name)
+	var secret *secretstore.Secret
+	var err error
+	if strings.HasSuffix(parameterName, "/") {
+		if version != "" {
+			return nil, fmt.Errorf("path %q has no versions", parameterName)
+		}
+		secret, err = c.getPath(ctx, parameterName)
+	} else {
+		secret, err = c.getParameter(ctx, parameterName, version)
+	}
+	if isAPIError(err, "ParameterNotFound", "ParameterVersionNotFound", "ParameterVersionLabelNotFound") {
+		return nil, &secretstore.NotFoundError{Name: name, Version: version}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	secret.Name = name
+	if key != "" {
+		if secret.Value, err = extractJSONKey(parameterName, secret.Value, key); err != nil {
+			return nil, err
+		}
+	}
+	return secret, nil
+}
+
+// getParameter reads a version of a parameter with the selector syntax
+// name:version, which accepts version numbers and labels
+func (c *ParameterStoreClient) getParameter(ctx context.Context, name, version string) (*secretstore.Secret, error) {
+	input := getParameterInput{Name: name, WithDecryption: true}
+	if version != "" {
+		input.Name = name + ":" + version
+	}
+	var output getParameterOutput
+	if err := c.api.call(ctx, "GetParameter", input, &output); err != nil {
+		return nil, err
+	}
+	return newParameterSecret(output.Parameter), nil
+}
+
+// getPath reads the parameters directly below path. Nested paths are not
+// read, as a policy that allows path/* only allows the direct children.
+func (c *ParameterStoreClient) getPath(ctx context.Context, path string) (*secretstore.Secret, error) {
+	parameters, err := c.ListParameters(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(parameters) == 0 {
+		return nil, &APIError{Code: "ParameterNotFound", Message: fmt.Sprintf("no parameters below %s", path)}
+	}
+	values := make(map[string]string, len(parameters))
+	for _, parameter := range parameters {
+		values[strings.TrimPrefix(parameter.Name, path)] = parameter.Value
+	}
+	value, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	return &secretstore.Secret{Value: string(value), Tags: make(map[string]string)}, nil
+}
+
+// ListParameters returns the latest versions of the parameters directly below
+// path, without those in nested paths, sorted by name
+func (c *ParameterStoreClient) ListParameters(ctx context.Context, path string) ([]*secretstore.Secret, error) {
+	input := getParametersByPathInput{Path: path, WithDecryption: true}
+	if path != "/" {
+		input.Path = strings.TrimSuffix(path, "/")
+	}
+	var parameters []*secretstore.Secret
+	for {
+		var output parametersOutput
+		if err := c.api.call(ctx, "GetParametersByPath", input, &output); err != nil {
+			return nil, err
+		}
+		for _, parameter := range output.Parameters {
+			parameters = append(parameters, newParameterSecret(parameter))
+		}
+		if output.NextToken == "" {
+			break
+		}
+		input.NextToken = output.NextToken
+	}
+	sort.Slice(parameters, func(i, j int) bool {
+		return parameters[i].Name < parameters[j].Name
+	})
+	return parameters, nil
+}
+
+// ListVersions returns the metadata of all versions of a parameter, newest
+// first
+func (c *ParameterStoreClient) ListVersions(ctx context.Context, name string) ([]*secretstore.Secret, error) {
+	parameterName, _ := splitJSONKey(name)
+	input := getParameterHistoryInput{Name: parameterName}
+	var history []parameter
+	for {
+		var output parametersOutput
+		err := c.api.call(ctx, "GetParameterHistory", input, &output)
+		if isAPIError(err, "ParameterNotFound") {
+			return nil, &secretstore.NotFoundError{Name: name}
+		}
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, output.Parameters...)
+		if output.NextToken == "" {
+			break
+		}
+		input.NextToken = output.NextToken
+	}
+
+	// version numbers order the versions even if they were modified within
+	// the same second
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].Version > history[j].Version
+	})
+	versions := make([]*secretstore.Secret, 0, len(history))
+	for _, parameter := range history {
+		version := newParameterSecret(parameter)
+		version.Name = name
+		version.Value = ""
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// newParameterSecret converts a parameter. Every change of a parameter
+// creates a new version, so the modification date is the creation date of
+// the version.
+func newParameterSecret(parameter parameter) *secretstore.Secret {
+	return &secretstore.Secret{
+		Name:    parameter.Name,
+		Value:   parameter.Value,
+		Version: strconv.FormatInt(parameter.Version, 10),
+		Tags:    make(map[string]string),
+		Created: epochTime(parameter.LastModifiedDate),
+	}
+}
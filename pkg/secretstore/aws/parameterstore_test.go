@@ -0,0 +1,176 @@
+package aws
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+func newTestParameterStore() *testService {
+	// versions of /app/prod/db, oldest first
+	history := []map[string]interface{}{
+		{"Name": "/app/prod/db", "Value": `{"password":"old"}`, "Version": 1, "LastModifiedDate": 1551438000},
+		{"Name": "/app/prod/db", "Value": `{"password":"new"}`, "Version": 2, "LastModifiedDate": 1551441600},
+	}
+	labels := map[string]int{"stable": 1}
+	return &testService{operations: map[string]func(map[string]interface{}) (interface{}, error){
+		"GetParameter": func(input map[string]interface{}) (interface{}, error) {
+			if input["WithDecryption"] != true {
+				return nil, &APIError{Code: "ValidationException", Message: "SecureString parameters must be decrypted"}
+			}
+			name := input["Name"].(string)
+			selector := ""
+			if i := strings.LastIndex(name, ":"); i >= 0 {
+				name, selector = name[:i], name[i+1:]
+			}
+			if name != "/app/prod/db" {
+				return nil, &APIError{Code: "ParameterNotFound"}
+			}
+			version := len(history)
+			if selector == "1" || selector == "2" {
+				version = int(selector[0] - '0')
+			} else if selector != "" {
+				var ok bool
+				if version, ok = labels[selector]; !ok {
+					return nil, &APIError{Code: "ParameterVersionLabelNotFound"}
+				}
+			}
+			return map[string]interface{}{"Parameter": history[version-1]}, nil
+		},
+		"GetParameterHistory": func(input map[string]interface{}) (interface{}, error) {
+			if input["Name"] != "/app/prod/db" {
+				return nil, &APIError{Code: "ParameterNotFound"}
+			}
+			if input["NextToken"] == nil {
+				return map[string]interface{}{"Parameters": history[:1], "NextToken": "page-2"}, nil
+			}
+			return map[string]interface{}{"Parameters": history[1:]}, nil
+		},
+		"GetParametersByPath": func(input map[string]interface{}) (interface{}, error) {
+			if input["Path"] != "/app/prod" || input["Recursive"] != false || input["WithDecryption"] != true {
+				return map[string]interface{}{"Parameters": []interface{}{}}, nil
+			}
+			if input["NextToken"] == nil {
+				return map[string]interface{}{
+					"Parameters": []interface{}{map[string]interface{}{"Name": "/app/prod/url", "Value": "https://example.com", "Version": 1}},
+					"NextToken":  "page-2",
+				}, nil
+			}
+			return map[string]interface{}{"Parameters": []interface{}{history[1]}}, nil
+		},
+	}}
+}
+
+func TestParameterStoreClient_GetSecret(t *testing.T) {
+	config, closeServer := newTestConfig(t, newTestParameterStore())
+	defer closeServer()
+	client, err := NewParameterStoreClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		secret      string
+		version     string
+		wantValue   string
+		wantVersion string
+		wantErr     bool
+		notFound    bool
+	}{
+		{
+			name:        "latest",
+			secret:      "/app/prod/db",
+			wantValue:   `{"password":"new"}`,
+			wantVersion: "2",
+		},
+		{
+			name:        "version number",
+			secret:      "/app/prod/db#password",
+			version:     "1",
+			wantValue:   "old",
+			wantVersion: "1",
+		},
+		{
+			name:        "label",
+			secret:      "/app/prod/db",
+			version:     "stable",
+			wantValue:   `{"password":"old"}`,
+			wantVersion: "1",
+		},
+		{
+			name:      "path",
+			secret:    "/app/prod/",
+			wantValue: `{"db":"{\"password\":\"new\"}","url":"https://example.com"}`,
+		},
+		{
+			name:      "key of path",
+			secret:    "/app/prod/#url",
+			wantValue: "https://example.com",
+		},
+		{
+			name:     "empty path",
+			secret:   "/app/test/",
+			wantErr:  true,
+			notFound: true,
+		},
+		{
+			name:     "missing parameter",
+			secret:   "/app/prod/other",
+			wantErr:  true,
+			notFound: true,
+		},
+		{
+			name:     "missing label",
+			secret:   "/app/prod/db",
+			version:  "canary",
+			wantErr:  true,
+			notFound: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := client.GetSecret(context.Background(), tt.secret, tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if secretstore.IsNotFound(err) != tt.notFound {
+				t.Errorf("GetSecret() error = %v, notFound %v", err, tt.notFound)
+			}
+			if err != nil {
+				return
+			}
+			if got.Name != tt.secret || got.Value != tt.wantValue || got.Version != tt.wantVersion {
+				t.Errorf("GetSecret() = %s %q %s, want %s %q %s", got.Name, got.Value, got.Version, tt.secret, tt.wantValue, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestParameterStoreClient_ListVersions(t *testing.T) {
+	config, closeServer := newTestConfig(t, newTestParameterStore())
+	defer closeServer()
+	client, err := NewParameterStoreClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := client.ListVersions(context.Background(), "/app/prod/db#password")
+	if err != nil {
+		t.Fatalf("ListVersions() error = %v", err)
+	}
+	if len(versions) != 2 || versions[0].Version != "2" || versions[1].Version != "1" {
+		t.Fatalf("ListVersions() = %+v, want versions 2 and 1", versions)
+	}
+	for _, version := range versions {
+		if version.Name != "/app/prod/db#password" || version.Value != "" || version.Created == nil {
+			t.Errorf("ListVersions() version = %+v, want metadata without value", version)
+		}
+	}
+
+	if _, err := client.ListVersions(context.Background(), "/app/prod/other"); !secretstore.IsNotFound(err) {
+		t.Errorf("ListVersions() error = %v, want NotFoundError", err)
+	}
+}
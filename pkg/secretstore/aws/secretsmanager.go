@@ -0,0 +1,137 @@
+package aws
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+// versionIDPattern matches version IDs of Secrets Manager, which are UUIDs
+// unless the client request token was set when the secret was written.
+// Other versions are treated as staging labels like AWSPREVIOUS.
+var versionIDPattern = regexp.MustCompile(`^[0-9a-zA-Z-]{32,64}$`)
+
+// SecretsManagerClient is a secretstore.Client for AWS Secrets Manager. A
+// version is either a version ID or a staging label, the latest version is
+// the one labelled AWSCURRENT.
+type SecretsManagerClient struct {
+	api *apiClient
+}
+
+type getSecretValueInput struct {
+	SecretID     string `json:"SecretId"`
+	VersionID    string `json:"VersionId,omitempty"`
+	VersionStage string `json:"VersionStage,omitempty"`
+}
+
+type getSecretValueOutput struct {
+	Name         string  `json:"Name"`
+	VersionID    string  `json:"VersionId"`
+	SecretString *string `json:"SecretString"`
+	SecretBinary []byte  `json:"SecretBinary"`
+	CreatedDate  float64 `json:"CreatedDate"`
+}
+
+type listSecretVersionIdsInput struct {
+	SecretID  string `json:"SecretId"`
+	NextToken string `json:"NextToken,omitempty"`
+}
+
+type listSecretVersionIdsOutput struct {
+	Versions []struct {
+		VersionID   string  `json:"VersionId"`
+		CreatedDate float64 `json:"CreatedDate"`
+	} `json:"Versions"`
+	NextToken string `json:"NextToken"`
+}
+
+// NewSecretsManagerClient returns a SecretsManagerClient for the region of
+// config
+func NewSecretsManagerClient(config Config) (*SecretsManagerClient, error) {
+	api, err := newAPIClient(config, "secretsmanager", "secretsmanager")
+	if err != nil {
+		return nil, err
+	}
+	return &SecretsManagerClient{api: api}, nil
+}
+
+func (c *SecretsManagerClient) GetSecretValue(ctx context.Context, name string) (string, error) {
+	return c.GetSecretValueForVersion(ctx, name, "")
+}
+
+func (c *SecretsManagerClient) GetSecretValueForVersion(ctx context.Context, name, version string) (string, error) {
+	secret, err := c.GetSecret(ctx, name, version)
+	if err != nil {
+		return "", err
+	}
+	return secret.Value, nil
+}
+
+func (c *SecretsManagerClient) GetSecret(ctx context.Context, name, version string) (*secretstore.Secret, error) {
+	secretID, key := splitJSONKey(name)
+	input := getSecretValueInput{SecretID: secretID}
+	if versionIDPattern.MatchString(version) {
+		input.VersionID = version
+	} else {
+		input.VersionStage = version
+	}
+	var output getSecretValueOutput
+	err := c.api.call(ctx, "GetSecretValue", input, &output)
+	if isAPIError(err, "ResourceNotFoundException") {
+		return nil, &secretstore.NotFoundError{Name: name, Version: version}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	value := string(output.SecretBinary)
+	if output.SecretString != nil {
+		value = *output.SecretString
+	}
+	if key != "" {
+		if value, err = extractJSONKey(secretID, value, key); err != nil {
+			return nil, err
+		}
+	}
+	return &secretstore.Secret{
+		Name:    name,
+		Value:   value,
+		Version: output.VersionID,
+		Tags:    make(map[string]string),
+		Created: epochTime(output.CreatedDate),
+	}, nil
+}
+
+// ListVersions returns the versions of a secret that have a staging label,
+// newest first. Versions without a label are deprecated and may be removed
+// by Secrets Manager at any time.
+func (c *SecretsManagerClient) ListVersions(ctx context.Context, name string) ([]*secretstore.Secret, error) {
+	secretID, _ := splitJSONKey(name)
+	input := listSecretVersionIdsInput{SecretID: secretID}
+	var versions []*secretstore.Secret
+	for {
+		var output listSecretVersionIdsOutput
+		err := c.api.call(ctx, "ListSecretVersionIds", input, &output)
+		if isAPIError(err, "ResourceNotFoundException") {
+			return nil, &secretstore.NotFoundError{Name: name}
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, version := range output.Versions {
+			versions = append(versions, &secretstore.Secret{
+				Name:    name,
+				Version: version.VersionID,
+				Tags:    make(map[string]string),
+				Created: epochTime(version.CreatedDate),
+			})
+		}
+		if output.NextToken == "" {
+			break
+		}
+		input.NextToken = output.NextToken
+	}
+	secretstore.SortVersions(versions)
+	return versions, nil
+}
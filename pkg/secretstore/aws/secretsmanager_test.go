@@ -0,0 +1,170 @@
+package aws
+
+import (
+	"context"
+	"testing"
+
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+const (
+	currentVersionID  = "6a5c1d6e-2b6f-4e4c-9d3a-0c4f6b1e7a01"
+	previousVersionID = "6a5c1d6e-2b6f-4e4c-9d3a-0c4f6b1e7a00"
+)
+
+func newTestSecretsManager() *testService {
+	versions := map[string]map[string]interface{}{
+		currentVersionID: {
+			"Name":         "db",
+			"VersionId":    currentVersionID,
+			"SecretString": `{"username":"app","password":"new","port":5432}`,
+			"CreatedDate":  1551441600.5,
+		},
+		previousVersionID: {
+			"Name":         "db",
+			"VersionId":    previousVersionID,
+			"SecretString": `{"username":"app","password":"old","port":5432}`,
+			"CreatedDate":  1551438000,
+		},
+	}
+	stages := map[string]string{"AWSCURRENT": currentVersionID, "AWSPREVIOUS": previousVersionID}
+	return &testService{operations: map[string]func(map[string]interface{}) (interface{}, error){
+		"GetSecretValue": func(input map[string]interface{}) (interface{}, error) {
+			if input["SecretId"] != "db" {
+				return nil, &APIError{Code: "ResourceNotFoundException", Message: "Secrets Manager can't find the specified secret."}
+			}
+			versionID, _ := input["VersionId"].(string)
+			if stage, ok := input["VersionStage"].(string); ok {
+				versionID = stages[stage]
+			}
+			if versionID == "" && input["VersionStage"] == nil {
+				versionID = stages["AWSCURRENT"]
+			}
+			version, ok := versions[versionID]
+			if !ok {
+				return nil, &APIError{Code: "ResourceNotFoundException", Message: "Secrets Manager can't find the specified secret value."}
+			}
+			return version, nil
+		},
+		"ListSecretVersionIds": func(input map[string]interface{}) (interface{}, error) {
+			if input["SecretId"] != "db" {
+				return nil, &APIError{Code: "ResourceNotFoundException"}
+			}
+			// the versions are returned in pages and in no particular order
+			if input["NextToken"] == nil {
+				return map[string]interface{}{
+					"Versions":  []interface{}{map[string]interface{}{"VersionId": previousVersionID, "CreatedDate": 1551438000}},
+					"NextToken": "page-2",
+				}, nil
+			}
+			return map[string]interface{}{
+				"Versions": []interface{}{map[string]interface{}{"VersionId": currentVersionID, "CreatedDate": 1551441600.5}},
+			}, nil
+		},
+	}}
+}
+
+func TestSecretsManagerClient_GetSecret(t *testing.T) {
+	config, closeServer := newTestConfig(t, newTestSecretsManager())
+	defer closeServer()
+	client, err := NewSecretsManagerClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		secret      string
+		version     string
+		wantValue   string
+		wantVersion string
+		wantErr     bool
+		notFound    bool
+	}{
+		{
+			name:        "latest",
+			secret:      "db",
+			wantValue:   `{"username":"app","password":"new","port":5432}`,
+			wantVersion: currentVersionID,
+		},
+		{
+			name:        "version ID",
+			secret:      "db#password",
+			version:     previousVersionID,
+			wantValue:   "old",
+			wantVersion: previousVersionID,
+		},
+		{
+			name:        "staging label",
+			secret:      "db#password",
+			version:     "AWSPREVIOUS",
+			wantValue:   "old",
+			wantVersion: previousVersionID,
+		},
+		{
+			name:        "JSON key that is not a string",
+			secret:      "db#port",
+			wantValue:   "5432",
+			wantVersion: currentVersionID,
+		},
+		{
+			name:    "missing JSON key",
+			secret:  "db#host",
+			wantErr: true,
+		},
+		{
+			name:     "missing secret",
+			secret:   "other",
+			wantErr:  true,
+			notFound: true,
+		},
+		{
+			name:     "missing staging label",
+			secret:   "db",
+			version:  "AWSPENDING",
+			wantErr:  true,
+			notFound: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := client.GetSecret(context.Background(), tt.secret, tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if secretstore.IsNotFound(err) != tt.notFound {
+				t.Errorf("GetSecret() error = %v, notFound %v", err, tt.notFound)
+			}
+			if err != nil {
+				return
+			}
+			if got.Name != tt.secret || got.Value != tt.wantValue || got.Version != tt.wantVersion {
+				t.Errorf("GetSecret() = %s %q %s, want %s %q %s", got.Name, got.Value, got.Version, tt.secret, tt.wantValue, tt.wantVersion)
+			}
+			if got.Created == nil {
+				t.Errorf("GetSecret() has no creation date")
+			}
+		})
+	}
+}
+
+func TestSecretsManagerClient_ListVersions(t *testing.T) {
+	config, closeServer := newTestConfig(t, newTestSecretsManager())
+	defer closeServer()
+	client, err := NewSecretsManagerClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := client.ListVersions(context.Background(), "db#password")
+	if err != nil {
+		t.Fatalf("ListVersions() error = %v", err)
+	}
+	if len(versions) != 2 || versions[0].Version != currentVersionID || versions[1].Version != previousVersionID {
+		t.Errorf("ListVersions() = %+v, want the current version first", versions)
+	}
+
+	if _, err := client.ListVersions(context.Background(), "other"); !secretstore.IsNotFound(err) {
+		t.Errorf("ListVersions() error = %v, want NotFoundError", err)
+	}
+}
@@ -0,0 +1,239 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+var (
+	cacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "secretcontroller",
+		Subsystem: "secretstore_cache",
+		Name:      "requests_total",
+		Help:      "Number of secret lookups served by the cache, partitioned by result (hit, miss or shared).",
+	}, []string{"result"})
+	cacheEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "secretcontroller",
+		Subsystem: "secretstore_cache",
+		Name:      "entries",
+		Help:      "Number of secret values currently held in the cache.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(cacheRequests, cacheEntries)
+}
+
+// MaxEntries is the number of secrets a Client holds at most. When it is
+// reached, expired secrets are dropped first and then the oldest tenth of
+// the remaining ones, so that pinned versions of secrets that are no longer
+// used do not accumulate.
+const MaxEntries = 10000
+
+type entry struct {
+	secret *secretstore.Secret
+	// added is the time the secret was fetched
+	added time.Time
+	// expires is zero for entries that never expire
+	expires time.Time
+}
+
+// Client is a secretstore.Client that keeps the secrets returned by another
+// Client in memory. Secrets for the latest version expire after the configured
+// TTL, secrets for an explicit version never change and are kept until the
+// cache is full, see MaxEntries. Concurrent lookups of the same key share a
+// single request.
+// The returned secrets are shared between callers and must not be modified.
+type Client struct {
+	client secretstore.Client
+	prefix string
+	ttl    time.Duration
+	now    func() time.Time
+	// maxEntries is MaxEntries outside of tests
+	maxEntries int
+
+	mu      sync.RWMutex
+	entries map[string]entry
+	group   singleflight.Group
+}
+
+// NewClient wraps client with a cache. The prefix identifies the wrapped
+// store, e.g. the Key Vault name, and becomes part of every cache key.
+func NewClient(client secretstore.Client, prefix string, ttl time.Duration) *Client {
+	return &Client{
+		client:     client,
+		prefix:     prefix,
+		ttl:        ttl,
+		now:        time.Now,
+		maxEntries: MaxEntries,
+		entries:    make(map[string]entry),
+	}
+}
+
+func (c *Client) GetSecretValue(ctx context.Context, name string) (string, error) {
+	return c.GetSecretValueForVersion(ctx, name, "")
+}
+
+func (c *Client) GetSecretValueForVersion(ctx context.Context, name, version string) (string, error) {
+	secret, err := c.GetSecret(ctx, name, version)
+	if err != nil {
+		return "", err
+	}
+	return secret.Value, nil
+}
+
+// GetSecret returns the cached secret or fetches it from the wrapped client.
+// A shared lookup runs with the context of the caller that started it; every
+// caller stops waiting as soon as its own context is done.
+func (c *Client) GetSecret(ctx context.Context, name, version string) (*secretstore.Secret, error) {
+	key := c.key(name, version)
+	if secret, ok := c.get(key); ok {
+		cacheRequests.WithLabelValues("hit").Inc()
+		return secret, nil
+	}
+
+	ch := c.group.DoChan(key, func() (interface{}, error) {
+		secret, err := c.client.GetSecret(ctx, name, version)
+		if err != nil {
+			return nil, err
+		}
+		c.set(key, secret, version == "")
+		return secret, nil
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result := <-ch:
+		if result.Shared {
+			cacheRequests.WithLabelValues("shared").Inc()
+		} else {
+			cacheRequests.WithLabelValues("miss").Inc()
+		}
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.(*secretstore.Secret), nil
+	}
+}
+
+// SetSecret writes secret to the wrapped client if it is a
+// secretstore.Writer and drops the cached latest version of the secret
+func (c *Client) SetSecret(ctx context.Context, secret *secretstore.Secret) (*secretstore.Secret, error) {
+	writer, ok := c.client.(secretstore.Writer)
+	if !ok {
+		return nil, fmt.Errorf("secret store does not support writing secrets")
+	}
+	defer c.delete(c.key(secret.Name, ""))
+	return writer.SetSecret(ctx, secret)
+}
+
+// DeleteSecret deletes the secret from the wrapped client if it is a
+// secretstore.Writer and drops the cached latest version of the secret
+func (c *Client) DeleteSecret(ctx context.Context, name string) error {
+	writer, ok := c.client.(secretstore.Writer)
+	if !ok {
+		return fmt.Errorf("secret store does not support writing secrets")
+	}
+	defer c.delete(c.key(name, ""))
+	return writer.DeleteSecret(ctx, name)
+}
+
+// ListVersions lists the versions of the secret with the wrapped client if it
+// is a secretstore.VersionLister. Lists are not cached, as they change with
+// every new version.
+func (c *Client) ListVersions(ctx context.Context, name string) ([]*secretstore.Secret, error) {
+	lister, ok := c.client.(secretstore.VersionLister)
+	if !ok {
+		return nil, fmt.Errorf("secret store does not support listing versions")
+	}
+	return lister.ListVersions(ctx, name)
+}
+
+// Invalidate drops the cached latest version of the secret, e.g. when the
+// store announces a new version
+func (c *Client) Invalidate(name string) {
+	c.delete(c.key(name, ""))
+}
+
+func (c *Client) key(name, version string) string {
+	return strings.Join([]string{c.prefix, name, version}, "/")
+}
+
+func (c *Client) get(key string) (*secretstore.Secret, bool) {
+	c.mu.RLock()
+	e, ok := c.entries[key]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	if !e.expires.IsZero() && !c.now().Before(e.expires) {
+		c.mu.Lock()
+		if e, ok := c.entries[key]; ok && !e.expires.IsZero() && !c.now().Before(e.expires) {
+			delete(c.entries, key)
+			cacheEntries.Dec()
+		}
+		c.mu.Unlock()
+		return nil, false
+	}
+	return e.secret, true
+}
+
+func (c *Client) set(key string, secret *secretstore.Secret, expires bool) {
+	now := c.now()
+	e := entry{secret: secret, added: now}
+	if expires {
+		e.expires = now.Add(c.ttl)
+	}
+	c.mu.Lock()
+	if _, ok := c.entries[key]; !ok {
+		if len(c.entries) >= c.maxEntries {
+			c.evict(now)
+		}
+		cacheEntries.Inc()
+	}
+	c.entries[key] = e
+	c.mu.Unlock()
+}
+
+// evict drops the expired entries and, if that does not free a tenth of the
+// cache, the oldest entries until it does. c.mu must be held.
+func (c *Client) evict(now time.Time) {
+	keys := make([]string, 0, len(c.entries))
+	for key, e := range c.entries {
+		if !e.expires.IsZero() && !now.Before(e.expires) {
+			delete(c.entries, key)
+			cacheEntries.Dec()
+			continue
+		}
+		keys = append(keys, key)
+	}
+	limit := c.maxEntries - c.maxEntries/10 - 1
+	if len(keys) <= limit {
+		return
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return c.entries[keys[i]].added.Before(c.entries[keys[j]].added)
+	})
+	for _, key := range keys[:len(keys)-limit] {
+		delete(c.entries, key)
+		cacheEntries.Dec()
+	}
+}
+
+func (c *Client) delete(key string) {
+	c.mu.Lock()
+	if _, ok := c.entries[key]; ok {
+		delete(c.entries, key)
+		cacheEntries.Dec()
+	}
+	c.mu.Unlock()
+}
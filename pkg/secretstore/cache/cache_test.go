@@ -0,0 +1,208 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+type countingClient struct {
+	calls   int32
+	value   string
+	err     error
+	release chan struct{}
+}
+
+func (s *countingClient) GetSecretValue(ctx context.Context, name string) (string, error) {
+	return s.GetSecretValueForVersion(ctx, name, "")
+}
+
+func (s *countingClient) GetSecretValueForVersion(ctx context.Context, name, version string) (string, error) {
+	secret, err := s.GetSecret(ctx, name, version)
+	if err != nil {
+		return "", err
+	}
+	return secret.Value, nil
+}
+
+func (s *countingClient) GetSecret(ctx context.Context, name, version string) (*secretstore.Secret, error) {
+	atomic.AddInt32(&s.calls, 1)
+	if s.release != nil {
+		<-s.release
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &secretstore.Secret{Name: name, Value: s.value + name + version, Version: version}, nil
+}
+
+func TestClient_GetSecretValueForVersion(t *testing.T) {
+	type lookup struct {
+		name    string
+		version string
+		after   time.Duration
+	}
+	tests := []struct {
+		name      string
+		lookups   []lookup
+		err       error
+		wantCalls int32
+	}{
+		{
+			name: "latest version within TTL",
+			lookups: []lookup{
+				{name: "secret"},
+				{name: "secret", after: 30 * time.Second},
+			},
+			wantCalls: 1,
+		},
+		{
+			name: "latest version after TTL",
+			lookups: []lookup{
+				{name: "secret"},
+				{name: "secret", after: time.Minute},
+			},
+			wantCalls: 2,
+		},
+		{
+			name: "pinned version never expires",
+			lookups: []lookup{
+				{name: "secret", version: "v1"},
+				{name: "secret", version: "v1", after: 24 * time.Hour},
+			},
+			wantCalls: 1,
+		},
+		{
+			name: "different versions are cached separately",
+			lookups: []lookup{
+				{name: "secret"},
+				{name: "secret", version: "v1"},
+				{name: "secret", version: "v2"},
+			},
+			wantCalls: 3,
+		},
+		{
+			name: "errors are not cached",
+			lookups: []lookup{
+				{name: "secret"},
+				{name: "secret"},
+			},
+			err:       fmt.Errorf("Secret not found"),
+			wantCalls: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &countingClient{value: "value", err: tt.err}
+			client := NewClient(store, "vault", time.Minute)
+			now := time.Now()
+			client.now = func() time.Time { return now }
+			for _, l := range tt.lookups {
+				now = now.Add(l.after)
+				got, err := client.GetSecretValueForVersion(context.Background(), l.name, l.version)
+				if (err != nil) != (tt.err != nil) {
+					t.Fatalf("GetSecretValueForVersion() error = %v, wantErr %v", err, tt.err != nil)
+				}
+				if want := "value" + l.name + l.version; err == nil && got != want {
+					t.Errorf("GetSecretValueForVersion() = %v, want %v", got, want)
+				}
+			}
+			if store.calls != tt.wantCalls {
+				t.Errorf("store called %d times, want %d", store.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestClient_ConcurrentLookups(t *testing.T) {
+	store := &countingClient{value: "value", release: make(chan struct{})}
+	client := NewClient(store, "vault", time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.GetSecretValue(context.Background(), "secret"); err != nil {
+				t.Errorf("GetSecretValue() error = %v", err)
+			}
+		}()
+	}
+	// give the goroutines a chance to join the in-flight lookup
+	time.Sleep(50 * time.Millisecond)
+	close(store.release)
+	wg.Wait()
+
+	if store.calls != 1 {
+		t.Errorf("store called %d times, want 1", store.calls)
+	}
+}
+
+func TestClient_CancelledLookup(t *testing.T) {
+	store := &countingClient{value: "value", release: make(chan struct{})}
+	defer close(store.release)
+	client := NewClient(store, "vault", time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := client.GetSecretValue(ctx, "secret"); err != context.DeadlineExceeded {
+		t.Errorf("GetSecretValue() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestClient_Invalidate(t *testing.T) {
+	store := &countingClient{value: "value"}
+	client := NewClient(store, "vault", time.Minute)
+	ctx := context.Background()
+
+	client.GetSecretValue(ctx, "secret")
+	client.GetSecretValueForVersion(ctx, "secret", "v1")
+	client.Invalidate("secret")
+	client.GetSecretValue(ctx, "secret")
+	client.GetSecretValueForVersion(ctx, "secret", "v1")
+	if store.calls != 3 {
+		t.Errorf("store called %d times, want 3", store.calls)
+	}
+}
+
+func TestClient_MaxEntries(t *testing.T) {
+	store := &countingClient{value: "value"}
+	client := NewClient(store, "vault", time.Minute)
+	client.maxEntries = 10
+	now := time.Now()
+	client.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		client.GetSecretValue(ctx, fmt.Sprintf("latest-%d", i))
+		now = now.Add(time.Second)
+		client.GetSecretValueForVersion(ctx, "pinned", fmt.Sprintf("v%d", i))
+		now = now.Add(time.Second)
+	}
+	// expired latest versions are dropped before pinned versions
+	now = now.Add(time.Minute)
+	client.GetSecretValueForVersion(ctx, "pinned", "v5")
+	if len(client.entries) != 6 {
+		t.Errorf("cache holds %d entries, want 6", len(client.entries))
+	}
+
+	// the oldest pinned versions are dropped when nothing has expired
+	for i := 6; i < 11; i++ {
+		now = now.Add(time.Second)
+		client.GetSecretValueForVersion(ctx, "pinned", fmt.Sprintf("v%d", i))
+	}
+	if len(client.entries) != 9 {
+		t.Errorf("cache holds %d entries, want 9", len(client.entries))
+	}
+	calls := store.calls
+	client.GetSecretValueForVersion(ctx, "pinned", "v10")
+	client.GetSecretValueForVersion(ctx, "pinned", "v0")
+	if store.calls != calls+1 {
+		t.Errorf("store called %d times, want %d", store.calls-calls, 1)
+	}
+}
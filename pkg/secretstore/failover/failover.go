@@ -0,0 +1,254 @@
+// Package failover implements a secret store that reads secrets from the
+// first of several backends that is available, e.g. a primary Key Vault and
+// the Key Vaults of other regions that hold copies of its secrets.
+package failover
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+// DefaultCooldown is how long a backend is skipped after a transient error if
+// Config.Cooldown is zero
+const DefaultCooldown = 30 * time.Second
+
+var (
+	backendRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "secretcontroller",
+		Subsystem: "secretstore_failover",
+		Name:      "requests_total",
+		Help:      "Number of secret lookups sent to a backend, partitioned by backend and result (served, not_found, transient_error or error).",
+	}, []string{"backend", "result"})
+	backendHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "secretcontroller",
+		Subsystem: "secretstore_failover",
+		Name:      "backend_healthy",
+		Help:      "Whether a backend is healthy (1) or is skipped after a transient error (0).",
+	}, []string{"backend"})
+)
+
+func init() {
+	prometheus.MustRegister(backendRequests, backendHealthy)
+}
+
+// Backend is a store that secrets are read from
+type Backend struct {
+	// Name identifies the backend in metrics and in the Source of the
+	// secrets it serves
+	Name   string
+	Client secretstore.Client
+}
+
+// Config configures a Client
+type Config struct {
+	// FailoverOnNotFound tries the next backend if a secret or version does
+	// not exist in a backend. By default a secret missing in the first
+	// backend is reported right away, as all backends are expected to hold
+	// the same secrets. Versions are specific to a vault, so a pinned
+	// version is only found in the first backend either way.
+	FailoverOnNotFound bool
+	// Cooldown is how long a backend is tried after the healthy ones once it
+	// failed with a transient error
+	Cooldown time.Duration
+}
+
+type backend struct {
+	Backend
+	// unhealthyUntil is zero for healthy backends
+	unhealthyUntil time.Time
+}
+
+// Client is a secretstore.Client that reads secrets from a list of backends
+// in order. It only moves on to the next backend after a transient error,
+// e.g. an outage or throttling, and, if configured, for missing secrets. All
+// other errors, like denied access, are returned right away. A secret is
+// only reported missing if no backend failed with a transient error.
+//
+// Backends that failed with a transient error are tried after the healthy
+// ones until the cooldown has passed, so that a failed backend does not slow
+// down every lookup. Secrets served by another backend than the first one
+// carry the name of that backend as Source.
+//
+// Secrets are only written to the first backend, which is expected to
+// replicate them to the others.
+type Client struct {
+	backends []*backend
+	config   Config
+	now      func() time.Time
+
+	mu sync.Mutex
+}
+
+// NewClient returns a Client for backends, which are tried in the given
+// order
+func NewClient(backends []Backend, config Config) (*Client, error) {
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("no backends to read secrets from")
+	}
+	if config.Cooldown <= 0 {
+		config.Cooldown = DefaultCooldown
+	}
+	c := &Client{config: config, now: time.Now}
+	names := make(map[string]bool)
+	for _, b := range backends {
+		if names[b.Name] {
+			return nil, fmt.Errorf("backend %q is configured twice", b.Name)
+		}
+		names[b.Name] = true
+		c.backends = append(c.backends, &backend{Backend: b})
+		backendHealthy.WithLabelValues(b.Name).Set(1)
+	}
+	return c, nil
+}
+
+func (c *Client) GetSecretValue(ctx context.Context, name string) (string, error) {
+	return c.GetSecretValueForVersion(ctx, name, "")
+}
+
+func (c *Client) GetSecretValueForVersion(ctx context.Context, name, version string) (string, error) {
+	secret, err := c.GetSecret(ctx, name, version)
+	if err != nil {
+		return "", err
+	}
+	return secret.Value, nil
+}
+
+func (c *Client) GetSecret(ctx context.Context, name, version string) (*secretstore.Secret, error) {
+	var secret *secretstore.Secret
+	b, err := c.try(ctx, func(client secretstore.Client) (err error) {
+		secret, err = client.GetSecret(ctx, name, version)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if b != c.backends[0] {
+		// the secret may be shared with the cache of the backend
+		copied := *secret
+		copied.Source = b.Name
+		secret = &copied
+	}
+	return secret, nil
+}
+
+// ListVersions lists the versions of the secret with the first available
+// backend that is a secretstore.VersionLister
+func (c *Client) ListVersions(ctx context.Context, name string) ([]*secretstore.Secret, error) {
+	var versions []*secretstore.Secret
+	_, err := c.try(ctx, func(client secretstore.Client) (err error) {
+		lister, ok := client.(secretstore.VersionLister)
+		if !ok {
+			return fmt.Errorf("secret store does not support listing versions")
+		}
+		versions, err = lister.ListVersions(ctx, name)
+		return err
+	})
+	return versions, err
+}
+
+// SetSecret writes secret to the first backend if it is a
+// secretstore.Writer
+func (c *Client) SetSecret(ctx context.Context, secret *secretstore.Secret) (*secretstore.Secret, error) {
+	writer, ok := c.backends[0].Client.(secretstore.Writer)
+	if !ok {
+		return nil, fmt.Errorf("secret store does not support writing secrets")
+	}
+	return writer.SetSecret(ctx, secret)
+}
+
+// DeleteSecret deletes the secret from the first backend if it is a
+// secretstore.Writer
+func (c *Client) DeleteSecret(ctx context.Context, name string) error {
+	writer, ok := c.backends[0].Client.(secretstore.Writer)
+	if !ok {
+		return fmt.Errorf("secret store does not support writing secrets")
+	}
+	return writer.DeleteSecret(ctx, name)
+}
+
+// Invalidate drops the cached latest version of the secret from all
+// backends that are a secretstore.Invalidator
+func (c *Client) Invalidate(name string) {
+	for _, b := range c.backends {
+		if invalidator, ok := b.Client.(secretstore.Invalidator); ok {
+			invalidator.Invalidate(name)
+		}
+	}
+}
+
+// try calls request with the clients of the backends until it succeeds or
+// fails with an error that another backend would not change. It returns the
+// backend that request succeeded with.
+//
+// Only the first backend is authoritative for missing secrets: a secondary
+// backend may lag behind the replication, and a version of the secret that
+// is pinned or listed is a version of the first backend that other
+// backends do not have. So if the first backend failed with a transient
+// error, or was skipped, its error is returned rather than the NotFoundError
+// of another backend, which would e.g. make the generator replace a
+// credential that is still in use.
+func (c *Client) try(ctx context.Context, request func(secretstore.Client) error) (*backend, error) {
+	var lastErr, transientErr error
+	for _, b := range c.order() {
+		err := request(b.Client)
+		switch {
+		case err == nil:
+			c.setHealthy(b, true)
+			backendRequests.WithLabelValues(b.Name, "served").Inc()
+			return b, nil
+		case secretstore.IsNotFound(err):
+			c.setHealthy(b, true)
+			backendRequests.WithLabelValues(b.Name, "not_found").Inc()
+			if !c.config.FailoverOnNotFound && b == c.backends[0] {
+				return nil, err
+			}
+		case secretstore.IsTransient(err) && ctx.Err() == nil:
+			c.setHealthy(b, false)
+			backendRequests.WithLabelValues(b.Name, "transient_error").Inc()
+			transientErr = err
+		default:
+			backendRequests.WithLabelValues(b.Name, "error").Inc()
+			return nil, err
+		}
+		lastErr = err
+	}
+	if transientErr != nil {
+		return nil, transientErr
+	}
+	return nil, lastErr
+}
+
+// order returns the healthy backends followed by the unhealthy ones
+func (c *Client) order() []*backend {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	healthy := make([]*backend, 0, len(c.backends))
+	var unhealthy []*backend
+	for _, b := range c.backends {
+		if now.Before(b.unhealthyUntil) {
+			unhealthy = append(unhealthy, b)
+		} else {
+			healthy = append(healthy, b)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+func (c *Client) setHealthy(b *backend, healthy bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if healthy {
+		b.unhealthyUntil = time.Time{}
+		backendHealthy.WithLabelValues(b.Name).Set(1)
+		return
+	}
+	b.unhealthyUntil = c.now().Add(c.config.Cooldown)
+	backendHealthy.WithLabelValues(b.Name).Set(0)
+}
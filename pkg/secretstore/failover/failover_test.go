@@ -0,0 +1,197 @@
+package failover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+// testBackend serves its value for every secret or fails with err
+type testBackend struct {
+	value string
+	err   error
+	calls int
+	set   int
+}
+
+func (b *testBackend) GetSecretValue(ctx context.Context, name string) (string, error) {
+	return b.GetSecretValueForVersion(ctx, name, "")
+}
+
+func (b *testBackend) GetSecretValueForVersion(ctx context.Context, name, version string) (string, error) {
+	secret, err := b.GetSecret(ctx, name, version)
+	if err != nil {
+		return "", err
+	}
+	return secret.Value, nil
+}
+
+func (b *testBackend) GetSecret(ctx context.Context, name, version string) (*secretstore.Secret, error) {
+	b.calls++
+	if b.err != nil {
+		return nil, b.err
+	}
+	return &secretstore.Secret{Name: name, Value: b.value, Version: version}, nil
+}
+
+func (b *testBackend) SetSecret(ctx context.Context, secret *secretstore.Secret) (*secretstore.Secret, error) {
+	b.set++
+	return secret, nil
+}
+
+func (b *testBackend) DeleteSecret(ctx context.Context, name string) error {
+	return nil
+}
+
+// outage is the error of a backend that cannot be reached
+var outage = &url.Error{Op: "Get", URL: "https://primary.vault.azure.net/", Err: errors.New("connection refused")}
+
+// throttled is the error of a store that throttles requests
+type throttled struct{}
+
+func (throttled) Error() string   { return "too many requests" }
+func (throttled) Transient() bool { return true }
+
+func TestClient_GetSecret(t *testing.T) {
+	tests := []struct {
+		name               string
+		primaryErr         error
+		secondaryErr       error
+		failoverOnNotFound bool
+		want               string
+		wantSource         string
+		wantErr            bool
+		notFound           bool
+		wantSecondaryCalls int
+	}{
+		{name: "primary", want: "primary"},
+		{name: "outage", primaryErr: outage, want: "secondary", wantSource: "secondary", wantSecondaryCalls: 1},
+		{name: "throttled", primaryErr: throttled{}, want: "secondary", wantSource: "secondary", wantSecondaryCalls: 1},
+		{name: "not found", primaryErr: &secretstore.NotFoundError{Name: "db-password"}, wantErr: true, notFound: true},
+		{name: "failover on not found", primaryErr: &secretstore.NotFoundError{Name: "db-password"}, failoverOnNotFound: true, want: "secondary", wantSource: "secondary", wantSecondaryCalls: 1},
+		{name: "permanent error", primaryErr: fmt.Errorf("access denied"), wantErr: true},
+		{name: "all backends down", primaryErr: outage, secondaryErr: throttled{}, wantErr: true, wantSecondaryCalls: 1},
+		{name: "outage and not found", primaryErr: outage, secondaryErr: &secretstore.NotFoundError{Name: "db-password"}, wantErr: true, wantSecondaryCalls: 1},
+		{name: "outage and not found with failover on not found", primaryErr: outage, secondaryErr: &secretstore.NotFoundError{Name: "db-password"}, failoverOnNotFound: true, wantErr: true, wantSecondaryCalls: 1},
+		{name: "not found anywhere", primaryErr: &secretstore.NotFoundError{Name: "db-password"}, secondaryErr: &secretstore.NotFoundError{Name: "db-password"}, failoverOnNotFound: true, wantErr: true, notFound: true, wantSecondaryCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := &testBackend{value: "primary", err: tt.primaryErr}
+			secondary := &testBackend{value: "secondary", err: tt.secondaryErr}
+			client, err := NewClient([]Backend{{Name: "primary", Client: primary}, {Name: "secondary", Client: secondary}},
+				Config{FailoverOnNotFound: tt.failoverOnNotFound})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := client.GetSecret(context.Background(), "db-password", "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if secretstore.IsNotFound(err) != tt.notFound {
+				t.Errorf("GetSecret() error = %v, notFound %v", err, tt.notFound)
+			}
+			if secondary.calls != tt.wantSecondaryCalls {
+				t.Errorf("GetSecret() called the secondary backend %d times, want %d", secondary.calls, tt.wantSecondaryCalls)
+			}
+			if err == nil && (got.Value != tt.want || got.Source != tt.wantSource) {
+				t.Errorf("GetSecret() = %q from %q, want %q from %q", got.Value, got.Source, tt.want, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestClient_cooldown(t *testing.T) {
+	now := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	primary := &testBackend{value: "primary", err: outage}
+	secondary := &testBackend{value: "secondary"}
+	client, err := NewClient([]Backend{{Name: "primary", Client: primary}, {Name: "secondary", Client: secondary}}, Config{Cooldown: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.now = func() time.Time { return now }
+	get := func() string {
+		secret, err := client.GetSecret(context.Background(), "db-password", "")
+		if err != nil {
+			t.Fatalf("GetSecret() error = %v", err)
+		}
+		return secret.Value
+	}
+
+	get()
+	// the primary backend is skipped during the cooldown
+	if got := get(); got != "secondary" || primary.calls != 1 {
+		t.Errorf("GetSecret() = %q after %d calls of the primary backend, want it to be skipped", got, primary.calls)
+	}
+
+	// and tried first again afterwards
+	now = now.Add(2 * time.Minute)
+	primary.err = nil
+	if got := get(); got != "primary" || primary.calls != 2 {
+		t.Errorf("GetSecret() = %q after %d calls of the primary backend, want primary", got, primary.calls)
+	}
+
+	// an unhealthy backend is still tried if all others fail
+	primary.err = outage
+	get()
+	secondary.err = throttled{}
+	primary.err = nil
+	if got := get(); got != "primary" {
+		t.Errorf("GetSecret() = %q, want the unhealthy primary backend to be tried", got)
+	}
+
+	// a secret missing in the secondary backend is looked up in the primary
+	// one during its cooldown
+	primary.err = outage
+	secondary.err = nil
+	get()
+	secondary.err = &secretstore.NotFoundError{Name: "db-password"}
+	primary.err = nil
+	if got := get(); got != "primary" {
+		t.Errorf("GetSecret() = %q, want the primary backend to be asked for a secret missing in the secondary one", got)
+	}
+}
+
+func TestClient_cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	primary := &testBackend{err: &url.Error{Op: "Get", URL: "https://primary.vault.azure.net/", Err: context.Canceled}}
+	secondary := &testBackend{}
+	client, err := NewClient([]Backend{{Name: "primary", Client: primary}, {Name: "secondary", Client: secondary}}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetSecret(ctx, "db-password", ""); err == nil || secondary.calls != 0 {
+		t.Errorf("GetSecret() error = %v, secondary calls %d, want no failover for cancelled requests", err, secondary.calls)
+	}
+}
+
+func TestClient_SetSecret(t *testing.T) {
+	primary := &testBackend{err: outage}
+	secondary := &testBackend{}
+	client, err := NewClient([]Backend{{Name: "primary", Client: primary}, {Name: "secondary", Client: secondary}}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.SetSecret(context.Background(), &secretstore.Secret{Name: "db-password"}); err != nil {
+		t.Fatalf("SetSecret() error = %v", err)
+	}
+	if primary.set != 1 || secondary.set != 0 {
+		t.Errorf("SetSecret() wrote %d times to the primary and %d times to the secondary backend, want only the primary", primary.set, secondary.set)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	if _, err := NewClient(nil, Config{}); err == nil {
+		t.Errorf("NewClient() accepted no backends")
+	}
+	backends := []Backend{{Name: "vault", Client: &testBackend{}}, {Name: "vault", Client: &testBackend{}}}
+	if _, err := NewClient(backends, Config{}); err == nil {
+		t.Errorf("NewClient() accepted duplicate backends")
+	}
+}
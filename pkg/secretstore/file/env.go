@@ -0,0 +1,55 @@
+package file
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+// EnvClient is a secretstore.Client that reads secrets from environment
+// variables. The variable of a secret is the prefix followed by the name in
+// upper case with dashes replaced by underscores, e.g. SECRET_DB_PASSWORD for
+// db-password and the prefix SECRET_. A version is appended after two
+// underscores, e.g. SECRET_DB_PASSWORD__V1.
+type EnvClient struct {
+	prefix    string
+	lookupEnv func(key string) (string, bool)
+}
+
+// NewEnvClient returns an EnvClient for the variables with the given prefix
+func NewEnvClient(prefix string) *EnvClient {
+	return &EnvClient{
+		prefix:    prefix,
+		lookupEnv: os.LookupEnv,
+	}
+}
+
+func (c *EnvClient) GetSecretValue(ctx context.Context, name string) (string, error) {
+	return c.GetSecretValueForVersion(ctx, name, "")
+}
+
+func (c *EnvClient) GetSecretValueForVersion(ctx context.Context, name, version string) (string, error) {
+	secret, err := c.GetSecret(ctx, name, version)
+	if err != nil {
+		return "", err
+	}
+	return secret.Value, nil
+}
+
+func (c *EnvClient) GetSecret(ctx context.Context, name, version string) (*secretstore.Secret, error) {
+	key := c.prefix + envName(name)
+	if version != "" {
+		key += "__" + envName(version)
+	}
+	value, ok := c.lookupEnv(key)
+	if !ok {
+		return nil, &secretstore.NotFoundError{Name: name, Version: version}
+	}
+	return &secretstore.Secret{Name: name, Value: value, Version: version}, nil
+}
+
+func envName(name string) string {
+	return strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
@@ -0,0 +1,170 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+// validName matches the names Key Vault allows, which keeps lookups inside
+// the directory of a Client
+var validName = regexp.MustCompile(`^[0-9a-zA-Z-]+$`)
+
+// Client is a secretstore.Client that reads secrets from a directory tree or
+// from a YAML or JSON file. The files are read on every lookup, so changes
+// are picked up without a restart.
+//
+// In a directory every secret is a file with the name of the secret. A
+// secret with versions is a directory instead, holding one file per version.
+// Its latest version is the most recently modified file.
+//
+// A YAML or JSON file maps secret names either to their value or to an
+// object with the fields value, version, contentType, tags, expires,
+// notBefore, disabled and versions, which maps older versions to their
+// values.
+type Client struct {
+	path string
+	dir  bool
+}
+
+// fileSecret is a secret in a YAML or JSON file
+type fileSecret struct {
+	Value       string            `json:"value"`
+	Version     string            `json:"version"`
+	ContentType string            `json:"contentType"`
+	Tags        map[string]string `json:"tags"`
+	Versions    map[string]string `json:"versions"`
+	Expires     *time.Time        `json:"expires"`
+	NotBefore   *time.Time        `json:"notBefore"`
+	Disabled    bool              `json:"disabled"`
+}
+
+// NewClient returns a Client for the directory or file at path
+func NewClient(path string) (*Client, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{path: path, dir: info.IsDir()}, nil
+}
+
+func (c *Client) GetSecretValue(ctx context.Context, name string) (string, error) {
+	return c.GetSecretValueForVersion(ctx, name, "")
+}
+
+func (c *Client) GetSecretValueForVersion(ctx context.Context, name, version string) (string, error) {
+	secret, err := c.GetSecret(ctx, name, version)
+	if err != nil {
+		return "", err
+	}
+	return secret.Value, nil
+}
+
+func (c *Client) GetSecret(ctx context.Context, name, version string) (*secretstore.Secret, error) {
+	if !validName.MatchString(name) || (version != "" && !validName.MatchString(version)) {
+		return nil, fmt.Errorf("invalid secret name %q or version %q", name, version)
+	}
+	if c.dir {
+		return c.readDir(name, version)
+	}
+	return c.readFile(name, version)
+}
+
+func (c *Client) readDir(name, version string) (*secretstore.Secret, error) {
+	path := filepath.Join(c.path, name)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, &secretstore.NotFoundError{Name: name, Version: version}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		// a single file has no versions
+		if version != "" {
+			return nil, &secretstore.NotFoundError{Name: name, Version: version}
+		}
+		value, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return &secretstore.Secret{Name: name, Value: string(value)}, nil
+	}
+
+	if version == "" {
+		files, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		// files are sorted by name, so the last name wins for equal times
+		var latest os.FileInfo
+		for _, file := range files {
+			if !file.IsDir() && (latest == nil || !file.ModTime().Before(latest.ModTime())) {
+				latest = file
+			}
+		}
+		if latest == nil {
+			return nil, &secretstore.NotFoundError{Name: name}
+		}
+		version = latest.Name()
+	}
+	value, err := ioutil.ReadFile(filepath.Join(path, version))
+	if os.IsNotExist(err) {
+		return nil, &secretstore.NotFoundError{Name: name, Version: version}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &secretstore.Secret{Name: name, Value: string(value), Version: version}, nil
+}
+
+func (c *Client) readFile(name, version string) (*secretstore.Secret, error) {
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return nil, err
+	}
+	var secrets map[string]json.RawMessage
+	if err := yaml.Unmarshal(data, &secrets); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %s", c.path, err)
+	}
+	raw, ok := secrets[name]
+	if !ok {
+		return nil, &secretstore.NotFoundError{Name: name, Version: version}
+	}
+	var stored fileSecret
+	if err := json.Unmarshal(raw, &stored.Value); err != nil {
+		if err := json.Unmarshal(raw, &stored); err != nil {
+			return nil, fmt.Errorf("error parsing secret %q in %s: %s", name, c.path, err)
+		}
+	}
+
+	secret := &secretstore.Secret{
+		Name:        name,
+		Value:       stored.Value,
+		Version:     stored.Version,
+		ContentType: stored.ContentType,
+		Tags:        stored.Tags,
+		Expires:     stored.Expires,
+		NotBefore:   stored.NotBefore,
+		Disabled:    stored.Disabled,
+	}
+	if version == "" || version == stored.Version {
+		return secret, nil
+	}
+	value, ok := stored.Versions[version]
+	if !ok {
+		return nil, &secretstore.NotFoundError{Name: name, Version: version}
+	}
+	// the attributes only describe the latest version
+	return &secretstore.Secret{Name: name, Value: value, Version: version}, nil
+}
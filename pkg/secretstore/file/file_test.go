@@ -0,0 +1,116 @@
+package file
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+func TestClient_GetSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secretstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tree := filepath.Join(dir, "tree")
+	writeFile(t, filepath.Join(tree, "single"), "single-value", time.Now())
+	writeFile(t, filepath.Join(tree, "versioned", "v1"), "old", time.Now().Add(-time.Hour))
+	writeFile(t, filepath.Join(tree, "versioned", "v2"), "new", time.Now())
+	yamlFile := filepath.Join(dir, "secrets.yaml")
+	writeFile(t, yamlFile, `
+short: short-value
+full:
+  value: new
+  version: v2
+  contentType: text/plain
+  versions:
+    v1: old
+`, time.Now())
+
+	tests := []struct {
+		name        string
+		path        string
+		secret      string
+		version     string
+		wantValue   string
+		wantVersion string
+		notFound    bool
+		wantErr     bool
+	}{
+		{name: "file", path: tree, secret: "single", wantValue: "single-value"},
+		{name: "file has no versions", path: tree, secret: "single", version: "v1", notFound: true},
+		{name: "latest version in directory", path: tree, secret: "versioned", wantValue: "new", wantVersion: "v2"},
+		{name: "version in directory", path: tree, secret: "versioned", version: "v1", wantValue: "old", wantVersion: "v1"},
+		{name: "missing in directory", path: tree, secret: "missing", notFound: true},
+		{name: "path outside directory", path: tree, secret: "..", wantErr: true},
+		{name: "value in YAML", path: yamlFile, secret: "short", wantValue: "short-value"},
+		{name: "latest version in YAML", path: yamlFile, secret: "full", wantValue: "new", wantVersion: "v2"},
+		{name: "version in YAML", path: yamlFile, secret: "full", version: "v1", wantValue: "old", wantVersion: "v1"},
+		{name: "missing version in YAML", path: yamlFile, secret: "full", version: "v3", notFound: true},
+		{name: "missing in YAML", path: yamlFile, secret: "missing", notFound: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewClient(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := client.GetSecret(context.Background(), tt.secret, tt.version)
+			if tt.notFound {
+				if !secretstore.IsNotFound(err) {
+					t.Errorf("GetSecret() error = %v, want NotFoundError", err)
+				}
+				return
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Value != tt.wantValue || got.Version != tt.wantVersion {
+				t.Errorf("GetSecret() = %q@%q, want %q@%q", got.Value, got.Version, tt.wantValue, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestEnvClient_GetSecret(t *testing.T) {
+	env := map[string]string{
+		"SECRET_DB_PASSWORD":     "new",
+		"SECRET_DB_PASSWORD__V1": "old",
+	}
+	client := NewEnvClient("SECRET_")
+	client.lookupEnv = func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+
+	if got, err := client.GetSecretValue(context.Background(), "db-password"); err != nil || got != "new" {
+		t.Errorf("GetSecretValue() = %q, %v, want %q", got, err, "new")
+	}
+	if got, err := client.GetSecretValueForVersion(context.Background(), "db-password", "v1"); err != nil || got != "old" {
+		t.Errorf("GetSecretValueForVersion() = %q, %v, want %q", got, err, "old")
+	}
+	if _, err := client.GetSecretValue(context.Background(), "missing"); !secretstore.IsNotFound(err) {
+		t.Errorf("GetSecretValue() error = %v, want NotFoundError", err)
+	}
+}
+
+func writeFile(t *testing.T, path, content string, modTime time.Time) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,253 @@
+// Package gcp implements a secret store for Google Cloud Secret Manager.
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+const defaultEndpoint = "https://secretmanager.googleapis.com/"
+
+var (
+	// validSecretID matches the IDs Secret Manager allows, which keeps
+	// requests inside the secrets collection of a project
+	validSecretID = regexp.MustCompile(`^[0-9a-zA-Z_-]{1,255}$`)
+	validProject  = regexp.MustCompile(`^[0-9a-z][0-9a-z.:-]*$`)
+	// validVersion matches version numbers, latest and aliases
+	validVersion = regexp.MustCompile(`^[0-9a-zA-Z_-]+$`)
+
+	crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// Config configures a Client
+type Config struct {
+	// Project is used for secrets that are not given with their resource
+	// name projects/<project>/secrets/<secret>
+	Project string
+	// Endpoint replaces the public endpoint of Secret Manager
+	Endpoint    string
+	TokenSource oauth2.TokenSource
+}
+
+// Client is a secretstore.Client for Google Cloud Secret Manager. A secret is
+// either given by its ID in the project of the Client or by its resource
+// name projects/<project>/secrets/<secret>. A version is the number of a
+// version, an alias of the secret or latest.
+type Client struct {
+	endpoint   string
+	project    string
+	httpClient *http.Client
+}
+
+// APIError is an error response of Secret Manager
+type APIError struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s (status %d): %s", e.Status, e.StatusCode, e.Message)
+}
+
+// Transient reports whether the request was throttled or failed with an
+// internal error of Secret Manager
+func (e *APIError) Transient() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+type accessSecretVersionResponse struct {
+	Name    string `json:"name"`
+	Payload struct {
+		Data       []byte `json:"data"`
+		DataCrc32c string `json:"dataCrc32c"`
+	} `json:"payload"`
+}
+
+type secretVersion struct {
+	Name       string     `json:"name"`
+	CreateTime *time.Time `json:"createTime"`
+	State      string     `json:"state"`
+}
+
+type listSecretVersionsResponse struct {
+	Versions      []secretVersion `json:"versions"`
+	NextPageToken string          `json:"nextPageToken"`
+}
+
+// NewClient returns a Client for config
+func NewClient(config Config) (*Client, error) {
+	if config.TokenSource == nil {
+		return nil, fmt.Errorf("no Google credentials set")
+	}
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = defaultEndpoint
+	}
+	return &Client{
+		endpoint: strings.TrimSuffix(endpoint, "/") + "/",
+		project:  config.Project,
+		httpClient: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &oauth2.Transport{Source: config.TokenSource},
+		},
+	}, nil
+}
+
+func (c *Client) GetSecretValue(ctx context.Context, name string) (string, error) {
+	return c.GetSecretValueForVersion(ctx, name, "")
+}
+
+func (c *Client) GetSecretValueForVersion(ctx context.Context, name, version string) (string, error) {
+	secret, err := c.GetSecret(ctx, name, version)
+	if err != nil {
+		return "", err
+	}
+	return secret.Value, nil
+}
+
+func (c *Client) GetSecret(ctx context.Context, name, version string) (*secretstore.Secret, error) {
+	resource, err := c.resourceName(name)
+	if err != nil {
+		return nil, err
+	}
+	if version == "" {
+		version = "latest"
+	}
+	if !validVersion.MatchString(version) {
+		return nil, fmt.Errorf("invalid version %q of secret %q", version, name)
+	}
+
+	var response accessSecretVersionResponse
+	err = c.get(ctx, resource+"/versions/"+version+":access", nil, &response)
+	if isNotFound(err) {
+		return nil, &secretstore.NotFoundError{Name: name, Version: version}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if response.Payload.DataCrc32c != "" {
+		checksum, err := strconv.ParseUint(response.Payload.DataCrc32c, 10, 32)
+		if err != nil || uint32(checksum) != crc32.Checksum(response.Payload.Data, crc32cTable) {
+			return nil, fmt.Errorf("checksum of secret %q version %q does not match", name, version)
+		}
+	}
+	return &secretstore.Secret{
+		Name:    name,
+		Value:   string(response.Payload.Data),
+		Version: path.Base(response.Name),
+		Tags:    make(map[string]string),
+	}, nil
+}
+
+// ListVersions returns the metadata of the versions of a secret that have
+// not been destroyed, newest first
+func (c *Client) ListVersions(ctx context.Context, name string) ([]*secretstore.Secret, error) {
+	resource, err := c.resourceName(name)
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{}
+	var versions []*secretstore.Secret
+	for {
+		var response listSecretVersionsResponse
+		err := c.get(ctx, resource+"/versions", query, &response)
+		if isNotFound(err) {
+			return nil, &secretstore.NotFoundError{Name: name}
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, version := range response.Versions {
+			if version.State == "DESTROYED" {
+				continue
+			}
+			versions = append(versions, &secretstore.Secret{
+				Name:     name,
+				Version:  path.Base(version.Name),
+				Tags:     make(map[string]string),
+				Created:  version.CreateTime,
+				Disabled: version.State == "DISABLED",
+			})
+		}
+		if response.NextPageToken == "" {
+			break
+		}
+		query.Set("pageToken", response.NextPageToken)
+	}
+	secretstore.SortVersions(versions)
+	return versions, nil
+}
+
+// resourceName returns the resource name projects/<project>/secrets/<secret>
+// of name
+func (c *Client) resourceName(name string) (string, error) {
+	project, secretID := c.project, name
+	if strings.HasPrefix(name, "projects/") {
+		parts := strings.Split(name, "/")
+		if len(parts) != 4 || parts[2] != "secrets" {
+			return "", fmt.Errorf("invalid secret name %q, expected projects/<project>/secrets/<secret>", name)
+		}
+		project, secretID = parts[1], parts[3]
+	}
+	if project == "" {
+		return "", fmt.Errorf("no project for secret %q", name)
+	}
+	if !validProject.MatchString(project) || !validSecretID.MatchString(secretID) {
+		return "", fmt.Errorf("invalid secret name %q", name)
+	}
+	return "projects/" + project + "/secrets/" + secretID, nil
+}
+
+// get calls a method of the REST API and decodes the response into result
+func (c *Client) get(ctx context.Context, resource string, query url.Values, result interface{}) error {
+	endpoint := c.endpoint + "v1/" + resource
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var response struct {
+			Error struct {
+				Message string `json:"message"`
+				Status  string `json:"status"`
+			} `json:"error"`
+		}
+		json.Unmarshal(body, &response)
+		return &APIError{StatusCode: resp.StatusCode, Status: response.Error.Status, Message: response.Error.Message}
+	}
+	return json.Unmarshal(body, result)
+}
+
+// isNotFound reports whether err is a response of Secret Manager with status
+// 404
+func isNotFound(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
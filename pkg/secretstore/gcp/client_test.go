@@ -0,0 +1,225 @@
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+// testSecretManager is a fake of the REST API of Secret Manager. versions
+// maps the resource names of versions to their values, aliases maps the
+// resource names of aliases to the resource names of the versions.
+type testSecretManager struct {
+	versions map[string]string
+	aliases  map[string]string
+	list     map[string][]listSecretVersionsResponse
+}
+
+func (s *testSecretManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer test-token" {
+		writeError(w, http.StatusUnauthorized, "UNAUTHENTICATED")
+		return
+	}
+	resource := strings.TrimPrefix(r.URL.Path, "/v1/")
+	if strings.HasSuffix(resource, ":access") {
+		resource = strings.TrimSuffix(resource, ":access")
+		if alias, ok := s.aliases[resource]; ok {
+			resource = alias
+		}
+		value, ok := s.versions[resource]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NOT_FOUND")
+			return
+		}
+		response := accessSecretVersionResponse{Name: resource}
+		response.Payload.Data = []byte(value)
+		response.Payload.DataCrc32c = strconv.FormatUint(uint64(crc32.Checksum([]byte(value), crc32cTable)), 10)
+		if value == "corrupted" {
+			response.Payload.DataCrc32c = "1"
+		}
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	pages, ok := s.list[strings.TrimSuffix(resource, "/versions")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NOT_FOUND")
+		return
+	}
+	page := 0
+	if token := r.URL.Query().Get("pageToken"); token != "" {
+		page, _ = strconv.Atoi(token)
+	}
+	json.NewEncoder(w).Encode(pages[page])
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"error":{"code":%d,"message":"test error","status":%q}}`, status, code)
+}
+
+func newTestClient(t *testing.T, secretManager *testSecretManager) (*Client, func()) {
+	server := httptest.NewServer(secretManager)
+	client, err := NewClient(Config{
+		Project:     "my-project",
+		Endpoint:    server.URL,
+		TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "test-token"}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server.Close
+}
+
+func TestClient_GetSecret(t *testing.T) {
+	client, closeServer := newTestClient(t, &testSecretManager{
+		versions: map[string]string{
+			"projects/my-project/secrets/db-password/versions/1":  "old",
+			"projects/my-project/secrets/db-password/versions/2":  "new",
+			"projects/shared-project/secrets/registry/versions/7": "shared",
+			"projects/my-project/secrets/corrupted/versions/1":    "corrupted",
+		},
+		aliases: map[string]string{
+			"projects/my-project/secrets/db-password/versions/latest":  "projects/my-project/secrets/db-password/versions/2",
+			"projects/my-project/secrets/db-password/versions/stable":  "projects/my-project/secrets/db-password/versions/1",
+			"projects/shared-project/secrets/registry/versions/latest": "projects/shared-project/secrets/registry/versions/7",
+			"projects/my-project/secrets/corrupted/versions/latest":    "projects/my-project/secrets/corrupted/versions/1",
+		},
+	})
+	defer closeServer()
+
+	tests := []struct {
+		name        string
+		secret      string
+		version     string
+		wantValue   string
+		wantVersion string
+		wantErr     bool
+		notFound    bool
+	}{
+		{
+			name:        "latest",
+			secret:      "db-password",
+			wantValue:   "new",
+			wantVersion: "2",
+		},
+		{
+			name:        "version number",
+			secret:      "db-password",
+			version:     "1",
+			wantValue:   "old",
+			wantVersion: "1",
+		},
+		{
+			name:        "alias",
+			secret:      "db-password",
+			version:     "stable",
+			wantValue:   "old",
+			wantVersion: "1",
+		},
+		{
+			name:        "resource name",
+			secret:      "projects/shared-project/secrets/registry",
+			wantValue:   "shared",
+			wantVersion: "7",
+		},
+		{
+			name:     "missing version",
+			secret:   "db-password",
+			version:  "5",
+			wantErr:  true,
+			notFound: true,
+		},
+		{
+			name:     "missing secret",
+			secret:   "other",
+			wantErr:  true,
+			notFound: true,
+		},
+		{
+			name:    "invalid resource name",
+			secret:  "projects/my-project/db-password",
+			wantErr: true,
+		},
+		{
+			name:    "invalid secret ID",
+			secret:  "../db-password",
+			wantErr: true,
+		},
+		{
+			name:    "invalid version",
+			secret:  "db-password",
+			version: "1/../2",
+			wantErr: true,
+		},
+		{
+			name:    "checksum mismatch",
+			secret:  "corrupted",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := client.GetSecret(context.Background(), tt.secret, tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if secretstore.IsNotFound(err) != tt.notFound {
+				t.Errorf("GetSecret() error = %v, notFound %v", err, tt.notFound)
+			}
+			if err != nil {
+				return
+			}
+			if got.Name != tt.secret || got.Value != tt.wantValue || got.Version != tt.wantVersion {
+				t.Errorf("GetSecret() = %s %q %s, want %s %q %s", got.Name, got.Value, got.Version, tt.secret, tt.wantValue, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestClient_ListVersions(t *testing.T) {
+	var pages []listSecretVersionsResponse
+	err := json.Unmarshal([]byte(`[
+		{"versions": [
+			{"name": "projects/my-project/secrets/db-password/versions/3", "createTime": "2019-03-01T12:00:00.123456Z", "state": "DISABLED"},
+			{"name": "projects/my-project/secrets/db-password/versions/1", "createTime": "2019-01-01T12:00:00Z", "state": "ENABLED"}
+		], "nextPageToken": "1"},
+		{"versions": [
+			{"name": "projects/my-project/secrets/db-password/versions/2", "createTime": "2019-02-01T12:00:00Z", "state": "ENABLED"},
+			{"name": "projects/my-project/secrets/db-password/versions/0", "createTime": "2018-12-01T12:00:00Z", "state": "DESTROYED"}
+		]}
+	]`), &pages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, closeServer := newTestClient(t, &testSecretManager{
+		list: map[string][]listSecretVersionsResponse{"projects/my-project/secrets/db-password": pages},
+	})
+	defer closeServer()
+
+	versions, err := client.ListVersions(context.Background(), "db-password")
+	if err != nil {
+		t.Fatalf("ListVersions() error = %v", err)
+	}
+	var got []string
+	for _, version := range versions {
+		got = append(got, fmt.Sprintf("%s:%v", version.Version, version.Disabled))
+	}
+	if want := "3:true 2:false 1:false"; strings.Join(got, " ") != want {
+		t.Errorf("ListVersions() = %v, want %s", got, want)
+	}
+
+	if _, err := client.ListVersions(context.Background(), "other"); !secretstore.IsNotFound(err) {
+		t.Errorf("ListVersions() error = %v, want NotFoundError", err)
+	}
+}
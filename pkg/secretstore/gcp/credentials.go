@@ -0,0 +1,142 @@
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/jwt"
+)
+
+const (
+	cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+	defaultTokenURL    = "https://oauth2.googleapis.com/token"
+	// defaultMetadataHost is the metadata server of GCE and GKE, which
+	// returns the tokens of the Google service account that the Kubernetes
+	// ServiceAccount is bound to with workload identity
+	defaultMetadataHost = "metadata.google.internal"
+)
+
+// serviceAccountKey holds the fields of a JSON key of a Google service
+// account that are needed to request tokens
+type serviceAccountKey struct {
+	Type         string `json:"type"`
+	ProjectID    string `json:"project_id"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	ClientEmail  string `json:"client_email"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// TokenSourceFromJSON returns a TokenSource for the service account of a
+// JSON key together with the project of the service account
+func TokenSourceFromJSON(ctx context.Context, data []byte) (oauth2.TokenSource, string, error) {
+	var key serviceAccountKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, "", fmt.Errorf("invalid service account key: %v", err)
+	}
+	if key.Type != "service_account" || key.ClientEmail == "" || key.PrivateKey == "" {
+		return nil, "", fmt.Errorf("invalid service account key: type %q, client_email and private_key are required", key.Type)
+	}
+	tokenURL := key.TokenURI
+	if tokenURL == "" {
+		tokenURL = defaultTokenURL
+	}
+	config := &jwt.Config{
+		Email:        key.ClientEmail,
+		PrivateKey:   []byte(key.PrivateKey),
+		PrivateKeyID: key.PrivateKeyID,
+		Scopes:       []string{cloudPlatformScope},
+		TokenURL:     tokenURL,
+	}
+	return config.TokenSource(ctx), key.ProjectID, nil
+}
+
+// metadataTokenSource requests tokens of the default service account from
+// the metadata server
+type metadataTokenSource struct {
+	host       string
+	httpClient *http.Client
+}
+
+// NewMetadataTokenSource returns a TokenSource that requests tokens from the
+// metadata server at host. The tokens are reused until they expire.
+func NewMetadataTokenSource(host string) oauth2.TokenSource {
+	return oauth2.ReuseTokenSource(nil, metadataTokenSource{
+		host:       host,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	})
+}
+
+func (s metadataTokenSource) Token() (*oauth2.Token, error) {
+	body, err := getMetadata(s.httpClient, s.host, "instance/service-accounts/default/token")
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+		TokenType   string `json:"token_type"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil || result.AccessToken == "" {
+		return nil, fmt.Errorf("invalid token response of the metadata server: %v", err)
+	}
+	return &oauth2.Token{
+		AccessToken: result.AccessToken,
+		TokenType:   result.TokenType,
+		Expiry:      time.Now().Add(time.Duration(result.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// getMetadata reads a value from the metadata server
+func getMetadata(httpClient *http.Client, host, path string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, "http://"+host+"/computeMetadata/v1/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metadata server returned status %d for %s", resp.StatusCode, path)
+	}
+	return body, nil
+}
+
+// NewTokenSourceFromEnv returns the credentials configured in the
+// environment together with their project. The JSON key of a service account
+// is read from GOOGLE_APPLICATION_CREDENTIALS. Otherwise the tokens of the
+// workload identity are requested from the metadata server, which may be
+// replaced with GCE_METADATA_HOST.
+func NewTokenSourceFromEnv(ctx context.Context) (oauth2.TokenSource, string, error) {
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, "", err
+		}
+		return TokenSourceFromJSON(ctx, data)
+	}
+
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = defaultMetadataHost
+	}
+	project, err := getMetadata(&http.Client{Timeout: 30 * time.Second}, host, "project/project-id")
+	if err != nil {
+		return nil, "", fmt.Errorf("no Google credentials, set GOOGLE_APPLICATION_CREDENTIALS or run with workload identity: %v", err)
+	}
+	return NewMetadataTokenSource(host), strings.TrimSpace(string(project)), nil
+}
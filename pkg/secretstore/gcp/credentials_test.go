@@ -0,0 +1,86 @@
+package gcp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTokenSourceFromJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		assertion := r.Form.Get("assertion")
+		if r.Form.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || strings.Count(assertion, ".") != 2 {
+			t.Errorf("token request = %v, want JWT bearer grant", r.Form)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"sa-token","token_type":"Bearer","expires_in":3600}`)
+	}))
+	defer server.Close()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := json.Marshal(serviceAccountKey{
+		Type:        "service_account",
+		ProjectID:   "my-project",
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})),
+		ClientEmail: "reader@my-project.iam.gserviceaccount.com",
+		TokenURI:    server.URL,
+	})
+
+	tokenSource, project, err := TokenSourceFromJSON(context.Background(), key)
+	if err != nil {
+		t.Fatalf("TokenSourceFromJSON() error = %v", err)
+	}
+	if project != "my-project" {
+		t.Errorf("TokenSourceFromJSON() project = %s, want my-project", project)
+	}
+	token, err := tokenSource.Token()
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if token.AccessToken != "sa-token" {
+		t.Errorf("Token() = %s, want sa-token", token.AccessToken)
+	}
+
+	if _, _, err := TokenSourceFromJSON(context.Background(), []byte(`{"type":"authorized_user"}`)); err == nil {
+		t.Errorf("TokenSourceFromJSON() accepted a key that is not of a service account")
+	}
+}
+
+func TestMetadataTokenSource(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Metadata-Flavor") != "Google" || r.URL.Path != "/computeMetadata/v1/instance/service-accounts/default/token" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"access_token":"workload-token","token_type":"Bearer","expires_in":3600}`)
+	}))
+	defer server.Close()
+
+	tokenSource := NewMetadataTokenSource(strings.TrimPrefix(server.URL, "http://"))
+	for i := 0; i < 2; i++ {
+		token, err := tokenSource.Token()
+		if err != nil {
+			t.Fatalf("Token() error = %v", err)
+		}
+		if token.AccessToken != "workload-token" {
+			t.Errorf("Token() = %s, want workload-token", token.AccessToken)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("Token() requested %d tokens, want the token to be reused", requests)
+	}
+}
@@ -23,8 +23,9 @@ const (
 
 // GetKeyvaultAuthorizer gets an OAuthTokenAuthorizer for use with Key Vault
 // keys and secrets. Note that Key Vault *Vaults* are managed by Azure Resource
-// Manager.
-func GetKeyvaultAuthorizer(tenantID, clientID, clientSecret string) (autorest.Authorizer, error) {
+// Manager. Tokens are requested with sender, or with a default HTTP client if
+// sender is nil.
+func GetKeyvaultAuthorizer(tenantID, clientID, clientSecret string, sender adal.Sender) (autorest.Authorizer, error) {
 	if keyvaultAuthorizer != nil {
 		return keyvaultAuthorizer, nil
 	}
@@ -51,6 +52,9 @@ func GetKeyvaultAuthorizer(tenantID, clientID, clientSecret string) (autorest.Au
 	if err != nil {
 		return a, err
 	}
+	if sender != nil {
+		token.SetSender(sender)
+	}
 
 	a = autorest.NewBearerAuthorizer(token)
 
@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+const (
+	// FederatedTokenAudience is the audience Azure AD expects in tokens that
+	// are exchanged through a federated credential
+	FederatedTokenAudience = "api://AzureADTokenExchange"
+
+	clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	vaultScope          = vaultEndpoint + "/.default"
+
+	// tokenRefreshMargin is how long before it expires an access token is
+	// replaced
+	tokenRefreshMargin = 5 * time.Minute
+)
+
+// AssertionFunc returns a signed token of an identity that a federated
+// credential of the Azure AD application trusts, e.g. a ServiceAccount token
+type AssertionFunc func(ctx context.Context) (string, error)
+
+type federatedToken struct {
+	accessToken string
+	expires     time.Time
+}
+
+// FederatedTokenCache exchanges assertions for Key Vault access tokens and
+// keeps the tokens until shortly before they expire
+type FederatedTokenCache struct {
+	authorityHost string
+	httpClient    *http.Client
+	now           func() time.Time
+
+	mu     sync.Mutex
+	tokens map[string]federatedToken
+}
+
+// NewFederatedTokenCache returns a FederatedTokenCache that requests tokens
+// from the Azure AD instance at authorityHost with httpClient. An empty
+// authorityHost uses the Azure public cloud, a nil httpClient a client with a
+// timeout of 30 seconds.
+func NewFederatedTokenCache(authorityHost string, httpClient *http.Client) *FederatedTokenCache {
+	if authorityHost == "" {
+		authorityHost = activeDirectoryEndpoint
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &FederatedTokenCache{
+		authorityHost: strings.TrimSuffix(authorityHost, "/") + "/",
+		httpClient:    httpClient,
+		now:           time.Now,
+		tokens:        make(map[string]federatedToken),
+	}
+}
+
+// Authorizer returns an autorest.Authorizer that authenticates requests to
+// Key Vault as the application clientID in tenantID, using assertion to
+// prove the identity. key identifies the identity in the cache.
+func (c *FederatedTokenCache) Authorizer(key, tenantID, clientID string, assertion AssertionFunc) autorest.Authorizer {
+	return federatedAuthorizer{
+		cache:     c,
+		key:       strings.Join([]string{key, tenantID, clientID}, "/"),
+		tenantID:  tenantID,
+		clientID:  clientID,
+		assertion: assertion,
+	}
+}
+
+// token returns the cached access token for key or requests a new one
+func (c *FederatedTokenCache) token(ctx context.Context, key, tenantID, clientID string, assertion AssertionFunc) (string, error) {
+	c.mu.Lock()
+	token, ok := c.tokens[key]
+	c.mu.Unlock()
+	if ok && c.now().Add(tokenRefreshMargin).Before(token.expires) {
+		return token.accessToken, nil
+	}
+
+	signed, err := assertion(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get assertion for %s: %v", key, err)
+	}
+	token, err = c.exchange(ctx, tenantID, clientID, signed)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	// drop the tokens of identities that are no longer used
+	for cachedKey, cached := range c.tokens {
+		if !c.now().Before(cached.expires) {
+			delete(c.tokens, cachedKey)
+		}
+	}
+	c.tokens[key] = token
+	c.mu.Unlock()
+	return token.accessToken, nil
+}
+
+// exchange requests an access token for Key Vault with the client
+// credentials flow, using the signed assertion instead of a client secret
+func (c *FederatedTokenCache) exchange(ctx context.Context, tenantID, clientID, assertion string) (federatedToken, error) {
+	form := url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_id":             {clientID},
+		"scope":                 {vaultScope},
+		"client_assertion_type": {clientAssertionType},
+		"client_assertion":      {assertion},
+	}
+	endpoint := c.authorityHost + url.PathEscape(tenantID) + "/oauth2/v2.0/token"
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return federatedToken{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	requested := c.now()
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return federatedToken{}, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return federatedToken{}, err
+	}
+
+	var result struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil && resp.StatusCode == http.StatusOK {
+		return federatedToken{}, fmt.Errorf("invalid token response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || result.AccessToken == "" {
+		return federatedToken{}, fmt.Errorf("token exchange for client %s failed with status %d: %s %s", clientID, resp.StatusCode, result.Error, result.ErrorDescription)
+	}
+	return federatedToken{
+		accessToken: result.AccessToken,
+		expires:     requested.Add(time.Duration(result.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// federatedAuthorizer adds an access token from a FederatedTokenCache to
+// every request
+type federatedAuthorizer struct {
+	cache     *FederatedTokenCache
+	key       string
+	tenantID  string
+	clientID  string
+	assertion AssertionFunc
+}
+
+func (a federatedAuthorizer) WithAuthorization() autorest.PrepareDecorator {
+	return func(p autorest.Preparer) autorest.Preparer {
+		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err != nil {
+				return r, err
+			}
+			token, err := a.cache.token(r.Context(), a.key, a.tenantID, a.clientID, a.assertion)
+			if err != nil {
+				return r, err
+			}
+			return autorest.Prepare(r, autorest.WithBearerAuthorization(token))
+		})
+	}
+}
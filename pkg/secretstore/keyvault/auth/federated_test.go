@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+func TestFederatedTokenCache(t *testing.T) {
+	var exchanges int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/tenant/oauth2/v2.0/token" {
+			t.Errorf("token request path = %s, want /tenant/oauth2/v2.0/token", r.URL.Path)
+		}
+		r.ParseForm()
+		if r.PostForm.Get("client_assertion") != "sa-token" || r.PostForm.Get("client_id") != "client" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": "invalid_client", "error_description": "AADSTS70021"}`)
+			return
+		}
+		exchanges++
+		fmt.Fprintf(w, `{"access_token": "token-%d", "expires_in": 3600}`, exchanges)
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	cache := NewFederatedTokenCache(server.URL, nil)
+	cache.now = func() time.Time { return now }
+	var assertions int
+	assertion := func(ctx context.Context) (string, error) {
+		assertions++
+		return "sa-token", nil
+	}
+	authorize := func(authorizer autorest.Authorizer) string {
+		req, err := autorest.Prepare(httptest.NewRequest(http.MethodGet, "https://vault.vault.azure.net/secrets/a", nil), authorizer.WithAuthorization())
+		if err != nil {
+			t.Fatalf("WithAuthorization() error = %v", err)
+		}
+		return req.Header.Get("Authorization")
+	}
+
+	authorizer := cache.Authorizer("ns/sa", "tenant", "client", assertion)
+	if got := authorize(authorizer); got != "Bearer token-1" {
+		t.Errorf("Authorization = %q, want %q", got, "Bearer token-1")
+	}
+	if got := authorize(authorizer); got != "Bearer token-1" {
+		t.Errorf("Authorization = %q, want cached %q", got, "Bearer token-1")
+	}
+	if assertions != 1 {
+		t.Errorf("assertion requested %d times, want 1", assertions)
+	}
+
+	now = now.Add(time.Hour - time.Minute)
+	if got := authorize(authorizer); got != "Bearer token-2" {
+		t.Errorf("Authorization = %q, want refreshed %q", got, "Bearer token-2")
+	}
+
+	denied := cache.Authorizer("ns/other", "tenant", "other-client", assertion)
+	if _, err := autorest.Prepare(httptest.NewRequest(http.MethodGet, "https://vault.vault.azure.net/secrets/a", nil), denied.WithAuthorization()); err == nil {
+		t.Errorf("WithAuthorization() succeeded for a client without federated credential")
+	}
+}
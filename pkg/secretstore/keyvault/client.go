@@ -5,11 +5,20 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"strings"
+	"time"
 
+	"github.com/twendt/secret-controller/pkg/secretstore"
 	"github.com/twendt/secret-controller/pkg/secretstore/keyvault/auth"
 
 	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure"
 )
 
 const (
@@ -56,67 +65,273 @@ type AzureJSON struct {
 	ProviderKeyVersion           string  `json:"providerKeyVersion"`
 }
 
-func NewVaultClient(name string) (Client, error) {
+// NewVaultClient returns a client for the vault name that authenticates with
+// the controller's own credentials. Requests are sent with httpClient, or
+// with the default HTTP client if httpClient is nil.
+func NewVaultClient(name string, httpClient *http.Client) (Client, error) {
 	if name == "" {
 		return Client{}, fmt.Errorf("No Vault Name set")
 	}
+	return NewVaultClientForURL(VaultURL(name), nil, httpClient)
+}
 
-	client := Client{
-		url: "https://" + name + ".vault.azure.net/",
-	}
+// VaultURL returns the URL of the vault name in the Azure public cloud
+func VaultURL(name string) string {
+	return "https://" + name + ".vault.azure.net/"
+}
 
+// NewAuthorizer returns the authorizer of the controller's own credentials:
+// the service principal in the KEYVAULT_* environment variables or the one
+// in azure.json. Tokens are requested with httpClient, or with a default
+// HTTP client if httpClient is nil.
+func NewAuthorizer(httpClient *http.Client) (autorest.Authorizer, error) {
 	tenantID := os.Getenv("KEYVAULT_TENANT_ID")
 	clientID := os.Getenv("KEYVAULT_CLIENT_ID")
 	clientSecret := os.Getenv("KEYVAULT_CLIENT_SECRET")
-
-	var keyvaultClient *keyvault.BaseClient
 	if tenantID != "" && clientID != "" && clientSecret != "" {
-		var err error
-		keyvaultClient, err = getVaultClient(tenantID, clientID, clientSecret)
-		if err != nil {
-			return client, err
-		}
-	} else {
-		var err error
-		keyvaultClient, err = getVaultClientFromAzureJSON()
-		if err != nil {
-			return client, err
+		return auth.GetKeyvaultAuthorizer(tenantID, clientID, clientSecret, sender(httpClient))
+	}
+	return getAuthorizerFromAzureJSON(httpClient)
+}
+
+// NewWorkloadIdentityAuthorizer returns an authorizer for the Azure workload
+// identity of the pod, which exchanges the token of its ServiceAccount in
+// AZURE_FEDERATED_TOKEN_FILE for a token of the application AZURE_CLIENT_ID.
+// It is only used when asked for explicitly, so that a webhook that adds
+// these variables to every pod cannot replace the credentials of the
+// controller.
+func NewWorkloadIdentityAuthorizer(httpClient *http.Client) (autorest.Authorizer, error) {
+	federatedTokenFile := os.Getenv("AZURE_FEDERATED_TOKEN_FILE")
+	tenantID := os.Getenv("AZURE_TENANT_ID")
+	clientID := os.Getenv("AZURE_CLIENT_ID")
+	if federatedTokenFile == "" || tenantID == "" || clientID == "" {
+		return nil, fmt.Errorf("Azure workload identity requires AZURE_FEDERATED_TOKEN_FILE, AZURE_TENANT_ID and AZURE_CLIENT_ID")
+	}
+	tokens := auth.NewFederatedTokenCache(os.Getenv("AZURE_AUTHORITY_HOST"), httpClient)
+	return tokens.Authorizer(federatedTokenFile, tenantID, clientID, func(ctx context.Context) (string, error) {
+		token, err := ioutil.ReadFile(federatedTokenFile)
+		return strings.TrimSpace(string(token)), err
+	}), nil
+}
+
+// sender returns httpClient as adal.Sender, nil if httpClient is nil
+func sender(httpClient *http.Client) adal.Sender {
+	if httpClient == nil {
+		return nil
+	}
+	return httpClient
+}
+
+// NewVaultClientWithAuthorizer returns a client for the vault name that
+// authenticates with authorizer instead of the controller's own credentials
+func NewVaultClientWithAuthorizer(name string, authorizer autorest.Authorizer, httpClient *http.Client) (Client, error) {
+	if name == "" {
+		return Client{}, fmt.Errorf("No Vault Name set")
+	}
+	return NewVaultClientForURL(VaultURL(name), authorizer, httpClient)
+}
+
+// NewVaultClientForURL returns a client for the vault at vaultURL, e.g. the
+// vault of a Key Vault reference, a private endpoint or a local emulator,
+// that authenticates with authorizer, or with the controller's own
+// credentials if authorizer is nil. Requests are sent with httpClient, or
+// with the default HTTP client if httpClient is nil.
+func NewVaultClientForURL(vaultURL string, authorizer autorest.Authorizer, httpClient *http.Client) (Client, error) {
+	parsed, err := url.Parse(vaultURL)
+	if err != nil || parsed.Host == "" {
+		return Client{}, fmt.Errorf("invalid vault URL %q", vaultURL)
+	}
+	if authorizer == nil {
+		if authorizer, err = NewAuthorizer(httpClient); err != nil {
+			return Client{}, err
 		}
 	}
-	client.keyvaultClient = keyvaultClient
-	return client, nil
+	vaultClient := keyvault.New()
+	vaultClient.Authorizer = authorizer
+	if httpClient != nil {
+		vaultClient.Sender = httpClient
+	}
+	if err := vaultClient.AddToUserAgent(userAgent); err != nil {
+		return Client{}, err
+	}
+	return Client{
+		keyvaultClient: &vaultClient,
+		url:            strings.TrimSuffix(vaultURL, "/") + "/",
+	}, nil
 }
 
-func (c Client) GetSecretValue(name string) (string, error) {
-	return c.GetSecretValueForVersion(name, "")
+func (c Client) GetSecretValue(ctx context.Context, name string) (string, error) {
+	return c.GetSecretValueForVersion(ctx, name, "")
 }
 
-func (c Client) GetSecretValueForVersion(name, version string) (string, error) {
-	ctx := context.Background()
-	secret, err := c.keyvaultClient.GetSecret(ctx, c.url, name, version)
+func (c Client) GetSecretValueForVersion(ctx context.Context, name, version string) (string, error) {
+	secret, err := c.GetSecret(ctx, name, version)
 	if err != nil {
 		return "", err
 	}
 
-	return *secret.Value, nil
+	return secret.Value, nil
 }
 
-func getVaultClient(tenantID, clientID, clientSecret string) (*keyvault.BaseClient, error) {
-	vaultClient := keyvault.New()
-	a, err := auth.GetKeyvaultAuthorizer(tenantID, clientID, clientSecret)
+func (c Client) GetSecret(ctx context.Context, name, version string) (*secretstore.Secret, error) {
+	bundle, err := c.keyvaultClient.GetSecret(ctx, c.url, name, version)
+	if isNotFound(err) {
+		return nil, &secretstore.NotFoundError{Name: name, Version: version}
+	}
+	if isDisabled(err) {
+		return nil, &secretstore.DisabledError{Name: name, Version: version}
+	}
 	if err != nil {
-		return nil, err
+		return nil, newError(err)
 	}
 
-	vaultClient.Authorizer = a
-	if err = vaultClient.AddToUserAgent(userAgent); err != nil {
-		return nil, err
+	return newSecret(name, bundle), nil
+}
+
+// ListVersions returns the metadata of all versions of a secret, newest
+// first
+func (c Client) ListVersions(ctx context.Context, name string) ([]*secretstore.Secret, error) {
+	page, err := c.keyvaultClient.GetSecretVersions(ctx, c.url, name, nil)
+	if isNotFound(err) {
+		return nil, &secretstore.NotFoundError{Name: name}
 	}
+	var versions []*secretstore.Secret
+	for ; err == nil && page.NotDone(); err = page.NextWithContext(ctx) {
+		for _, item := range page.Values() {
+			versions = append(versions, newSecretMetadata(name, item.ID, item.ContentType, item.Tags, item.Attributes))
+		}
+	}
+	if err != nil {
+		return nil, newError(err)
+	}
+	secretstore.SortVersions(versions)
+	return versions, nil
+}
 
-	return &vaultClient, nil
+func (c Client) SetSecret(ctx context.Context, secret *secretstore.Secret) (*secretstore.Secret, error) {
+	parameters := keyvault.SecretSetParameters{
+		Value: &secret.Value,
+		Tags:  make(map[string]*string),
+	}
+	if secret.ContentType != "" {
+		parameters.ContentType = &secret.ContentType
+	}
+	for key, value := range secret.Tags {
+		value := value
+		parameters.Tags[key] = &value
+	}
+	bundle, err := c.keyvaultClient.SetSecret(ctx, c.url, secret.Name, parameters)
+	if err != nil {
+		return nil, newError(err)
+	}
+
+	return newSecret(secret.Name, bundle), nil
+}
+
+func (c Client) DeleteSecret(ctx context.Context, name string) error {
+	_, err := c.keyvaultClient.DeleteSecret(ctx, c.url, name)
+	if isNotFound(err) {
+		return &secretstore.NotFoundError{Name: name}
+	}
+	if err != nil {
+		return newError(err)
+	}
+	return nil
+}
+
+// Error is a failed request to Key Vault
+type Error struct {
+	// StatusCode is zero if Key Vault did not respond
+	StatusCode int
+	Err        error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
 }
 
-func getVaultClientFromAzureJSON() (*keyvault.BaseClient, error) {
+// Transient reports whether the request failed because Key Vault could not
+// be reached, throttled the request or had an internal error
+func (e *Error) Transient() bool {
+	return e.StatusCode == 0 || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+func newError(err error) *Error {
+	keyvaultErr := &Error{Err: err}
+	if detailedErr, ok := err.(autorest.DetailedError); ok {
+		keyvaultErr.StatusCode, _ = detailedErr.StatusCode.(int)
+	}
+	return keyvaultErr
+}
+
+// isNotFound reports whether err is a response of Key Vault with status 404
+func isNotFound(err error) bool {
+	detailedErr, ok := err.(autorest.DetailedError)
+	return ok && detailedErr.StatusCode == http.StatusNotFound
+}
+
+// isDisabled reports whether err is the response of Key Vault to a request
+// for the value of a disabled secret, which is a 403 like a missing
+// permission but has the inner error code SecretDisabled
+func isDisabled(err error) bool {
+	detailedErr, ok := err.(autorest.DetailedError)
+	if !ok || detailedErr.StatusCode != http.StatusForbidden {
+		return false
+	}
+	requestErr, ok := detailedErr.Original.(*azure.RequestError)
+	if !ok || requestErr.ServiceError == nil {
+		return false
+	}
+	code, _ := requestErr.ServiceError.InnerError["code"].(string)
+	return code == "SecretDisabled"
+}
+
+// newSecret converts a SecretBundle returned by Key Vault
+func newSecret(name string, bundle keyvault.SecretBundle) *secretstore.Secret {
+	secret := newSecretMetadata(name, bundle.ID, bundle.ContentType, bundle.Tags, bundle.Attributes)
+	if bundle.Value != nil {
+		secret.Value = *bundle.Value
+	}
+	return secret
+}
+
+// newSecretMetadata converts the fields shared by SecretBundle and SecretItem
+func newSecretMetadata(name string, id, contentType *string, tags map[string]*string, attributes *keyvault.SecretAttributes) *secretstore.Secret {
+	secret := &secretstore.Secret{
+		Name: name,
+		Tags: make(map[string]string),
+	}
+	if id != nil {
+		// the ID has the form https://<vault>.vault.azure.net/secrets/<name>/<version>
+		secret.Version = path.Base(*id)
+	}
+	if contentType != nil {
+		secret.ContentType = *contentType
+	}
+	for key, value := range tags {
+		if value != nil {
+			secret.Tags[key] = *value
+		}
+	}
+	if attributes != nil {
+		if attributes.Expires != nil {
+			expires := time.Time(*attributes.Expires)
+			secret.Expires = &expires
+		}
+		if attributes.NotBefore != nil {
+			notBefore := time.Time(*attributes.NotBefore)
+			secret.NotBefore = &notBefore
+		}
+		if attributes.Created != nil {
+			created := time.Time(*attributes.Created)
+			secret.Created = &created
+		}
+		secret.Disabled = attributes.Enabled != nil && !*attributes.Enabled
+	}
+	return secret
+}
+
+func getAuthorizerFromAzureJSON(httpClient *http.Client) (autorest.Authorizer, error) {
 	jsonFile, err := os.Open(azureJSONPath)
 	if err != nil {
 		return nil, err
@@ -134,10 +349,5 @@ func getVaultClientFromAzureJSON() (*keyvault.BaseClient, error) {
 		return nil, err
 	}
 
-	vaultClient, err := getVaultClient(azureJSON.TenantID, azureJSON.AadClientID, azureJSON.AadClientSecret)
-	if err != nil {
-		return nil, err
-	}
-
-	return vaultClient, nil
+	return auth.GetKeyvaultAuthorizer(azureJSON.TenantID, azureJSON.AadClientID, azureJSON.AadClientSecret, sender(httpClient))
 }
@@ -0,0 +1,69 @@
+package keyvault
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+func TestClient_GetSecret_errors(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		body         string
+		wantNotFound bool
+		wantDisabled bool
+	}{
+		{
+			name:         "not found",
+			status:       http.StatusNotFound,
+			body:         `{"error":{"code":"SecretNotFound","message":"A secret with (name/id) db-password was not found in this key vault."}}`,
+			wantNotFound: true,
+		},
+		{
+			name:         "disabled",
+			status:       http.StatusForbidden,
+			body:         `{"error":{"code":"Forbidden","message":"Operation get is not allowed on a disabled secret.","innererror":{"code":"SecretDisabled"}}}`,
+			wantDisabled: true,
+		},
+		{
+			name:   "access denied",
+			status: http.StatusForbidden,
+			body:   `{"error":{"code":"Forbidden","message":"The user, group or application does not have secrets get permission on key vault.","innererror":{"code":"AccessDenied"}}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}))
+			defer vault.Close()
+
+			client, err := NewVaultClientForURL(vault.URL, autorest.NullAuthorizer{}, vault.Client())
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = client.GetSecret(context.Background(), "db-password", "v1")
+			if err == nil {
+				t.Fatal("GetSecret() succeeded")
+			}
+			if secretstore.IsNotFound(err) != tt.wantNotFound {
+				t.Errorf("GetSecret() error = %v, want not found %v", err, tt.wantNotFound)
+			}
+			if secretstore.IsDisabled(err) != tt.wantDisabled {
+				t.Errorf("GetSecret() error = %v, want disabled %v", err, tt.wantDisabled)
+			}
+			if secretstore.IsTransient(err) {
+				t.Errorf("GetSecret() error = %v is transient", err)
+			}
+		})
+	}
+}
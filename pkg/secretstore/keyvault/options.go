@@ -0,0 +1,104 @@
+package keyvault
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DefaultTimeout is the timeout of requests to Key Vault and Azure AD if
+// Options.Timeout is zero
+const DefaultTimeout = 30 * time.Second
+
+// tlsVersions are the values of Options.MinTLSVersion
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+}
+
+// Options configures the HTTP connections to Key Vault and Azure AD, e.g. for
+// vaults behind a corporate proxy or a private endpoint with a certificate of
+// an internal CA
+type Options struct {
+	// Proxy is the URL of the HTTP(S) proxy. If empty the proxy is taken
+	// from the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables.
+	Proxy string
+	// CAFile is a PEM file of CA certificates that are trusted in addition
+	// to the ones of the system
+	CAFile string
+	// MinTLSVersion is the lowest TLS version that is accepted, only 1.2 as
+	// Key Vault and Azure AD do not accept older versions
+	MinTLSVersion string
+	// InsecureSkipVerify accepts any certificate, e.g. of a local emulator
+	InsecureSkipVerify bool
+	// Timeout is the timeout of a request including reading the response
+	Timeout time.Duration
+}
+
+// NewHTTPClient returns an HTTP client for the requests of the controller
+// to Key Vault and Azure AD as configured by options
+func NewHTTPClient(options Options) (*http.Client, error) {
+	tlsConfig, err := newTLSConfig(options)
+	if err != nil {
+		return nil, err
+	}
+	proxy := http.ProxyFromEnvironment
+	if options.Proxy != "" {
+		proxyURL, err := url.Parse(options.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", options.Proxy)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	// the same settings as http.DefaultTransport
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
+
+func newTLSConfig(options Options) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: options.InsecureSkipVerify,
+	}
+	if options.MinTLSVersion != "" {
+		version, ok := tlsVersions[options.MinTLSVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported TLS version %q, must be 1.2", options.MinTLSVersion)
+		}
+		config.MinVersion = version
+	}
+	if options.CAFile != "" {
+		pem, err := ioutil.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificates: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no CA certificates found in %s", options.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
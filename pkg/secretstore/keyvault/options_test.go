@@ -0,0 +1,114 @@
+package keyvault
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	// a stand-in for a vault behind a private endpoint with a certificate of
+	// an internal CA
+	vault := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"value":"s3cret","id":"https://%s%s1"}`, r.Host, r.URL.Path)
+	}))
+	// the handshakes with the unknown CA are expected to fail
+	vault.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	vault.StartTLS()
+	defer vault.Close()
+
+	dir, err := ioutil.TempDir("", "keyvault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: vault.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0644); err != nil {
+		t.Fatal(err)
+	}
+	invalidCAFile := filepath.Join(dir, "invalid.pem")
+	if err := ioutil.WriteFile(invalidCAFile, []byte("no certificates"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		options    Options
+		wantErr    bool
+		wantGetErr bool
+	}{
+		{name: "CA file", options: Options{CAFile: caFile}},
+		{name: "insecure", options: Options{InsecureSkipVerify: true}},
+		{name: "unknown CA", options: Options{}, wantGetErr: true},
+		{name: "missing CA file", options: Options{CAFile: filepath.Join(dir, "missing.pem")}, wantErr: true},
+		{name: "no certificates in CA file", options: Options{CAFile: invalidCAFile}, wantErr: true},
+		{name: "TLS version", options: Options{CAFile: caFile, MinTLSVersion: "1.2"}},
+		{name: "deprecated TLS version", options: Options{MinTLSVersion: "1.1"}, wantErr: true},
+		{name: "unsupported TLS version", options: Options{MinTLSVersion: "1.4"}, wantErr: true},
+		{name: "invalid proxy", options: Options{Proxy: "proxy:3128"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpClient, err := NewHTTPClient(tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewHTTPClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			client, err := NewVaultClientForURL(vault.URL, autorest.NullAuthorizer{}, httpClient)
+			if err != nil {
+				t.Fatal(err)
+			}
+			value, err := client.GetSecretValue(context.Background(), "db-password")
+			if (err != nil) != tt.wantGetErr {
+				t.Fatalf("GetSecretValue() error = %v, wantErr %v", err, tt.wantGetErr)
+			}
+			if err == nil && value != "s3cret" {
+				t.Errorf("GetSecretValue() = %q, want s3cret", value)
+			}
+		})
+	}
+}
+
+func TestNewHTTPClient_proxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		fmt.Fprint(w, `{"value":"s3cret","id":"http://myvault.vault.azure.net/secrets/db-password/1"}`)
+	}))
+	defer proxy.Close()
+
+	httpClient, err := NewHTTPClient(Options{Proxy: proxy.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewVaultClientForURL("http://myvault.vault.azure.net/", autorest.NullAuthorizer{}, httpClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetSecretValue(context.Background(), "db-password"); err != nil {
+		t.Fatalf("GetSecretValue() error = %v", err)
+	}
+	if want := "http://myvault.vault.azure.net/secrets/db-password/?api-version=2016-10-01"; proxied != want {
+		t.Errorf("proxy received %q, want %q", proxied, want)
+	}
+}
+
+func TestNewVaultClientForURL(t *testing.T) {
+	for _, vaultURL := range []string{"", "myvault", "://myvault"} {
+		if _, err := NewVaultClientForURL(vaultURL, autorest.NullAuthorizer{}, nil); err == nil {
+			t.Errorf("NewVaultClientForURL(%q) accepted an invalid URL", vaultURL)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Package kubernetes implements a secret store that reads the values of
+// other Kubernetes Secrets and ConfigMaps, so that they can be combined with
+// the secrets of another store.
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	corelisters "k8s.io/client-go/listers/core/v1"
+
+	"github.com/twendt/secret-controller/pkg/policy"
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+const (
+	// Prefix marks the names that refer to a key of a Secret or ConfigMap:
+	// kubernetes://secret/[namespace/]name/key or
+	// kubernetes://configmap/[namespace/]name/key
+	Prefix = "kubernetes://"
+
+	KindSecret    = "secret"
+	KindConfigMap = "configmap"
+)
+
+// Reference is a key of a Secret or ConfigMap
+type Reference struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Key       string
+}
+
+// IsReference reports whether name refers to a Secret or ConfigMap
+func IsReference(name string) bool {
+	return strings.HasPrefix(name, Prefix)
+}
+
+// ParseReference parses name. References without a namespace refer to
+// defaultNamespace.
+func ParseReference(name, defaultNamespace string) (Reference, error) {
+	if !IsReference(name) {
+		return Reference{}, fmt.Errorf("%q is not a reference to a Secret or ConfigMap", name)
+	}
+	parts := strings.Split(strings.TrimPrefix(name, Prefix), "/")
+	var ref Reference
+	switch len(parts) {
+	case 3:
+		ref = Reference{Kind: parts[0], Namespace: defaultNamespace, Name: parts[1], Key: parts[2]}
+	case 4:
+		ref = Reference{Kind: parts[0], Namespace: parts[1], Name: parts[2], Key: parts[3]}
+	default:
+		return Reference{}, fmt.Errorf("invalid reference %q, expected %s<kind>/[namespace/]name/key", name, Prefix)
+	}
+	if ref.Kind != KindSecret && ref.Kind != KindConfigMap {
+		return Reference{}, fmt.Errorf("invalid reference %q, kind must be %s or %s", name, KindSecret, KindConfigMap)
+	}
+	if ref.Namespace == "" || ref.Name == "" || ref.Key == "" {
+		return Reference{}, fmt.Errorf("invalid reference %q, namespace, name and key must not be empty", name)
+	}
+	return ref, nil
+}
+
+// Client is a secretstore.Client that reads references to Secrets and
+// ConfigMaps from the caches of informers and all other names from the
+// wrapped store. References have no versions.
+type Client struct {
+	store      secretstore.Client
+	secrets    corelisters.SecretLister
+	configMaps corelisters.ConfigMapLister
+	namespace  string
+	policy     *policy.Policy
+}
+
+// NewClient returns a Client for the KeyvaultSecrets and PushSecrets in
+// namespace, which may read the Secrets and ConfigMaps of other namespaces
+// if p allows it. configMaps may be nil, which rejects references to
+// ConfigMaps.
+func NewClient(store secretstore.Client, secrets corelisters.SecretLister, configMaps corelisters.ConfigMapLister, namespace string, p *policy.Policy) *Client {
+	return &Client{
+		store:      store,
+		secrets:    secrets,
+		configMaps: configMaps,
+		namespace:  namespace,
+		policy:     p,
+	}
+}
+
+func (c *Client) GetSecretValue(ctx context.Context, name string) (string, error) {
+	return c.GetSecretValueForVersion(ctx, name, "")
+}
+
+func (c *Client) GetSecretValueForVersion(ctx context.Context, name, version string) (string, error) {
+	if !IsReference(name) {
+		return c.store.GetSecretValueForVersion(ctx, name, version)
+	}
+	secret, err := c.GetSecret(ctx, name, version)
+	if err != nil {
+		return "", err
+	}
+	return secret.Value, nil
+}
+
+func (c *Client) GetSecret(ctx context.Context, name, version string) (*secretstore.Secret, error) {
+	if !IsReference(name) {
+		return c.store.GetSecret(ctx, name, version)
+	}
+	if version != "" {
+		return nil, fmt.Errorf("%s has no versions", name)
+	}
+	ref, err := ParseReference(name, c.namespace)
+	if err != nil {
+		return nil, err
+	}
+	if !c.policy.SourceAllowed(c.namespace, ref.Kind, ref.Namespace, ref.Name) {
+		return nil, &policy.AccessDeniedError{Namespace: c.namespace, Name: name}
+	}
+
+	secret := &secretstore.Secret{Name: name, Tags: make(map[string]string)}
+	var found bool
+	switch ref.Kind {
+	case KindSecret:
+		source, err := c.secrets.Secrets(ref.Namespace).Get(ref.Name)
+		if errors.IsNotFound(err) {
+			return nil, &secretstore.NotFoundError{Name: name}
+		}
+		if err != nil {
+			return nil, err
+		}
+		var value []byte
+		value, found = source.Data[ref.Key]
+		secret.Value = string(value)
+		secret.Version = source.ResourceVersion
+		secret.Created = &source.CreationTimestamp.Time
+	case KindConfigMap:
+		if c.configMaps == nil {
+			return nil, fmt.Errorf("references to ConfigMaps are not enabled")
+		}
+		source, err := c.configMaps.ConfigMaps(ref.Namespace).Get(ref.Name)
+		if errors.IsNotFound(err) {
+			return nil, &secretstore.NotFoundError{Name: name}
+		}
+		if err != nil {
+			return nil, err
+		}
+		secret.Value, found = source.Data[ref.Key]
+		if !found {
+			var value []byte
+			value, found = source.BinaryData[ref.Key]
+			secret.Value = string(value)
+		}
+		secret.Version = source.ResourceVersion
+		secret.Created = &source.CreationTimestamp.Time
+	}
+	if !found {
+		return nil, &secretstore.NotFoundError{Name: name}
+	}
+	return secret, nil
+}
+
+// ListVersions lists the versions of secrets of the wrapped store if it is a
+// secretstore.VersionLister
+func (c *Client) ListVersions(ctx context.Context, name string) ([]*secretstore.Secret, error) {
+	if IsReference(name) {
+		return nil, fmt.Errorf("%s has no versions", name)
+	}
+	lister, ok := c.store.(secretstore.VersionLister)
+	if !ok {
+		return nil, fmt.Errorf("secret store does not support listing versions")
+	}
+	return lister.ListVersions(ctx, name)
+}
+
+// SetSecret writes secret to the wrapped store if it is a secretstore.Writer.
+// Secrets and ConfigMaps are never written.
+func (c *Client) SetSecret(ctx context.Context, secret *secretstore.Secret) (*secretstore.Secret, error) {
+	if IsReference(secret.Name) {
+		return nil, fmt.Errorf("%s cannot be written", secret.Name)
+	}
+	writer, ok := c.store.(secretstore.Writer)
+	if !ok {
+		return nil, fmt.Errorf("secret store does not support writing secrets")
+	}
+	return writer.SetSecret(ctx, secret)
+}
+
+// DeleteSecret deletes the secret from the wrapped store if it is a
+// secretstore.Writer
+func (c *Client) DeleteSecret(ctx context.Context, name string) error {
+	if IsReference(name) {
+		return fmt.Errorf("%s cannot be deleted", name)
+	}
+	writer, ok := c.store.(secretstore.Writer)
+	if !ok {
+		return fmt.Errorf("secret store does not support writing secrets")
+	}
+	return writer.DeleteSecret(ctx, name)
+}
@@ -0,0 +1,123 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/twendt/secret-controller/pkg/policy"
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+// testStore returns the name of every secret as its value
+type testStore struct{}
+
+func (testStore) GetSecretValue(ctx context.Context, name string) (string, error) {
+	return name, nil
+}
+
+func (testStore) GetSecretValueForVersion(ctx context.Context, name, version string) (string, error) {
+	return name, nil
+}
+
+func (testStore) GetSecret(ctx context.Context, name, version string) (*secretstore.Secret, error) {
+	return &secretstore.Secret{Name: name, Value: name, Version: version}, nil
+}
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Reference
+		wantErr bool
+	}{
+		{name: "kubernetes://secret/db/password", want: Reference{Kind: KindSecret, Namespace: "team-a", Name: "db", Key: "password"}},
+		{name: "kubernetes://configmap/shared/ca/ca.crt", want: Reference{Kind: KindConfigMap, Namespace: "shared", Name: "ca", Key: "ca.crt"}},
+		{name: "kubernetes://pod/db/password", wantErr: true},
+		{name: "kubernetes://secret/db", wantErr: true},
+		{name: "kubernetes://secret/db/", wantErr: true},
+		{name: "kubernetes://secret/a/b/c/d", wantErr: true},
+		{name: "db-password", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseReference(tt.name, "team-a")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseReference(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseReference(%q) = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestClient_GetSecret(t *testing.T) {
+	secrets := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	secrets.Add(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "team-a", ResourceVersion: "7"},
+		Data:       map[string][]byte{"password": []byte("own")},
+	})
+	secrets.Add(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "postgres-credentials", Namespace: "databases"},
+		Data:       map[string][]byte{"password": []byte("operator-generated")},
+	})
+	secrets.Add(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "admin", Namespace: "databases"},
+		Data:       map[string][]byte{"password": []byte("admin")},
+	})
+	configMaps := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	configMaps.Add(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "team-a"},
+		Data:       map[string]string{"host": "db.example.com"},
+		BinaryData: map[string][]byte{"ca.der": []byte("binary")},
+	})
+	p := &policy.Policy{Rules: []policy.Rule{{
+		Namespaces: []string{"team-*"},
+		Sources:    []string{"secret/databases/*-credentials"},
+	}}}
+
+	tests := []struct {
+		name         string
+		secret       string
+		version      string
+		noConfigMaps bool
+		want         string
+		wantErr      bool
+		notFound     bool
+		accessDenied bool
+	}{
+		{name: "store secret", secret: "db-password", want: "db-password"},
+		{name: "own secret", secret: "kubernetes://secret/db/password", want: "own"},
+		{name: "own secret with namespace", secret: "kubernetes://secret/team-a/db/password", want: "own"},
+		{name: "allowed secret of other namespace", secret: "kubernetes://secret/databases/postgres-credentials/password", want: "operator-generated"},
+		{name: "denied secret of other namespace", secret: "kubernetes://secret/databases/admin/password", wantErr: true, accessDenied: true},
+		{name: "missing key", secret: "kubernetes://secret/db/username", wantErr: true, notFound: true},
+		{name: "missing secret", secret: "kubernetes://secret/other/password", wantErr: true, notFound: true},
+		{name: "configmap", secret: "kubernetes://configmap/settings/host", want: "db.example.com"},
+		{name: "binary configmap data", secret: "kubernetes://configmap/settings/ca.der", want: "binary"},
+		{name: "configmaps disabled", secret: "kubernetes://configmap/settings/host", noConfigMaps: true, wantErr: true},
+		{name: "version", secret: "kubernetes://secret/db/password", version: "7", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var configMapLister corelisters.ConfigMapLister
+			if !tt.noConfigMaps {
+				configMapLister = corelisters.NewConfigMapLister(configMaps)
+			}
+			client := NewClient(testStore{}, corelisters.NewSecretLister(secrets), configMapLister, "team-a", p)
+			got, err := client.GetSecret(context.Background(), tt.secret, tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if secretstore.IsNotFound(err) != tt.notFound || policy.IsAccessDenied(err) != tt.accessDenied {
+				t.Errorf("GetSecret() error = %v, notFound %v, accessDenied %v", err, tt.notFound, tt.accessDenied)
+			}
+			if err == nil && (got.Value != tt.want || got.Name != tt.secret) {
+				t.Errorf("GetSecret() = %s %q, want %s %q", got.Name, got.Value, tt.secret, tt.want)
+			}
+		})
+	}
+}
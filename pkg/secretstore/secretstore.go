@@ -1,7 +1,138 @@
 package secretstore
 
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"time"
+)
+
 // Client ist the interface implemented by all secret stores
 type Client interface {
-	GetSecretValue(name string) (string, error)
-	GetSecretValueForVersion(name, version string) (string, error)
+	GetSecretValue(ctx context.Context, name string) (string, error)
+	GetSecretValueForVersion(ctx context.Context, name, version string) (string, error)
+	// GetSecret returns the value of a secret together with its metadata.
+	// An empty version refers to the latest version.
+	GetSecret(ctx context.Context, name, version string) (*Secret, error)
+}
+
+// Writer is implemented by secret stores that secrets can be written to. It
+// is kept apart from Client, so that read-only stores do not need to
+// implement it.
+type Writer interface {
+	// SetSecret stores the value, content type and tags of secret as a new
+	// version and returns the stored secret including that version
+	SetSecret(ctx context.Context, secret *Secret) (*Secret, error)
+	// DeleteSecret deletes all versions of a secret
+	DeleteSecret(ctx context.Context, name string) error
+}
+
+// VersionLister is implemented by secret stores that keep older versions of
+// a secret
+type VersionLister interface {
+	// ListVersions returns the metadata of all versions of a secret without
+	// their values, newest first
+	ListVersions(ctx context.Context, name string) ([]*Secret, error)
+}
+
+// Invalidator is implemented by secret stores that cache secrets
+type Invalidator interface {
+	// Invalidate drops the cached latest version of a secret, so that the
+	// next lookup reads it from the store
+	Invalidate(name string)
+}
+
+// Secret is a secret value together with the metadata kept by the store
+type Secret struct {
+	Name        string
+	Value       string
+	Version     string
+	ContentType string
+	Tags        map[string]string
+	// Expires is nil for secrets that do not expire
+	Expires *time.Time
+	// NotBefore is nil for secrets that are valid immediately
+	NotBefore *time.Time
+	// Disabled secrets must not be used
+	Disabled bool
+	// Created is nil if the store does not record when a version was created
+	Created *time.Time
+	// Source is the name of the backend that served the secret if a store
+	// failed over from its primary backend, empty otherwise
+	Source string
+	// Reference identifies the secret that the value was read from if the
+	// store followed a reference to another store, e.g. <host>/<name> of the
+	// Key Vault secret of an App Configuration reference, empty otherwise
+	Reference string
+}
+
+// SortVersions sorts versions by their creation time, newest first. Versions
+// without a creation time are sorted last.
+func SortVersions(versions []*Secret) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].Created == nil || versions[j].Created == nil {
+			return versions[j].Created == nil && versions[i].Created != nil
+		}
+		return versions[i].Created.After(*versions[j].Created)
+	})
+}
+
+// NotFoundError is returned by a store for secrets or versions that do not
+// exist
+type NotFoundError struct {
+	Name    string
+	Version string
+}
+
+func (e *NotFoundError) Error() string {
+	if e.Version != "" {
+		return fmt.Sprintf("secret %q version %q not found", e.Name, e.Version)
+	}
+	return fmt.Sprintf("secret %q not found", e.Name)
+}
+
+// IsNotFound reports whether err is a NotFoundError
+func IsNotFound(err error) bool {
+	_, ok := err.(*NotFoundError)
+	return ok
+}
+
+// DisabledError is returned by a store for versions of secrets that exist
+// but are disabled, so that their value cannot be read
+type DisabledError struct {
+	Name    string
+	Version string
+}
+
+func (e *DisabledError) Error() string {
+	if e.Version != "" {
+		return fmt.Sprintf("secret %q version %q is disabled", e.Name, e.Version)
+	}
+	return fmt.Sprintf("secret %q is disabled", e.Name)
+}
+
+// IsDisabled reports whether err is a DisabledError
+func IsDisabled(err error) bool {
+	_, ok := err.(*DisabledError)
+	return ok
+}
+
+// IsTransient reports whether err is a failure of the store rather than of
+// the request, e.g. an outage, throttling or a network error, so that the
+// request may succeed later or with another store. Errors of stores report
+// it with a Transient method, errors of the HTTP client are always
+// transient.
+func IsTransient(err error) bool {
+	switch e := err.(type) {
+	case interface{ Transient() bool }:
+		return e.Transient()
+	case *url.Error:
+		return e.Err != context.Canceled
+	case net.Error:
+		return true
+	default:
+		return false
+	}
 }
@@ -0,0 +1,370 @@
+package sops
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// ageBase64 is the encoding of the header of age files, which like age
+// rejects encodings that are not canonical
+var ageBase64 = base64.RawStdEncoding.Strict()
+
+const (
+	ageIntro          = "age-encryption.org/v1"
+	ageIdentityPrefix = "AGE-SECRET-KEY-1"
+	ageX25519Label    = "age-encryption.org/v1/X25519"
+	ageArmorHeader    = "-----BEGIN AGE ENCRYPTED FILE-----"
+	ageArmorFooter    = "-----END AGE ENCRYPTED FILE-----"
+	// ageColumns is the length of all but the last line of the base64
+	// encoded armor and stanza bodies
+	ageColumns = 64
+	// ageMaxWhitespace is the amount of whitespace that is accepted around
+	// the armor
+	ageMaxWhitespace = 1024
+	// ageChunkSize is the size of the plaintext of all but the last chunk of
+	// the payload
+	ageChunkSize = 64 * 1024
+)
+
+// ageIdentity is an X25519 identity of age, the private key of a recipient
+type ageIdentity struct {
+	secret    [32]byte
+	recipient [32]byte
+}
+
+// parseAgeIdentity parses an identity in the form AGE-SECRET-KEY-1...
+func parseAgeIdentity(s string) (*ageIdentity, error) {
+	hrp, data, err := bech32Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid age identity: %s", err)
+	}
+	if hrp != "age-secret-key-" || len(data) != 32 {
+		return nil, fmt.Errorf("invalid age identity: not an X25519 identity")
+	}
+	identity := &ageIdentity{}
+	copy(identity.secret[:], data)
+	curve25519.ScalarBaseMult(&identity.recipient, &identity.secret)
+	return identity, nil
+}
+
+// ageStanza is a recipient stanza of the header of an age file
+type ageStanza struct {
+	args []string
+	body []byte
+}
+
+// unwrap returns the file key if the X25519 stanza is addressed to the
+// identity
+func (i *ageIdentity) unwrap(stanza ageStanza) ([]byte, bool) {
+	if len(stanza.args) != 2 || stanza.args[0] != "X25519" {
+		return nil, false
+	}
+	share, err := ageBase64.DecodeString(stanza.args[1])
+	if err != nil || len(share) != 32 {
+		return nil, false
+	}
+	var point, shared [32]byte
+	copy(point[:], share)
+	curve25519.ScalarMult(&shared, &i.secret, &point)
+	if shared == [32]byte{} {
+		return nil, false
+	}
+	salt := append(share, i.recipient[:]...)
+	aead, err := chacha20poly1305.New(hkdfKey(shared[:], salt, ageX25519Label))
+	if err != nil {
+		return nil, false
+	}
+	fileKey, err := aead.Open(nil, make([]byte, chacha20poly1305.NonceSize), stanza.body, nil)
+	if err != nil || len(fileKey) != 16 {
+		return nil, false
+	}
+	return fileKey, true
+}
+
+// decryptAge decrypts an age file, optionally ASCII armored, with the first
+// of identities that it is encrypted to
+func decryptAge(data []byte, identities []*ageIdentity) ([]byte, error) {
+	if bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte(ageArmorHeader)) {
+		var err error
+		if data, err = dearmorAge(data); err != nil {
+			return nil, err
+		}
+	}
+	header, stanzas, mac, payload, err := parseAgeHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileKey []byte
+	for _, stanza := range stanzas {
+		for _, identity := range identities {
+			if key, ok := identity.unwrap(stanza); ok {
+				fileKey = key
+				break
+			}
+		}
+		if fileKey != nil {
+			break
+		}
+	}
+	if fileKey == nil {
+		return nil, fmt.Errorf("no age identity matches a recipient of the file")
+	}
+
+	h := hmac.New(sha256.New, hkdfKey(fileKey, nil, "header"))
+	h.Write(header)
+	if !hmac.Equal(h.Sum(nil), mac) {
+		return nil, fmt.Errorf("invalid header MAC of age file")
+	}
+
+	if len(payload) < 16 {
+		return nil, fmt.Errorf("age payload is too short")
+	}
+	aead, err := chacha20poly1305.New(hkdfKey(fileKey, payload[:16], "payload"))
+	if err != nil {
+		return nil, err
+	}
+	payload = payload[16:]
+	var plaintext []byte
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	for counter := uint64(0); ; counter++ {
+		chunk := payload
+		last := len(chunk) <= ageChunkSize+aead.Overhead()
+		if !last {
+			chunk = payload[:ageChunkSize+aead.Overhead()]
+		}
+		for i := 0; i < 8; i++ {
+			nonce[10-i] = byte(counter >> (8 * uint(i)))
+		}
+		if last {
+			nonce[11] = 1
+		}
+		opened, err := aead.Open(nil, nonce, chunk, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting age payload: %s", err)
+		}
+		// only the payload of an empty file ends with an empty chunk
+		if last && len(opened) == 0 && counter > 0 {
+			return nil, fmt.Errorf("invalid age payload: empty last chunk")
+		}
+		plaintext = append(plaintext, opened...)
+		if last {
+			return plaintext, nil
+		}
+		payload = payload[len(chunk):]
+	}
+}
+
+// parseAgeHeader splits an age file into the header that the MAC is
+// computed over, the recipient stanzas, the MAC and the payload
+func parseAgeHeader(data []byte) (header []byte, stanzas []ageStanza, mac, payload []byte, err error) {
+	r := bufio.NewReader(bytes.NewReader(data))
+	var consumed int
+	readLine := func() (string, error) {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("invalid age header: %s", err)
+		}
+		consumed += len(line)
+		return strings.TrimSuffix(line, "\n"), nil
+	}
+
+	line, err := readLine()
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if line != ageIntro {
+		return nil, nil, nil, nil, fmt.Errorf("not an age file")
+	}
+	for {
+		line, err := readLine()
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		if strings.HasPrefix(line, "---") {
+			args := strings.Split(line, " ")
+			if args[0] != "---" || len(args) != 2 || !validAgeArgument(args[1]) {
+				return nil, nil, nil, nil, fmt.Errorf("invalid age header line %q", line)
+			}
+			// the MAC covers the header up to and including "---"
+			header = data[:consumed-len(line)-1+3]
+			mac, err = ageBase64.DecodeString(args[1])
+			if err != nil || len(mac) != sha256.Size {
+				return nil, nil, nil, nil, fmt.Errorf("invalid age header MAC %q", args[1])
+			}
+			return header, stanzas, mac, data[consumed:], nil
+		}
+		args := strings.Split(line, " ")
+		if args[0] != "->" || len(args) < 2 {
+			return nil, nil, nil, nil, fmt.Errorf("invalid age header line %q", line)
+		}
+		for _, arg := range args[1:] {
+			if !validAgeArgument(arg) {
+				return nil, nil, nil, nil, fmt.Errorf("invalid age stanza argument %q", arg)
+			}
+		}
+		stanza := ageStanza{args: args[1:]}
+		// the body is wrapped at 64 columns and ends with a shorter line
+		for {
+			line, err := readLine()
+			if err != nil {
+				return nil, nil, nil, nil, err
+			}
+			if len(line) > ageColumns || strings.Contains(line, "\r") {
+				return nil, nil, nil, nil, fmt.Errorf("invalid age stanza body line %q", line)
+			}
+			chunk, err := ageBase64.DecodeString(line)
+			if err != nil {
+				return nil, nil, nil, nil, fmt.Errorf("invalid age stanza body: %s", err)
+			}
+			stanza.body = append(stanza.body, chunk...)
+			if len(line) < ageColumns {
+				break
+			}
+		}
+		stanzas = append(stanzas, stanza)
+	}
+}
+
+// validAgeArgument reports whether s is a valid argument of a stanza, a
+// non-empty string of printable ASCII characters without spaces
+func validAgeArgument(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < 33 || c > 126 {
+			return false
+		}
+	}
+	return true
+}
+
+// dearmorAge decodes an ASCII armored age file as strictly as age does: the
+// armor may only be surrounded by whitespace, has no headers and its lines
+// are 64 columns of canonical base64 but the last one
+func dearmorAge(data []byte) ([]byte, error) {
+	lines := strings.Split(string(data), "\n")
+	var skipped int
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		skipped += len(lines[0]) + 1
+		lines = lines[1:]
+	}
+	if skipped > ageMaxWhitespace {
+		return nil, fmt.Errorf("invalid age armor: too much leading whitespace")
+	}
+	if len(lines) == 0 || strings.TrimSuffix(lines[0], "\r") != ageArmorHeader {
+		return nil, fmt.Errorf("invalid age armor: missing %s", ageArmorHeader)
+	}
+	var decoded []byte
+	for i := 1; i < len(lines); i++ {
+		line := strings.TrimSuffix(lines[i], "\r")
+		if line == ageArmorFooter {
+			trailing := strings.Join(lines[i+1:], "\n")
+			if strings.TrimSpace(trailing) != "" || len(trailing) >= ageMaxWhitespace {
+				return nil, fmt.Errorf("invalid age armor: data after %s", ageArmorFooter)
+			}
+			return decoded, nil
+		}
+		// the footer has to follow the first line that is shorter
+		if len(line) > ageColumns || len(decoded)%(ageColumns/4*3) != 0 {
+			return nil, fmt.Errorf("invalid age armor: invalid line %q", line)
+		}
+		chunk, err := base64.StdEncoding.Strict().DecodeString(line)
+		if err != nil || len(chunk) == 0 {
+			return nil, fmt.Errorf("invalid age armor: invalid line %q", line)
+		}
+		decoded = append(decoded, chunk...)
+	}
+	return nil, fmt.Errorf("invalid age armor: missing %s", ageArmorFooter)
+}
+
+// hkdfKey derives a 32 byte key with HKDF-SHA-256
+func hkdfKey(secret, salt []byte, info string) []byte {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// bech32Decode decodes a bech32 string as used by age, which does not limit
+// the length of the string
+func bech32Decode(s string) (string, []byte, error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, fmt.Errorf("mixed case")
+	}
+	s = strings.ToLower(s)
+	pos := strings.LastIndex(s, "1")
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, fmt.Errorf("invalid separator")
+	}
+	hrp := s[:pos]
+	var values []byte
+	for _, c := range s[pos+1:] {
+		v := strings.IndexRune(bech32Charset, c)
+		if v < 0 {
+			return "", nil, fmt.Errorf("invalid character %q", c)
+		}
+		values = append(values, byte(v))
+	}
+	if bech32Polymod(append(bech32ExpandHRP(hrp), values...)) != 1 {
+		return "", nil, fmt.Errorf("invalid checksum")
+	}
+
+	// convert the 5 bit groups without the checksum to bytes
+	var data []byte
+	var acc uint32
+	var bits uint
+	for _, v := range values[:len(values)-6] {
+		acc = acc<<5 | uint32(v)
+		bits += 5
+		if bits >= 8 {
+			bits -= 8
+			data = append(data, byte(acc>>bits))
+		}
+	}
+	if bits >= 5 || acc&(1<<bits-1) != 0 {
+		return "", nil, fmt.Errorf("invalid padding")
+	}
+	return hrp, data, nil
+}
+
+func bech32ExpandHRP(hrp string) []byte {
+	expanded := make([]byte, 0, 2*len(hrp)+1)
+	for _, c := range hrp {
+		expanded = append(expanded, byte(c>>5))
+	}
+	expanded = append(expanded, 0)
+	for _, c := range hrp {
+		expanded = append(expanded, byte(c&31))
+	}
+	return expanded
+}
+
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
@@ -0,0 +1,200 @@
+package sops
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+)
+
+// newTestIdentity returns a new identity in the form AGE-SECRET-KEY-1...
+func newTestIdentity(t *testing.T) (string, *ageIdentity) {
+	var secret [32]byte
+	if _, err := rand.Read(secret[:]); err != nil {
+		t.Fatal(err)
+	}
+	encoded := strings.ToUpper(bech32Encode("age-secret-key-", secret[:]))
+	identity, err := parseAgeIdentity(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded, identity
+}
+
+func bech32Encode(hrp string, data []byte) string {
+	var values []byte
+	var acc uint32
+	var bits uint
+	for _, b := range data {
+		acc = acc<<8 | uint32(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			values = append(values, byte(acc>>bits)&31)
+		}
+	}
+	if bits > 0 {
+		values = append(values, byte(acc<<(5-bits))&31)
+	}
+	polymod := bech32Polymod(append(append(bech32ExpandHRP(hrp), values...), 0, 0, 0, 0, 0, 0)) ^ 1
+	for i := 0; i < 6; i++ {
+		values = append(values, byte(polymod>>uint(5*(5-i)))&31)
+	}
+	encoded := hrp + "1"
+	for _, v := range values {
+		encoded += string(bech32Charset[v])
+	}
+	return encoded
+}
+
+// encryptAge encrypts plaintext to recipient as an armored age file, like
+// the age keysource of SOPS does with the data key
+func encryptAge(t *testing.T, recipient [32]byte, plaintext []byte) string {
+	fileKey := make([]byte, 16)
+	var ephemeral [32]byte
+	rand.Read(fileKey)
+	rand.Read(ephemeral[:])
+	var share, shared [32]byte
+	curve25519.ScalarBaseMult(&share, &ephemeral)
+	curve25519.ScalarMult(&shared, &ephemeral, &recipient)
+	aead, err := chacha20poly1305.New(hkdfKey(shared[:], append(share[:], recipient[:]...), ageX25519Label))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := aead.Seal(nil, make([]byte, chacha20poly1305.NonceSize), fileKey, nil)
+
+	var header bytes.Buffer
+	fmt.Fprintf(&header, "%s\n-> X25519 %s\n%s\n---", ageIntro,
+		base64.RawStdEncoding.EncodeToString(share[:]), base64.RawStdEncoding.EncodeToString(body))
+	h := hmac.New(sha256.New, hkdfKey(fileKey, nil, "header"))
+	h.Write(header.Bytes())
+	fmt.Fprintf(&header, " %s\n", base64.RawStdEncoding.EncodeToString(h.Sum(nil)))
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	aead, err = chacha20poly1305.New(hkdfKey(fileKey, nonce, "payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunkNonce := make([]byte, chacha20poly1305.NonceSize)
+	chunkNonce[11] = 1
+	header.Write(nonce)
+	header.Write(aead.Seal(nil, chunkNonce, plaintext, nil))
+	return string(pem.EncodeToMemory(&pem.Block{Type: "AGE ENCRYPTED FILE", Bytes: header.Bytes()}))
+}
+
+func Test_decryptAge(t *testing.T) {
+	_, identity := newTestIdentity(t)
+	_, other := newTestIdentity(t)
+	encrypted := encryptAge(t, identity.recipient, []byte("data key"))
+
+	got, err := decryptAge([]byte(encrypted), []*ageIdentity{other, identity})
+	if err != nil {
+		t.Fatalf("decryptAge() error = %v", err)
+	}
+	if string(got) != "data key" {
+		t.Errorf("decryptAge() = %q, want data key", got)
+	}
+
+	if _, err := decryptAge([]byte(encrypted), []*ageIdentity{other}); err == nil {
+		t.Errorf("decryptAge() decrypted a file that is not encrypted to the identity")
+	}
+
+	block, _ := pem.Decode([]byte(encrypted))
+	tampered := bytes.Replace(block.Bytes, []byte(ageIntro+"\n"), []byte(ageIntro+"\n-> other\n\n"), 1)
+	if _, err := decryptAge(tampered, []*ageIdentity{identity}); err == nil {
+		t.Errorf("decryptAge() accepted a file with a modified header")
+	}
+}
+
+func Test_parseAgeIdentity(t *testing.T) {
+	encoded, identity := newTestIdentity(t)
+	if _, err := parseAgeIdentity(strings.ToLower(encoded)); err != nil {
+		t.Errorf("parseAgeIdentity() error = %v for a lower case identity", err)
+	}
+	if identity.recipient == [32]byte{} {
+		t.Errorf("parseAgeIdentity() did not derive the recipient")
+	}
+	changed := "Q"
+	if strings.HasSuffix(encoded, changed) {
+		changed = "P"
+	}
+	tests := []string{
+		encoded[:len(encoded)-1] + changed,
+		strings.ToLower(encoded[:20]) + encoded[20:],
+		"age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p",
+	}
+	for _, invalid := range tests {
+		if _, err := parseAgeIdentity(invalid); err == nil {
+			t.Errorf("parseAgeIdentity(%q) accepted an invalid identity", invalid)
+		}
+	}
+}
+
+// Test_decryptAge_vectors decrypts the X25519 test vectors of the age test
+// kit, https://github.com/C2SP/CCTV/tree/main/age, in testdata/age
+func Test_decryptAge_vectors(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "age", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no test vectors found")
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var expect, payload string
+			var identities []*ageIdentity
+			for {
+				i := bytes.IndexByte(data, '\n')
+				line := string(data[:i])
+				data = data[i+1:]
+				if line == "" {
+					break
+				}
+				parts := strings.SplitN(line, ": ", 2)
+				switch parts[0] {
+				case "expect":
+					expect = parts[1]
+				case "payload":
+					payload = parts[1]
+				case "identity":
+					identity, err := parseAgeIdentity(parts[1])
+					if err != nil {
+						t.Fatal(err)
+					}
+					identities = append(identities, identity)
+				}
+			}
+
+			got, err := decryptAge(data, identities)
+			if expect != "success" {
+				if err == nil {
+					t.Fatalf("decryptAge() accepted a file with %s", expect)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decryptAge() error = %v", err)
+			}
+			if sum := sha256.Sum256(got); hex.EncodeToString(sum[:]) != payload {
+				t.Errorf("decryptAge() payload hash = %x, want %s", sum, payload)
+			}
+		})
+	}
+}
@@ -0,0 +1,555 @@
+// Package sops implements a secret store that reads values from YAML and JSON
+// files encrypted with SOPS, e.g. in a git repository that is synced into a
+// directory of the pod.
+//
+// A secret is addressed as <file>#<path>, where file is relative to the
+// directory of the store and path selects a value in the file with the keys
+// of maps and the indices of lists separated by dots, e.g.
+// prod/db.enc.yaml#postgres.password. Values that are maps or lists are
+// returned as JSON.
+package sops
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+const (
+	pathSeparator = "#"
+	keySeparator  = "."
+	// metadataKey is the key of the SOPS metadata in every encrypted file
+	metadataKey = "sops"
+	// defaultUnencryptedSuffix is used by SOPS if a file has no other rule
+	// for the values that are encrypted
+	defaultUnencryptedSuffix = "_unencrypted"
+)
+
+var (
+	// encryptedValue matches the values that SOPS encrypted
+	encryptedValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:([^,]*),iv:([^,]+),tag:([^,]+),type:([a-z]+)\]$`)
+	// macOnlyEncryptedInitialization starts the MAC of files with
+	// mac_only_encrypted, so that it differs from the MAC of all values
+	macOnlyEncryptedInitialization = []byte{0x8a, 0x3f, 0xd2, 0xad, 0x54, 0xce, 0x66, 0x52, 0x7b, 0x10, 0x34, 0xf3, 0xd1, 0x47, 0xbe, 0x0b, 0x0b, 0x97, 0x5b, 0x3b, 0xf4, 0x4f, 0x72, 0xc6, 0xfd, 0xad, 0xec, 0x81, 0x76, 0xf2, 0x7d, 0x69}
+)
+
+// Client is a secretstore.Client that reads SOPS encrypted files from a
+// directory. The data key of a file is decrypted with the age identities and
+// PGP private keys read from a file or directory, e.g. a mounted Secret.
+//
+// Files are read again when they change, so updates of the directory are
+// picked up without a restart. The keys are read whenever a file is
+// decrypted, so that they can be rotated the same way.
+//
+// Like SOPS, a file is only used once the MAC over all its values has been
+// verified and every value that its encryption rules select is encrypted, so
+// that values can be neither replaced with plaintext nor added or removed.
+// Unencrypted values are read as YAML 1.1, files whose unencrypted values
+// YAML 1.2 reads differently, e.g. yes and no, fail the verification.
+type Client struct {
+	dir  string
+	keys string
+
+	mu    sync.Mutex
+	files map[string]*file
+}
+
+// file is a decrypted file
+type file struct {
+	modTime      time.Time
+	size         int64
+	tree         map[string]interface{}
+	lastModified *time.Time
+}
+
+// metadata is the part of the SOPS metadata needed to decrypt and verify a
+// file
+type metadata struct {
+	LastModified      string `json:"lastmodified"`
+	MAC               string `json:"mac"`
+	MACOnlyEncrypted  bool   `json:"mac_only_encrypted"`
+	UnencryptedSuffix string `json:"unencrypted_suffix"`
+	EncryptedSuffix   string `json:"encrypted_suffix"`
+	UnencryptedRegex  string `json:"unencrypted_regex"`
+	EncryptedRegex    string `json:"encrypted_regex"`
+	// the comment rules depend on comments, which are not read
+	UnencryptedCommentRegex string `json:"unencrypted_comment_regex"`
+	EncryptedCommentRegex   string `json:"encrypted_comment_regex"`
+	Age                     []struct {
+		Recipient string `json:"recipient"`
+		Enc       string `json:"enc"`
+	} `json:"age"`
+	PGP []struct {
+		Fingerprint string `json:"fp"`
+		Enc         string `json:"enc"`
+	} `json:"pgp"`
+	KeyGroups []json.RawMessage `json:"key_groups"`
+}
+
+// NewClient returns a Client for the encrypted files in dir that decrypts
+// them with the keys in the file or directory at keys
+func NewClient(dir, keys string) (*Client, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	if _, err := os.Stat(keys); err != nil {
+		return nil, err
+	}
+	return &Client{dir: dir, keys: keys, files: make(map[string]*file)}, nil
+}
+
+func (c *Client) GetSecretValue(ctx context.Context, name string) (string, error) {
+	return c.GetSecretValueForVersion(ctx, name, "")
+}
+
+func (c *Client) GetSecretValueForVersion(ctx context.Context, name, version string) (string, error) {
+	secret, err := c.GetSecret(ctx, name, version)
+	if err != nil {
+		return "", err
+	}
+	return secret.Value, nil
+}
+
+func (c *Client) GetSecret(ctx context.Context, name, version string) (*secretstore.Secret, error) {
+	if version != "" {
+		return nil, fmt.Errorf("%s has no versions", name)
+	}
+	i := strings.LastIndex(name, pathSeparator)
+	if i < 0 || name[i+1:] == "" {
+		return nil, fmt.Errorf("invalid secret name %q, expected <file>%s<path>", name, pathSeparator)
+	}
+	filename := filepath.Clean(filepath.FromSlash(name[:i]))
+	if filepath.IsAbs(filename) || filename == ".." || strings.HasPrefix(filename, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("invalid secret name %q, the file must be inside the directory of the store", name)
+	}
+
+	f, err := c.read(filename)
+	if os.IsNotExist(err) {
+		return nil, &secretstore.NotFoundError{Name: name}
+	}
+	if err != nil {
+		return nil, err
+	}
+	value, err := f.lookup(strings.Split(name[i+1:], keySeparator))
+	if err != nil {
+		if secretstore.IsNotFound(err) {
+			return nil, &secretstore.NotFoundError{Name: name}
+		}
+		return nil, fmt.Errorf("error decrypting %s: %s", name, err)
+	}
+	return &secretstore.Secret{
+		Name:    name,
+		Value:   value,
+		Tags:    make(map[string]string),
+		Created: f.lastModified,
+	}, nil
+}
+
+// read returns the file with the given name, which is only read, decrypted
+// and verified again if it changed
+func (c *Client) read(name string) (*file, error) {
+	path := filepath.Join(c.dir, name)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.files[name]; ok && f.modTime.Equal(info.ModTime()) && f.size == info.Size() {
+		return f, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// the MAC depends on the order of the values in the file
+	var document yaml.MapSlice
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %s", name, err)
+	}
+	var tree yaml.MapSlice
+	var rawMetadata interface{}
+	for _, item := range document {
+		if item.Key == metadataKey {
+			rawMetadata = item.Value
+			continue
+		}
+		tree = append(tree, item)
+	}
+	raw, err := json.Marshal(toJSON(rawMetadata))
+	if err != nil {
+		return nil, err
+	}
+	var meta metadata
+	if err := json.Unmarshal(raw, &meta); err != nil || rawMetadata == nil {
+		return nil, fmt.Errorf("%s is not encrypted with SOPS", name)
+	}
+	rules, err := newEncryptionRules(&meta)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", name, err)
+	}
+
+	dataKey, err := c.decryptDataKey(&meta)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting the data key of %s: %s", name, err)
+	}
+	d := &decryptor{dataKey: dataKey, rules: rules, macOnlyEncrypted: meta.MACOnlyEncrypted, mac: sha512.New()}
+	if meta.MACOnlyEncrypted {
+		d.mac.Write(macOnlyEncryptedInitialization)
+	}
+	decrypted, err := d.decrypt(tree, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting %s: %s", name, err)
+	}
+	if err := d.verify(&meta); err != nil {
+		return nil, fmt.Errorf("error verifying %s: %s", name, err)
+	}
+
+	f := &file{modTime: info.ModTime(), size: info.Size(), tree: decrypted.(map[string]interface{})}
+	if t, err := time.Parse(time.RFC3339, meta.LastModified); err == nil {
+		f.lastModified = &t
+	}
+	c.files[name] = f
+	return f, nil
+}
+
+// decryptDataKey decrypts the data key with the first key that it is
+// encrypted to
+func (c *Client) decryptDataKey(meta *metadata) ([]byte, error) {
+	if len(meta.KeyGroups) > 0 {
+		return nil, fmt.Errorf("key groups are not supported")
+	}
+	k, err := readKeys(c.keys)
+	if err != nil {
+		return nil, err
+	}
+	if len(k.age) > 0 {
+		for _, recipient := range meta.Age {
+			if dataKey, err := decryptAge([]byte(recipient.Enc), k.age); err == nil {
+				return dataKey, nil
+			}
+		}
+	}
+	if len(k.pgp) > 0 {
+		for _, recipient := range meta.PGP {
+			if dataKey, err := k.decryptPGP(recipient.Enc); err == nil {
+				return dataKey, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("none of the keys matches a recipient of the file")
+}
+
+// lookup returns the value at path
+func (f *file) lookup(path []string) (string, error) {
+	var node interface{} = f.tree
+	for _, key := range path {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			child, ok := n[key]
+			if !ok {
+				return "", &secretstore.NotFoundError{}
+			}
+			node = child
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(n) {
+				return "", &secretstore.NotFoundError{}
+			}
+			node = n[index]
+		default:
+			return "", &secretstore.NotFoundError{}
+		}
+	}
+
+	switch v := node.(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", nil
+	default:
+		data, err := json.Marshal(v)
+		return string(data), err
+	}
+}
+
+// encryptionRules select the values of a file that SOPS encrypts by the
+// keys on their path
+type encryptionRules struct {
+	unencryptedSuffix string
+	encryptedSuffix   string
+	unencryptedRegex  *regexp.Regexp
+	encryptedRegex    *regexp.Regexp
+}
+
+func newEncryptionRules(meta *metadata) (*encryptionRules, error) {
+	if meta.UnencryptedCommentRegex != "" || meta.EncryptedCommentRegex != "" {
+		return nil, fmt.Errorf("unencrypted_comment_regex and encrypted_comment_regex are not supported")
+	}
+	rules := &encryptionRules{unencryptedSuffix: meta.UnencryptedSuffix, encryptedSuffix: meta.EncryptedSuffix}
+	count := 0
+	for _, rule := range []string{meta.UnencryptedSuffix, meta.EncryptedSuffix, meta.UnencryptedRegex, meta.EncryptedRegex} {
+		if rule != "" {
+			count++
+		}
+	}
+	switch {
+	case count > 1:
+		return nil, fmt.Errorf("only one of unencrypted_suffix, encrypted_suffix, unencrypted_regex and encrypted_regex may be set")
+	case count == 0:
+		rules.unencryptedSuffix = defaultUnencryptedSuffix
+	}
+	var err error
+	if meta.UnencryptedRegex != "" {
+		if rules.unencryptedRegex, err = regexp.Compile(meta.UnencryptedRegex); err != nil {
+			return nil, fmt.Errorf("invalid unencrypted_regex: %s", err)
+		}
+	}
+	if meta.EncryptedRegex != "" {
+		if rules.encryptedRegex, err = regexp.Compile(meta.EncryptedRegex); err != nil {
+			return nil, fmt.Errorf("invalid encrypted_regex: %s", err)
+		}
+	}
+	return rules, nil
+}
+
+// encrypted reports whether SOPS encrypts the value at path
+func (r *encryptionRules) encrypted(path []string) bool {
+	anyKey := func(match func(string) bool) bool {
+		for _, key := range path {
+			if match(key) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case r.unencryptedSuffix != "":
+		return !anyKey(func(key string) bool { return strings.HasSuffix(key, r.unencryptedSuffix) })
+	case r.encryptedSuffix != "":
+		return anyKey(func(key string) bool { return strings.HasSuffix(key, r.encryptedSuffix) })
+	case r.unencryptedRegex != nil:
+		return !anyKey(r.unencryptedRegex.MatchString)
+	case r.encryptedRegex != nil:
+		return anyKey(r.encryptedRegex.MatchString)
+	}
+	return true
+}
+
+// decryptor decrypts the values of a file and computes their MAC like SOPS
+type decryptor struct {
+	dataKey          []byte
+	rules            *encryptionRules
+	macOnlyEncrypted bool
+	mac              hash.Hash
+}
+
+// decrypt decrypts all values of node, which is at path, in the order of the
+// file. Maps are returned as map[string]interface{}.
+func (d *decryptor) decrypt(node interface{}, path []string) (interface{}, error) {
+	switch n := node.(type) {
+	case yaml.MapSlice:
+		decrypted := make(map[string]interface{}, len(n))
+		for _, item := range n {
+			key := fmt.Sprint(item.Key)
+			if _, ok := decrypted[key]; ok {
+				return nil, fmt.Errorf("duplicate key %q", strings.Join(append(path, key), keySeparator))
+			}
+			value, err := d.decrypt(item.Value, append(path[:len(path):len(path)], key))
+			if err != nil {
+				return nil, err
+			}
+			decrypted[key] = value
+		}
+		return decrypted, nil
+	case []interface{}:
+		// the indices of lists are not part of the path
+		decrypted := make([]interface{}, len(n))
+		for i, child := range n {
+			value, err := d.decrypt(child, path)
+			if err != nil {
+				return nil, err
+			}
+			decrypted[i] = value
+		}
+		return decrypted, nil
+	case nil:
+		// SOPS neither encrypts nor authenticates null values
+		return nil, nil
+	default:
+		return d.decryptLeaf(n, path)
+	}
+}
+
+// decryptLeaf decrypts value if the rules select it for encryption and adds
+// it to the MAC
+func (d *decryptor) decryptLeaf(value interface{}, path []string) (interface{}, error) {
+	encrypted := d.rules.encrypted(path)
+	if encrypted {
+		s, ok := value.(string)
+		if !ok || !encryptedValue.MatchString(s) {
+			return nil, fmt.Errorf("value at %q is not encrypted", strings.Join(path, keySeparator))
+		}
+		var err error
+		if value, err = decryptValue(s, d.dataKey, strings.Join(path, ":")+":"); err != nil {
+			return nil, err
+		}
+	}
+	if encrypted || !d.macOnlyEncrypted {
+		data, err := macBytes(value)
+		if err != nil {
+			return nil, fmt.Errorf("value at %q: %s", strings.Join(path, keySeparator), err)
+		}
+		d.mac.Write(data)
+	}
+	if f, ok := value.(float64); ok {
+		// numbers are kept as JSON numbers in objects
+		return json.Number(strconv.FormatFloat(f, 'f', -1, 64)), nil
+	}
+	return value, nil
+}
+
+// verify compares the MAC of the decrypted values with the MAC of the file,
+// which is encrypted with its last modification time as additional data
+func (d *decryptor) verify(meta *metadata) error {
+	if meta.MAC == "" {
+		return fmt.Errorf("the file has no MAC")
+	}
+	lastModified, err := time.Parse(time.RFC3339, meta.LastModified)
+	if err != nil {
+		return fmt.Errorf("invalid lastmodified %q", meta.LastModified)
+	}
+	if !encryptedValue.MatchString(meta.MAC) {
+		return fmt.Errorf("the MAC is not encrypted")
+	}
+	mac, err := decryptValue(meta.MAC, d.dataKey, lastModified.Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("the MAC cannot be decrypted")
+	}
+	if mac != fmt.Sprintf("%X", d.mac.Sum(nil)) {
+		return fmt.Errorf("the MAC does not match the values, the file was modified without SOPS")
+	}
+	return nil
+}
+
+// macBytes returns the bytes that SOPS adds to the MAC for a value
+func macBytes(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case string:
+		return []byte(v), nil
+	case []byte:
+		return v, nil
+	case int:
+		return []byte(strconv.Itoa(v)), nil
+	case int64:
+		return []byte(strconv.FormatInt(v, 10)), nil
+	case uint64:
+		return []byte(strconv.FormatUint(v, 10)), nil
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'f', -1, 64)), nil
+	case bool:
+		if v {
+			return []byte("True"), nil
+		}
+		return []byte("False"), nil
+	default:
+		return nil, fmt.Errorf("unsupported type %T", value)
+	}
+}
+
+// toJSON converts the maps that YAML is read into to
+// map[string]interface{}, so that they can be encoded as JSON
+func toJSON(node interface{}) interface{} {
+	switch n := node.(type) {
+	case yaml.MapSlice:
+		m := make(map[string]interface{}, len(n))
+		for _, item := range n {
+			m[fmt.Sprint(item.Key)] = toJSON(item.Value)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(n))
+		for i, child := range n {
+			l[i] = toJSON(child)
+		}
+		return l
+	default:
+		return n
+	}
+}
+
+// decryptValue decrypts a value of the form
+// ENC[AES256_GCM,data:...,iv:...,tag:...,type:...] with AES-GCM and returns
+// it with its type like SOPS does
+func decryptValue(value string, dataKey []byte, additionalData string) (interface{}, error) {
+	match := encryptedValue.FindStringSubmatch(value)
+	var parts [3][]byte
+	for i := range parts {
+		var err error
+		if parts[i], err = base64.StdEncoding.DecodeString(match[i+1]); err != nil {
+			return nil, fmt.Errorf("invalid encrypted value: %s", err)
+		}
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return nil, fmt.Errorf("value at %q cannot be decrypted", strings.TrimSuffix(additionalData, ":"))
+	}
+
+	switch valueType := match[4]; valueType {
+	case "str", "bytes", "comment":
+		return string(plaintext), nil
+	case "int":
+		i, err := strconv.Atoi(string(plaintext))
+		if err != nil {
+			return nil, fmt.Errorf("invalid int %q", plaintext)
+		}
+		return i, nil
+	case "float":
+		f, err := strconv.ParseFloat(string(plaintext), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid float %q", plaintext)
+		}
+		return f, nil
+	case "bool":
+		// SOPS writes True and False
+		b, err := strconv.ParseBool(string(plaintext))
+		if err != nil {
+			return nil, fmt.Errorf("invalid bool %q", plaintext)
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("unknown type %q", valueType)
+	}
+}
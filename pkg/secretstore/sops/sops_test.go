@@ -0,0 +1,462 @@
+package sops
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"gopkg.in/yaml.v2"
+
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+const testLastModified = "2019-03-01T12:00:00Z"
+
+// encryptValue encrypts a value like SOPS does with the data key
+func encryptValue(t *testing.T, dataKey []byte, value, valueType, additionalData string) string {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := make([]byte, 32)
+	rand.Read(iv)
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := gcm.Seal(nil, iv, []byte(value), []byte(additionalData))
+	encode := base64.StdEncoding.EncodeToString
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]",
+		encode(sealed[:len(sealed)-16]), encode(iv), encode(sealed[len(sealed)-16:]), valueType)
+}
+
+// testEncryption encrypts trees like SOPS does
+type testEncryption struct {
+	dataKey []byte
+	// encrypted selects the values that are encrypted, all but the ones
+	// with the default unencrypted suffix if nil
+	encrypted        func(path []string) bool
+	macOnlyEncrypted bool
+}
+
+// encrypt returns tree with its values encrypted and the encrypted MAC of
+// the values
+func (e testEncryption) encrypt(t *testing.T, tree yaml.MapSlice) (yaml.MapSlice, string) {
+	encrypted := e.encrypted
+	if encrypted == nil {
+		encrypted = (&encryptionRules{unencryptedSuffix: defaultUnencryptedSuffix}).encrypted
+	}
+	mac := sha512.New()
+	if e.macOnlyEncrypted {
+		mac.Write(macOnlyEncryptedInitialization)
+	}
+	var walk func(node interface{}, path []string) interface{}
+	walk = func(node interface{}, path []string) interface{} {
+		switch n := node.(type) {
+		case yaml.MapSlice:
+			result := make(yaml.MapSlice, len(n))
+			for i, item := range n {
+				key := item.Key.(string)
+				result[i] = yaml.MapItem{Key: key, Value: walk(item.Value, append(path[:len(path):len(path)], key))}
+			}
+			return result
+		case []interface{}:
+			result := make([]interface{}, len(n))
+			for i, child := range n {
+				result[i] = walk(child, path)
+			}
+			return result
+		}
+		var plaintext, valueType string
+		switch v := node.(type) {
+		case nil:
+			return nil
+		case string:
+			plaintext, valueType = v, "str"
+		case int:
+			plaintext, valueType = strconv.Itoa(v), "int"
+		case float64:
+			plaintext, valueType = strconv.FormatFloat(v, 'f', -1, 64), "float"
+		case bool:
+			plaintext, valueType = "False", "bool"
+			if v {
+				plaintext = "True"
+			}
+		default:
+			t.Fatalf("unsupported type %T", node)
+		}
+		if !encrypted(path) {
+			if !e.macOnlyEncrypted {
+				mac.Write([]byte(plaintext))
+			}
+			return node
+		}
+		mac.Write([]byte(plaintext))
+		return encryptValue(t, e.dataKey, plaintext, valueType, strings.Join(path, ":")+":")
+	}
+	result := walk(tree, nil).(yaml.MapSlice)
+	return result, encryptValue(t, e.dataKey, fmt.Sprintf("%X", mac.Sum(nil)), "str", testLastModified)
+}
+
+// writeEncryptedFile writes tree together with the SOPS metadata in sops
+// and the MAC
+func writeEncryptedFile(t *testing.T, path string, tree yaml.MapSlice, mac string, sops yaml.MapSlice, modTime time.Time) {
+	sops = append(sops, yaml.MapSlice{
+		{Key: "lastmodified", Value: testLastModified},
+		{Key: "mac", Value: mac},
+		{Key: "version", Value: "3.7.3"},
+	}...)
+	data, err := yaml.Marshal(append(tree, yaml.MapItem{Key: metadataKey, Value: sops}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, path, data, modTime)
+}
+
+func writeFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// ageRecipients returns the age metadata of a file whose data key is
+// encrypted to recipients
+func ageRecipients(t *testing.T, dataKey []byte, recipients ...*ageIdentity) yaml.MapSlice {
+	var stanzas []interface{}
+	for _, recipient := range recipients {
+		stanzas = append(stanzas, yaml.MapSlice{
+			{Key: "recipient", Value: "age1test"},
+			{Key: "enc", Value: encryptAge(t, recipient.recipient, dataKey)},
+		})
+	}
+	return yaml.MapSlice{{Key: "age", Value: stanzas}}
+}
+
+func newDataKey() []byte {
+	dataKey := make([]byte, 32)
+	rand.Read(dataKey)
+	return dataKey
+}
+
+// newTestPGPKey returns an armored private key and a function that encrypts
+// messages to it
+func newTestPGPKey(t *testing.T) (string, func([]byte) string) {
+	entity, err := openpgp.NewEntity("secret-controller", "test", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// prefer SHA-256 over the default RIPEMD-160, which is not compiled in
+	for _, id := range entity.Identities {
+		id.SelfSignature.PreferredHash = []uint8{8}
+	}
+	var private bytes.Buffer
+	w, _ := armor.Encode(&private, openpgp.PrivateKeyType, nil)
+	if err := entity.SerializePrivate(w, nil); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	encrypt := func(plaintext []byte) string {
+		var message bytes.Buffer
+		w, _ := armor.Encode(&message, "PGP MESSAGE", nil)
+		p, err := openpgp.Encrypt(w, openpgp.EntityList{entity}, nil, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Write(plaintext)
+		p.Close()
+		w.Close()
+		return message.String()
+	}
+	return private.String(), encrypt
+}
+
+func TestClient_GetSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	repo := filepath.Join(dir, "repo")
+	keys := filepath.Join(dir, "keys")
+
+	ageKey, identity := newTestIdentity(t)
+	_, unknown := newTestIdentity(t)
+	pgpKey, encryptPGP := newTestPGPKey(t)
+	writeFile(t, filepath.Join(keys, "keys.txt"), []byte("# public key: age1...\n"+ageKey+"\n"), time.Now())
+	writeFile(t, filepath.Join(keys, "pgp.asc"), []byte(pgpKey), time.Now())
+	writeFile(t, filepath.Join(keys, "..data", "ignored"), []byte("AGE-SECRET-KEY-1INVALID"), time.Now())
+
+	dataKey := newDataKey()
+	tree, mac := testEncryption{dataKey: dataKey}.encrypt(t, yaml.MapSlice{
+		{Key: "db", Value: yaml.MapSlice{
+			{Key: "password", Value: "s3cret"},
+			{Key: "port", Value: 5432},
+			{Key: "tls", Value: true},
+			{Key: "hosts", Value: []interface{}{"db-0", "db-1"}},
+			{Key: "timeout", Value: 1.5},
+		}},
+		{Key: "description_unencrypted", Value: "plain"},
+		{Key: "empty", Value: nil},
+	})
+	writeEncryptedFile(t, filepath.Join(repo, "app.enc.yaml"), tree, mac, ageRecipients(t, dataKey, unknown, identity), time.Now())
+
+	pgpDataKey := newDataKey()
+	tree, mac = testEncryption{dataKey: pgpDataKey}.encrypt(t, yaml.MapSlice{{Key: "token", Value: "pgp-token"}})
+	writeEncryptedFile(t, filepath.Join(repo, "team", "pgp.enc.yaml"), tree, mac, yaml.MapSlice{
+		{Key: "pgp", Value: []interface{}{yaml.MapSlice{{Key: "fp", Value: "TEST"}, {Key: "enc", Value: encryptPGP(pgpDataKey)}}}},
+	}, time.Now())
+
+	otherDataKey := newDataKey()
+	tree, mac = testEncryption{dataKey: otherDataKey}.encrypt(t, yaml.MapSlice{{Key: "token", Value: "other"}})
+	writeEncryptedFile(t, filepath.Join(repo, "other.enc.yaml"), tree, mac, ageRecipients(t, otherDataKey, unknown), time.Now())
+	writeFile(t, filepath.Join(repo, "plain.yaml"), []byte("token: plain\n"), time.Now())
+
+	client, err := NewClient(repo, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		secret   string
+		version  string
+		want     string
+		wantErr  bool
+		notFound bool
+	}{
+		{name: "age", secret: "app.enc.yaml#db.password", want: "s3cret"},
+		{name: "int", secret: "app.enc.yaml#db.port", want: "5432"},
+		{name: "bool", secret: "app.enc.yaml#db.tls", want: "true"},
+		{name: "float", secret: "app.enc.yaml#db.timeout", want: "1.5"},
+		{name: "list item", secret: "app.enc.yaml#db.hosts.1", want: "db-1"},
+		{name: "list", secret: "app.enc.yaml#db.hosts", want: `["db-0","db-1"]`},
+		{name: "map", secret: "app.enc.yaml#db", want: `{"hosts":["db-0","db-1"],"password":"s3cret","port":5432,"timeout":1.5,"tls":true}`},
+		{name: "unencrypted", secret: "app.enc.yaml#description_unencrypted", want: "plain"},
+		{name: "null", secret: "app.enc.yaml#empty", want: ""},
+		{name: "pgp", secret: "team/pgp.enc.yaml#token", want: "pgp-token"},
+		{name: "missing key", secret: "app.enc.yaml#db.username", wantErr: true, notFound: true},
+		{name: "missing list item", secret: "app.enc.yaml#db.hosts.2", wantErr: true, notFound: true},
+		{name: "metadata", secret: "app.enc.yaml#sops.lastmodified", wantErr: true, notFound: true},
+		{name: "missing file", secret: "missing.enc.yaml#token", wantErr: true, notFound: true},
+		{name: "no matching key", secret: "other.enc.yaml#token", wantErr: true},
+		{name: "not encrypted", secret: "plain.yaml#token", wantErr: true},
+		{name: "no path", secret: "app.enc.yaml", wantErr: true},
+		{name: "file outside directory", secret: "../keys/keys.txt#token", wantErr: true},
+		{name: "version", secret: "app.enc.yaml#db.password", version: "1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := client.GetSecret(context.Background(), tt.secret, tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if secretstore.IsNotFound(err) != tt.notFound {
+				t.Errorf("GetSecret() error = %v, notFound %v", err, tt.notFound)
+			}
+			if err != nil {
+				return
+			}
+			if got.Name != tt.secret || got.Value != tt.want {
+				t.Errorf("GetSecret() = %s %q, want %s %q", got.Name, got.Value, tt.secret, tt.want)
+			}
+			if got.Created == nil || got.Created.Format(time.RFC3339) != testLastModified {
+				t.Errorf("GetSecret() created = %v, want %s", got.Created, testLastModified)
+			}
+		})
+	}
+}
+
+func TestClient_verify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ageKey, identity := newTestIdentity(t)
+	keys := filepath.Join(dir, "keys.txt")
+	writeFile(t, keys, []byte(ageKey+"\n"), time.Now())
+
+	dataKey := newDataKey()
+	plain := yaml.MapSlice{
+		{Key: "password", Value: "s3cret"},
+		{Key: "port", Value: 5432},
+		{Key: "owner_unencrypted", Value: "team-a"},
+	}
+	encryptedRegex := func(path []string) bool { return path[0] == "password" }
+	tests := []struct {
+		name       string
+		encryption testEncryption
+		rule       yaml.MapSlice
+		// tamper changes the encrypted tree after its MAC was computed
+		tamper  func(tree yaml.MapSlice) yaml.MapSlice
+		noMAC   bool
+		wantErr bool
+	}{
+		{name: "valid"},
+		{name: "value replaced with plaintext", tamper: func(tree yaml.MapSlice) yaml.MapSlice {
+			tree[0].Value = "hacked"
+			return tree
+		}, wantErr: true},
+		{name: "value moved to another key", tamper: func(tree yaml.MapSlice) yaml.MapSlice {
+			tree[1].Value = tree[0].Value
+			return tree
+		}, wantErr: true},
+		{name: "value removed", tamper: func(tree yaml.MapSlice) yaml.MapSlice {
+			return append(tree[:1], tree[2:]...)
+		}, wantErr: true},
+		{name: "unencrypted value added", tamper: func(tree yaml.MapSlice) yaml.MapSlice {
+			return append(tree, yaml.MapItem{Key: "admin_unencrypted", Value: "true"})
+		}, wantErr: true},
+		{name: "unencrypted value changed", tamper: func(tree yaml.MapSlice) yaml.MapSlice {
+			tree[2].Value = "team-b"
+			return tree
+		}, wantErr: true},
+		{name: "values reordered", tamper: func(tree yaml.MapSlice) yaml.MapSlice {
+			tree[0], tree[1] = tree[1], tree[0]
+			return tree
+		}, wantErr: true},
+		{name: "no MAC", noMAC: true, wantErr: true},
+		{name: "encrypted_regex", encryption: testEncryption{encrypted: encryptedRegex},
+			rule: yaml.MapSlice{{Key: "encrypted_regex", Value: "^password$"}}},
+		{name: "encrypted_regex with plaintext", encryption: testEncryption{encrypted: encryptedRegex},
+			rule: yaml.MapSlice{{Key: "encrypted_regex", Value: "^password$"}},
+			tamper: func(tree yaml.MapSlice) yaml.MapSlice {
+				tree[0].Value = "hacked"
+				return tree
+			}, wantErr: true},
+		{name: "unencrypted value changed with mac_only_encrypted", encryption: testEncryption{macOnlyEncrypted: true},
+			rule: yaml.MapSlice{{Key: "mac_only_encrypted", Value: true}},
+			tamper: func(tree yaml.MapSlice) yaml.MapSlice {
+				tree[2].Value = "team-b"
+				return tree
+			}},
+		{name: "mac_only_encrypted removed", encryption: testEncryption{macOnlyEncrypted: true}, wantErr: true},
+		{name: "several rules", rule: yaml.MapSlice{
+			{Key: "unencrypted_suffix", Value: "_unencrypted"},
+			{Key: "encrypted_regex", Value: "^password$"},
+		}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := filepath.Join(dir, strings.Replace(tt.name, " ", "-", -1))
+			encryption := tt.encryption
+			encryption.dataKey = dataKey
+			tree, mac := encryption.encrypt(t, append(yaml.MapSlice{}, plain...))
+			if tt.tamper != nil {
+				tree = tt.tamper(tree)
+			}
+			if tt.noMAC {
+				mac = ""
+			}
+			writeEncryptedFile(t, filepath.Join(repo, "app.enc.yaml"), tree, mac, append(ageRecipients(t, dataKey, identity), tt.rule...), time.Now())
+			client, err := NewClient(repo, keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := client.GetSecretValue(context.Background(), "app.enc.yaml#password")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetSecretValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got != "s3cret" {
+				t.Errorf("GetSecretValue() = %q, want s3cret", got)
+			}
+		})
+	}
+}
+
+func TestClient_reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	repo := filepath.Join(dir, "repo")
+	keys := filepath.Join(dir, "keys")
+	path := filepath.Join(repo, "app.enc.yaml")
+
+	oldKey, oldIdentity := newTestIdentity(t)
+	newKey, newIdentity := newTestIdentity(t)
+	writeFile(t, filepath.Join(keys, "keys.txt"), []byte(oldKey+"\n"), time.Now())
+	write := func(value string, identity *ageIdentity, modTime time.Time) {
+		dataKey := newDataKey()
+		tree, mac := testEncryption{dataKey: dataKey}.encrypt(t, yaml.MapSlice{{Key: "password", Value: value}})
+		writeEncryptedFile(t, path, tree, mac, ageRecipients(t, dataKey, identity), modTime)
+	}
+	write("old", oldIdentity, time.Now().Add(-time.Hour))
+
+	client, err := NewClient(repo, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := client.GetSecretValue(context.Background(), "app.enc.yaml#password"); err != nil || got != "old" {
+		t.Fatalf("GetSecretValue() = %q, %v, want old", got, err)
+	}
+
+	// the file is re-encrypted with a key that is not known yet
+	write("new", newIdentity, time.Now())
+	if _, err := client.GetSecretValue(context.Background(), "app.enc.yaml#password"); err == nil {
+		t.Fatalf("GetSecretValue() decrypted a file without its key")
+	}
+	writeFile(t, filepath.Join(keys, "new.txt"), []byte(newKey+"\n"), time.Now())
+	if got, err := client.GetSecretValue(context.Background(), "app.enc.yaml#password"); err != nil || got != "new" {
+		t.Errorf("GetSecretValue() = %q, %v, want new", got, err)
+	}
+}
+
+// TestClient_fixtures decrypts the files in testdata/sops/repo, which were
+// encrypted with sops 3.9.0 to the keys in testdata/sops/keys
+func TestClient_fixtures(t *testing.T) {
+	client, err := NewClient(filepath.Join("testdata", "sops", "repo"), filepath.Join("testdata", "sops", "keys"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		secret string
+		want   string
+	}{
+		{secret: "app.enc.yaml#db.password", want: "s3cret"},
+		{secret: "app.enc.yaml#db.port", want: "5432"},
+		{secret: "app.enc.yaml#db.tls", want: "true"},
+		{secret: "app.enc.yaml#db.timeout", want: "1.5"},
+		{secret: "app.enc.yaml#db.hosts", want: `["db-0","db-1"]`},
+		{secret: "app.enc.yaml#description_unencrypted", want: "plain"},
+		{secret: "app.enc.json#db.password", want: "s3cret"},
+		{secret: "app.enc.json#db.port", want: "5432"},
+		{secret: "app.enc.json#db.hosts.1", want: "db-1"},
+		{secret: "app.enc.json#description_unencrypted", want: "plain"},
+		{secret: "regex.enc.yaml#password", want: "s3cret"},
+		{secret: "regex.enc.yaml#owner", want: "team-a"},
+		{secret: "pgp.enc.yaml#token", want: "pgp-token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.secret, func(t *testing.T) {
+			got, err := client.GetSecretValue(context.Background(), tt.secret)
+			if err != nil {
+				t.Fatalf("GetSecretValue() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("GetSecretValue() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+
+	keyvaultsecretv1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+	"github.com/twendt/secret-controller/pkg/policy"
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+const (
+	// SecretPushed is used as part of the Event 'reason' when a key of a
+	// Secret is written to Key Vault
+	SecretPushed = "Pushed"
+	// ErrPushConflict is used as part of the Event 'reason' when a Key Vault
+	// secret exists that is not managed by the PushSecret
+	ErrPushConflict = "ErrPushConflict"
+	// ErrPushSourceMissing is used as part of the Event 'reason' when the
+	// Secret or key of a PushSecret does not exist
+	ErrPushSourceMissing = "ErrPushSourceMissing"
+
+	// MessageSecretPushed is the message used for an Event fired when a key
+	// of a Secret is written to Key Vault
+	MessageSecretPushed = "Key %q written to Key Vault secret %q version %q"
+	// MessagePushConflict is the message used for an Event fired when a Key
+	// Vault secret exists that is not managed by the PushSecret
+	MessagePushConflict = "Key Vault secret %q already exists and is not managed by PushSecret"
+	// MessagePushSecretMissing is the message used for an Event fired when
+	// the Secret of a PushSecret does not exist
+	MessagePushSecretMissing = "Secret %q does not exist"
+	// MessagePushKeyMissing is the message used for an Event fired when a
+	// key of a PushSecret does not exist in its Secret
+	MessagePushKeyMissing = "Secret %q has no key %q"
+)
+
+const (
+	// managedByTag is set on all Key Vault secrets written by the controller
+	managedByTag = "managed-by"
+	// pushSecretTag holds the namespace/name of the PushSecret that wrote a
+	// Key Vault secret
+	pushSecretTag = "push-secret"
+)
+
+// pushSecretFinalizer keeps a PushSecret with deletion policy Delete until
+// its Key Vault secrets have been deleted
+var pushSecretFinalizer = keyvaultsecretv1alpha1.SchemeGroupVersion.Group + "/push-secret"
+
+// pushSecretHandler writes the keys of the Secret of a PushSecret to Key
+// Vault and deletes the Key Vault secrets that are no longer pushed if the
+// deletion policy asks for it
+func (c *Controller) pushSecretHandler(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("invalid resource key: %s", key))
+		return nil
+	}
+
+	pushSecret, err := c.pushSecretsLister.PushSecrets(namespace).Get(name)
+	if errors.IsNotFound(err) {
+		c.logger.Infof("push secret key '%s' deleted", key)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	storeClient, err := c.storeClientFor(namespace, pushSecret.Spec.ServiceAccountName)
+	if err != nil {
+		return err
+	}
+	writer, ok := storeClient.(secretstore.Writer)
+	if !ok {
+		return fmt.Errorf("secret store does not support writing secrets")
+	}
+
+	err = c.syncPushSecret(ctx, pushSecret, storeClient, writer)
+	if policy.IsAccessDenied(err) {
+		c.recorder.Event(pushSecret, corev1.EventTypeWarning, ErrAccessDenied, err.Error())
+	}
+	return err
+}
+
+func (c *Controller) syncPushSecret(ctx context.Context, pushSecret *keyvaultsecretv1alpha1.PushSecret, storeClient secretstore.Client, writer secretstore.Writer) error {
+	deleteSecrets := pushSecret.Spec.GetDeletionPolicy() == keyvaultsecretv1alpha1.DeletionPolicyDelete
+	if pushSecret.DeletionTimestamp != nil {
+		if !hasFinalizer(pushSecret, pushSecretFinalizer) {
+			return nil
+		}
+		for _, item := range pushSecret.Status.Items {
+			if err := c.deletePushedSecret(ctx, pushSecret, storeClient, writer, item.KeyvaultName); err != nil {
+				return err
+			}
+		}
+		return c.setPushSecretFinalizer(pushSecret, false)
+	}
+	// the finalizer is only needed to delete the Key Vault secrets
+	if deleteSecrets != hasFinalizer(pushSecret, pushSecretFinalizer) {
+		return c.setPushSecretFinalizer(pushSecret, deleteSecrets)
+	}
+
+	if _, err := pushSecret.Spec.IsValid(); err != nil {
+		return err
+	}
+	secret, err := c.secretsLister.Secrets(pushSecret.Namespace).Get(pushSecret.Spec.SecretName)
+	if errors.IsNotFound(err) {
+		// the PushSecret is enqueued again once the Secret is created
+		c.recorder.Eventf(pushSecret, corev1.EventTypeWarning, ErrPushSourceMissing, MessagePushSecretMissing, pushSecret.Spec.SecretName)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var status keyvaultsecretv1alpha1.PushSecretStatus
+	pushed := make(map[string]bool)
+	for _, item := range pushSecret.Spec.Items {
+		value, ok := secret.Data[item.KubernetesName]
+		if !ok {
+			msg := fmt.Sprintf(MessagePushKeyMissing, secret.Name, item.KubernetesName)
+			c.recorder.Event(pushSecret, corev1.EventTypeWarning, ErrPushSourceMissing, msg)
+			return stderrors.New(msg)
+		}
+		statusItem := keyvaultsecretv1alpha1.PushSecretStatusItem{
+			KeyvaultName: item.KeyvaultName,
+			ContentHash:  hashData(c.config.ContentHashKey, map[string][]byte{"value": value, "contentType": []byte(item.ContentType)}),
+		}
+		if previous := findPushStatusItem(pushSecret.Status, item.KeyvaultName); previous != nil && previous.ContentHash == statusItem.ContentHash {
+			statusItem.Version = previous.Version
+		} else {
+			statusItem.Version, err = c.pushItem(ctx, pushSecret, storeClient, writer, item, string(value))
+			if err != nil {
+				return err
+			}
+		}
+		status.Items = append(status.Items, statusItem)
+		pushed[item.KeyvaultName] = true
+	}
+
+	for _, item := range pushSecret.Status.Items {
+		if pushed[item.KeyvaultName] || !deleteSecrets {
+			continue
+		}
+		if err := c.deletePushedSecret(ctx, pushSecret, storeClient, writer, item.KeyvaultName); err != nil {
+			return err
+		}
+	}
+	return c.updatePushSecretStatus(pushSecret, status)
+}
+
+// pushItem writes value to the Key Vault secret of item and returns the
+// version that holds it. No new version is written if the latest version
+// already holds the value.
+func (c *Controller) pushItem(ctx context.Context, pushSecret *keyvaultsecretv1alpha1.PushSecret, storeClient secretstore.Client, writer secretstore.Writer, item keyvaultsecretv1alpha1.PushSecretEntry, value string) (string, error) {
+	tags := make(map[string]string)
+	existing, err := storeClient.GetSecret(ctx, item.KeyvaultName, "")
+	if err != nil && !secretstore.IsNotFound(err) {
+		return "", err
+	}
+	if err == nil {
+		managed := isPushedBy(existing, pushSecret)
+		if !managed && pushSecret.Spec.GetConflictPolicy() != keyvaultsecretv1alpha1.ConflictPolicyOverwrite {
+			msg := fmt.Sprintf(MessagePushConflict, item.KeyvaultName)
+			c.recorder.Event(pushSecret, corev1.EventTypeWarning, ErrPushConflict, msg)
+			return "", stderrors.New(msg)
+		}
+		if managed && existing.Value == value && existing.ContentType == item.ContentType {
+			return existing.Version, nil
+		}
+		// tags set by others are kept
+		for key, value := range existing.Tags {
+			tags[key] = value
+		}
+	}
+	tags[managedByTag] = controllerAgentName
+	tags[pushSecretTag] = pushSecret.Namespace + "/" + pushSecret.Name
+
+	written, err := writer.SetSecret(ctx, &secretstore.Secret{
+		Name:        item.KeyvaultName,
+		Value:       value,
+		ContentType: item.ContentType,
+		Tags:        tags,
+	})
+	if err != nil {
+		return "", err
+	}
+	c.recorder.Eventf(pushSecret, corev1.EventTypeNormal, SecretPushed, MessageSecretPushed, item.KubernetesName, item.KeyvaultName, written.Version)
+	return written.Version, nil
+}
+
+// deletePushedSecret deletes a Key Vault secret unless it has been taken
+// over by someone else since it was written
+func (c *Controller) deletePushedSecret(ctx context.Context, pushSecret *keyvaultsecretv1alpha1.PushSecret, storeClient secretstore.Client, writer secretstore.Writer, name string) error {
+	existing, err := storeClient.GetSecret(ctx, name, "")
+	if secretstore.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !isPushedBy(existing, pushSecret) {
+		c.logger.Infof("not deleting Key Vault secret '%s' that is not managed by push secret '%s/%s'", name, pushSecret.Namespace, pushSecret.Name)
+		return nil
+	}
+	err = writer.DeleteSecret(ctx, name)
+	if secretstore.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// setPushSecretFinalizer adds or removes the finalizer of pushSecret
+func (c *Controller) setPushSecretFinalizer(pushSecret *keyvaultsecretv1alpha1.PushSecret, add bool) error {
+	// NEVER modify objects from the store. It's a read-only, local cache.
+	pushSecretCopy := pushSecret.DeepCopy()
+	pushSecretCopy.Finalizers = nil
+	for _, finalizer := range pushSecret.Finalizers {
+		if finalizer != pushSecretFinalizer {
+			pushSecretCopy.Finalizers = append(pushSecretCopy.Finalizers, finalizer)
+		}
+	}
+	if add {
+		pushSecretCopy.Finalizers = append(pushSecretCopy.Finalizers, pushSecretFinalizer)
+	}
+	_, err := c.crdclientset.SecretcontrollerV1alpha1().PushSecrets(pushSecret.Namespace).Update(pushSecretCopy)
+	return err
+}
+
+// updatePushSecretStatus writes status to pushSecret if it changed. Like
+// KeyvaultSecrets, PushSecrets have no status subresource.
+func (c *Controller) updatePushSecretStatus(pushSecret *keyvaultsecretv1alpha1.PushSecret, status keyvaultsecretv1alpha1.PushSecretStatus) error {
+	if reflect.DeepEqual(pushSecret.Status, status) {
+		return nil
+	}
+	// NEVER modify objects from the store. It's a read-only, local cache.
+	pushSecretCopy := pushSecret.DeepCopy()
+	pushSecretCopy.Status = status
+	_, err := c.crdclientset.SecretcontrollerV1alpha1().PushSecrets(pushSecret.Namespace).Update(pushSecretCopy)
+	return err
+}
+
+// handlePushedSecret enqueues all PushSecrets that push the given Secret
+func (c *Controller) handlePushedSecret(obj interface{}) {
+	secret, ok := obj.(*corev1.Secret)
+	if !ok {
+		return
+	}
+	pushSecrets, err := c.pushSecretsLister.PushSecrets(secret.Namespace).List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	for _, pushSecret := range pushSecrets {
+		if pushSecret.Spec.SecretName == secret.Name {
+			c.enqueuePushSecret(pushSecret)
+		}
+	}
+}
+
+func (c *Controller) enqueuePushSecret(obj interface{}) {
+	var key string
+	var err error
+	if key, err = cache.MetaNamespaceKeyFunc(obj); err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	c.pushWorkqueue.AddRateLimited(key)
+}
+
+// isPushedBy reports whether secret was written by pushSecret
+func isPushedBy(secret *secretstore.Secret, pushSecret *keyvaultsecretv1alpha1.PushSecret) bool {
+	return secret.Tags[managedByTag] == controllerAgentName &&
+		secret.Tags[pushSecretTag] == pushSecret.Namespace+"/"+pushSecret.Name
+}
+
+func findPushStatusItem(status keyvaultsecretv1alpha1.PushSecretStatus, keyvaultName string) *keyvaultsecretv1alpha1.PushSecretStatusItem {
+	for i := range status.Items {
+		if status.Items[i].KeyvaultName == keyvaultName {
+			return &status.Items[i]
+		}
+	}
+	return nil
+}
+
+func hasFinalizer(pushSecret *keyvaultsecretv1alpha1.PushSecret, finalizer string) bool {
+	for _, f := range pushSecret.Finalizers {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
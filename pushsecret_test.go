@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	keyvaultsecretv1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+	"github.com/twendt/secret-controller/pkg/client/clientset/versioned/fake"
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+// testWritableStore is an in-memory secret store that keeps the latest
+// version of every secret
+type testWritableStore struct {
+	secrets map[string]*secretstore.Secret
+	writes  int
+}
+
+func (s *testWritableStore) GetSecretValue(ctx context.Context, name string) (string, error) {
+	return s.GetSecretValueForVersion(ctx, name, "")
+}
+
+func (s *testWritableStore) GetSecretValueForVersion(ctx context.Context, name, version string) (string, error) {
+	secret, err := s.GetSecret(ctx, name, version)
+	if err != nil {
+		return "", err
+	}
+	return secret.Value, nil
+}
+
+func (s *testWritableStore) GetSecret(ctx context.Context, name, version string) (*secretstore.Secret, error) {
+	secret, ok := s.secrets[name]
+	if !ok {
+		return nil, &secretstore.NotFoundError{Name: name, Version: version}
+	}
+	return secret, nil
+}
+
+func (s *testWritableStore) SetSecret(ctx context.Context, secret *secretstore.Secret) (*secretstore.Secret, error) {
+	s.writes++
+	written := *secret
+	written.Version = strconv.Itoa(s.writes)
+	s.secrets[secret.Name] = &written
+	return &written, nil
+}
+
+func (s *testWritableStore) DeleteSecret(ctx context.Context, name string) error {
+	if _, ok := s.secrets[name]; !ok {
+		return &secretstore.NotFoundError{Name: name}
+	}
+	delete(s.secrets, name)
+	return nil
+}
+
+func Test_syncPushSecret(t *testing.T) {
+	managedTags := map[string]string{managedByTag: controllerAgentName, pushSecretTag: "test-namespace/test-push"}
+	foreignTags := map[string]string{"owner": "someone-else"}
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "test-namespace"},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	}
+	passwordHash := hashData(nil, map[string][]byte{"value": []byte("secret"), "contentType": nil})
+
+	tests := []struct {
+		name           string
+		conflictPolicy keyvaultsecretv1alpha1.ConflictPolicy
+		deletionPolicy keyvaultsecretv1alpha1.DeletionPolicy
+		items          []keyvaultsecretv1alpha1.PushSecretEntry
+		status         keyvaultsecretv1alpha1.PushSecretStatus
+		existing       map[string]*secretstore.Secret
+		wantErr        bool
+		wantWrites     int
+		wantSecrets    []string
+		wantTags       map[string]string
+	}{
+		{
+			name:        "new secret",
+			items:       []keyvaultsecretv1alpha1.PushSecretEntry{{KubernetesName: "password", KeyvaultName: "db-password"}},
+			wantWrites:  1,
+			wantSecrets: []string{"db-password"},
+			wantTags:    managedTags,
+		},
+		{
+			name:        "conflict with foreign secret",
+			items:       []keyvaultsecretv1alpha1.PushSecretEntry{{KubernetesName: "password", KeyvaultName: "db-password"}},
+			existing:    map[string]*secretstore.Secret{"db-password": {Name: "db-password", Value: "other", Tags: foreignTags}},
+			wantErr:     true,
+			wantSecrets: []string{"db-password"},
+			wantTags:    foreignTags,
+		},
+		{
+			name:           "overwrite foreign secret",
+			conflictPolicy: keyvaultsecretv1alpha1.ConflictPolicyOverwrite,
+			items:          []keyvaultsecretv1alpha1.PushSecretEntry{{KubernetesName: "password", KeyvaultName: "db-password"}},
+			existing:       map[string]*secretstore.Secret{"db-password": {Name: "db-password", Value: "other", Tags: foreignTags}},
+			wantWrites:     1,
+			wantSecrets:    []string{"db-password"},
+			wantTags:       map[string]string{"owner": "someone-else", managedByTag: controllerAgentName, pushSecretTag: "test-namespace/test-push"},
+		},
+		{
+			name:  "unchanged value",
+			items: []keyvaultsecretv1alpha1.PushSecretEntry{{KubernetesName: "password", KeyvaultName: "db-password"}},
+			status: keyvaultsecretv1alpha1.PushSecretStatus{Items: []keyvaultsecretv1alpha1.PushSecretStatusItem{
+				{KeyvaultName: "db-password", Version: "1", ContentHash: passwordHash},
+			}},
+			existing:    map[string]*secretstore.Secret{"db-password": {Name: "db-password", Value: "secret", Tags: managedTags}},
+			wantSecrets: []string{"db-password"},
+			wantTags:    managedTags,
+		},
+		{
+			name:           "removed item is deleted",
+			deletionPolicy: keyvaultsecretv1alpha1.DeletionPolicyDelete,
+			status: keyvaultsecretv1alpha1.PushSecretStatus{Items: []keyvaultsecretv1alpha1.PushSecretStatusItem{
+				{KeyvaultName: "db-password", Version: "1", ContentHash: passwordHash},
+			}},
+			existing: map[string]*secretstore.Secret{"db-password": {Name: "db-password", Value: "secret", Tags: managedTags}},
+		},
+		{
+			name: "removed item is retained",
+			status: keyvaultsecretv1alpha1.PushSecretStatus{Items: []keyvaultsecretv1alpha1.PushSecretStatusItem{
+				{KeyvaultName: "db-password", Version: "1", ContentHash: passwordHash},
+			}},
+			existing:    map[string]*secretstore.Secret{"db-password": {Name: "db-password", Value: "secret", Tags: managedTags}},
+			wantSecrets: []string{"db-password"},
+			wantTags:    managedTags,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pushSecret := &keyvaultsecretv1alpha1.PushSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-push", Namespace: "test-namespace"},
+				Spec: keyvaultsecretv1alpha1.PushSecretSpec{
+					SecretName:     "db",
+					Items:          tt.items,
+					ConflictPolicy: tt.conflictPolicy,
+					DeletionPolicy: tt.deletionPolicy,
+				},
+				Status: tt.status,
+			}
+			if tt.deletionPolicy == keyvaultsecretv1alpha1.DeletionPolicyDelete {
+				pushSecret.Finalizers = []string{pushSecretFinalizer}
+			}
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			indexer.Add(source)
+			c := &Controller{
+				crdclientset:  fake.NewSimpleClientset(pushSecret),
+				secretsLister: corelisters.NewSecretLister(indexer),
+				recorder:      record.NewFakeRecorder(10),
+				logger:        logrus.NewEntry(logrus.New()),
+			}
+			store := &testWritableStore{secrets: make(map[string]*secretstore.Secret)}
+			for name, secret := range tt.existing {
+				store.secrets[name] = secret
+			}
+
+			err := c.syncPushSecret(context.Background(), pushSecret, store, store)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("syncPushSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if store.writes != tt.wantWrites {
+				t.Errorf("syncPushSecret() wrote %d times, want %d", store.writes, tt.wantWrites)
+			}
+			if len(store.secrets) != len(tt.wantSecrets) {
+				t.Fatalf("syncPushSecret() left %d secrets, want %v", len(store.secrets), tt.wantSecrets)
+			}
+			for _, name := range tt.wantSecrets {
+				secret, ok := store.secrets[name]
+				if !ok {
+					t.Fatalf("syncPushSecret() deleted secret %q", name)
+				}
+				if len(secret.Tags) != len(tt.wantTags) {
+					t.Errorf("syncPushSecret() tags = %v, want %v", secret.Tags, tt.wantTags)
+				}
+				for key, value := range tt.wantTags {
+					if secret.Tags[key] != value {
+						t.Errorf("syncPushSecret() tags = %v, want %v", secret.Tags, tt.wantTags)
+					}
+				}
+			}
+		})
+	}
+}
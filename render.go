@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+
+	keyvaultsecretv1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+// redactedValue replaces the values of rendered Secrets unless they are
+// revealed
+const redactedValue = "<redacted>"
+
+// runRender implements the render subcommand, which prints the Secrets of
+// the KeyvaultSecrets in a file without talking to Kubernetes. It returns the
+// exit code.
+func runRender(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("render", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	file := flags.String("f", "", "Path to a YAML file with one or more KeyvaultSecrets, - reads from stdin")
+	var store storeConfig
+	store.addFlags(flags)
+	valuesFile := flags.String("values", "", "Path to a YAML or JSON file with secrets, short for --store file --store-path")
+	reveal := flags.Bool("reveal", false, "Print the values of the Secrets instead of redacting them")
+	timeout := flags.Duration("timeout", 30*time.Second, "Maximum time allowed to render all KeyvaultSecrets")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *file == "" {
+		fmt.Fprintln(stderr, "render requires -f")
+		flags.Usage()
+		return 2
+	}
+	if *valuesFile != "" {
+		store.store = "file"
+		store.path = *valuesFile
+	}
+
+	storeClient, _, err := store.newClient()
+	if err != nil {
+		fmt.Fprintf(stderr, "Error creating secret store: %s\n", err)
+		return 1
+	}
+	// rendering must never create secrets through a generator
+	storeClient = readOnlyClient{storeClient}
+
+	keyvaultSecrets, err := readKeyvaultSecrets(*file)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error reading %s: %s\n", *file, err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	exitCode := 0
+	printed := false
+	for _, keyvaultSecret := range keyvaultSecrets {
+		converter := SecretConverter{ctx: ctx, keyvaultSecret: keyvaultSecret, storeClient: storeClient, strict: true}
+		secret, err := converter.getK8sSecret()
+		if err != nil {
+			fmt.Fprintf(stderr, "Error rendering KeyvaultSecret %s/%s: %s\n", keyvaultSecret.Namespace, keyvaultSecret.Name, err)
+			exitCode = 1
+			continue
+		}
+		out, err := yaml.Marshal(renderedSecret(secret, *reveal))
+		if err != nil {
+			fmt.Fprintf(stderr, "Error rendering KeyvaultSecret %s/%s: %s\n", keyvaultSecret.Namespace, keyvaultSecret.Name, err)
+			exitCode = 1
+			continue
+		}
+		if printed {
+			fmt.Fprintln(stdout, "---")
+		}
+		stdout.Write(out)
+		printed = true
+	}
+	return exitCode
+}
+
+// readKeyvaultSecrets returns the KeyvaultSecrets in a YAML or JSON file.
+// Documents of other kinds are skipped.
+func readKeyvaultSecrets(file string) ([]*keyvaultsecretv1alpha1.KeyvaultSecret, error) {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var keyvaultSecrets []*keyvaultsecretv1alpha1.KeyvaultSecret
+	decoder := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		keyvaultSecret := &keyvaultsecretv1alpha1.KeyvaultSecret{}
+		err := decoder.Decode(keyvaultSecret)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if keyvaultSecret.Kind != "KeyvaultSecret" {
+			continue
+		}
+		if keyvaultSecret.Namespace == "" {
+			keyvaultSecret.Namespace = "default"
+		}
+		keyvaultSecrets = append(keyvaultSecrets, keyvaultSecret)
+	}
+	return keyvaultSecrets, nil
+}
+
+// renderedSecret returns a copy of secret for printing, with the values in
+// stringData so they are readable when revealed
+func renderedSecret(secret *corev1.Secret, reveal bool) *corev1.Secret {
+	rendered := secret.DeepCopy()
+	rendered.APIVersion = "v1"
+	rendered.Kind = "Secret"
+	rendered.Data = nil
+	rendered.StringData = make(map[string]string, len(secret.Data))
+	for key, value := range secret.Data {
+		if reveal {
+			rendered.StringData[key] = string(value)
+		} else {
+			rendered.StringData[key] = redactedValue
+		}
+	}
+	return rendered
+}
+
+// readOnlyClient hides the secretstore.Writer implementation of a client
+type readOnlyClient struct {
+	secretstore.Client
+}
+
+func (c readOnlyClient) ListVersions(ctx context.Context, name string) ([]*secretstore.Secret, error) {
+	lister, ok := c.Client.(secretstore.VersionLister)
+	if !ok {
+		return nil, fmt.Errorf("secret store does not support listing versions")
+	}
+	return lister.ListVersions(ctx, name)
+}
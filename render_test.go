@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_runRender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "render")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	valuesFile := filepath.Join(dir, "values.yaml")
+	if err := ioutil.WriteFile(valuesFile, []byte("password:\n  value: s3cret\n  versions:\n    v1: old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		manifest     string
+		reveal       bool
+		wantExitCode int
+		wantOut      []string
+	}{
+		{
+			name: "redacted",
+			manifest: `apiVersion: secretcontroller.twendt.de/v1alpha1
+kind: KeyvaultSecret
+metadata:
+  name: app
+spec:
+  items:
+    - kubernetesName: password
+      keyvaultName: password
+`,
+			wantOut: []string{"kind: Secret", "name: app", "namespace: default", "password: <redacted>"},
+		},
+		{
+			name:   "revealed template and pinned version",
+			reveal: true,
+			manifest: `apiVersion: secretcontroller.twendt.de/v1alpha1
+kind: KeyvaultSecret
+metadata:
+  name: app
+spec:
+  items:
+    - kubernetesName: url
+      secretTemplate: 'postgres://app:[[ secretValue "password" ]]@db'
+    - kubernetesName: old
+      keyvaultName: password
+      keyvaultVersion: v1
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: ignored
+`,
+			wantOut: []string{"url: postgres://app:s3cret@db", "old: old"},
+		},
+		{
+			name: "missing secret in template",
+			manifest: `apiVersion: secretcontroller.twendt.de/v1alpha1
+kind: KeyvaultSecret
+metadata:
+  name: app
+spec:
+  items:
+    - kubernetesName: url
+      secretTemplate: '[[ secretValue "missing" ]]'
+`,
+			wantExitCode: 1,
+		},
+		{
+			name: "invalid entry",
+			manifest: `apiVersion: secretcontroller.twendt.de/v1alpha1
+kind: KeyvaultSecret
+metadata:
+  name: app
+spec:
+  items:
+    - kubernetesName: password
+`,
+			wantExitCode: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifestFile := filepath.Join(dir, "keyvaultsecret.yaml")
+			if err := ioutil.WriteFile(manifestFile, []byte(tt.manifest), 0600); err != nil {
+				t.Fatal(err)
+			}
+			args := []string{"-f", manifestFile, "--values", valuesFile}
+			if tt.reveal {
+				args = append(args, "--reveal")
+			}
+			var stdout, stderr bytes.Buffer
+			if got := runRender(args, &stdout, &stderr); got != tt.wantExitCode {
+				t.Fatalf("runRender() = %d, want %d, stderr: %s", got, tt.wantExitCode, stderr.String())
+			}
+			for _, want := range tt.wantOut {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("runRender() output does not contain %q:\n%s", want, stdout.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+
+	keyvaultsecretv1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+	clientset "github.com/twendt/secret-controller/pkg/client/clientset/versioned"
+)
+
+const (
+	// RolledBack is used as part of the Event 'reason' when the entries of a
+	// KeyvaultSecret are pinned to their previous versions
+	RolledBack = "RolledBack"
+	// ErrRollback is used as part of the Event 'reason' when a rollback is
+	// requested but no previous versions are known
+	ErrRollback = "ErrRollback"
+
+	// MessageRolledBack is the message used for an Event fired when the
+	// entries of a KeyvaultSecret are pinned to their previous versions
+	MessageRolledBack = "Pinned %d entries to their previous versions"
+	// MessageRollbackFailed is the message used for an Event fired when a
+	// rollback is requested but no previous versions are known
+	MessageRollbackFailed = "Rollback requested, but no previous versions are known"
+)
+
+// rollbackAnnotation requests a rollback of a KeyvaultSecret. The
+// controller removes it once the rollback is done.
+var rollbackAnnotation = keyvaultsecretv1alpha1.SchemeGroupVersion.Group + "/rollback"
+
+// rollback pins the entries of keyvaultSecret to the versions recorded in
+// Status.PreviousItems, except the ones with a generator, and removes the
+// rollback annotation. The update
+// triggers a sync with the pinned versions.
+func (c *Controller) rollback(keyvaultSecret *keyvaultsecretv1alpha1.KeyvaultSecret) error {
+	// NEVER modify objects from the store. It's a read-only, local cache.
+	keyvaultSecretCopy := keyvaultSecret.DeepCopy()
+	delete(keyvaultSecretCopy.Annotations, rollbackAnnotation)
+
+	pinned := 0
+	for i, item := range keyvaultSecretCopy.Spec.Items {
+		for _, previous := range keyvaultSecret.Status.PreviousItems {
+			if previous.KubernetesName != item.KubernetesName || previous.KeyvaultName != item.KeyvaultName || previous.Version == "" {
+				continue
+			}
+			// a generator cannot be combined with a pinned version, and
+			// generated secrets are not replaced by the controller anyway
+			if item.Generator != nil {
+				continue
+			}
+			keyvaultSecretCopy.Spec.Items[i].KeyvaultVersion = previous.Version
+			keyvaultSecretCopy.Spec.Items[i].VersionPolicy = keyvaultsecretv1alpha1.VersionPolicy{Type: keyvaultsecretv1alpha1.VersionPolicyPinned}
+			pinned++
+		}
+	}
+
+	_, err := c.crdclientset.SecretcontrollerV1alpha1().KeyvaultSecrets(keyvaultSecret.Namespace).Update(keyvaultSecretCopy)
+	if err != nil {
+		return err
+	}
+	if pinned == 0 {
+		c.recorder.Event(keyvaultSecret, corev1.EventTypeWarning, ErrRollback, MessageRollbackFailed)
+	} else {
+		c.recorder.Eventf(keyvaultSecret, corev1.EventTypeNormal, RolledBack, MessageRolledBack, pinned)
+	}
+	return nil
+}
+
+// runRollback implements the rollback subcommand, which requests a rollback
+// of a KeyvaultSecret by setting the rollback annotation. It returns the exit
+// code.
+func runRollback(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	kubeconfig := flags.String("kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
+	masterURL := flags.String("master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(stderr, "usage: secret-controller rollback [flags] <namespace>/<name>")
+		flags.PrintDefaults()
+		return 2
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(flags.Arg(0))
+	if err != nil || namespace == "" {
+		fmt.Fprintf(stderr, "Invalid KeyvaultSecret '%s', expected namespace/name\n", flags.Arg(0))
+		return 2
+	}
+
+	cfg, err := clientcmd.BuildConfigFromFlags(*masterURL, *kubeconfig)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error building kubeconfig: %s\n", err)
+		return 1
+	}
+	crdClient, err := clientset.NewForConfig(cfg)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error building clientset: %s\n", err)
+		return 1
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				rollbackAnnotation: time.Now().UTC().Format(time.RFC3339),
+			},
+		},
+	})
+	if err != nil {
+		fmt.Fprintf(stderr, "Error creating patch: %s\n", err)
+		return 1
+	}
+	_, err = crdClient.SecretcontrollerV1alpha1().KeyvaultSecrets(namespace).Patch(name, types.MergePatchType, patch)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error requesting rollback of %s/%s: %s\n", namespace, name, err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "Rollback of %s/%s requested\n", namespace, name)
+	return 0
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	keyvaultsecretv1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+	"github.com/twendt/secret-controller/pkg/client/clientset/versioned/fake"
+)
+
+func Test_rollback(t *testing.T) {
+	tests := []struct {
+		name          string
+		previousItems []keyvaultsecretv1alpha1.KeyvaultSecretStatusItem
+		wantVersions  []string
+		wantReason    string
+	}{
+		{
+			name: "pins previous versions",
+			previousItems: []keyvaultsecretv1alpha1.KeyvaultSecretStatusItem{
+				{KubernetesName: "password", KeyvaultName: "db-password", Version: "v1"},
+			},
+			wantVersions: []string{"v1", "", ""},
+			wantReason:   RolledBack,
+		},
+		{
+			name:         "no previous versions",
+			wantVersions: []string{"", "", ""},
+			wantReason:   ErrRollback,
+		},
+		{
+			name: "generated secret",
+			previousItems: []keyvaultsecretv1alpha1.KeyvaultSecretStatusItem{
+				{KubernetesName: "token", KeyvaultName: "api-token", Version: "v1"},
+			},
+			wantVersions: []string{"", "", ""},
+			wantReason:   ErrRollback,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyvaultSecret := &keyvaultsecretv1alpha1.KeyvaultSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-secret",
+					Namespace:   "test-namespace",
+					Annotations: map[string]string{rollbackAnnotation: "2019-01-01T00:00:00Z"},
+				},
+				Spec: keyvaultsecretv1alpha1.KeyvaultSecretSpec{
+					Items: []keyvaultsecretv1alpha1.KeyvaultSecretEntry{
+						{KubernetesName: "password", KeyvaultName: "db-password"},
+						{KubernetesName: "user", KeyvaultName: "db-user"},
+						{KubernetesName: "token", KeyvaultName: "api-token", Generator: &keyvaultsecretv1alpha1.SecretGenerator{Type: keyvaultsecretv1alpha1.GeneratorTypePassword}},
+					},
+				},
+				Status: keyvaultsecretv1alpha1.KeyvaultSecretStatus{PreviousItems: tt.previousItems},
+			}
+			crdclientset := fake.NewSimpleClientset(keyvaultSecret)
+			recorder := record.NewFakeRecorder(10)
+			c := &Controller{
+				crdclientset: crdclientset,
+				recorder:     recorder,
+				logger:       logrus.NewEntry(logrus.New()),
+			}
+
+			if err := c.rollback(keyvaultSecret); err != nil {
+				t.Fatalf("rollback() error = %v", err)
+			}
+			updated, err := crdclientset.SecretcontrollerV1alpha1().KeyvaultSecrets("test-namespace").Get("test-secret", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := updated.Annotations[rollbackAnnotation]; ok {
+				t.Errorf("rollback() kept annotation %s", rollbackAnnotation)
+			}
+			for i, item := range updated.Spec.Items {
+				if ok, err := item.IsValid(); !ok {
+					t.Errorf("rollback() item %s is invalid: %v", item.KubernetesName, err)
+				}
+				if item.KeyvaultVersion != tt.wantVersions[i] {
+					t.Errorf("rollback() item %s version = %q, want %q", item.KubernetesName, item.KeyvaultVersion, tt.wantVersions[i])
+				}
+				if tt.wantVersions[i] != "" && item.GetVersionPolicyType() != keyvaultsecretv1alpha1.VersionPolicyPinned {
+					t.Errorf("rollback() item %s policy = %q, want Pinned", item.KubernetesName, item.VersionPolicy.Type)
+				}
+			}
+			select {
+			case event := <-recorder.Events:
+				if !strings.Contains(event, tt.wantReason) {
+					t.Errorf("rollback() event = %q, want reason %s", event, tt.wantReason)
+				}
+			default:
+				t.Errorf("rollback() recorded no event")
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	keyvaultsecretv1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+)
+
+const (
+	// RolloutTriggered is used as part of the Event 'reason' when a workload
+	// is restarted because the content of its Secret changed
+	RolloutTriggered = "RolloutTriggered"
+	// RolloutDryRun is used as part of the Event 'reason' when a workload
+	// would have been restarted
+	RolloutDryRun = "RolloutDryRun"
+	// ErrRollout is used as part of the Event 'reason' when a workload could
+	// not be restarted
+	ErrRollout = "ErrRollout"
+
+	// MessageRolloutTriggered is the message used for an Event fired when a
+	// workload is restarted
+	MessageRolloutTriggered = "Restarted %s %q after the content of Secret %q changed"
+	// MessageRolloutDryRun is the message used for an Event fired when a
+	// workload would have been restarted
+	MessageRolloutDryRun = "Would restart %s %q after the content of Secret %q changed"
+	// MessageRolloutFailed is the message used for an Event fired when a
+	// workload could not be restarted
+	MessageRolloutFailed = "Failed to restart %s %q: %s"
+)
+
+var (
+	// rolloutAnnotation opts a workload in to be restarted whenever a Secret
+	// it references changes
+	rolloutAnnotation = keyvaultsecretv1alpha1.SchemeGroupVersion.Group + "/rollout"
+	// checksumAnnotationPrefix is the prefix of the pod template annotation
+	// that carries the content hash of a Secret. Changing it restarts the pods.
+	checksumAnnotationPrefix = "checksum." + keyvaultsecretv1alpha1.SchemeGroupVersion.Group + "/"
+)
+
+// workload is a Deployment, StatefulSet or DaemonSet
+type workload struct {
+	kind        string
+	name        string
+	annotations map[string]string
+	podSpec     corev1.PodSpec
+}
+
+// rolloutWorkloads restarts the rollout targets of keyvaultSecret and all
+// workloads that opted in with the rollout annotation and reference secret,
+// by setting the content hash of secret as an annotation on their pod
+// templates.
+func (c *Controller) rolloutWorkloads(keyvaultSecret *keyvaultsecretv1alpha1.KeyvaultSecret, secret *corev1.Secret, contentHash string) error {
+	targets := make(map[keyvaultsecretv1alpha1.RolloutTarget]bool)
+	for _, target := range keyvaultSecret.Spec.RolloutTargets {
+		targets[target] = true
+	}
+	workloads, err := c.listWorkloads(keyvaultSecret.Namespace)
+	if err != nil {
+		return err
+	}
+	for _, w := range workloads {
+		target := keyvaultsecretv1alpha1.RolloutTarget{Kind: w.kind, Name: w.name}
+		if w.annotations[rolloutAnnotation] == "true" && podSpecReferencesSecret(w.podSpec, secret.Name) {
+			targets[target] = true
+		}
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{
+						checksumAnnotationPrefix + checksumAnnotationName(keyvaultSecret.Name): contentHash,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for target := range targets {
+		if keyvaultSecret.Spec.RolloutDryRun {
+			c.recorder.Eventf(keyvaultSecret, corev1.EventTypeNormal, RolloutDryRun, MessageRolloutDryRun, target.Kind, target.Name, secret.Name)
+			continue
+		}
+		err := c.patchWorkload(keyvaultSecret.Namespace, target, patch)
+		if errors.IsNotFound(err) {
+			c.recorder.Eventf(keyvaultSecret, corev1.EventTypeWarning, ErrRollout, MessageRolloutFailed, target.Kind, target.Name, "not found")
+			continue
+		}
+		if err != nil {
+			c.recorder.Eventf(keyvaultSecret, corev1.EventTypeWarning, ErrRollout, MessageRolloutFailed, target.Kind, target.Name, err)
+			lastErr = err
+			continue
+		}
+		c.recorder.Eventf(keyvaultSecret, corev1.EventTypeNormal, RolloutTriggered, MessageRolloutTriggered, target.Kind, target.Name, secret.Name)
+	}
+	return lastErr
+}
+
+func (c *Controller) listWorkloads(namespace string) ([]workload, error) {
+	var workloads []workload
+	apps := c.kubeclientset.AppsV1()
+	deployments, err := apps.Deployments(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range deployments.Items {
+		workloads = append(workloads, workload{"Deployment", d.Name, d.Annotations, d.Spec.Template.Spec})
+	}
+	statefulSets, err := apps.StatefulSets(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range statefulSets.Items {
+		workloads = append(workloads, workload{"StatefulSet", s.Name, s.Annotations, s.Spec.Template.Spec})
+	}
+	daemonSets, err := apps.DaemonSets(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range daemonSets.Items {
+		workloads = append(workloads, workload{"DaemonSet", d.Name, d.Annotations, d.Spec.Template.Spec})
+	}
+	return workloads, nil
+}
+
+func (c *Controller) patchWorkload(namespace string, target keyvaultsecretv1alpha1.RolloutTarget, patch []byte) error {
+	apps := c.kubeclientset.AppsV1()
+	var err error
+	switch target.Kind {
+	case "Deployment":
+		_, err = apps.Deployments(namespace).Patch(target.Name, types.StrategicMergePatchType, patch)
+	case "StatefulSet":
+		_, err = apps.StatefulSets(namespace).Patch(target.Name, types.StrategicMergePatchType, patch)
+	case "DaemonSet":
+		_, err = apps.DaemonSets(namespace).Patch(target.Name, types.StrategicMergePatchType, patch)
+	default:
+		err = fmt.Errorf("unsupported kind %q", target.Kind)
+	}
+	return err
+}
+
+// checksumAnnotationName returns name if it is a valid annotation name and a
+// hash of it otherwise
+func checksumAnnotationName(name string) string {
+	if len(name) <= 63 {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:])[:10]
+}
+
+// podSpecReferencesSecret reports whether the pods use the Secret with the
+// given name in a volume or an environment variable
+func podSpecReferencesSecret(spec corev1.PodSpec, name string) bool {
+	for _, volume := range spec.Volumes {
+		if volume.Secret != nil && volume.Secret.SecretName == name {
+			return true
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.Secret != nil && source.Secret.Name == name {
+					return true
+				}
+			}
+		}
+	}
+	containers := append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...)
+	for _, container := range containers {
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.SecretRef != nil && envFrom.SecretRef.Name == name {
+				return true
+			}
+		}
+		for _, env := range container.Env {
+			if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil && env.ValueFrom.SecretKeyRef.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
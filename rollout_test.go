@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	keyvaultsecretv1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+)
+
+func Test_rolloutWorkloads(t *testing.T) {
+	newDeployment := func(name string, annotations map[string]string, podSpec corev1.PodSpec) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "test-namespace",
+				Annotations: annotations,
+			},
+			Spec: appsv1.DeploymentSpec{
+				Template: corev1.PodTemplateSpec{Spec: podSpec},
+			},
+		}
+	}
+	usesSecret := corev1.PodSpec{
+		Containers: []corev1.Container{{
+			EnvFrom: []corev1.EnvFromSource{{
+				SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "test-secret"}},
+			}},
+		}},
+	}
+	optIn := map[string]string{rolloutAnnotation: "true"}
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "test-secret", Namespace: "test-namespace"}}
+
+	tests := []struct {
+		name        string
+		targets     []keyvaultsecretv1alpha1.RolloutTarget
+		dryRun      bool
+		deployments []*appsv1.Deployment
+		wantPatched []string
+	}{
+		{
+			name:    "explicit target",
+			targets: []keyvaultsecretv1alpha1.RolloutTarget{{Kind: "Deployment", Name: "explicit"}},
+			deployments: []*appsv1.Deployment{
+				newDeployment("explicit", nil, corev1.PodSpec{}),
+				newDeployment("other", nil, usesSecret),
+			},
+			wantPatched: []string{"explicit"},
+		},
+		{
+			name: "discovered by annotation",
+			deployments: []*appsv1.Deployment{
+				newDeployment("opted-in", optIn, usesSecret),
+				newDeployment("not-opted-in", nil, usesSecret),
+				newDeployment("not-referencing", optIn, corev1.PodSpec{}),
+			},
+			wantPatched: []string{"opted-in"},
+		},
+		{
+			name:    "dry run",
+			targets: []keyvaultsecretv1alpha1.RolloutTarget{{Kind: "Deployment", Name: "explicit"}},
+			dryRun:  true,
+			deployments: []*appsv1.Deployment{
+				newDeployment("explicit", nil, corev1.PodSpec{}),
+				newDeployment("opted-in", optIn, usesSecret),
+			},
+		},
+		{
+			name:        "missing target",
+			targets:     []keyvaultsecretv1alpha1.RolloutTarget{{Kind: "Deployment", Name: "missing"}},
+			wantPatched: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kubeclientset := fake.NewSimpleClientset()
+			for _, d := range tt.deployments {
+				kubeclientset.AppsV1().Deployments(d.Namespace).Create(d)
+			}
+			c := &Controller{
+				kubeclientset: kubeclientset,
+				recorder:      record.NewFakeRecorder(10),
+				logger:        logrus.NewEntry(logrus.New()),
+			}
+			keyvaultSecret := &keyvaultsecretv1alpha1.KeyvaultSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-secret", Namespace: "test-namespace"},
+				Spec: keyvaultsecretv1alpha1.KeyvaultSecretSpec{
+					RolloutTargets: tt.targets,
+					RolloutDryRun:  tt.dryRun,
+				},
+			}
+			if err := c.rolloutWorkloads(keyvaultSecret, secret, "hash"); err != nil {
+				t.Fatalf("rolloutWorkloads() error = %v", err)
+			}
+
+			var patched []string
+			for _, d := range tt.deployments {
+				got, err := kubeclientset.AppsV1().Deployments(d.Namespace).Get(d.Name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Spec.Template.Annotations[checksumAnnotationPrefix+"test-secret"] == "hash" {
+					patched = append(patched, d.Name)
+				}
+			}
+			if len(patched) != len(tt.wantPatched) {
+				t.Fatalf("rolloutWorkloads() patched %v, want %v", patched, tt.wantPatched)
+			}
+			for i := range patched {
+				if patched[i] != tt.wantPatched[i] {
+					t.Errorf("rolloutWorkloads() patched %v, want %v", patched, tt.wantPatched)
+				}
+			}
+		})
+	}
+}
@@ -2,18 +2,97 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"html/template"
+	"reflect"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
 
 	keyvaultsecretv1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+	"github.com/twendt/secret-controller/pkg/generator"
+	"github.com/twendt/secret-controller/pkg/policy"
 	"github.com/twendt/secret-controller/pkg/secretstore"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
+var (
+	// managedKeysAnnotation records the data keys of a Secret that are owned by
+	// its KeyvaultSecret, so keys removed from the KeyvaultSecret can be removed
+	// from the Secret without touching keys added by someone else
+	managedKeysAnnotation = keyvaultsecretv1alpha1.SchemeGroupVersion.Group + "/managed-keys"
+	// managedLabelsAnnotation and managedAnnotationsAnnotation do the same for
+	// the labels and annotations of the target section
+	managedLabelsAnnotation      = keyvaultsecretv1alpha1.SchemeGroupVersion.Group + "/managed-labels"
+	managedAnnotationsAnnotation = keyvaultsecretv1alpha1.SchemeGroupVersion.Group + "/managed-annotations"
+	// keyvaultSecretLabel is set on immutable Secrets to find all versions
+	// created for a KeyvaultSecret
+	keyvaultSecretLabel = keyvaultsecretv1alpha1.SchemeGroupVersion.Group + "/keyvaultsecret"
+)
+
+// generatorTag is set on Key Vault secrets created by a generator and holds
+// the generator type
+const generatorTag = "generator"
+
+// metadataTemplateData is passed to the label and annotation templates
+type metadataTemplateData struct {
+	// Items holds the metadata of the Key Vault secrets of all entries with
+	// a keyvaultName by their kubernetesName
+	Items map[string]*secretMetadata
+}
+
+// secretMetadata is the metadata of a Key Vault secret that label and
+// annotation templates can read. It has no value, so that templates cannot
+// copy secrets into labels or annotations.
+type secretMetadata struct {
+	Name        string
+	Version     string
+	ContentType string
+	Tags        map[string]string
+	Expires     *time.Time
+}
+
+func newSecretMetadata(storeSecret *secretstore.Secret) *secretMetadata {
+	return &secretMetadata{
+		Name:        storeSecret.Name,
+		Version:     storeSecret.Version,
+		ContentType: storeSecret.ContentType,
+		Tags:        storeSecret.Tags,
+		Expires:     storeSecret.Expires,
+	}
+}
+
 type SecretConverter struct {
+	ctx            context.Context
 	keyvaultSecret *keyvaultsecretv1alpha1.KeyvaultSecret
 	storeClient    secretstore.Client
+	// hashKey is the content hash key of the names of immutable Secrets
+	hashKey []byte
+	// strict fails templates on every lookup error instead of rendering
+	// the error message as value
+	strict bool
+	// err records an access denied error, or any error in strict mode,
+	// inside a template function, as the template functions return lookup
+	// errors as value
+	err error
+	// generated holds the names of the Key Vault secrets created by a
+	// generator
+	generated []string
+	// secrets holds the secrets used for the data of the Secret by name and
+	// version, for the validity checks
+	secrets map[string]*secretstore.Secret
+	// resolved holds the versions selected for the entries
+	resolved []keyvaultsecretv1alpha1.KeyvaultSecretStatusItem
+	// resync is the time at which a version policy selects a different
+	// version next, zero if unknown
+	resync time.Time
 }
 
 // newSecret creates a new Secret from a KeyvaultSecret resource
@@ -22,43 +101,242 @@ func (c *SecretConverter) newSecret() *corev1.Secret {
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      c.keyvaultSecret.Name,
 			Namespace: c.keyvaultSecret.Namespace,
-			OwnerReferences: []metav1.OwnerReference{
-				*metav1.NewControllerRef(c.keyvaultSecret, schema.GroupVersionKind{
-					Group:   keyvaultsecretv1alpha1.SchemeGroupVersion.Group,
-					Version: keyvaultsecretv1alpha1.SchemeGroupVersion.Version,
-					Kind:    "KeyvaultSecret",
-				}),
-			},
 		},
 	}
+	if c.keyvaultSecret.Spec.Target.GetCreationPolicy() == keyvaultsecretv1alpha1.CreationPolicyOwner {
+		secret.OwnerReferences = []metav1.OwnerReference{
+			*metav1.NewControllerRef(c.keyvaultSecret, schema.GroupVersionKind{
+				Group:   keyvaultsecretv1alpha1.SchemeGroupVersion.Group,
+				Version: keyvaultsecretv1alpha1.SchemeGroupVersion.Version,
+				Kind:    "KeyvaultSecret",
+			}),
+		}
+	}
 	return secret
 }
 
 func (c *SecretConverter) getK8sSecret() (*corev1.Secret, error) {
 	secret := c.newSecret()
 	secret.Data = make(map[string][]byte)
+	target := c.keyvaultSecret.Spec.Target
+	if ok, err := c.keyvaultSecret.Spec.IsValid(); !ok {
+		return secret, err
+	}
+	items := make(map[string]*secretMetadata)
 	for _, item := range c.keyvaultSecret.Spec.Items {
-		if ok, err := item.IsValid(); !ok {
-			return secret, err
-		}
 		if item.IsTemplateEntry() {
 			parsed, err := c.processTemplate(item)
 			if err != nil {
 				return secret, err
 			}
+			if c.err != nil {
+				return secret, c.err
+			}
 			secret.Data[item.KubernetesName] = []byte(parsed)
 			continue
 		}
 
-		secretValue, err := c.storeClient.GetSecretValueForVersion(item.KeyvaultName, item.KeyvaultVersion)
+		version, err := c.resolveVersion(item)
+		if err != nil {
+			return secret, err
+		}
+		storeSecret, err := c.storeClient.GetSecret(c.ctx, item.KeyvaultName, version)
+		if secretstore.IsNotFound(err) && item.Generator != nil {
+			storeSecret, err = c.generateSecret(item)
+		}
 		if err != nil {
 			return secret, err
 		}
-		secret.Data[item.KubernetesName] = []byte(secretValue)
+		c.recordSecret(storeSecret, version)
+		c.resolved = append(c.resolved, keyvaultsecretv1alpha1.KeyvaultSecretStatusItem{
+			KubernetesName: item.KubernetesName,
+			KeyvaultName:   item.KeyvaultName,
+			Version:        storeSecret.Version,
+		})
+		secret.Data[item.KubernetesName] = []byte(storeSecret.Value)
+		items[item.KubernetesName] = newSecretMetadata(storeSecret)
+		if item.PreviousVersions > 0 {
+			previous, err := c.previousVersions(item, storeSecret.Version)
+			if err != nil {
+				return secret, err
+			}
+			for i, previousSecret := range previous {
+				secret.Data[item.PreviousKey(i+1)] = []byte(previousSecret.Value)
+			}
+		}
+	}
+
+	data := metadataTemplateData{Items: items}
+	labels, err := c.processMetadataTemplates(target.Labels, data)
+	if err != nil {
+		return secret, err
+	}
+	annotations, err := c.processMetadataTemplates(target.Annotations, data)
+	if err != nil {
+		return secret, err
+	}
+	annotations[managedKeysAnnotation] = joinKeys(dataKeys(secret.Data))
+	if len(target.Labels) > 0 {
+		annotations[managedLabelsAnnotation] = joinKeys(mapKeys(target.Labels))
+	}
+	if len(target.Annotations) > 0 {
+		annotations[managedAnnotationsAnnotation] = joinKeys(mapKeys(target.Annotations))
+	}
+
+	secret.Labels = labels
+	secret.Annotations = annotations
+	if target.Immutable {
+		// the name changes with the content and the metadata, so an
+		// existing Secret never has to be updated
+		secret.Name = secret.Name + "-" + hashSecret(c.hashKey, secret)
+		labels[keyvaultSecretLabel] = c.keyvaultSecret.Name
 	}
 	return secret, nil
 }
 
+// resolveVersion returns the version of the Key Vault secret of item that is
+// selected by its version policy. An empty version refers to the latest
+// version.
+func (c *SecretConverter) resolveVersion(item keyvaultsecretv1alpha1.KeyvaultSecretEntry) (string, error) {
+	policyType := item.GetVersionPolicyType()
+	switch policyType {
+	case keyvaultsecretv1alpha1.VersionPolicyLatest:
+		return "", nil
+	case keyvaultsecretv1alpha1.VersionPolicyPinned:
+		return item.KeyvaultVersion, nil
+	}
+
+	lister, ok := c.storeClient.(secretstore.VersionLister)
+	if !ok {
+		return "", fmt.Errorf("secret store does not support listing versions")
+	}
+	versions, err := lister.ListVersions(c.ctx, item.KeyvaultName)
+	if err != nil {
+		return "", err
+	}
+	var enabled []*secretstore.Secret
+	for _, version := range versions {
+		if !version.Disabled {
+			enabled = append(enabled, version)
+		}
+	}
+
+	if policyType == keyvaultsecretv1alpha1.VersionPolicyPrevious {
+		previous := item.VersionPolicy.Previous
+		if len(enabled) <= previous {
+			return "", fmt.Errorf("secret %q has %d enabled versions, cannot go back %d versions", item.KeyvaultName, len(enabled), previous)
+		}
+		return enabled[previous].Version, nil
+	}
+
+	minAge := item.VersionPolicy.MinAge.Duration
+	now := time.Now()
+	for _, version := range enabled {
+		if version.Created == nil {
+			continue
+		}
+		staged := version.Created.Add(minAge)
+		if !staged.After(now) {
+			return version.Version, nil
+		}
+		// the newer version is used once it is old enough
+		c.resync = earliest(c.resync, staged)
+	}
+	return "", fmt.Errorf("secret %q has no enabled version older than %s", item.KeyvaultName, minAge)
+}
+
+// previousVersions returns up to item.PreviousVersions enabled versions of
+// the Key Vault secret of item before version, newest first. Fewer versions
+// are returned if the secret does not have that many.
+func (c *SecretConverter) previousVersions(item keyvaultsecretv1alpha1.KeyvaultSecretEntry, version string) ([]*secretstore.Secret, error) {
+	lister, ok := c.storeClient.(secretstore.VersionLister)
+	if !ok {
+		return nil, fmt.Errorf("secret store does not support listing versions")
+	}
+	versions, err := lister.ListVersions(c.ctx, item.KeyvaultName)
+	if err != nil {
+		return nil, err
+	}
+	current := -1
+	for i, v := range versions {
+		if v.Version == version {
+			current = i
+			break
+		}
+	}
+	if current < 0 {
+		return nil, fmt.Errorf("version %q of secret %q not found", version, item.KeyvaultName)
+	}
+
+	var previous []*secretstore.Secret
+	for _, v := range versions[current+1:] {
+		if len(previous) == item.PreviousVersions {
+			break
+		}
+		if v.Disabled {
+			continue
+		}
+		// listed versions do not necessarily carry their value
+		storeSecret, err := c.storeClient.GetSecret(c.ctx, item.KeyvaultName, v.Version)
+		if err != nil {
+			return nil, err
+		}
+		// previous versions are subject to the validity policy as well
+		c.recordSecret(storeSecret, v.Version)
+		previous = append(previous, storeSecret)
+	}
+	return previous, nil
+}
+
+// generateSecret creates the missing Key Vault secret of item with a value
+// created by its generator
+func (c *SecretConverter) generateSecret(item keyvaultsecretv1alpha1.KeyvaultSecretEntry) (*secretstore.Secret, error) {
+	writer, ok := c.storeClient.(secretstore.Writer)
+	if !ok {
+		return nil, fmt.Errorf("secret store does not support writing secrets")
+	}
+	value, contentType, err := generator.Generate(*item.Generator)
+	if err != nil {
+		return nil, err
+	}
+	storeSecret, err := writer.SetSecret(c.ctx, &secretstore.Secret{
+		Name:        item.KeyvaultName,
+		Value:       value,
+		ContentType: contentType,
+		Tags: map[string]string{
+			managedByTag: controllerAgentName,
+			generatorTag: string(item.Generator.Type),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.generated = append(c.generated, item.KeyvaultName)
+	return storeSecret, nil
+}
+
+// processMetadataTemplates renders the values of the target labels or
+// annotations. Unlike secret templates they are plain text, as HTML escaping
+// would alter values like URLs.
+func (c *SecretConverter) processMetadataTemplates(templates map[string]string, data metadataTemplateData) (map[string]string, error) {
+	result := make(map[string]string, len(templates))
+	for key, text := range templates {
+		t, err := texttemplate.New(key).Delims("[[", "]]").Funcs(texttemplate.FuncMap{
+			"secretMetadata":           c.templateFuncSecretMetadata,
+			"secretMetadataForVersion": c.templateFuncSecretMetadataForVersion,
+		}).Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		var tpl bytes.Buffer
+		if err := t.Execute(&tpl, data); err != nil {
+			return nil, err
+		}
+		result[key] = tpl.String()
+	}
+	return result, nil
+}
+
 func (c *SecretConverter) processTemplate(item keyvaultsecretv1alpha1.KeyvaultSecretEntry) (string, error) {
 	t, err := c.getTemplate(item)
 	if err != nil {
@@ -75,17 +353,192 @@ func (c *SecretConverter) getTemplate(item keyvaultsecretv1alpha1.KeyvaultSecret
 	return template.New(item.KubernetesName).Delims("[[", "]]").Funcs(template.FuncMap{
 		"secretValue":           c.templateFuncSecretValue,
 		"secretValueForVersion": c.templateFuncSecretValueForVersion,
+		"publicKey":             c.templateFuncPublicKey,
 	}).Parse(item.SecretTemplate)
 }
 
+// templateFuncPublicKey returns the public key of a private key created by a
+// generator
+func (c *SecretConverter) templateFuncPublicKey(privateKey string) string {
+	publicKey, err := generator.PublicKey(privateKey)
+	if err != nil {
+		return c.templateError(err)
+	}
+	return publicKey
+}
+
 func (c *SecretConverter) templateFuncSecretValue(name string) string {
 	return c.templateFuncSecretValueForVersion(name, "")
 }
 
+func (c *SecretConverter) templateFuncSecretMetadata(name string) (*secretMetadata, error) {
+	return c.templateFuncSecretMetadataForVersion(name, "")
+}
+
+func (c *SecretConverter) templateFuncSecretMetadataForVersion(name, version string) (*secretMetadata, error) {
+	storeSecret, err := c.storeClient.GetSecret(c.ctx, name, version)
+	if err != nil {
+		return nil, err
+	}
+	c.recordSecret(storeSecret, version)
+	return newSecretMetadata(storeSecret), nil
+}
+
 func (c *SecretConverter) templateFuncSecretValueForVersion(name, version string) string {
-	secretValue, err := c.storeClient.GetSecretValue(name)
+	storeSecret, err := c.storeClient.GetSecret(c.ctx, name, version)
 	if err != nil {
+		return c.templateError(err)
+	}
+	c.recordSecret(storeSecret, version)
+	return storeSecret.Value
+}
+
+// recordSecret remembers a secret that was looked up with version
+func (c *SecretConverter) recordSecret(storeSecret *secretstore.Secret, version string) {
+	if c.secrets == nil {
+		c.secrets = make(map[string]*secretstore.Secret)
+	}
+	c.secrets[storeSecret.Name+"/"+version] = storeSecret
+}
+
+// templateError returns the value a template function renders for err and
+// records err if it has to fail the template
+func (c *SecretConverter) templateError(err error) string {
+	if !c.strict && !policy.IsAccessDenied(err) {
 		return err.Error()
 	}
-	return secretValue
+	if c.err == nil {
+		c.err = err
+	}
+	return ""
+}
+
+// mergeSecret applies the parts of desired that are managed by the controller
+// to a copy of existing. Data keys, labels and annotations that were added by
+// other tools are preserved. The returned bool reports whether the merged
+// Secret differs from existing.
+func mergeSecret(existing, desired *corev1.Secret) (*corev1.Secret, bool) {
+	merged := existing.DeepCopy()
+
+	if merged.Data == nil {
+		merged.Data = make(map[string][]byte)
+	}
+	for _, key := range splitKeys(existing.Annotations[managedKeysAnnotation]) {
+		if _, ok := desired.Data[key]; !ok {
+			delete(merged.Data, key)
+		}
+	}
+	for key, value := range desired.Data {
+		merged.Data[key] = value
+	}
+
+	for _, key := range splitKeys(existing.Annotations[managedLabelsAnnotation]) {
+		if _, ok := desired.Labels[key]; !ok {
+			delete(merged.Labels, key)
+		}
+	}
+	for _, key := range splitKeys(existing.Annotations[managedAnnotationsAnnotation]) {
+		if _, ok := desired.Annotations[key]; !ok {
+			delete(merged.Annotations, key)
+		}
+	}
+	for _, key := range []string{managedLabelsAnnotation, managedAnnotationsAnnotation} {
+		if _, ok := desired.Annotations[key]; !ok {
+			delete(merged.Annotations, key)
+		}
+	}
+	merged.Labels = mergeMap(merged.Labels, desired.Labels)
+	merged.Annotations = mergeMap(merged.Annotations, desired.Annotations)
+
+	for _, ref := range desired.OwnerReferences {
+		found := false
+		for i := range merged.OwnerReferences {
+			if merged.OwnerReferences[i].UID == ref.UID {
+				merged.OwnerReferences[i] = ref
+				found = true
+			}
+		}
+		if !found {
+			merged.OwnerReferences = append(merged.OwnerReferences, ref)
+		}
+	}
+
+	changed := !reflect.DeepEqual(existing.Data, merged.Data) ||
+		!reflect.DeepEqual(existing.Labels, merged.Labels) ||
+		!reflect.DeepEqual(existing.Annotations, merged.Annotations) ||
+		!reflect.DeepEqual(existing.OwnerReferences, merged.OwnerReferences)
+	return merged, changed
+}
+
+func mergeMap(existing, desired map[string]string) map[string]string {
+	if len(desired) == 0 {
+		return existing
+	}
+	if existing == nil {
+		existing = make(map[string]string)
+	}
+	for key, value := range desired {
+		existing[key] = value
+	}
+	return existing
+}
+
+// hashData returns a short HMAC-SHA-256 with key over the keys and values of
+// data. The hashes end up in the status of resources and in the names of
+// immutable Secrets, so without the content hash key of the controller they
+// must not allow guessing the values.
+func hashData(key []byte, data map[string][]byte) string {
+	hash := hmac.New(sha256.New, key)
+	for _, name := range dataKeys(data) {
+		hash.Write([]byte(name))
+		hash.Write([]byte{0})
+		hash.Write(data[name])
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:10]
+}
+
+// hashSecret returns a short HMAC-SHA-256 with key over the data, labels and
+// annotations of secret
+func hashSecret(key []byte, secret *corev1.Secret) string {
+	content := make(map[string][]byte, len(secret.Data)+len(secret.Labels)+len(secret.Annotations))
+	for name, value := range secret.Data {
+		content["data/"+name] = value
+	}
+	for name, value := range secret.Labels {
+		content["labels/"+name] = []byte(value)
+	}
+	for name, value := range secret.Annotations {
+		content["annotations/"+name] = []byte(value)
+	}
+	return hashData(key, content)
+}
+
+func dataKeys(data map[string][]byte) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func mapKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func joinKeys(keys []string) string {
+	return strings.Join(keys, ",")
+}
+
+func splitKeys(keys string) []string {
+	if keys == "" {
+		return nil
+	}
+	return strings.Split(keys, ",")
 }
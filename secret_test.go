@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	keyvaultsecretv1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+	"github.com/twendt/secret-controller/pkg/secretstore"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -75,14 +78,22 @@ type testSecretStoreClient struct {
 	GetSecretValueFunc func() (string, error)
 }
 
-func (s testSecretStoreClient) GetSecretValue(name string) (string, error) {
-	return s.GetSecretValueForVersion(name, "")
+func (s testSecretStoreClient) GetSecretValue(ctx context.Context, name string) (string, error) {
+	return s.GetSecretValueForVersion(ctx, name, "")
 }
 
-func (s testSecretStoreClient) GetSecretValueForVersion(name, version string) (string, error) {
+func (s testSecretStoreClient) GetSecretValueForVersion(ctx context.Context, name, version string) (string, error) {
 	return s.GetSecretValueFunc()
 }
 
+func (s testSecretStoreClient) GetSecret(ctx context.Context, name, version string) (*secretstore.Secret, error) {
+	value, err := s.GetSecretValueFunc()
+	if err != nil {
+		return nil, err
+	}
+	return &secretstore.Secret{Name: name, Value: value, Version: version}, nil
+}
+
 func Test_setSecretItems(t *testing.T) {
 	type args struct {
 		items  []keyvaultsecretv1alpha1.KeyvaultSecretEntry
@@ -287,6 +298,7 @@ func Test_setSecretItems(t *testing.T) {
 				},
 			}
 			converter := SecretConverter{
+				ctx:            context.Background(),
 				keyvaultSecret: keyvaultSecret,
 				storeClient:    client,
 			}
@@ -304,3 +316,529 @@ func Test_setSecretItems(t *testing.T) {
 		})
 	}
 }
+
+func Test_mergeSecret(t *testing.T) {
+	owner := metav1.OwnerReference{Kind: "KeyvaultSecret", Name: "test-secret", UID: "uid"}
+	desired := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "test-secret",
+			Annotations:     map[string]string{managedKeysAnnotation: "A,B"},
+			OwnerReferences: []metav1.OwnerReference{owner},
+		},
+		Data: map[string][]byte{"A": []byte("a"), "B": []byte("b")},
+	}
+	tests := []struct {
+		name        string
+		existing    *corev1.Secret
+		want        *corev1.Secret
+		wantChanged bool
+	}{
+		{
+			name:        "unchanged",
+			existing:    desired.DeepCopy(),
+			want:        desired,
+			wantChanged: false,
+		},
+		{
+			name: "foreign labels, annotations and keys are preserved",
+			existing: &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            "test-secret",
+					ResourceVersion: "42",
+					Labels:          map[string]string{"app": "test"},
+					Annotations:     map[string]string{"reloader": "true", managedKeysAnnotation: "A"},
+					OwnerReferences: []metav1.OwnerReference{owner},
+				},
+				Data: map[string][]byte{"A": []byte("old"), "C": []byte("c")},
+			},
+			want: &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            "test-secret",
+					ResourceVersion: "42",
+					Labels:          map[string]string{"app": "test"},
+					Annotations:     map[string]string{"reloader": "true", managedKeysAnnotation: "A,B"},
+					OwnerReferences: []metav1.OwnerReference{owner},
+				},
+				Data: map[string][]byte{"A": []byte("a"), "B": []byte("b"), "C": []byte("c")},
+			},
+			wantChanged: true,
+		},
+		{
+			name: "removed managed keys are deleted",
+			existing: &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            "test-secret",
+					Annotations:     map[string]string{managedKeysAnnotation: "A,B,D"},
+					OwnerReferences: []metav1.OwnerReference{owner},
+				},
+				Data: map[string][]byte{"A": []byte("a"), "B": []byte("b"), "D": []byte("d")},
+			},
+			want:        desired,
+			wantChanged: true,
+		},
+		{
+			name: "owner reference is added",
+			existing: &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-secret",
+					Annotations: map[string]string{managedKeysAnnotation: "A,B"},
+				},
+				Data: map[string][]byte{"A": []byte("a"), "B": []byte("b")},
+			},
+			want:        desired,
+			wantChanged: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed := mergeSecret(tt.existing, desired)
+			if changed != tt.wantChanged {
+				t.Errorf("mergeSecret() changed = %v, want %v", changed, tt.wantChanged)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeSecret() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_getK8sSecretTarget(t *testing.T) {
+	items := []keyvaultsecretv1alpha1.KeyvaultSecretEntry{
+		{
+			KubernetesName:  "KubernetesName",
+			KeyvaultName:    "KeyvaultName",
+			KeyvaultVersion: "versionx",
+		},
+	}
+	tests := []struct {
+		name            string
+		target          keyvaultsecretv1alpha1.KeyvaultSecretTarget
+		wantName        string
+		wantLabels      map[string]string
+		wantAnnotations map[string]string
+		wantOwner       bool
+		// wantRecorded are the secrets subject to the validity policy
+		wantRecorded []string
+		wantErr      bool
+	}{
+		{
+			name:            "default target",
+			wantName:        "test-secret",
+			wantLabels:      map[string]string{},
+			wantAnnotations: map[string]string{managedKeysAnnotation: "KubernetesName"},
+			wantOwner:       true,
+		},
+		{
+			name: "labels and annotations with metadata templates",
+			target: keyvaultsecretv1alpha1.KeyvaultSecretTarget{
+				Labels:      map[string]string{"version": `[[ (index .Items "KubernetesName").Version ]]`},
+				Annotations: map[string]string{"source": `[[ (secretMetadataForVersion "Other" "v2").Name ]]@[[ (secretMetadataForVersion "Other" "v2").Version ]]`},
+			},
+			wantName:   "test-secret",
+			wantLabels: map[string]string{"version": "versionx"},
+			wantAnnotations: map[string]string{
+				"source":                     "Other@v2",
+				managedKeysAnnotation:        "KubernetesName",
+				managedLabelsAnnotation:      "version",
+				managedAnnotationsAnnotation: "source",
+			},
+			wantOwner:    true,
+			wantRecorded: []string{"KeyvaultName/versionx", "Other/v2"},
+		},
+		{
+			name: "value of an item in a label",
+			target: keyvaultsecretv1alpha1.KeyvaultSecretTarget{
+				Labels: map[string]string{"password": `[[ (index .Items "KubernetesName").Value ]]`},
+			},
+			wantErr: true,
+		},
+		{
+			name: "value of secret metadata in an annotation",
+			target: keyvaultsecretv1alpha1.KeyvaultSecretTarget{
+				Annotations: map[string]string{"password": `[[ (secretMetadata "Other").Value ]]`},
+			},
+			wantErr: true,
+		},
+		{
+			name:   "immutable",
+			target: keyvaultsecretv1alpha1.KeyvaultSecretTarget{Immutable: true},
+			wantName: "test-secret-" + hashSecret(nil, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{managedKeysAnnotation: "KubernetesName"}},
+				Data:       map[string][]byte{"KubernetesName": []byte("value")},
+			}),
+			wantLabels:      map[string]string{keyvaultSecretLabel: "test-secret"},
+			wantAnnotations: map[string]string{managedKeysAnnotation: "KubernetesName"},
+			wantOwner:       true,
+		},
+		{
+			name:            "merge",
+			target:          keyvaultsecretv1alpha1.KeyvaultSecretTarget{CreationPolicy: keyvaultsecretv1alpha1.CreationPolicyMerge},
+			wantName:        "test-secret",
+			wantLabels:      map[string]string{},
+			wantAnnotations: map[string]string{managedKeysAnnotation: "KubernetesName"},
+			wantOwner:       false,
+		},
+		{
+			name: "immutable merge",
+			target: keyvaultsecretv1alpha1.KeyvaultSecretTarget{
+				CreationPolicy: keyvaultsecretv1alpha1.CreationPolicyMerge,
+				Immutable:      true,
+			},
+			wantErr: true,
+		},
+		{
+			name:    "unknown creation policy",
+			target:  keyvaultsecretv1alpha1.KeyvaultSecretTarget{CreationPolicy: "Orphan"},
+			wantErr: true,
+		},
+		{
+			name: "invalid label template",
+			target: keyvaultsecretv1alpha1.KeyvaultSecretTarget{
+				Labels: map[string]string{"version": `[[ .Unknown ]]`},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := testSecretStoreClient{
+				GetSecretValueFunc: func() (string, error) {
+					return "value", nil
+				},
+			}
+			keyvaultSecret := &keyvaultsecretv1alpha1.KeyvaultSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-secret",
+					Namespace: "test-namespace",
+				},
+				Spec: keyvaultsecretv1alpha1.KeyvaultSecretSpec{
+					Items:  items,
+					Target: tt.target,
+				},
+			}
+			converter := SecretConverter{
+				ctx:            context.Background(),
+				keyvaultSecret: keyvaultSecret,
+				storeClient:    client,
+			}
+			secret, err := converter.getK8sSecret()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getK8sSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if secret.Name != tt.wantName {
+				t.Errorf("getK8sSecret() Name = %v, want %v", secret.Name, tt.wantName)
+			}
+			if !reflect.DeepEqual(secret.Labels, tt.wantLabels) {
+				t.Errorf("getK8sSecret() Labels = %v, want %v", secret.Labels, tt.wantLabels)
+			}
+			if !reflect.DeepEqual(secret.Annotations, tt.wantAnnotations) {
+				t.Errorf("getK8sSecret() Annotations = %v, want %v", secret.Annotations, tt.wantAnnotations)
+			}
+			if hasOwner := metav1.GetControllerOf(secret) != nil; hasOwner != tt.wantOwner {
+				t.Errorf("getK8sSecret() has owner = %v, want %v", hasOwner, tt.wantOwner)
+			}
+			for _, key := range tt.wantRecorded {
+				if converter.secrets[key] == nil {
+					t.Errorf("getK8sSecret() did not record %s, recorded %v", key, converter.secrets)
+				}
+			}
+		})
+	}
+}
+
+func Test_getK8sSecretGenerator(t *testing.T) {
+	generated := &keyvaultsecretv1alpha1.SecretGenerator{Type: keyvaultsecretv1alpha1.GeneratorTypePassword, Length: 16}
+	tests := []struct {
+		name          string
+		existing      map[string]*secretstore.Secret
+		wantGenerated []string
+		wantValue     string
+	}{
+		{
+			name:          "missing secret is generated",
+			wantGenerated: []string{"password"},
+		},
+		{
+			name:      "existing secret is kept",
+			existing:  map[string]*secretstore.Secret{"password": {Name: "password", Value: "existing"}},
+			wantValue: "existing",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &testWritableStore{secrets: make(map[string]*secretstore.Secret)}
+			for name, secret := range tt.existing {
+				store.secrets[name] = secret
+			}
+			converter := SecretConverter{
+				ctx: context.Background(),
+				keyvaultSecret: &keyvaultsecretv1alpha1.KeyvaultSecret{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-secret", Namespace: "test-namespace"},
+					Spec: keyvaultsecretv1alpha1.KeyvaultSecretSpec{
+						Items: []keyvaultsecretv1alpha1.KeyvaultSecretEntry{
+							{KubernetesName: "password", KeyvaultName: "password", Generator: generated},
+						},
+					},
+				},
+				storeClient: store,
+			}
+			secret, err := converter.getK8sSecret()
+			if err != nil {
+				t.Fatalf("getK8sSecret() error = %v", err)
+			}
+			if !reflect.DeepEqual(converter.generated, tt.wantGenerated) {
+				t.Errorf("getK8sSecret() generated %v, want %v", converter.generated, tt.wantGenerated)
+			}
+			got := string(secret.Data["password"])
+			if want := store.secrets["password"].Value; got != want {
+				t.Errorf("getK8sSecret() data = %q, want the value in the store %q", got, want)
+			}
+			if tt.wantValue != "" && got != tt.wantValue {
+				t.Errorf("getK8sSecret() data = %q, want %q", got, tt.wantValue)
+			}
+			if len(tt.wantGenerated) > 0 && len(got) != 16 {
+				t.Errorf("getK8sSecret() generated %q, want 16 characters", got)
+			}
+		})
+	}
+}
+
+func Test_getK8sSecretGeneratorInvalid(t *testing.T) {
+	generator := &keyvaultsecretv1alpha1.SecretGenerator{Type: keyvaultsecretv1alpha1.GeneratorTypePassword, Length: 16}
+	tests := []struct {
+		name          string
+		versionPolicy keyvaultsecretv1alpha1.VersionPolicy
+	}{
+		{
+			name:          "previous version",
+			versionPolicy: keyvaultsecretv1alpha1.VersionPolicy{Type: keyvaultsecretv1alpha1.VersionPolicyPrevious, Previous: 1},
+		},
+		{
+			name:          "min age",
+			versionPolicy: keyvaultsecretv1alpha1.VersionPolicy{Type: keyvaultsecretv1alpha1.VersionPolicyMinAge, MinAge: metav1.Duration{Duration: time.Hour}},
+		},
+		{
+			name:          "unknown version policy",
+			versionPolicy: keyvaultsecretv1alpha1.VersionPolicy{Type: "Oldest"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &testWritableStore{secrets: make(map[string]*secretstore.Secret)}
+			converter := SecretConverter{
+				ctx: context.Background(),
+				keyvaultSecret: &keyvaultsecretv1alpha1.KeyvaultSecret{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-secret", Namespace: "test-namespace"},
+					Spec: keyvaultsecretv1alpha1.KeyvaultSecretSpec{
+						Items: []keyvaultsecretv1alpha1.KeyvaultSecretEntry{
+							{KubernetesName: "password", KeyvaultName: "password", Generator: generator, VersionPolicy: tt.versionPolicy},
+						},
+					},
+				},
+				storeClient: store,
+			}
+			if _, err := converter.getK8sSecret(); err == nil {
+				t.Errorf("getK8sSecret() succeeded with a generator and the version policy %q", tt.versionPolicy.Type)
+			}
+			if len(store.secrets) != 0 {
+				t.Errorf("getK8sSecret() generated %v", store.secrets)
+			}
+		})
+	}
+}
+
+// testVersionStore is a secret store that lists the given versions of every
+// secret
+type testVersionStore struct {
+	testSecretStoreClient
+	versions []*secretstore.Secret
+}
+
+func (s testVersionStore) GetSecret(ctx context.Context, name, version string) (*secretstore.Secret, error) {
+	for _, secret := range s.versions {
+		if version == "" || secret.Version == version {
+			return secret, nil
+		}
+	}
+	return nil, &secretstore.NotFoundError{Name: name, Version: version}
+}
+
+func (s testVersionStore) ListVersions(ctx context.Context, name string) ([]*secretstore.Secret, error) {
+	return s.versions, nil
+}
+
+func Test_resolveVersion(t *testing.T) {
+	now := time.Now()
+	hoursAgo := func(hours int) *time.Time {
+		created := now.Add(-time.Duration(hours) * time.Hour)
+		return &created
+	}
+	versions := []*secretstore.Secret{
+		{Name: "db-password", Version: "v4", Created: hoursAgo(1)},
+		{Name: "db-password", Version: "v3", Created: hoursAgo(2), Disabled: true},
+		{Name: "db-password", Version: "v2", Created: hoursAgo(30)},
+		{Name: "db-password", Version: "v1", Created: hoursAgo(50)},
+	}
+	tests := []struct {
+		name       string
+		policy     keyvaultsecretv1alpha1.VersionPolicy
+		version    string
+		want       string
+		wantResync bool
+		wantErr    bool
+	}{
+		{
+			name:   "latest",
+			policy: keyvaultsecretv1alpha1.VersionPolicy{Type: keyvaultsecretv1alpha1.VersionPolicyLatest},
+			want:   "",
+		},
+		{
+			name:    "pinned",
+			version: "v1",
+			want:    "v1",
+		},
+		{
+			name:   "previous skips disabled versions",
+			policy: keyvaultsecretv1alpha1.VersionPolicy{Type: keyvaultsecretv1alpha1.VersionPolicyPrevious, Previous: 1},
+			want:   "v2",
+		},
+		{
+			name:    "previous beyond the oldest version",
+			policy:  keyvaultsecretv1alpha1.VersionPolicy{Type: keyvaultsecretv1alpha1.VersionPolicyPrevious, Previous: 3},
+			wantErr: true,
+		},
+		{
+			name:       "min age",
+			policy:     keyvaultsecretv1alpha1.VersionPolicy{Type: keyvaultsecretv1alpha1.VersionPolicyMinAge, MinAge: metav1.Duration{Duration: 24 * time.Hour}},
+			want:       "v2",
+			wantResync: true,
+		},
+		{
+			name:       "min age older than all versions",
+			policy:     keyvaultsecretv1alpha1.VersionPolicy{Type: keyvaultsecretv1alpha1.VersionPolicyMinAge, MinAge: metav1.Duration{Duration: 100 * time.Hour}},
+			wantResync: true,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &SecretConverter{ctx: context.Background(), storeClient: testVersionStore{versions: versions}}
+			item := keyvaultsecretv1alpha1.KeyvaultSecretEntry{KeyvaultName: "db-password", KeyvaultVersion: tt.version, VersionPolicy: tt.policy}
+			got, err := c.resolveVersion(item)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolveVersion() = %q, want %q", got, tt.want)
+			}
+			if c.resync.IsZero() == tt.wantResync {
+				t.Errorf("resolveVersion() resync = %v, wantResync %v", c.resync, tt.wantResync)
+			}
+		})
+	}
+}
+
+func Test_getK8sSecretPreviousVersions(t *testing.T) {
+	versions := []*secretstore.Secret{
+		{Name: "db-password", Version: "v4", Value: "four"},
+		{Name: "db-password", Version: "v3", Value: "three", Disabled: true},
+		{Name: "db-password", Version: "v2", Value: "two"},
+		{Name: "db-password", Version: "v1", Value: "one"},
+	}
+	tests := []struct {
+		name string
+		item keyvaultsecretv1alpha1.KeyvaultSecretEntry
+		want map[string]string
+	}{
+		{
+			name: "previous version",
+			item: keyvaultsecretv1alpha1.KeyvaultSecretEntry{KubernetesName: "PASSWORD", KeyvaultName: "db-password", PreviousVersions: 1},
+			want: map[string]string{"PASSWORD": "four", "PASSWORD_PREVIOUS": "two"},
+		},
+		{
+			name: "more versions than exist",
+			item: keyvaultsecretv1alpha1.KeyvaultSecretEntry{KubernetesName: "PASSWORD", KeyvaultName: "db-password", PreviousVersions: 5, PreviousKeySuffix: "_OLD"},
+			want: map[string]string{"PASSWORD": "four", "PASSWORD_OLD": "two", "PASSWORD_OLD_2": "one"},
+		},
+		{
+			name: "before pinned version",
+			item: keyvaultsecretv1alpha1.KeyvaultSecretEntry{KubernetesName: "PASSWORD", KeyvaultName: "db-password", KeyvaultVersion: "v2", PreviousVersions: 1},
+			want: map[string]string{"PASSWORD": "two", "PASSWORD_PREVIOUS": "one"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyvaultSecret := &keyvaultsecretv1alpha1.KeyvaultSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-secret", Namespace: "test-namespace"},
+				Spec:       keyvaultsecretv1alpha1.KeyvaultSecretSpec{Items: []keyvaultsecretv1alpha1.KeyvaultSecretEntry{tt.item}},
+			}
+			c := &SecretConverter{ctx: context.Background(), keyvaultSecret: keyvaultSecret, storeClient: testVersionStore{versions: versions}}
+			got, err := c.getK8sSecret()
+			if err != nil {
+				t.Fatalf("getK8sSecret() error = %v", err)
+			}
+			if len(got.Data) != len(tt.want) {
+				t.Errorf("getK8sSecret() data = %v, want %v", got.Data, tt.want)
+			}
+			for key, value := range tt.want {
+				if string(got.Data[key]) != value {
+					t.Errorf("getK8sSecret() data[%s] = %q, want %q", key, got.Data[key], value)
+				}
+			}
+			// every version is checked against the validity policy
+			if len(c.secrets) != len(tt.want) {
+				t.Errorf("getK8sSecret() recorded %d secrets, want %d", len(c.secrets), len(tt.want))
+			}
+		})
+	}
+}
+
+func Test_getK8sSecretPreviousVersionsInvalid(t *testing.T) {
+	generator := &keyvaultsecretv1alpha1.SecretGenerator{Type: keyvaultsecretv1alpha1.GeneratorTypePassword, Length: 16}
+	tests := []struct {
+		name  string
+		items []keyvaultsecretv1alpha1.KeyvaultSecretEntry
+	}{
+		{
+			name: "previous key is another entry",
+			items: []keyvaultsecretv1alpha1.KeyvaultSecretEntry{
+				{KubernetesName: "PASSWORD", KeyvaultName: "db-password", PreviousVersions: 1},
+				{KubernetesName: "PASSWORD_PREVIOUS", KeyvaultName: "other-password"},
+			},
+		},
+		{
+			name: "previous keys of two entries",
+			items: []keyvaultsecretv1alpha1.KeyvaultSecretEntry{
+				{KubernetesName: "PASSWORD", KeyvaultName: "db-password", PreviousVersions: 2, PreviousKeySuffix: "_OLD"},
+				{KubernetesName: "PASSWORD_OLD", KeyvaultName: "other-password", PreviousVersions: 1, PreviousKeySuffix: "_2"},
+			},
+		},
+		{
+			name:  "too many previous versions",
+			items: []keyvaultsecretv1alpha1.KeyvaultSecretEntry{{KubernetesName: "PASSWORD", KeyvaultName: "db-password", PreviousVersions: keyvaultsecretv1alpha1.MaxPreviousVersions + 1}},
+		},
+		{
+			name:  "too many previous versions of a generated secret",
+			items: []keyvaultsecretv1alpha1.KeyvaultSecretEntry{{KubernetesName: "PASSWORD", KeyvaultName: "db-password", Generator: generator, PreviousVersions: keyvaultsecretv1alpha1.MaxPreviousVersions + 1}},
+		},
+		{
+			name:  "negative previous versions of a generated secret",
+			items: []keyvaultsecretv1alpha1.KeyvaultSecretEntry{{KubernetesName: "PASSWORD", KeyvaultName: "db-password", Generator: generator, PreviousVersions: -1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyvaultSecret := &keyvaultsecretv1alpha1.KeyvaultSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-secret", Namespace: "test-namespace"},
+				Spec:       keyvaultsecretv1alpha1.KeyvaultSecretSpec{Items: tt.items},
+			}
+			c := &SecretConverter{ctx: context.Background(), keyvaultSecret: keyvaultSecret, storeClient: testVersionStore{}}
+			if _, err := c.getK8sSecret(); err == nil {
+				t.Errorf("getK8sSecret() succeeded with an invalid spec")
+			}
+		})
+	}
+}
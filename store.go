@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+
+	"github.com/twendt/secret-controller/pkg/secretstore"
+	"github.com/twendt/secret-controller/pkg/secretstore/appconfig"
+	"github.com/twendt/secret-controller/pkg/secretstore/aws"
+	"github.com/twendt/secret-controller/pkg/secretstore/failover"
+	"github.com/twendt/secret-controller/pkg/secretstore/file"
+	"github.com/twendt/secret-controller/pkg/secretstore/gcp"
+	"github.com/twendt/secret-controller/pkg/secretstore/keyvault"
+	"github.com/twendt/secret-controller/pkg/secretstore/sops"
+)
+
+// storeConfig selects the secret store that secrets are read from
+type storeConfig struct {
+	store     string
+	vaultName string
+	path      string
+	envPrefix string
+	// vaultURL replaces the public URL of the vault of vaultName, e.g. with
+	// a private endpoint or a local emulator
+	vaultURL string
+	// keyvault configures the connections to Key Vault and Azure AD,
+	// keyvaultHTTPClient is created from it on first use
+	keyvault           keyvault.Options
+	keyvaultHTTPClient *http.Client
+	// workloadIdentity authenticates to Key Vault with the Azure workload
+	// identity of the pod instead of the controller's credentials
+	workloadIdentity bool
+	// failoverVaultNames are read from in order if the vault of vaultName
+	// cannot be reached
+	failoverVaultNames string
+	failoverOnNotFound bool
+	failoverCooldown   time.Duration
+	// awsRegion and awsEndpoint configure the AWS stores
+	awsRegion   string
+	awsEndpoint string
+	// gcpProject is the project of secrets that are not given with their
+	// resource name
+	gcpProject string
+	// appConfigLabel is the label of key-values that are read without one
+	appConfigLabel string
+	// appConfigVaultHosts are the hosts of the vaults that Key Vault
+	// references may point to
+	appConfigVaultHosts string
+	// sopsKeys is the file or directory with the keys of the sops store
+	sopsKeys string
+}
+
+func (s *storeConfig) addFlags(flags *flag.FlagSet) {
+	flags.StringVar(&s.store, "store", "keyvault", "Secret store to use: keyvault, aws-secrets-manager, aws-parameter-store, gcp-secret-manager, appconfig, sops, file or env")
+	flags.StringVar(&s.vaultName, "vault-name", "", "Name of Azure Key Vault to use")
+	flags.StringVar(&s.vaultURL, "vault-url", "", "URL of the Azure Key Vault to use, e.g. a private endpoint or a local emulator, replaces the URL of --vault-name")
+	flags.StringVar(&s.keyvault.Proxy, "keyvault-proxy", "", "URL of the HTTP(S) proxy for requests to Key Vault and Azure AD, defaults to HTTPS_PROXY")
+	flags.StringVar(&s.keyvault.CAFile, "keyvault-ca-file", "", "PEM file with CA certificates that are trusted for Key Vault and Azure AD in addition to the system ones")
+	flags.StringVar(&s.keyvault.MinTLSVersion, "keyvault-min-tls-version", "1.2", "Lowest TLS version accepted from Key Vault and Azure AD, only 1.2 is supported")
+	flags.BoolVar(&s.keyvault.InsecureSkipVerify, "keyvault-insecure-skip-verify", false, "Accept any certificate of Key Vault and Azure AD, only for testing with an emulator")
+	flags.DurationVar(&s.keyvault.Timeout, "keyvault-timeout", keyvault.DefaultTimeout, "Timeout of requests to Key Vault and Azure AD")
+	flags.BoolVar(&s.workloadIdentity, "azure-workload-identity", false, "Authenticate to Key Vault with the Azure workload identity of the pod (AZURE_FEDERATED_TOKEN_FILE, AZURE_CLIENT_ID and AZURE_TENANT_ID) instead of the KEYVAULT_* variables or azure.json")
+	flags.StringVar(&s.failoverVaultNames, "failover-vault-names", "", "Comma separated names or URLs of Key Vaults that are read from, in order, if the vault of --vault-name cannot be reached")
+	flags.BoolVar(&s.failoverOnNotFound, "failover-on-not-found", false, "Read secrets that do not exist in a vault from the next failover vault")
+	flags.DurationVar(&s.failoverCooldown, "failover-cooldown", failover.DefaultCooldown, "How long a vault that could not be reached is only read from after the other vaults")
+	flags.StringVar(&s.path, "store-path", "", "Directory or YAML/JSON file with the secrets of the file store, or directory with the encrypted files of the sops store")
+	flags.StringVar(&s.envPrefix, "env-prefix", "SECRET_", "Prefix of the environment variables of the env store")
+	flags.StringVar(&s.awsRegion, "aws-region", awsRegionFromEnv(), "AWS region of the aws-secrets-manager and aws-parameter-store stores")
+	flags.StringVar(&s.awsEndpoint, "aws-endpoint", "", "Endpoint that replaces the public endpoint of the AWS store, e.g. a VPC endpoint")
+	flags.StringVar(&s.gcpProject, "gcp-project", os.Getenv("GOOGLE_CLOUD_PROJECT"), "Google Cloud project of the gcp-secret-manager store, defaults to the project of the credentials")
+	flags.StringVar(&s.appConfigLabel, "appconfig-label", "", "Label of the key-values that the appconfig store reads, e.g. the environment")
+	flags.StringVar(&s.appConfigVaultHosts, "appconfig-vault-hosts", appconfig.DefaultVaultHosts, "Comma separated host patterns of the Key Vaults that Key Vault references of the appconfig store may point to, the host of --vault-url is always allowed")
+	flags.StringVar(&s.sopsKeys, "sops-keys", os.Getenv("SOPS_AGE_KEY_FILE"), "File or directory, e.g. a mounted Secret, with the age identities and PGP private keys of the sops store")
+}
+
+// newClient returns the client of the configured store and a name that
+// identifies the store in logs and cache keys
+func (s *storeConfig) newClient() (secretstore.Client, string, error) {
+	switch s.store {
+	case "keyvault":
+		vaultURL, name, err := s.vault()
+		if err != nil {
+			return nil, "", err
+		}
+		httpClient, err := s.httpClient()
+		if err != nil {
+			return nil, "", err
+		}
+		authorizer, err := s.authorizer(httpClient)
+		if err != nil {
+			return nil, "", err
+		}
+		client, err := keyvault.NewVaultClientForURL(vaultURL, authorizer, httpClient)
+		if err != nil || s.failoverVaultNames == "" {
+			return client, name, err
+		}
+		backends := []failover.Backend{{Name: name, Client: client}}
+		for _, vault := range strings.Split(s.failoverVaultNames, ",") {
+			vaultURL, backendName := failoverVault(strings.TrimSpace(vault))
+			client, err := keyvault.NewVaultClientForURL(vaultURL, authorizer, httpClient)
+			if err != nil {
+				return nil, "", err
+			}
+			backends = append(backends, failover.Backend{Name: backendName, Client: client})
+		}
+		failoverClient, err := failover.NewClient(backends, failover.Config{
+			FailoverOnNotFound: s.failoverOnNotFound,
+			Cooldown:           s.failoverCooldown,
+		})
+		return failoverClient, name, err
+	case "aws-secrets-manager", "aws-parameter-store":
+		credentials, err := aws.NewCredentialsFromEnv(s.awsRegion)
+		if err != nil {
+			return nil, "", err
+		}
+		config := aws.Config{Region: s.awsRegion, Endpoint: s.awsEndpoint, Credentials: credentials}
+		name := s.store + ":" + s.awsRegion
+		if s.store == "aws-secrets-manager" {
+			client, err := aws.NewSecretsManagerClient(config)
+			return client, name, err
+		}
+		client, err := aws.NewParameterStoreClient(config)
+		return client, name, err
+	case "gcp-secret-manager":
+		tokenSource, project, err := gcp.NewTokenSourceFromEnv(context.Background())
+		if err != nil {
+			return nil, "", err
+		}
+		if s.gcpProject != "" {
+			project = s.gcpProject
+		}
+		client, err := gcp.NewClient(gcp.Config{Project: project, TokenSource: tokenSource})
+		return client, s.store + ":" + project, err
+	case "appconfig":
+		config, err := appconfig.ParseConnectionString(os.Getenv("AZURE_APPCONFIG_CONNECTION_STRING"))
+		if err != nil {
+			return nil, "", fmt.Errorf("the appconfig store requires AZURE_APPCONFIG_CONNECTION_STRING: %v", err)
+		}
+		config.Label = s.appConfigLabel
+		for _, host := range strings.Split(s.appConfigVaultHosts, ",") {
+			if host = strings.TrimSpace(host); host != "" {
+				config.VaultHosts = append(config.VaultHosts, host)
+			}
+		}
+		if parsed, err := url.Parse(s.vaultURL); s.vaultURL != "" && err == nil {
+			config.VaultHosts = append(config.VaultHosts, parsed.Host)
+		}
+		httpClient, err := s.httpClient()
+		if err != nil {
+			return nil, "", err
+		}
+		authorizer, err := s.authorizer(httpClient)
+		if err != nil {
+			return nil, "", err
+		}
+		// Key Vault references are read with the controller's own credentials
+		config.KeyVault = func(vaultURL string) (secretstore.Client, error) {
+			return keyvault.NewVaultClientForURL(vaultURL, authorizer, httpClient)
+		}
+		client, err := appconfig.NewClient(config)
+		return client, "appconfig:" + config.Endpoint + "@" + s.appConfigLabel, err
+	case "sops":
+		if s.path == "" || s.sopsKeys == "" {
+			return nil, "", fmt.Errorf("the sops store requires --store-path and --sops-keys")
+		}
+		client, err := sops.NewClient(s.path, s.sopsKeys)
+		return client, "sops:" + s.path, err
+	case "file":
+		if s.path == "" {
+			return nil, "", fmt.Errorf("the file store requires --store-path")
+		}
+		client, err := file.NewClient(s.path)
+		return client, s.path, err
+	case "env":
+		return file.NewEnvClient(s.envPrefix), "env:" + s.envPrefix, nil
+	default:
+		return nil, "", fmt.Errorf("unknown secret store %q", s.store)
+	}
+}
+
+// vault returns the URL of the vault of the keyvault store and its name,
+// which identifies it in logs, cache keys and Event Grid events. Without
+// --vault-name the name is the first label of the host of the URL, e.g.
+// myvault for https://myvault.privatelink.vaultcore.azure.net.
+func (s *storeConfig) vault() (string, string, error) {
+	if s.vaultURL == "" {
+		if s.vaultName == "" {
+			return "", "", fmt.Errorf("the keyvault store requires --vault-name or --vault-url")
+		}
+		return keyvault.VaultURL(s.vaultName), s.vaultName, nil
+	}
+	parsed, err := url.Parse(s.vaultURL)
+	if err != nil || parsed.Host == "" {
+		return "", "", fmt.Errorf("invalid --vault-url %q", s.vaultURL)
+	}
+	if s.vaultName != "" {
+		return s.vaultURL, s.vaultName, nil
+	}
+	return s.vaultURL, strings.SplitN(parsed.Hostname(), ".", 2)[0], nil
+}
+
+// execArgs returns the flags of the exec subcommand that read from the vault
+// of s with the same settings, or from vaultName instead if it is not empty.
+// The CA certificates and the credentials have to be passed separately, as
+// they are files and variables of the controller's pod.
+func (s *storeConfig) execArgs(vaultName string) []string {
+	var args []string
+	if vaultName != "" {
+		args = append(args, "--vault-name", vaultName)
+	} else {
+		if s.vaultName != "" {
+			args = append(args, "--vault-name", s.vaultName)
+		}
+		if s.vaultURL != "" {
+			args = append(args, "--vault-url", s.vaultURL)
+		}
+		if s.failoverVaultNames != "" {
+			args = append(args, "--failover-vault-names", s.failoverVaultNames)
+		}
+		if s.failoverOnNotFound {
+			args = append(args, "--failover-on-not-found")
+		}
+	}
+	if s.keyvault.Proxy != "" {
+		args = append(args, "--keyvault-proxy", s.keyvault.Proxy)
+	}
+	if s.keyvault.MinTLSVersion != "" {
+		args = append(args, "--keyvault-min-tls-version", s.keyvault.MinTLSVersion)
+	}
+	if s.keyvault.InsecureSkipVerify {
+		args = append(args, "--keyvault-insecure-skip-verify")
+	}
+	if s.keyvault.Timeout > 0 {
+		args = append(args, "--keyvault-timeout", s.keyvault.Timeout.String())
+	}
+	return args
+}
+
+// authorizer returns the authorizer of the Azure workload identity of the pod
+// if --azure-workload-identity is set and nil for the controller's own
+// credentials otherwise
+func (s *storeConfig) authorizer(httpClient *http.Client) (autorest.Authorizer, error) {
+	if !s.workloadIdentity {
+		return nil, nil
+	}
+	return keyvault.NewWorkloadIdentityAuthorizer(httpClient)
+}
+
+// failoverVault returns the URL and name of an entry of
+// --failover-vault-names, which is either the name or the URL of a vault
+func failoverVault(vault string) (string, string) {
+	if !strings.Contains(vault, "://") {
+		return keyvault.VaultURL(vault), vault
+	}
+	if parsed, err := url.Parse(vault); err == nil && parsed.Host != "" {
+		return vault, parsed.Host
+	}
+	// NewVaultClientForURL rejects the URL
+	return vault, vault
+}
+
+// httpClient returns the HTTP client of all requests to Key Vault and
+// Azure AD
+func (s *storeConfig) httpClient() (*http.Client, error) {
+	if s.keyvaultHTTPClient == nil {
+		httpClient, err := keyvault.NewHTTPClient(s.keyvault)
+		if err != nil {
+			return nil, err
+		}
+		s.keyvaultHTTPClient = httpClient
+	}
+	return s.keyvaultHTTPClient, nil
+}
+
+// awsRegionFromEnv returns the region the AWS SDKs would use
+func awsRegionFromEnv() string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	return os.Getenv("AWS_DEFAULT_REGION")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+
+	keyvaultsecretv1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+// ValidityPolicy defines how the controller treats secrets that are
+// disabled, expired or not yet valid
+type ValidityPolicy string
+
+const (
+	// ValidityPolicyIgnore uses such secrets without notice
+	ValidityPolicyIgnore ValidityPolicy = "Ignore"
+	// ValidityPolicyWarn uses such secrets and records a Warning event
+	ValidityPolicyWarn ValidityPolicy = "Warn"
+	// ValidityPolicyEnforce records a Warning event and does not update the
+	// Secret
+	ValidityPolicyEnforce ValidityPolicy = "Enforce"
+)
+
+const (
+	// ErrSecretInvalid is used as part of the Event 'reason' when a
+	// KeyvaultSecret uses a secret that is disabled, expired or not yet valid
+	ErrSecretInvalid = "ErrSecretInvalid"
+	// SecretExpiring is used as part of the Event 'reason' when a
+	// KeyvaultSecret uses a secret that expires soon
+	SecretExpiring = "SecretExpiring"
+
+	// MessageSecretExpiring is the message used for an Event fired when a
+	// KeyvaultSecret uses a secret that expires soon
+	MessageSecretExpiring = "Key Vault secret %q expires at %s"
+)
+
+var secretExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "secretcontroller",
+	Subsystem: "keyvaultsecret",
+	Name:      "expiry_timestamp_seconds",
+	Help:      "Unix time at which the first secret used by a KeyvaultSecret expires.",
+}, []string{"namespace", "name"})
+
+func init() {
+	prometheus.MustRegister(secretExpiry)
+}
+
+// checkValidity records events for secrets that are invalid or expire within
+// the configured warning period and updates the expiry metric. It returns an
+// error if the validity policy does not allow to use the secrets, and the
+// time at which the validity of the secrets changes next, which is zero if
+// it never does.
+func (c *Controller) checkValidity(keyvaultSecret *keyvaultsecretv1alpha1.KeyvaultSecret, secrets map[string]*secretstore.Secret) (time.Time, error) {
+	now := c.now()
+	var problems []string
+	var next, firstExpiry time.Time
+	// keep events in a stable order
+	keys := make([]string, 0, len(secrets))
+	for key := range secrets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		secret := secrets[key]
+		if problem := validityProblem(secret, now); problem != "" {
+			problems = append(problems, problem)
+		}
+		if secret.NotBefore != nil && now.Before(*secret.NotBefore) {
+			next = earliest(next, *secret.NotBefore)
+		}
+		if secret.Expires == nil || !now.Before(*secret.Expires) {
+			continue
+		}
+		firstExpiry = earliest(firstExpiry, *secret.Expires)
+		warnAt := secret.Expires.Add(-c.config.ExpiryWarning)
+		if now.Before(warnAt) {
+			next = earliest(next, warnAt)
+		} else {
+			c.recorder.Eventf(keyvaultSecret, corev1.EventTypeWarning, SecretExpiring, MessageSecretExpiring, secret.Name, secret.Expires.Format(time.RFC3339))
+		}
+		next = earliest(next, *secret.Expires)
+	}
+
+	if firstExpiry.IsZero() {
+		secretExpiry.DeleteLabelValues(keyvaultSecret.Namespace, keyvaultSecret.Name)
+	} else {
+		secretExpiry.WithLabelValues(keyvaultSecret.Namespace, keyvaultSecret.Name).Set(float64(firstExpiry.Unix()))
+	}
+
+	if c.config.ValidityPolicy == ValidityPolicyIgnore {
+		return next, nil
+	}
+	for _, problem := range problems {
+		c.recorder.Event(keyvaultSecret, corev1.EventTypeWarning, ErrSecretInvalid, problem)
+	}
+	if c.config.ValidityPolicy == ValidityPolicyEnforce && len(problems) > 0 {
+		return next, fmt.Errorf("%d secrets are not valid: %s", len(problems), problems[0])
+	}
+	return next, nil
+}
+
+// validityProblem describes why secret must not be used at now. It returns
+// an empty string for valid secrets.
+func validityProblem(secret *secretstore.Secret, now time.Time) string {
+	switch {
+	case secret.Disabled:
+		return fmt.Sprintf("Key Vault secret %q version %q is disabled", secret.Name, secret.Version)
+	case secret.NotBefore != nil && now.Before(*secret.NotBefore):
+		return fmt.Sprintf("Key Vault secret %q version %q is not valid before %s", secret.Name, secret.Version, secret.NotBefore.Format(time.RFC3339))
+	case secret.Expires != nil && !now.Before(*secret.Expires):
+		return fmt.Sprintf("Key Vault secret %q version %q expired at %s", secret.Name, secret.Version, secret.Expires.Format(time.RFC3339))
+	}
+	return ""
+}
+
+// earliest returns the earlier of two times, ignoring zero times
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	keyvaultsecretv1alpha1 "github.com/twendt/secret-controller/pkg/apis/secretcontroller/v1alpha1"
+	"github.com/twendt/secret-controller/pkg/secretstore"
+)
+
+func Test_checkValidity(t *testing.T) {
+	now := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+	tests := []struct {
+		name       string
+		policy     ValidityPolicy
+		secret     secretstore.Secret
+		wantNext   time.Time
+		wantEvents int
+		wantErr    bool
+	}{
+		{
+			name:     "valid secret without expiry",
+			policy:   ValidityPolicyEnforce,
+			secret:   secretstore.Secret{Name: "valid"},
+			wantNext: time.Time{},
+		},
+		{
+			name:     "expiry after warning period",
+			policy:   ValidityPolicyEnforce,
+			secret:   secretstore.Secret{Name: "valid", Expires: at(48 * time.Hour)},
+			wantNext: now.Add(24 * time.Hour),
+		},
+		{
+			name:       "expiry within warning period",
+			policy:     ValidityPolicyEnforce,
+			secret:     secretstore.Secret{Name: "expiring", Expires: at(time.Hour)},
+			wantNext:   now.Add(time.Hour),
+			wantEvents: 1,
+		},
+		{
+			name:       "expired secret with warn policy",
+			policy:     ValidityPolicyWarn,
+			secret:     secretstore.Secret{Name: "expired", Expires: at(-time.Hour)},
+			wantEvents: 1,
+		},
+		{
+			name:       "expired secret with enforce policy",
+			policy:     ValidityPolicyEnforce,
+			secret:     secretstore.Secret{Name: "expired", Expires: at(-time.Hour)},
+			wantEvents: 1,
+			wantErr:    true,
+		},
+		{
+			name:   "expired secret with ignore policy",
+			policy: ValidityPolicyIgnore,
+			secret: secretstore.Secret{Name: "expired", Expires: at(-time.Hour)},
+		},
+		{
+			name:       "not yet valid secret",
+			policy:     ValidityPolicyEnforce,
+			secret:     secretstore.Secret{Name: "future", NotBefore: at(time.Hour)},
+			wantNext:   now.Add(time.Hour),
+			wantEvents: 1,
+			wantErr:    true,
+		},
+		{
+			name:       "disabled secret",
+			policy:     ValidityPolicyEnforce,
+			secret:     secretstore.Secret{Name: "disabled", Disabled: true},
+			wantEvents: 1,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			c := &Controller{
+				config:   Config{ValidityPolicy: tt.policy, ExpiryWarning: 24 * time.Hour},
+				recorder: recorder,
+				logger:   logrus.NewEntry(logrus.New()),
+				now:      func() time.Time { return now },
+			}
+			keyvaultSecret := &keyvaultsecretv1alpha1.KeyvaultSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-secret", Namespace: "test-namespace"},
+			}
+			secret := tt.secret
+			next, err := c.checkValidity(keyvaultSecret, map[string]*secretstore.Secret{secret.Name + "/": &secret})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkValidity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !next.Equal(tt.wantNext) {
+				t.Errorf("checkValidity() next = %v, want %v", next, tt.wantNext)
+			}
+			if got := len(recorder.Events); got != tt.wantEvents {
+				t.Errorf("checkValidity() recorded %d events, want %d", got, tt.wantEvents)
+			}
+		})
+	}
+}